@@ -0,0 +1,293 @@
+package main
+
+import (
+    "database/sql"
+    "flag"
+    "fmt"
+    "os"
+    "sort"
+    "strings"
+    "time"
+)
+
+// Tags and notes of a workspace, keyed by "kind:value" of their subject
+type annotations struct {
+    tags  map[string][]string
+    notes map[string][]string
+}
+
+// Subject key of a host or hash
+func subjectKey(kind, value string) string {
+    return kind + ":" + value
+}
+
+// Load every tag and note of a workspace
+func loadAnnotations(db *sql.DB, workspace string) (annotations, error) {
+    a := annotations{tags: map[string][]string{}, notes: map[string][]string{}}
+    rows, err := db.Query("SELECT kind, value, tag FROM tags WHERE workspace = ? ORDER BY tag", workspace)
+    if err != nil {
+        return a, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var kind, value, tag string
+        if err := rows.Scan(&kind, &value, &tag); err != nil {
+            return a, err
+        }
+        a.tags[subjectKey(kind, value)] = append(a.tags[subjectKey(kind, value)], tag)
+    }
+    if err := rows.Err(); err != nil {
+        return a, err
+    }
+
+    notes, err := db.Query("SELECT kind, value, note FROM notes WHERE workspace = ? ORDER BY id", workspace)
+    if err != nil {
+        return a, err
+    }
+    defer notes.Close()
+    for notes.Next() {
+        var kind, value, note string
+        if err := notes.Scan(&kind, &value, &note); err != nil {
+            return a, err
+        }
+        a.notes[subjectKey(kind, value)] = append(a.notes[subjectKey(kind, value)], note)
+    }
+    return a, notes.Err()
+}
+
+// Attach the tags and notes of a record's host and hashes
+func (a annotations) apply(r *record) {
+    r.HostTags = a.tags[subjectKey("host", r.host())]
+    r.HashTags, r.Notes = nil, nil
+    for _, key := range []string{subjectKey("md5", r.MD5), subjectKey("sha256", r.SHA256), subjectKey("mmh3", r.MMH3)} {
+        r.HashTags = append(r.HashTags, a.tags[key]...)
+    }
+    r.HashTags = uniqueSorted(r.HashTags)
+    for _, key := range []string{subjectKey("host", r.host()), subjectKey("md5", r.MD5), subjectKey("sha256", r.SHA256), subjectKey("mmh3", r.MMH3)} {
+        r.Notes = append(r.Notes, a.notes[key]...)
+    }
+}
+
+// Sorted copy of a list without duplicates
+func uniqueSorted(items []string) []string {
+    if len(items) == 0 {
+        return nil
+    }
+    seen := map[string]bool{}
+    var out []string
+    for _, item := range items {
+        if !seen[item] {
+            seen[item] = true
+            out = append(out, item)
+        }
+    }
+    sort.Strings(out)
+    return out
+}
+
+// The host or hash a tag or note is about, from -host, -md5, -sha256 or -mmh3
+type subjectFlags struct {
+    host   *string
+    md5    *string
+    sha256 *string
+    mmh3   *string
+}
+
+func registerSubjectFlags(fs *flag.FlagSet) subjectFlags {
+    return subjectFlags{
+        host:   fs.String("host", "", "Host the annotation is about"),
+        md5:    fs.String("md5", "", "Favicon MD5 the annotation is about"),
+        sha256: fs.String("sha256", "", "Favicon SHA256 the annotation is about"),
+        mmh3:   fs.String("mmh3", "", "Favicon MMH3 the annotation is about"),
+    }
+}
+
+// Kind and normalized value of the one subject given; ok is false when
+// none was, an error is returned when several were
+func (f subjectFlags) subject() (kind, value string, ok bool, err error) {
+    for _, s := range []struct {
+        kind  string
+        value string
+    }{{"host", *f.host}, {"md5", *f.md5}, {"sha256", *f.sha256}, {"mmh3", *f.mmh3}} {
+        if s.value = strings.TrimSpace(s.value); s.value == "" {
+            continue
+        }
+        if ok {
+            return "", "", false, fmt.Errorf("give only one of -host, -md5, -sha256 and -mmh3")
+        }
+        kind, value, ok = s.kind, strings.ToLower(s.value), true
+    }
+    return kind, value, ok, nil
+}
+
+// Add, remove and list tags on hosts and hashes
+func tagCommand(args []string) {
+    if len(args) == 0 || isHelp(args[0]) {
+        fmt.Fprintln(os.Stderr, "Usage: maplink tag add|remove|list [flags] [tag...]")
+        return
+    }
+    fs := flag.NewFlagSet("tag "+args[0], flag.ExitOnError)
+    dbOpts := dbFlags(fs)
+    subj := registerSubjectFlags(fs)
+    parseFlags(fs, args[1:])
+
+    kind, value, ok, err := subj.subject()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        return
+    }
+    tags := fs.Args()
+    switch args[0] {
+    case "add", "remove":
+        if !ok || len(tags) == 0 {
+            fmt.Fprintf(os.Stderr, "Usage: maplink tag %s -host|-md5|-sha256|-mmh3 <value> <tag>...\n", args[0])
+            return
+        }
+    case "list":
+    default:
+        fmt.Fprintf(os.Stderr, "Unknown tag command %q\n", args[0])
+        return
+    }
+
+    db, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer db.Close()
+
+    now := time.Now().UTC().Format(time.RFC3339)
+    for _, tag := range tags {
+        if tag = strings.TrimSpace(tag); tag == "" {
+            continue
+        }
+        switch args[0] {
+        case "add":
+            _, err = db.Exec("INSERT OR IGNORE INTO tags(workspace, kind, value, tag, created_at) VALUES(?, ?, ?, ?, ?)", dbOpts.workspace, kind, value, tag, now)
+        case "remove":
+            _, err = db.Exec("DELETE FROM tags WHERE workspace = ? AND kind = ? AND value = ? AND tag = ?", dbOpts.workspace, kind, value, tag)
+        }
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error saving tag %s: %v\n", tag, err)
+            return
+        }
+    }
+    if args[0] == "remove" {
+        recordCLIAction(db, auditAction{Workspace: dbOpts.workspace, Action: actionTagRemove, Subject: kind + ":" + value, Detail: strings.Join(tags, ",")})
+    }
+    if args[0] != "list" {
+        fmt.Printf("Updated tags of %s %s\n", kind, value)
+        return
+    }
+
+    // List every tag, or those of one subject or with the given names
+    query := "SELECT kind, value, tag, COALESCE(created_at, '') FROM tags WHERE workspace = ?"
+    params := []interface{}{dbOpts.workspace}
+    if ok {
+        query += " AND kind = ? AND value = ?"
+        params = append(params, kind, value)
+    }
+    if len(tags) > 0 {
+        query += " AND tag IN (?" + strings.Repeat(", ?", len(tags)-1) + ")"
+        for _, tag := range tags {
+            params = append(params, tag)
+        }
+    }
+    rows, err := db.Query(query+" ORDER BY kind, value, tag", params...)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error reading tags: %v\n", err)
+        return
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var k, v, tag, created string
+        if err := rows.Scan(&k, &v, &tag, &created); err != nil {
+            fmt.Fprintf(os.Stderr, "Error reading tags: %v\n", err)
+            return
+        }
+        fmt.Printf("%s\t%s\t%s\t%s\n", k, v, tag, created)
+    }
+}
+
+// Add, list and delete free-text notes on hosts and hashes
+func noteCommand(args []string) {
+    if len(args) == 0 || isHelp(args[0]) {
+        fmt.Fprintln(os.Stderr, "Usage: maplink note add|list|delete [flags] [text|id]")
+        return
+    }
+    fs := flag.NewFlagSet("note "+args[0], flag.ExitOnError)
+    dbOpts := dbFlags(fs)
+    subj := registerSubjectFlags(fs)
+    parseFlags(fs, args[1:])
+
+    kind, value, ok, err := subj.subject()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        return
+    }
+    db, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer db.Close()
+
+    switch args[0] {
+    case "add":
+        text := strings.TrimSpace(strings.Join(fs.Args(), " "))
+        if !ok || text == "" {
+            fmt.Fprintln(os.Stderr, "Usage: maplink note add -host|-md5|-sha256|-mmh3 <value> <text>")
+            return
+        }
+        res, err := db.Exec("INSERT INTO notes(workspace, kind, value, note, created_at) VALUES(?, ?, ?, ?, ?)",
+            dbOpts.workspace, kind, value, text, time.Now().UTC().Format(time.RFC3339))
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error saving note: %v\n", err)
+            return
+        }
+        id, _ := res.LastInsertId()
+        fmt.Printf("Added note #%d to %s %s\n", id, kind, value)
+    case "delete":
+        if fs.NArg() != 1 {
+            fmt.Fprintln(os.Stderr, "Usage: maplink note delete <id>")
+            return
+        }
+        // What the note said goes to the action trail with its deletion
+        var noteKind, noteValue, text string
+        err := db.QueryRow("DELETE FROM notes WHERE workspace = ? AND id = ? RETURNING kind, value, note", dbOpts.workspace, fs.Arg(0)).Scan(&noteKind, &noteValue, &text)
+        if err == sql.ErrNoRows {
+            fmt.Fprintf(os.Stderr, "No note #%s in workspace %s\n", fs.Arg(0), dbOpts.workspace)
+            return
+        }
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error deleting note: %v\n", err)
+            return
+        }
+        recordCLIAction(db, auditAction{Workspace: dbOpts.workspace, Action: actionNoteDelete, Subject: noteKind + ":" + noteValue, Detail: "#" + fs.Arg(0) + " " + text})
+        fmt.Printf("Deleted note #%s\n", fs.Arg(0))
+    case "list":
+        query := "SELECT id, kind, value, note, COALESCE(created_at, '') FROM notes WHERE workspace = ?"
+        params := []interface{}{dbOpts.workspace}
+        if ok {
+            query += " AND kind = ? AND value = ?"
+            params = append(params, kind, value)
+        }
+        rows, err := db.Query(query+" ORDER BY id", params...)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error reading notes: %v\n", err)
+            return
+        }
+        defer rows.Close()
+        for rows.Next() {
+            var id int64
+            var k, v, note, created string
+            if err := rows.Scan(&id, &k, &v, &note, &created); err != nil {
+                fmt.Fprintf(os.Stderr, "Error reading notes: %v\n", err)
+                return
+            }
+            fmt.Printf("#%d\t%s\t%s\t%s\t%s\n", id, k, v, created, note)
+        }
+    default:
+        fmt.Fprintf(os.Stderr, "Unknown note command %q\n", args[0])
+    }
+}
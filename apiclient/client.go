@@ -0,0 +1,143 @@
+// Package apiclient is a client for the HTTP API of maplink serve.
+//
+// The types and methods in client_gen.go are generated from the server's
+// routes; run go generate in the repository root after changing them.
+package apiclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Client calls the API at BaseURL with an API key.
+type Client struct {
+	BaseURL    string
+	APIKey     string
+	HTTPClient *http.Client
+}
+
+// New returns a client for the API at baseURL, e.g. https://maplink.example:8080.
+func New(baseURL, apiKey string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/"), APIKey: apiKey, HTTPClient: http.DefaultClient}
+}
+
+// APIError is an error response of the API.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("maplink API: %d %s", e.StatusCode, e.Message)
+}
+
+// Event is a server-sent event of a streaming endpoint. Data decodes into
+// the type its Name says: Job for job, JobProgress for progress and
+// StreamResult for result.
+type Event struct {
+	ID   int64
+	Name string
+	Data json.RawMessage
+}
+
+func (c *Client) request(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		apiErr := &APIError{StatusCode: resp.StatusCode, Message: resp.Status}
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&e) == nil && e.Error != "" {
+			apiErr.Message = e.Error
+		}
+		return nil, apiErr
+	}
+	return resp, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	resp, err := c.request(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// stream reads the server-sent events of a GET endpoint, passing each to
+// handle, until the server ends the stream or handle returns an error.
+func (c *Client) stream(ctx context.Context, path string, query url.Values, handle func(Event) error) error {
+	resp, err := c.request(ctx, "GET", path, query, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var ev Event
+	var data []string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if ev.Name != "" || len(data) > 0 {
+				ev.Data = json.RawMessage(strings.Join(data, "\n"))
+				if err := handle(ev); err != nil {
+					return err
+				}
+			}
+			ev, data = Event{ID: ev.ID}, nil
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			ev.ID, _ = strconv.ParseInt(value, 10, 64)
+		case "event":
+			ev.Name = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,610 @@
+// Code generated by maplink openapi; DO NOT EDIT.
+
+package apiclient
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+type AuditAction struct {
+	ID        int64  `json:"id"`
+	At        string `json:"at"`
+	Workspace string `json:"workspace,omitempty"`
+	Actor     string `json:"actor"`
+	Action    string `json:"action"`
+	Subject   string `json:"subject,omitempty"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+type AuditActionPage struct {
+	Items      []AuditAction `json:"items"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+type AuditEntry struct {
+	ID      int64    `json:"id"`
+	At      string   `json:"at"`
+	Key     string   `json:"key,omitempty"`
+	Remote  string   `json:"remote"`
+	Method  string   `json:"method"`
+	Path    string   `json:"path"`
+	Status  int      `json:"status"`
+	Targets []string `json:"targets,omitempty"`
+}
+
+type AuditEntryPage struct {
+	Items      []AuditEntry `json:"items"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+type Favicon struct {
+	Link      string            `json:"link"`
+	Target    string            `json:"target,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Server    string            `json:"server,omitempty"`
+	Labels    string            `json:"labels,omitempty"`
+	RunLabels map[string]string `json:"run_labels,omitempty"`
+	FinalURL  string            `json:"final_url,omitempty"`
+	Apex      string            `json:"apex,omitempty"`
+	MD5       string            `json:"md5"`
+	SHA256    string            `json:"sha256"`
+	MMH3      string            `json:"mmh3"`
+	FirstSeen string            `json:"first_seen,omitempty"`
+	LastSeen  string            `json:"last_seen,omitempty"`
+	Tags      []string          `json:"tags,omitempty"`
+	Notes     []string          `json:"notes,omitempty"`
+}
+
+type FaviconPage struct {
+	Items      []Favicon `json:"items"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+type HashError struct {
+	URL   string `json:"url"`
+	Error string `json:"error"`
+}
+
+type HashResult struct {
+	URL       string      `json:"url"`
+	Title     string      `json:"title,omitempty"`
+	Icons     []IconHash  `json:"icons"`
+	Errors    []HashError `json:"errors,omitempty"`
+	FetchedAt string      `json:"fetched_at"`
+	Cached    bool        `json:"cached"`
+}
+
+type IconHash struct {
+	URL         string `json:"url"`
+	ContentType string `json:"content_type,omitempty"`
+	Size        int    `json:"size"`
+	MD5         string `json:"md5"`
+	SHA256      string `json:"sha256"`
+	MMH3        string `json:"mmh3"`
+}
+
+type Job struct {
+	ID          int64             `json:"id"`
+	Status      string            `json:"status"`
+	Key         string            `json:"key,omitempty"`
+	Targets     int               `json:"targets"`
+	Done        int               `json:"done"`
+	Results     int               `json:"results"`
+	Errors      int               `json:"errors"`
+	SubmittedAt string            `json:"submitted_at"`
+	StartedAt   string            `json:"started_at,omitempty"`
+	FinishedAt  string            `json:"finished_at,omitempty"`
+	RunID       int64             `json:"run_id,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+type JobAccepted struct {
+	Job    int64 `json:"job"`
+	Queued int   `json:"queued"`
+}
+
+type JobPage struct {
+	Items      []Job  `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type JobProgress struct {
+	Job     int64 `json:"job"`
+	Done    int   `json:"done"`
+	Targets int   `json:"targets"`
+	Errors  int   `json:"errors"`
+}
+
+type JobRequest struct {
+	Targets []Target          `json:"targets"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+type Result struct {
+	Target           string                 `json:"target"`
+	URL              string                 `json:"url"`
+	Host             string                 `json:"host"`
+	MD5              string                 `json:"md5"`
+	SHA256           string                 `json:"sha256"`
+	MMH3             string                 `json:"mmh3"`
+	ContentType      string                 `json:"content_type,omitempty"`
+	Title            string                 `json:"title,omitempty"`
+	Server           string                 `json:"server,omitempty"`
+	Size             int                    `json:"size"`
+	Status           string                 `json:"status"`
+	Labels           []string               `json:"labels,omitempty"`
+	RunLabels        map[string]string      `json:"run_labels,omitempty"`
+	FinalURL         string                 `json:"final_url,omitempty"`
+	FinalHost        string                 `json:"final_host,omitempty"`
+	Apex             string                 `json:"apex,omitempty"`
+	NotModified      bool                   `json:"not_modified,omitempty"`
+	Match            string                 `json:"match,omitempty"`
+	Default          string                 `json:"default,omitempty"`
+	Screenshot       string                 `json:"screenshot,omitempty"`
+	ScreenshotSHA256 string                 `json:"screenshot_sha256,omitempty"`
+	Script           map[string]interface{} `json:"script,omitempty"`
+	Timestamp        time.Time              `json:"timestamp"`
+	SignedAt         string                 `json:"signed_at,omitempty"`
+	SigningKey       string                 `json:"signing_key,omitempty"`
+	Signature        string                 `json:"signature,omitempty"`
+}
+
+type ResultPage struct {
+	Items      []Result `json:"items"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+}
+
+type Run struct {
+	ID         int64             `json:"id"`
+	Name       string            `json:"name,omitempty"`
+	StartedAt  string            `json:"started_at"`
+	FinishedAt string            `json:"finished_at,omitempty"`
+	Targets    int               `json:"targets"`
+	Favicons   int               `json:"favicons"`
+	Errors     int               `json:"errors"`
+	Skipped    int               `json:"skipped"`
+	Deferred   int               `json:"deferred"`
+	Status     string            `json:"status"`
+	Labels     map[string]string `json:"labels,omitempty"`
+}
+
+type RunPage struct {
+	Items      []Run  `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type StreamResult struct {
+	Job    int64  `json:"job"`
+	Result Result `json:"result"`
+}
+
+type Target struct {
+	URL        string            `json:"url"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Cookies    map[string]string `json:"cookies,omitempty"`
+	Proxy      string            `json:"proxy,omitempty"`
+	Labels     []string          `json:"labels,omitempty"`
+	Connect    string            `json:"connect,omitempty"`
+	HostHeader string            `json:"host_header,omitempty"`
+	SNI        string            `json:"sni,omitempty"`
+}
+
+type WebhookRequest struct {
+	Hostnames []string `json:"hostnames"`
+}
+
+// ListFaviconsParams are the query parameters of ListFavicons.
+type ListFaviconsParams struct {
+	// Text in the link, target, title, server or labels
+	Search string
+	// MD5, SHA256 or MMH3 of the icon
+	Hash string
+	// Apex domain, or final host and its subdomains
+	Domain string
+	// Tag on the favicon's host or hashes
+	Tag string
+	// Only favicons new or changed in this run
+	Run int64
+	// Last seen at or after this time (RFC 3339 or YYYY-MM-DD)
+	Since string
+	// Last seen before this time (RFC 3339 or YYYY-MM-DD)
+	Until string
+	// next_cursor of the previous page
+	Cursor string
+	// Items per page (default 100, at most 1000)
+	Limit int64
+	// Sort field, - prefixed for descending: last_seen, first_seen, link
+	Sort string
+}
+
+func (p *ListFaviconsParams) values() url.Values {
+	q := url.Values{}
+	if p == nil {
+		return q
+	}
+	if p.Search != "" {
+		q.Set("search", p.Search)
+	}
+	if p.Hash != "" {
+		q.Set("hash", p.Hash)
+	}
+	if p.Domain != "" {
+		q.Set("domain", p.Domain)
+	}
+	if p.Tag != "" {
+		q.Set("tag", p.Tag)
+	}
+	if p.Run != 0 {
+		q.Set("run", strconv.FormatInt(p.Run, 10))
+	}
+	if p.Since != "" {
+		q.Set("since", p.Since)
+	}
+	if p.Until != "" {
+		q.Set("until", p.Until)
+	}
+	if p.Cursor != "" {
+		q.Set("cursor", p.Cursor)
+	}
+	if p.Limit != 0 {
+		q.Set("limit", strconv.FormatInt(p.Limit, 10))
+	}
+	if p.Sort != "" {
+		q.Set("sort", p.Sort)
+	}
+	return q
+}
+
+// ListFavicons: Search stored favicons (GET /favicons).
+func (c *Client) ListFavicons(ctx context.Context, p *ListFaviconsParams) (*FaviconPage, error) {
+	var out FaviconPage
+	if err := c.do(ctx, "GET", "/favicons", p.values(), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DeleteFaviconParams are the query parameters of DeleteFavicon.
+type DeleteFaviconParams struct {
+	// Favicon URL
+	Link string
+}
+
+func (p *DeleteFaviconParams) values() url.Values {
+	q := url.Values{}
+	if p == nil {
+		return q
+	}
+	if p.Link != "" {
+		q.Set("link", p.Link)
+	}
+	return q
+}
+
+// DeleteFavicon: Delete a stored favicon and its history (DELETE /favicons).
+func (c *Client) DeleteFavicon(ctx context.Context, p *DeleteFaviconParams) error {
+	return c.do(ctx, "DELETE", "/favicons", p.values(), nil, nil)
+}
+
+// ListRunsParams are the query parameters of ListRuns.
+type ListRunsParams struct {
+	// running, completed, interrupted or timed_out
+	Status string
+	// Started at or after this time (RFC 3339 or YYYY-MM-DD)
+	Since string
+	// Started before this time (RFC 3339 or YYYY-MM-DD)
+	Until string
+	// next_cursor of the previous page
+	Cursor string
+	// Items per page (default 100, at most 1000)
+	Limit int64
+	// Sort field, - prefixed for descending: id, started_at
+	Sort string
+}
+
+func (p *ListRunsParams) values() url.Values {
+	q := url.Values{}
+	if p == nil {
+		return q
+	}
+	if p.Status != "" {
+		q.Set("status", p.Status)
+	}
+	if p.Since != "" {
+		q.Set("since", p.Since)
+	}
+	if p.Until != "" {
+		q.Set("until", p.Until)
+	}
+	if p.Cursor != "" {
+		q.Set("cursor", p.Cursor)
+	}
+	if p.Limit != 0 {
+		q.Set("limit", strconv.FormatInt(p.Limit, 10))
+	}
+	if p.Sort != "" {
+		q.Set("sort", p.Sort)
+	}
+	return q
+}
+
+// ListRuns: List scan runs (GET /runs).
+func (c *Client) ListRuns(ctx context.Context, p *ListRunsParams) (*RunPage, error) {
+	var out RunPage
+	if err := c.do(ctx, "GET", "/runs", p.values(), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// SubmitJob: Queue targets to scan as one job (POST /jobs).
+func (c *Client) SubmitJob(ctx context.Context, body JobRequest) (*JobAccepted, error) {
+	var out JobAccepted
+	if err := c.do(ctx, "POST", "/jobs", nil, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// HostsWebhook: Queue hosts reported by a discovery system for scanning (POST /webhooks/hosts).
+func (c *Client) HostsWebhook(ctx context.Context, body WebhookRequest) (*JobAccepted, error) {
+	var out JobAccepted
+	if err := c.do(ctx, "POST", "/webhooks/hosts", nil, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// HashURLParams are the query parameters of HashURL.
+type HashURLParams struct {
+	// URL or host name of the target
+	Url string
+}
+
+func (p *HashURLParams) values() url.Values {
+	q := url.Values{}
+	if p == nil {
+		return q
+	}
+	if p.Url != "" {
+		q.Set("url", p.Url)
+	}
+	return q
+}
+
+// HashURL: Fetch one target's favicons now and return their hashes (GET /hash).
+func (c *Client) HashURL(ctx context.Context, p *HashURLParams) (*HashResult, error) {
+	var out HashResult
+	if err := c.do(ctx, "GET", "/hash", p.values(), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListJobsParams are the query parameters of ListJobs.
+type ListJobsParams struct {
+	// queued, running, failed, or the status of the job's run
+	Status string
+	// Name of the API key that submitted the job
+	Key string
+	// Submitted at or after this time (RFC 3339 or YYYY-MM-DD)
+	Since string
+	// Submitted before this time (RFC 3339 or YYYY-MM-DD)
+	Until string
+	// next_cursor of the previous page
+	Cursor string
+	// Items per page (default 100, at most 1000)
+	Limit int64
+	// Sort field, - prefixed for descending: id
+	Sort string
+}
+
+func (p *ListJobsParams) values() url.Values {
+	q := url.Values{}
+	if p == nil {
+		return q
+	}
+	if p.Status != "" {
+		q.Set("status", p.Status)
+	}
+	if p.Key != "" {
+		q.Set("key", p.Key)
+	}
+	if p.Since != "" {
+		q.Set("since", p.Since)
+	}
+	if p.Until != "" {
+		q.Set("until", p.Until)
+	}
+	if p.Cursor != "" {
+		q.Set("cursor", p.Cursor)
+	}
+	if p.Limit != 0 {
+		q.Set("limit", strconv.FormatInt(p.Limit, 10))
+	}
+	if p.Sort != "" {
+		q.Set("sort", p.Sort)
+	}
+	return q
+}
+
+// ListJobs: List jobs (GET /jobs).
+func (c *Client) ListJobs(ctx context.Context, p *ListJobsParams) (*JobPage, error) {
+	var out JobPage
+	if err := c.do(ctx, "GET", "/jobs", p.values(), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetJob: Get a job and its progress (GET /jobs/{id}).
+func (c *Client) GetJob(ctx context.Context, id int64) (*Job, error) {
+	var out Job
+	if err := c.do(ctx, "GET", "/jobs/"+strconv.FormatInt(id, 10), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListJobResultsParams are the query parameters of ListJobResults.
+type ListJobResultsParams struct {
+	// next_cursor of the previous page
+	Cursor string
+	// Items per page (default 100, at most 1000)
+	Limit int64
+}
+
+func (p *ListJobResultsParams) values() url.Values {
+	q := url.Values{}
+	if p == nil {
+		return q
+	}
+	if p.Cursor != "" {
+		q.Set("cursor", p.Cursor)
+	}
+	if p.Limit != 0 {
+		q.Set("limit", strconv.FormatInt(p.Limit, 10))
+	}
+	return q
+}
+
+// ListJobResults: Page through the results of a job (GET /jobs/{id}/results).
+func (c *Client) ListJobResults(ctx context.Context, id int64, p *ListJobResultsParams) (*ResultPage, error) {
+	var out ResultPage
+	if err := c.do(ctx, "GET", "/jobs/"+strconv.FormatInt(id, 10)+"/results", p.values(), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// JobEvents: Stream a job's state, progress and results until it finishes (GET /jobs/{id}/events).
+// handle is called with each event until it returns an error or the stream ends.
+func (c *Client) JobEvents(ctx context.Context, id int64, handle func(Event) error) error {
+	return c.stream(ctx, "/jobs/"+strconv.FormatInt(id, 10)+"/events", nil, handle)
+}
+
+// Events: Stream live events of every job (GET /events).
+// handle is called with each event until it returns an error or the stream ends.
+func (c *Client) Events(ctx context.Context, handle func(Event) error) error {
+	return c.stream(ctx, "/events", nil, handle)
+}
+
+// ListAuditParams are the query parameters of ListAudit.
+type ListAuditParams struct {
+	// Name of the API key
+	Key string
+	// HTTP status of the response
+	Status int64
+	// Requested at or after this time (RFC 3339 or YYYY-MM-DD)
+	Since string
+	// Requested before this time (RFC 3339 or YYYY-MM-DD)
+	Until string
+	// next_cursor of the previous page
+	Cursor string
+	// Items per page (default 100, at most 1000)
+	Limit int64
+	// Sort field, - prefixed for descending: id
+	Sort string
+}
+
+func (p *ListAuditParams) values() url.Values {
+	q := url.Values{}
+	if p == nil {
+		return q
+	}
+	if p.Key != "" {
+		q.Set("key", p.Key)
+	}
+	if p.Status != 0 {
+		q.Set("status", strconv.FormatInt(p.Status, 10))
+	}
+	if p.Since != "" {
+		q.Set("since", p.Since)
+	}
+	if p.Until != "" {
+		q.Set("until", p.Until)
+	}
+	if p.Cursor != "" {
+		q.Set("cursor", p.Cursor)
+	}
+	if p.Limit != 0 {
+		q.Set("limit", strconv.FormatInt(p.Limit, 10))
+	}
+	if p.Sort != "" {
+		q.Set("sort", p.Sort)
+	}
+	return q
+}
+
+// ListAudit: Read the audit trail (GET /audit).
+func (c *Client) ListAudit(ctx context.Context, p *ListAuditParams) (*AuditEntryPage, error) {
+	var out AuditEntryPage
+	if err := c.do(ctx, "GET", "/audit", p.values(), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListActionsParams are the query parameters of ListActions.
+type ListActionsParams struct {
+	// key:<name> for API keys, cli:<login>@<host> or daemon:<login>@<host> for the command line
+	Actor string
+	// e.g. favicon.delete, workspace.delete, watchlist.remove, apikey.revoke
+	Action string
+	// Workspace the action was taken in
+	Workspace string
+	// Taken at or after this time (RFC 3339 or YYYY-MM-DD)
+	Since string
+	// Taken before this time (RFC 3339 or YYYY-MM-DD)
+	Until string
+	// next_cursor of the previous page
+	Cursor string
+	// Items per page (default 100, at most 1000)
+	Limit int64
+	// Sort field, - prefixed for descending: id
+	Sort string
+}
+
+func (p *ListActionsParams) values() url.Values {
+	q := url.Values{}
+	if p == nil {
+		return q
+	}
+	if p.Actor != "" {
+		q.Set("actor", p.Actor)
+	}
+	if p.Action != "" {
+		q.Set("action", p.Action)
+	}
+	if p.Workspace != "" {
+		q.Set("workspace", p.Workspace)
+	}
+	if p.Since != "" {
+		q.Set("since", p.Since)
+	}
+	if p.Until != "" {
+		q.Set("until", p.Until)
+	}
+	if p.Cursor != "" {
+		q.Set("cursor", p.Cursor)
+	}
+	if p.Limit != 0 {
+		q.Set("limit", strconv.FormatInt(p.Limit, 10))
+	}
+	if p.Sort != "" {
+		q.Set("sort", p.Sort)
+	}
+	return q
+}
+
+// ListActions: Read the trail of destructive and administrative actions (GET /audit/actions).
+func (c *Client) ListActions(ctx context.Context, p *ListActionsParams) (*AuditActionPage, error) {
+	var out AuditActionPage
+	if err := c.do(ctx, "GET", "/audit/actions", p.values(), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
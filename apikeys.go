@@ -0,0 +1,382 @@
+package main
+
+import (
+    "context"
+    "crypto/rand"
+    "crypto/sha256"
+    "database/sql"
+    "encoding/hex"
+    "flag"
+    "fmt"
+    "math"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Prefix of generated API keys, so leaked ones are easy to grep for
+const apiKeyPrefix = "mlk_"
+
+// SHA256 of a key as stored; the key itself is never kept
+func apiKeyHash(key string) string {
+    sum := sha256.Sum256([]byte(key))
+    return hex.EncodeToString(sum[:])
+}
+
+// Random key for a new client
+func newAPIKey() (string, error) {
+    b := make([]byte, 24)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    return apiKeyPrefix + hex.EncodeToString(b), nil
+}
+
+// Roles of API keys, each allowed everything the ones before it are
+const (
+    roleReadOnly = "read-only" // search favicons and runs
+    roleScanner  = "scanner"   // also submit targets to scan
+    roleAdmin    = "admin"     // also delete data and read the audit trail
+)
+
+var roleRank = map[string]int{roleReadOnly: 1, roleScanner: 2, roleAdmin: 3}
+
+// Check a -role name
+func validateRole(role string) error {
+    if roleRank[role] == 0 {
+        return fmt.Errorf("invalid role %q: use %s, %s or %s", role, roleReadOnly, roleScanner, roleAdmin)
+    }
+    return nil
+}
+
+// An API client
+type apiKey struct {
+    Name          string
+    Role          string
+    RatePerMinute int
+    CreatedAt     string
+    RevokedAt     string
+}
+
+// Look up the active client holding key
+func findAPIKey(db *sql.DB, key string) (apiKey, bool, error) {
+    var k apiKey
+    err := db.QueryRow(`SELECT name, role, rate_per_minute, COALESCE(created_at, '') FROM api_keys
+        WHERE key_sha256 = ? AND revoked_at IS NULL`, apiKeyHash(key)).Scan(&k.Name, &k.Role, &k.RatePerMinute, &k.CreatedAt)
+    if err == sql.ErrNoRows {
+        return k, false, nil
+    }
+    return k, err == nil, err
+}
+
+// Token bucket refilled at rate per minute, holding at most rate tokens
+type rateBucket struct {
+    tokens float64
+    last   time.Time
+}
+
+// Per-key request rate limits
+type rateLimiter struct {
+    mu      sync.Mutex
+    buckets map[string]*rateBucket
+}
+
+func newRateLimiter() *rateLimiter {
+    return &rateLimiter{buckets: map[string]*rateBucket{}}
+}
+
+// Take a token for name; when none is left, report how long until one is
+func (l *rateLimiter) allow(name string, perMinute int) (bool, time.Duration) {
+    if perMinute <= 0 {
+        return true, 0
+    }
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    now := time.Now()
+    b, ok := l.buckets[name]
+    if !ok {
+        b = &rateBucket{tokens: float64(perMinute), last: now}
+        l.buckets[name] = b
+    }
+    rate := float64(perMinute) / 60
+    b.tokens = math.Min(float64(perMinute), b.tokens+now.Sub(b.last).Seconds()*rate)
+    b.last = now
+    if b.tokens < 1 {
+        return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+    }
+    b.tokens--
+    return true, 0
+}
+
+// What the audit trail records about a request, and the role of its key;
+// handlers fill in targets
+type auditEntry struct {
+    key     string
+    role    string
+    targets []string
+}
+
+type auditKey struct{}
+
+// Audit entry of the request being handled
+func auditFrom(ctx context.Context) *auditEntry {
+    e, _ := ctx.Value(auditKey{}).(*auditEntry)
+    if e == nil {
+        return &auditEntry{}
+    }
+    return e
+}
+
+// Response writer that remembers the status code for the audit trail
+type statusRecorder struct {
+    http.ResponseWriter
+    status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+    w.status = status
+    w.ResponseWriter.WriteHeader(status)
+}
+
+// For http.ResponseController, which event streams flush through
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+    return w.ResponseWriter
+}
+
+// API key from an "Authorization: Bearer" or X-API-Key header
+func requestAPIKey(r *http.Request) string {
+    if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+        return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+    }
+    return r.Header.Get("X-API-Key")
+}
+
+// Require a valid API key within its rate limit, and write every request,
+// rejected ones included, to the audit trail
+func (sv *server) authenticate(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        entry := &auditEntry{}
+        rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+        defer func() { sv.audit(r, entry, rec.status) }()
+
+        key := requestAPIKey(r)
+        if key == "" {
+            writeError(rec, http.StatusUnauthorized, "missing API key")
+            return
+        }
+        k, ok, err := findAPIKey(sv.db, key)
+        if err != nil {
+            errorf("Error checking API key: %v\n", err)
+            writeError(rec, http.StatusInternalServerError, "checking API key")
+            return
+        }
+        if !ok {
+            writeError(rec, http.StatusUnauthorized, "invalid API key")
+            return
+        }
+        entry.key, entry.role = k.Name, k.Role
+        if ok, wait := sv.limits.allow(k.Name, k.RatePerMinute); !ok {
+            rec.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+            writeError(rec, http.StatusTooManyRequests, "rate limit exceeded")
+            return
+        }
+        next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), auditKey{}, entry)))
+    })
+}
+
+// Let only keys with at least the given role through to a handler
+func requireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if have := auditFrom(r.Context()).role; roleRank[have] < roleRank[role] {
+            writeError(w, http.StatusForbidden, fmt.Sprintf("%s role required", role))
+            return
+        }
+        next(w, r)
+    }
+}
+
+// Record a request in the audit trail
+func (sv *server) audit(r *http.Request, e *auditEntry, status int) {
+    var targets interface{}
+    if len(e.targets) > 0 {
+        targets = strings.Join(e.targets, "\n")
+    }
+    var key interface{}
+    if e.key != "" {
+        key = e.key
+    }
+    if _, err := sv.db.Exec("INSERT INTO api_audit(at, key_name, remote, method, path, status, targets) VALUES(?, ?, ?, ?, ?, ?, ?)",
+        time.Now().UTC().Format(time.RFC3339), key, r.RemoteAddr, r.Method, r.URL.RequestURI(), status, targets); err != nil {
+        errorf("Error writing audit trail: %v\n", err)
+    }
+}
+
+// A row of the audit trail
+type auditRow struct {
+    ID      int64    `json:"id"`
+    At      string   `json:"at"`
+    Key     string   `json:"key,omitempty"`
+    Remote  string   `json:"remote"`
+    Method  string   `json:"method"`
+    Path    string   `json:"path"`
+    Status  int      `json:"status"`
+    Targets []string `json:"targets,omitempty"`
+}
+
+// Most recent audit rows, newest first, optionally of one key only
+func loadAudit(db *sql.DB, key string, limit int) ([]auditRow, error) {
+    if key == "" {
+        return selectAudit(db, "", "id DESC", limit)
+    }
+    return selectAudit(db, "key_name = ?", "id DESC", limit, key)
+}
+
+// At most limit audit rows matching a WHERE clause, in the given order
+func selectAudit(db *sql.DB, where, orderBy string, limit int, args ...interface{}) ([]auditRow, error) {
+    query := "SELECT id, at, COALESCE(key_name, ''), COALESCE(remote, ''), method, path, status, COALESCE(targets, '') FROM api_audit"
+    if where != "" {
+        query += " WHERE " + where
+    }
+    rows, err := db.Query(query+" ORDER BY "+orderBy+" LIMIT ?", append(args, limit)...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var entries []auditRow
+    for rows.Next() {
+        var e auditRow
+        var targets string
+        if err := rows.Scan(&e.ID, &e.At, &e.Key, &e.Remote, &e.Method, &e.Path, &e.Status, &targets); err != nil {
+            return nil, err
+        }
+        if targets != "" {
+            e.Targets = strings.Split(targets, "\n")
+        }
+        entries = append(entries, e)
+    }
+    return entries, rows.Err()
+}
+
+// Manage API keys and read the audit trail
+func apiKeyCommand(args []string) {
+    if len(args) == 0 || isHelp(args[0]) {
+        fmt.Fprintln(os.Stderr, "Usage: maplink apikey add|list|role|revoke|audit [flags]")
+        return
+    }
+    fs := flag.NewFlagSet("apikey "+args[0], flag.ExitOnError)
+    dbOpts := dbFlags(fs)
+    rate := fs.Int("rate", 60, "Requests per minute the key may make (0 means no limit; with add)")
+    role := fs.String("role", roleReadOnly, "Role of the key: read-only, scanner or admin (with add)")
+    keyName := fs.String("key", "", "Only show requests made with this key (with audit)")
+    limit := fs.Int("limit", 100, "Most recent audit rows to print (with audit)")
+    parseFlags(fs, args[1:])
+
+    db, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer db.Close()
+
+    switch args[0] {
+    case "add":
+        if fs.NArg() != 1 {
+            fmt.Fprintln(os.Stderr, "Usage: maplink apikey add [-rate N] [-role ROLE] <name>")
+            return
+        }
+        if err := validateRole(*role); err != nil {
+            fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+            return
+        }
+        key, err := newAPIKey()
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error generating key: %v\n", err)
+            return
+        }
+        if _, err := db.Exec("INSERT INTO api_keys(name, key_sha256, role, rate_per_minute, created_at) VALUES(?, ?, ?, ?, ?)",
+            fs.Arg(0), apiKeyHash(key), *role, *rate, time.Now().UTC().Format(time.RFC3339)); err != nil {
+            fmt.Fprintf(os.Stderr, "Error saving key: %v\n", err)
+            return
+        }
+        recordCLIAction(db, auditAction{Action: actionKeyAdd, Subject: fs.Arg(0), Detail: fmt.Sprintf("%s, %d/min", *role, *rate)})
+        fmt.Fprintf(os.Stderr, "Created %s key %s; it is shown only once:\n", *role, fs.Arg(0))
+        fmt.Println(key)
+    case "role":
+        if fs.NArg() != 2 {
+            fmt.Fprintln(os.Stderr, "Usage: maplink apikey role <name> read-only|scanner|admin")
+            return
+        }
+        if err := validateRole(fs.Arg(1)); err != nil {
+            fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+            return
+        }
+        res, err := db.Exec("UPDATE api_keys SET role = ? WHERE name = ?", fs.Arg(1), fs.Arg(0))
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error changing role: %v\n", err)
+            return
+        }
+        if n, _ := res.RowsAffected(); n == 0 {
+            fmt.Fprintf(os.Stderr, "No key named %s\n", fs.Arg(0))
+            return
+        }
+        recordCLIAction(db, auditAction{Action: actionKeyRole, Subject: fs.Arg(0), Detail: fs.Arg(1)})
+        fmt.Printf("Key %s is now %s\n", fs.Arg(0), fs.Arg(1))
+    case "revoke":
+        if fs.NArg() != 1 {
+            fmt.Fprintln(os.Stderr, "Usage: maplink apikey revoke <name>")
+            return
+        }
+        res, err := db.Exec("UPDATE api_keys SET revoked_at = ? WHERE name = ? AND revoked_at IS NULL", time.Now().UTC().Format(time.RFC3339), fs.Arg(0))
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error revoking key: %v\n", err)
+            return
+        }
+        if n, _ := res.RowsAffected(); n == 0 {
+            fmt.Fprintf(os.Stderr, "No active key named %s\n", fs.Arg(0))
+            return
+        }
+        recordCLIAction(db, auditAction{Action: actionKeyRevoke, Subject: fs.Arg(0)})
+        fmt.Printf("Revoked key %s\n", fs.Arg(0))
+    case "list":
+        rows, err := db.Query("SELECT name, role, rate_per_minute, COALESCE(created_at, ''), COALESCE(revoked_at, '') FROM api_keys ORDER BY name")
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error reading keys: %v\n", err)
+            return
+        }
+        defer rows.Close()
+        for rows.Next() {
+            var k apiKey
+            if err := rows.Scan(&k.Name, &k.Role, &k.RatePerMinute, &k.CreatedAt, &k.RevokedAt); err != nil {
+                fmt.Fprintf(os.Stderr, "Error reading keys: %v\n", err)
+                return
+            }
+            status := "active"
+            if k.RevokedAt != "" {
+                status = "revoked " + k.RevokedAt
+            }
+            fmt.Printf("%s\t%s\t%d/min\tcreated %s\t%s\n", k.Name, k.Role, k.RatePerMinute, k.CreatedAt, status)
+        }
+    case "audit":
+        entries, err := loadAudit(db, *keyName, *limit)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error reading audit trail: %v\n", err)
+            return
+        }
+        for _, e := range entries {
+            key := e.Key
+            if key == "" {
+                key = "-"
+            }
+            line := fmt.Sprintf("%s\t%s\t%s\t%s %s\t%d", e.At, key, e.Remote, e.Method, e.Path, e.Status)
+            if len(e.Targets) > 0 {
+                line += "\t" + strings.Join(e.Targets, ",")
+            }
+            fmt.Println(line)
+        }
+    default:
+        fmt.Fprintf(os.Stderr, "Unknown apikey command %q\n", args[0])
+    }
+}
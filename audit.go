@@ -0,0 +1,197 @@
+package main
+
+import (
+    "database/sql"
+    "flag"
+    "fmt"
+    "net/http"
+    "os"
+    "os/user"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Actions of the append-only action trail
+const (
+    actionFaviconDelete   = "favicon.delete"
+    actionWorkspaceDelete = "workspace.delete"
+    actionWatchlistAdd    = "watchlist.add"
+    actionWatchlistRemove = "watchlist.remove"
+    actionWatchlistImport = "watchlist.import"
+    actionTagRemove       = "tag.remove"
+    actionNoteDelete      = "note.delete"
+    actionPrune           = "db.prune"
+    actionKeyAdd          = "apikey.add"
+    actionKeyRole         = "apikey.role"
+    actionKeyRevoke       = "apikey.revoke"
+)
+
+// A destructive or administrative action and who took it
+type auditAction struct {
+    ID        int64  `json:"id"`
+    At        string `json:"at"`
+    Workspace string `json:"workspace,omitempty"`
+    Actor     string `json:"actor"`
+    Action    string `json:"action"`
+    Subject   string `json:"subject,omitempty"`
+    Detail    string `json:"detail,omitempty"`
+}
+
+// A database or transaction to record an action in, so that it is recorded
+// in the same transaction as the change where there is one
+type sqlExecer interface {
+    Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Who runs this process, as actors of the command line are recorded:
+// kind:login@host, with the login sudo was run from where there is one
+func localActor(kind string) string {
+    login := "unknown"
+    if u, err := user.Current(); err == nil {
+        login = u.Username
+    }
+    if sudo := os.Getenv("SUDO_USER"); sudo != "" && sudo != login {
+        login = sudo + " as " + login
+    }
+    host, _ := os.Hostname()
+    return kind + ":" + login + "@" + host
+}
+
+// Actor of an API request as the action trail records it
+func keyActor(key string) string {
+    return "key:" + key
+}
+
+func recordAction(x sqlExecer, a auditAction) error {
+    if _, err := x.Exec("INSERT INTO action_audit(at, workspace, actor, action, subject, detail) VALUES(?, NULLIF(?, ''), ?, ?, NULLIF(?, ''), NULLIF(?, ''))",
+        time.Now().UTC().Format(time.RFC3339), a.Workspace, a.Actor, a.Action, a.Subject, a.Detail); err != nil {
+        return fmt.Errorf("recording %s in the action trail: %v", a.Action, err)
+    }
+    return nil
+}
+
+// Record an action a command already took, reporting a failure to do so
+func recordCLIAction(db *sql.DB, a auditAction) {
+    a.Actor = localActor("cli")
+    if err := recordAction(db, a); err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+    }
+}
+
+// At most limit actions matching a WHERE clause, in the given order
+func selectActions(db *sql.DB, where, orderBy string, limit int, args ...interface{}) ([]auditAction, error) {
+    query := "SELECT id, at, COALESCE(workspace, ''), actor, action, COALESCE(subject, ''), COALESCE(detail, '') FROM action_audit"
+    if where != "" {
+        query += " WHERE " + where
+    }
+    rows, err := db.Query(query+" ORDER BY "+orderBy+" LIMIT ?", append(args, limit)...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var actions []auditAction
+    for rows.Next() {
+        var a auditAction
+        if err := rows.Scan(&a.ID, &a.At, &a.Workspace, &a.Actor, &a.Action, &a.Subject, &a.Detail); err != nil {
+            return nil, err
+        }
+        actions = append(actions, a)
+    }
+    return actions, rows.Err()
+}
+
+// Sort orders of GET /audit/actions
+var actionSorts = map[string]sortColumn{
+    "id": {expr: "id", numeric: true},
+}
+
+// GET /audit/actions, newest first, filtered by ?actor=, ?action=,
+// ?workspace= and ?since=/?until=
+func (sv *server) listActions(w http.ResponseWriter, r *http.Request) {
+    lr, err := parseListRequest(r, actionSorts, "-id")
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    q := r.URL.Query()
+    var f listFilter
+    for _, column := range []string{"actor", "action", "workspace"} {
+        if v := q.Get(column); v != "" {
+            f.add(column+" = ?", v)
+        }
+    }
+    if err := addTimeRange(r, &f, "at"); err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    order := lr.keyset(&f, "id", true)
+
+    actions, err := selectActions(sv.db, f.where(), order, lr.fetch(), f.args...)
+    if err != nil {
+        errorf("Error reading action trail: %v\n", err)
+        writeError(w, http.StatusInternalServerError, "reading action trail")
+        return
+    }
+    more := len(actions) > lr.limit
+    if more {
+        actions = actions[:lr.limit]
+    }
+    if actions == nil {
+        actions = []auditAction{}
+    }
+    page := apiPage{Items: actions}
+    if more {
+        id := strconv.FormatInt(actions[len(actions)-1].ID, 10)
+        page.NextCursor = lr.cursor(id, id)
+    }
+    writeJSON(w, http.StatusOK, page)
+}
+
+// Print the destructive and administrative actions taken, newest first
+func auditCommand(args []string) {
+    fs := flag.NewFlagSet("audit", flag.ExitOnError)
+    dbOpts := dbFlags(fs)
+    actor := fs.String("actor", "", "Only actions of this actor, e.g. key:alice or cli:bob@host")
+    action := fs.String("action", "", "Only this action, e.g. favicon.delete or watchlist.remove")
+    since := fs.String("since", "", "Only actions at or after this time (RFC 3339 or YYYY-MM-DD)")
+    limit := fs.Int("limit", 100, "Most recent actions to print")
+    parseFlags(fs, args)
+
+    var clauses []string
+    var params []interface{}
+    if *actor != "" {
+        clauses, params = append(clauses, "actor = ?"), append(params, *actor)
+    }
+    if *action != "" {
+        clauses, params = append(clauses, "action = ?"), append(params, *action)
+    }
+    if *since != "" {
+        t, err := parseTimeParam("-since", *since)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+            return
+        }
+        clauses, params = append(clauses, "at >= ?"), append(params, t)
+    }
+
+    db, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer db.Close()
+
+    actions, err := selectActions(db, strings.Join(clauses, " AND "), "id DESC", *limit, params...)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error reading action trail: %v\n", err)
+        return
+    }
+    for _, a := range actions {
+        workspace := a.Workspace
+        if workspace == "" {
+            workspace = "-"
+        }
+        fmt.Printf("%s\t%s\t%s\t%s\t%s\t%s\n", a.At, a.Actor, workspace, a.Action, a.Subject, a.Detail)
+    }
+}
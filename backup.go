@@ -0,0 +1,148 @@
+package main
+
+import (
+    "compress/gzip"
+    "context"
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "github.com/klauspost/compress/zstd"
+)
+
+// Take a consistent copy of the database to tmp. SQLite copies in one read
+// transaction, which scans and daemons keep writing alongside; Postgres is
+// dumped by pg_dump in its custom format.
+func snapshotDatabase(ctx context.Context, dbOpts *dbOptions, from, tmp string) error {
+    if strings.HasPrefix(from, "postgres://") || strings.HasPrefix(from, "postgresql://") {
+        cmd := exec.CommandContext(ctx, "pg_dump", "--format=custom", "--file="+tmp, "--dbname="+from)
+        cmd.Stderr = os.Stderr
+        if err := cmd.Run(); err != nil {
+            return fmt.Errorf("pg_dump: %v", err)
+        }
+        return nil
+    }
+    if dbOpts.path == ":memory:" {
+        return fmt.Errorf("an in-memory database has nothing to back up")
+    }
+    db, err := dbOpts.openReadOnly()
+    if err != nil {
+        return err
+    }
+    defer db.Close()
+    return dumpDatabase(db, tmp)
+}
+
+// Copy src to dst through the -compress encoder
+func compressFile(src, dst, method string) error {
+    in, err := os.Open(src)
+    if err != nil {
+        return err
+    }
+    defer in.Close()
+    out, err := os.Create(dst)
+    if err != nil {
+        return err
+    }
+    var w io.WriteCloser
+    switch method {
+    case "gzip":
+        w = gzip.NewWriter(out)
+    case "zstd":
+        w, err = zstd.NewWriter(out)
+    }
+    if err == nil {
+        _, err = io.Copy(w, in)
+    }
+    if err == nil {
+        err = w.Close()
+    }
+    if closeErr := out.Close(); err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        os.Remove(dst)
+    }
+    return err
+}
+
+// Snapshot the database while it is in use, optionally compressed and uploaded
+func backupCommand(args []string) {
+    fs := flag.NewFlagSet("db backup", flag.ExitOnError)
+    dbOpts := dbFlags(fs)
+    from := fs.String("from", "", "postgres:// URL to back up with pg_dump instead of -db-path")
+    out := fs.String("out", "", "Backup file (default maplink-<timestamp>.db, or .dump for Postgres)")
+    compress := fs.String("compress", "", "Compress the backup: gzip or zstd")
+    s3Bucket := fs.String("s3-bucket", "", "Also upload the backup to this S3-compatible bucket")
+    s3Endpoint := fs.String("s3-endpoint", "", "S3-compatible endpoint URL (default: AWS for -s3-region)")
+    s3Region := fs.String("s3-region", "us-east-1", "S3 region (use auto for GCS)")
+    s3Prefix := fs.String("s3-prefix", "maplink/backups", "Key prefix of uploaded backups")
+    parseFlags(fs, args)
+
+    ext := map[string]string{"": "", "gzip": ".gz", "zstd": ".zst"}
+    if _, ok := ext[*compress]; !ok {
+        fmt.Fprintf(os.Stderr, "Error: unknown -compress %q (use gzip or zstd)\n", *compress)
+        return
+    }
+    var client *s3Client
+    if *s3Bucket != "" {
+        var err error
+        if client, err = newS3Client(*s3Endpoint, *s3Region, *s3Bucket); err != nil {
+            fmt.Fprintf(os.Stderr, "Error setting up upload: %v\n", err)
+            return
+        }
+    }
+    if *out == "" {
+        *out = "maplink-" + time.Now().UTC().Format("20060102T150405Z") + ".db"
+        if *from != "" {
+            *out = strings.TrimSuffix(*out, ".db") + ".dump"
+        }
+        *out += ext[*compress]
+    }
+
+    // Snapshot next to the destination, so a failed backup never leaves a
+    // partial file under the final name
+    tmp := *out + ".tmp"
+    os.Remove(tmp)
+    defer os.Remove(tmp)
+    started := time.Now()
+    if err := snapshotDatabase(signalContext(), dbOpts, *from, tmp); err != nil {
+        fmt.Fprintf(os.Stderr, "Error taking snapshot: %v\n", err)
+        return
+    }
+    var err error
+    if *compress != "" {
+        err = compressFile(tmp, *out, *compress)
+    } else {
+        err = os.Rename(tmp, *out)
+    }
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", *out, err)
+        return
+    }
+    info, err := os.Stat(*out)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", *out, err)
+        return
+    }
+    fmt.Printf("Backed up to %s (%d bytes) in %s\n", *out, info.Size(), time.Since(started).Round(time.Millisecond))
+
+    if client != nil {
+        data, err := os.ReadFile(*out)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", *out, err)
+            return
+        }
+        key := strings.Trim(*s3Prefix, "/") + "/" + filepath.Base(*out)
+        if err := client.put(key, "application/octet-stream", data); err != nil {
+            fmt.Fprintf(os.Stderr, "Error uploading %s: %v\n", *out, err)
+            return
+        }
+        fmt.Printf("Uploaded to s3://%s/%s\n", *s3Bucket, key)
+    }
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+    "sync"
+
+    "github.com/klauspost/compress/zstd"
+)
+
+// Encoding of compressed rows in favicon_blobs
+const blobZstd = "zstd"
+
+// Shared codecs; their EncodeAll and DecodeAll are safe for concurrent use
+var (
+    blobEncoder = sync.OnceValue(func() *zstd.Encoder {
+        e, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+        return e
+    })
+    blobDecoder = sync.OnceValue(func() *zstd.Decoder {
+        d, _ := zstd.NewReader(nil)
+        return d
+    })
+)
+
+// Icon bytes encoded for favicon_blobs, and how
+type encodedBlob struct {
+    data     []byte
+    encoding interface{}
+}
+
+func newEncodedBlob(data []byte) encodedBlob {
+    stored, encoding := encodeBlob(data)
+    return encodedBlob{data: stored, encoding: encoding}
+}
+
+// Bytes of an icon as they go into favicon_blobs, with their encoding. PNGs
+// and other formats that are compressed already often do not shrink; those
+// are kept as they are.
+func encodeBlob(data []byte) ([]byte, interface{}) {
+    if len(data) == 0 {
+        return data, nil
+    }
+    packed := blobEncoder().EncodeAll(data, nil)
+    if len(packed) >= len(data) {
+        return data, nil
+    }
+    return packed, blobZstd
+}
+
+// Icon bytes of a favicon_blobs row
+func decodeBlob(data []byte, encoding string) ([]byte, error) {
+    if encoding != blobZstd || data == nil {
+        return data, nil
+    }
+    return blobDecoder().DecodeAll(data, nil)
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "net/url"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// Legitimate icons of a brand: exact hashes and perceptual hashes, each
+// with a label saying where it came from
+type brandCorpus struct {
+    hashes  map[string]string
+    phashes []brandPHash
+}
+
+type brandPHash struct {
+    hash  uint64
+    label string
+}
+
+// Load a brand corpus: one "entry[,label]" per line, # for comments. An entry
+// is an MD5, SHA256 or MMH3 hash, "phash:" and 16 hex digits, or the path of
+// an icon file (relative to the corpus), which adds all of its hashes.
+func loadBrandCorpus(filename string) (*brandCorpus, error) {
+    file, err := os.Open(filename)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    c := &brandCorpus{hashes: map[string]string{}}
+    lines := bufio.NewScanner(file)
+    for n := 1; lines.Scan(); n++ {
+        line := strings.TrimSpace(lines.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        entry, label, _ := strings.Cut(line, ",")
+        entry, label = strings.TrimSpace(entry), strings.TrimSpace(label)
+        if label == "" {
+            label = entry
+        }
+        switch {
+        case strings.HasPrefix(strings.ToLower(entry), "phash:"):
+            h, err := strconv.ParseUint(entry[len("phash:"):], 16, 64)
+            if err != nil {
+                return nil, fmt.Errorf("%s:%d: invalid perceptual hash %q", filename, n, entry)
+            }
+            c.phashes = append(c.phashes, brandPHash{hash: h, label: label})
+        case isIconPath(filename, entry):
+            path := entry
+            if !filepath.IsAbs(path) {
+                path = filepath.Join(filepath.Dir(filename), path)
+            }
+            data, err := os.ReadFile(path)
+            if err != nil {
+                return nil, err
+            }
+            c.addIcon(data, label)
+        default:
+            c.hashes[strings.ToLower(entry)] = label
+        }
+    }
+    if err := lines.Err(); err != nil {
+        return nil, err
+    }
+    if len(c.hashes) == 0 && len(c.phashes) == 0 {
+        return nil, fmt.Errorf("%s has no brand icons", filename)
+    }
+    return c, nil
+}
+
+// Whether a corpus entry names an icon file rather than a hash
+func isIconPath(corpus, entry string) bool {
+    if !filepath.IsAbs(entry) {
+        entry = filepath.Join(filepath.Dir(corpus), entry)
+    }
+    info, err := os.Stat(entry)
+    return err == nil && !info.IsDir()
+}
+
+// Add the exact hashes of an icon and, when it decodes, its perceptual hash
+func (c *brandCorpus) addIcon(data []byte, label string) {
+    h := calculateHashes(data)
+    for _, hash := range []string{h.MD5, h.SHA256, h.MMH3} {
+        c.hashes[hash] = label
+    }
+    if img, err := decodeIcon(data); err == nil {
+        c.phashes = append(c.phashes, brandPHash{hash: perceptualHash(img), label: label})
+    }
+}
+
+// A scanned favicon that is, or looks like, one of the brand's icons
+type brandMatch struct {
+    Host        string `json:"host"`
+    Target      string `json:"target"`
+    URL         string `json:"url"`
+    FinalURL    string `json:"final_url,omitempty"`
+    MD5         string `json:"md5"`
+    SHA256      string `json:"sha256"`
+    MMH3        string `json:"mmh3"`
+    Match       string `json:"match"` // exact or perceptual
+    Brand       string `json:"brand"` // label of the corpus entry matched
+    Distance    int    `json:"distance"`
+    Permutation string `json:"permutation,omitempty"` // how typosquat made the domain
+}
+
+// Compare a favicon with the corpus; data may be nil when only hashes are known
+func (c *brandCorpus) match(res result, data []byte, threshold int) (brandMatch, bool) {
+    m := brandMatch{Host: hostOf(res.Target), Target: res.Target, URL: res.URL, FinalURL: res.FinalURL, MD5: res.MD5, SHA256: res.SHA256, MMH3: res.MMH3}
+    for _, h := range []string{res.MMH3, res.MD5, res.SHA256} {
+        if label, ok := c.hashes[h]; ok && h != "" {
+            m.Match, m.Brand = "exact", label
+            return m, true
+        }
+    }
+    if len(c.phashes) == 0 || len(data) == 0 {
+        return m, false
+    }
+    img, err := decodeIcon(data)
+    if err != nil {
+        return m, false
+    }
+    hash, best := perceptualHash(img), -1
+    for _, p := range c.phashes {
+        if d := hammingDistance(hash, p.hash); d <= threshold && (best < 0 || d < best) {
+            best, m.Brand = d, p.label
+        }
+    }
+    if best < 0 {
+        return m, false
+    }
+    m.Match, m.Distance = "perceptual", best
+    return m, true
+}
+
+// Host name of a URL, or the string itself when it has none
+func hostOf(link string) string {
+    if u, err := url.Parse(link); err == nil && u.Hostname() != "" {
+        return strings.ToLower(u.Hostname())
+    }
+    return strings.ToLower(link)
+}
+
+// Domains allowed to use the brand's icons, with their subdomains
+type domainAllowlist []string
+
+func (a domainAllowlist) allows(host string) bool {
+    host = strings.TrimSuffix(strings.ToLower(host), ".")
+    for _, d := range a {
+        if host == d || strings.HasSuffix(host, "."+d) {
+            return true
+        }
+    }
+    return false
+}
+
+// Allowlist from a comma-separated list and a file of one domain per line
+func loadAllowlist(list, filename string) (domainAllowlist, error) {
+    domains, err := listAndFile(list, filename)
+    if err != nil {
+        return nil, err
+    }
+    var a domainAllowlist
+    for _, d := range domains {
+        a = append(a, strings.TrimPrefix(strings.TrimSuffix(strings.ToLower(d), "."), "*."))
+    }
+    return a, nil
+}
+
+// Sink flagging scanned favicons that match the brand on hosts outside the
+// allowlist. Favicons answered with 304 carry no bytes, so the stored blob
+// is read for the perceptual comparison.
+type brandSink struct {
+    corpus    *brandCorpus
+    allow     domainAllowlist
+    threshold int
+    stored    func(link string) []byte
+
+    mu      sync.Mutex
+    matches []brandMatch
+}
+
+func (b *brandSink) Write(res result) error {
+    data := res.Data
+    if len(data) == 0 && res.NotModified && len(b.corpus.phashes) > 0 {
+        data = b.stored(res.URL)
+    }
+    m, ok := b.corpus.match(res, data, b.threshold)
+    if !ok || b.allow.allows(m.Host) || (res.FinalHost != "" && b.allow.allows(res.FinalHost)) {
+        return nil
+    }
+    b.mu.Lock()
+    b.matches = append(b.matches, m)
+    b.mu.Unlock()
+    return nil
+}
+
+func (b *brandSink) Close() error {
+    return nil
+}
+
+// Flagged favicons, the closest first and one per host and icon
+func (b *brandSink) results() []brandMatch {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    seen := map[string]bool{}
+    var out []brandMatch
+    for _, m := range b.matches {
+        if key := m.Host + " " + m.URL; !seen[key] {
+            seen[key] = true
+            out = append(out, m)
+        }
+    }
+    sort.SliceStable(out, func(i, j int) bool {
+        if out[i].Distance != out[j].Distance {
+            return out[i].Distance < out[j].Distance
+        }
+        return out[i].Host < out[j].Host
+    })
+    return out
+}
+
+// Print flagged favicons as text or JSON lines
+func printBrandMatches(matches []brandMatch, format string) {
+    if format == "json" {
+        enc := json.NewEncoder(os.Stdout)
+        for _, m := range matches {
+            enc.Encode(m)
+        }
+        return
+    }
+    if len(matches) == 0 {
+        fmt.Println("No lookalike favicons found.")
+        return
+    }
+    fmt.Printf("%d lookalike favicons:\n", len(matches))
+    for _, m := range matches {
+        how := "exact match"
+        if m.Match == "perceptual" {
+            how = fmt.Sprintf("perceptual match, distance %d", m.Distance)
+        }
+        if m.Permutation != "" {
+            how += ", " + m.Permutation
+        }
+        fmt.Printf("  %s: %s (%s of %s)\n", m.Host, m.URL, how, m.Brand)
+    }
+}
+
+// Scan candidate domains and flag those serving the brand's favicon, or one
+// close to it, without being on the allowlist
+func brandCommand(args []string) {
+    fs := flag.NewFlagSet("brand", flag.ExitOnError)
+    corpusFile := fs.String("corpus", "", "File of the brand's legitimate favicons: hashes, phash:HEX perceptual hashes or icon files")
+    filename := fs.String("file", "", "File of candidate domains or URLs to check")
+    allow := fs.String("allow", "", "Comma-separated domains allowed to use the brand's icons, with their subdomains")
+    allowFile := fs.String("allow-file", "", "File of allowed domains, one per line")
+    threshold := fs.Int("threshold", 6, "Maximum perceptual hash distance (bits) counted as a lookalike")
+    report := fs.String("report", "text", "Format of the lookalike report printed after the scan: text or json")
+    dbOpts := dbFlags(fs)
+    opts := registerScanFlags(fs)
+    parseFlags(fs, args)
+
+    if *corpusFile == "" || *filename == "" {
+        fmt.Println("Usage: maplink brand -corpus brand.txt -file candidates.txt [-allow example.com]")
+        return
+    }
+    corpus, err := loadBrandCorpus(*corpusFile)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error reading brand corpus: %v\n", err)
+        return
+    }
+    allowed, err := loadAllowlist(*allow, *allowFile)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error reading allowlist: %v\n", err)
+        return
+    }
+    targets, err := readTargets(*filename)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error reading candidates: %v\n", err)
+        return
+    }
+
+    db, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer db.Close()
+
+    s, err := opts.newScanner(db, dbOpts.workspace)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error setting up scan: %v\n", err)
+        return
+    }
+    flagged := &brandSink{corpus: corpus, allow: allowed, threshold: *threshold, stored: func(link string) []byte {
+        records, err := queryRecords(db, dbOpts.workspace, true, "f.link = ?", link)
+        if err != nil || len(records) == 0 {
+            return nil
+        }
+        return records[0].Data
+    }}
+    s.sinks = append(s.sinks, flagged)
+    s.scanTargets(signalContext(), targets)
+    s.close()
+
+    printBrandMatches(flagged.results(), *report)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Error of requests to a host whose circuit breaker is open
+type circuitOpenError struct {
+    host  string
+    until time.Time
+}
+
+func (e *circuitOpenError) Error() string {
+    return fmt.Sprintf("circuit breaker open for %s until %s", e.host, e.until.Format(time.TimeOnly))
+}
+
+// HTTP transport that stops sending requests to a host after limit
+// consecutive failures, for cooldown. The first request after the cooldown
+// goes through; another failure opens the breaker again.
+type circuitBreaker struct {
+    next     http.RoundTripper
+    limit    int
+    cooldown time.Duration
+    mu       sync.Mutex
+    hosts    map[string]*hostCircuit
+}
+
+// Failures in a row of one host and when its breaker closes again
+type hostCircuit struct {
+    failures  int
+    openUntil time.Time
+}
+
+func newCircuitBreaker(next http.RoundTripper, limit int, cooldown time.Duration) *circuitBreaker {
+    return &circuitBreaker{next: next, limit: limit, cooldown: cooldown, hosts: map[string]*hostCircuit{}}
+}
+
+// Whether requests to host are being refused, and until when; never with
+// no breaker configured
+func (b *circuitBreaker) open(host string) (time.Time, bool) {
+    if b == nil {
+        return time.Time{}, false
+    }
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    c, ok := b.hosts[host]
+    if !ok || !time.Now().Before(c.openUntil) {
+        return time.Time{}, false
+    }
+    return c.openUntil, true
+}
+
+// Count a request's outcome against its host
+func (b *circuitBreaker) record(host string, failed bool) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    c, ok := b.hosts[host]
+    if !failed {
+        if ok {
+            delete(b.hosts, host)
+        }
+        return
+    }
+    if !ok {
+        c = &hostCircuit{}
+        b.hosts[host] = c
+    }
+    c.failures++
+    if c.failures >= b.limit {
+        // Left one short, so a failed request after the cooldown reopens it
+        c.failures, c.openUntil = b.limit-1, time.Now().Add(b.cooldown)
+        infof("Circuit breaker open for %s: %d failures in a row, deferring it for %s\n", host, b.limit, b.cooldown)
+    }
+}
+
+// Host name of a target, whether a URL or a bare host
+func targetHost(target string) string {
+    if host, _, ok := bareHost(target); ok {
+        return strings.ToLower(host)
+    }
+    return hostOf(target)
+}
+
+func (b *circuitBreaker) RoundTrip(req *http.Request) (*http.Response, error) {
+    host := req.URL.Hostname()
+    if until, ok := b.open(host); ok {
+        return nil, &circuitOpenError{host: host, until: until}
+    }
+    resp, err := b.next.RoundTrip(req)
+    // Requests the scan itself cancelled say nothing about the host; timeouts do
+    if !errors.Is(err, context.Canceled) {
+        b.record(host, err != nil)
+    }
+    return resp, err
+}
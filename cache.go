@@ -0,0 +1,96 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/binary"
+    "net/http"
+    "net/http/httputil"
+    "time"
+
+    bolt "go.etcd.io/bbolt"
+)
+
+// Bucket holding cached responses keyed by URL
+var cacheBucket = []byte("responses")
+
+// HTTP transport that answers GET requests from an on-disk cache while the
+// stored response is younger than ttl, and stores fresh 200 responses
+type cacheTransport struct {
+    db   *bolt.DB
+    ttl  time.Duration
+    next http.RoundTripper
+}
+
+// Open the cache file and wrap next with it
+func newCacheTransport(path string, ttl time.Duration, next http.RoundTripper) (*cacheTransport, error) {
+    db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+    if err != nil {
+        return nil, err
+    }
+    err = db.Update(func(tx *bolt.Tx) error {
+        _, err := tx.CreateBucketIfNotExists(cacheBucket)
+        return err
+    })
+    if err != nil {
+        db.Close()
+        return nil, err
+    }
+    return &cacheTransport{db: db, ttl: ttl, next: next}, nil
+}
+
+func (c *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    if req.Method != http.MethodGet {
+        return c.next.RoundTrip(req)
+    }
+    key := []byte(req.URL.String())
+    if resp := c.lookup(key, req); resp != nil {
+        return resp, nil
+    }
+
+    resp, err := c.next.RoundTrip(req)
+    if err != nil || resp.StatusCode != http.StatusOK {
+        return resp, err
+    }
+    // Reading the body here buffers it; DumpResponse leaves resp readable
+    dump, err := httputil.DumpResponse(resp, true)
+    if err != nil {
+        return resp, nil
+    }
+    entry := make([]byte, 8, 8+len(dump))
+    binary.BigEndian.PutUint64(entry, uint64(time.Now().UnixNano()))
+    entry = append(entry, dump...)
+    c.db.Update(func(tx *bolt.Tx) error {
+        return tx.Bucket(cacheBucket).Put(key, entry)
+    })
+    return resp, nil
+}
+
+// Return the cached response for key if it is still fresh
+func (c *cacheTransport) lookup(key []byte, req *http.Request) *http.Response {
+    var dump []byte
+    c.db.View(func(tx *bolt.Tx) error {
+        entry := tx.Bucket(cacheBucket).Get(key)
+        if len(entry) < 8 {
+            return nil
+        }
+        stored := time.Unix(0, int64(binary.BigEndian.Uint64(entry[:8])))
+        if time.Since(stored) < c.ttl {
+            // Values are only valid inside the transaction
+            dump = append([]byte(nil), entry[8:]...)
+        }
+        return nil
+    })
+    if dump == nil {
+        return nil
+    }
+    resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(dump)), req)
+    if err != nil {
+        return nil
+    }
+    return resp
+}
+
+func (c *cacheTransport) Close() error {
+    return c.db.Close()
+}
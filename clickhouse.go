@@ -0,0 +1,187 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// ClickHouse table layout for results
+const clickhouseSchema = `CREATE TABLE IF NOT EXISTS %s (
+    run_id String,
+    target String,
+    url String,
+    host LowCardinality(String),
+    md5 String,
+    sha256 String,
+    mmh3 Int32,
+    content_type LowCardinality(String),
+    size UInt32,
+    status LowCardinality(String),
+    timestamp DateTime64(3, 'UTC'),
+    run_labels Map(String, String)
+) ENGINE = MergeTree
+ORDER BY (mmh3, host, timestamp)`
+
+// Columns added since, for tables created before them
+const clickhouseUpgrade = `ALTER TABLE %s ADD COLUMN IF NOT EXISTS run_labels Map(String, String)`
+
+// Row as sent in JSONEachRow format
+type clickhouseRow struct {
+    RunID       string            `json:"run_id"`
+    Target      string            `json:"target"`
+    URL         string            `json:"url"`
+    Host        string            `json:"host"`
+    MD5         string            `json:"md5"`
+    SHA256      string            `json:"sha256"`
+    MMH3        int32             `json:"mmh3"`
+    ContentType string            `json:"content_type"`
+    Size        int               `json:"size"`
+    Status      string            `json:"status"`
+    Timestamp   string            `json:"timestamp"`
+    RunLabels   map[string]string `json:"run_labels"`
+}
+
+// Stores results in ClickHouse over its HTTP interface. Rows are buffered
+// and sent in batches with async_insert so the server can merge them further.
+type clickhouseSink struct {
+    endpoint string // base URL including credentials and database parameters
+    table    string
+    runID    string
+    batch    int
+
+    mu     sync.Mutex
+    rows   []clickhouseRow
+    ticker *time.Ticker
+    done   chan struct{}
+}
+
+// Connect, create the table and start the periodic flusher
+func newClickhouseSink(endpoint, table, runID string, batch int, interval time.Duration) (*clickhouseSink, error) {
+    c := &clickhouseSink{endpoint: endpoint, table: table, runID: runID, batch: batch, done: make(chan struct{})}
+    if err := c.exec(fmt.Sprintf(clickhouseSchema, table), nil); err != nil {
+        return nil, fmt.Errorf("creating ClickHouse table: %v", err)
+    }
+    if err := c.exec(fmt.Sprintf(clickhouseUpgrade, table), nil); err != nil {
+        return nil, fmt.Errorf("upgrading ClickHouse table: %v", err)
+    }
+
+    c.ticker = time.NewTicker(interval)
+    go func() {
+        for {
+            select {
+            case <-c.ticker.C:
+                if err := c.flush(); err != nil {
+                    fmt.Fprintf(os.Stderr, "Error writing to ClickHouse: %v\n", err)
+                }
+            case <-c.done:
+                return
+            }
+        }
+    }()
+    return c, nil
+}
+
+// Run a query, with an optional body appended as data
+func (c *clickhouseSink) exec(query string, body []byte) error {
+    u, err := url.Parse(c.endpoint)
+    if err != nil {
+        return err
+    }
+    params := u.Query()
+    params.Set("query", query)
+    if body != nil {
+        params.Set("async_insert", "1")
+        params.Set("wait_for_async_insert", "1")
+    }
+    u.RawQuery = params.Encode()
+
+    req, err := http.NewRequest("POST", u.String(), bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    if u.User != nil {
+        password, _ := u.User.Password()
+        req.SetBasicAuth(u.User.Username(), password)
+        u.User = nil
+        req.URL = u
+    }
+
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+        return fmt.Errorf("error: status code %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+    }
+    return nil
+}
+
+func (c *clickhouseSink) Write(r result) error {
+    mmh3, _ := strconv.ParseInt(r.MMH3, 10, 32)
+    row := clickhouseRow{
+        RunID:       c.runID,
+        Target:      r.Target,
+        URL:         r.URL,
+        Host:        r.Host,
+        MD5:         r.MD5,
+        SHA256:      r.SHA256,
+        MMH3:        int32(mmh3),
+        ContentType: r.ContentType,
+        Size:        r.Size,
+        Status:      r.Status,
+        Timestamp:   r.Timestamp.UTC().Format("2006-01-02 15:04:05.000"),
+        RunLabels:   map[string]string{},
+    }
+    for k, v := range r.RunLabels {
+        row.RunLabels[k] = v
+    }
+
+    c.mu.Lock()
+    c.rows = append(c.rows, row)
+    full := len(c.rows) >= c.batch
+    c.mu.Unlock()
+
+    if full {
+        return c.flush()
+    }
+    return nil
+}
+
+// Send buffered rows as one INSERT
+func (c *clickhouseSink) flush() error {
+    c.mu.Lock()
+    rows := c.rows
+    c.rows = nil
+    c.mu.Unlock()
+    if len(rows) == 0 {
+        return nil
+    }
+
+    var body bytes.Buffer
+    enc := json.NewEncoder(&body)
+    for _, row := range rows {
+        if err := enc.Encode(row); err != nil {
+            return err
+        }
+    }
+    return c.exec("INSERT INTO "+c.table+" FORMAT JSONEachRow", body.Bytes())
+}
+
+// Stop the flusher and send what is left
+func (c *clickhouseSink) Close() error {
+    c.ticker.Stop()
+    close(c.done)
+    return c.flush()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+    "context"
+    "crypto/tls"
+    "net"
+    "net/http"
+    "net/url"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Transport under every request. One instance is shared by the whole scan
+// so the page and favicon requests of a host reuse kept-alive connections
+// and resumed TLS sessions instead of paying a handshake each. The
+// context's target proxy wins over the environment's HTTP_PROXY/HTTPS_PROXY.
+func newBaseTransport() *http.Transport {
+    dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+    return &http.Transport{
+        Proxy: func(req *http.Request) (*url.URL, error) {
+            if p := targetFrom(req.Context()).Proxy; p != "" {
+                return url.Parse(p)
+            }
+            return http.ProxyFromEnvironment(req)
+        },
+        DialContext:           dialer.DialContext,
+        ForceAttemptHTTP2:     true,
+        MaxIdleConns:          1000,
+        MaxIdleConnsPerHost:   4,
+        IdleConnTimeout:       90 * time.Second,
+        TLSHandshakeTimeout:   10 * time.Second,
+        ExpectContinueTimeout: time.Second,
+        TLSClientConfig:       &tls.Config{ClientSessionCache: tls.NewLRUClientSessionCache(1024)},
+    }
+}
+
+// Size the connection pool for the scan: enough idle connections per host
+// for every worker that may hit it, and a dial timeout of dialTimeout
+func tuneTransport(tr *http.Transport, workers, hostConcurrency int, dialTimeout time.Duration) {
+    perHost := max(workers, 4)
+    if hostConcurrency > 0 {
+        perHost = hostConcurrency
+    }
+    tr.MaxIdleConnsPerHost = perHost
+    tr.MaxIdleConns = max(1000, workers*2)
+    tr.DialContext = (&net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}).DialContext
+}
+
+// Sends requests for a target's own host to its connect address instead of
+// resolving the name, as curl --resolve does, and with its SNI override, so
+// an origin can be asked for each of its virtual hosts. Every address and
+// server name gets its own clone of the base transport, so kept-alive
+// connections made for one never carry another's requests.
+type originTransport struct {
+    base   *http.Transport
+    mu     sync.Mutex
+    byAddr map[string]*http.Transport
+}
+
+func newOriginTransport(base *http.Transport) *originTransport {
+    return &originTransport{base: base, byAddr: map[string]*http.Transport{}}
+}
+
+func (o *originTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    t := targetFrom(req.Context())
+    if (t.Connect == "" && t.SNI == "") || !t.ownHost(req.URL) {
+        o.mu.Lock()
+        base := o.base
+        o.mu.Unlock()
+        return base.RoundTrip(req)
+    }
+    return o.transport(t.Connect, t.SNI).RoundTrip(req)
+}
+
+// Send later requests over base, dropping the idle connections of the
+// transports before it
+func (o *originTransport) reset(base *http.Transport) {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    o.base.CloseIdleConnections()
+    for _, tr := range o.byAddr {
+        tr.CloseIdleConnections()
+    }
+    o.base, o.byAddr = base, map[string]*http.Transport{}
+}
+
+// Transport dialing addr, if set, keeping the requested port unless addr
+// has one, and presenting sni, if set, as the TLS server name
+func (o *originTransport) transport(addr, sni string) *http.Transport {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    key := addr + " " + sni
+    if tr, ok := o.byAddr[key]; ok {
+        return tr
+    }
+    tr := o.base.Clone()
+    if sni != "" {
+        tr.TLSClientConfig = tr.TLSClientConfig.Clone()
+        tr.TLSClientConfig.ServerName = sni
+    }
+    o.byAddr[key] = tr
+    if addr == "" {
+        return tr
+    }
+    tr.Proxy = nil
+    dial := tr.DialContext
+    tr.DialContext = func(ctx context.Context, network, hostport string) (net.Conn, error) {
+        _, port, _ := net.SplitHostPort(hostport)
+        host, p, err := net.SplitHostPort(addr)
+        if err != nil {
+            host = strings.Trim(addr, "[]")
+        } else {
+            port = p
+        }
+        return dial(ctx, network, net.JoinHostPort(host, port))
+    }
+    return tr
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "net"
+    "os"
+    "sort"
+    "strings"
+
+    "golang.org/x/net/publicsuffix"
+)
+
+// Hosts sharing the same (or a perceptually similar) favicon
+type cluster struct {
+    Hashes  []string // SHA256 of every icon in the cluster
+    Records []record
+    Apexes  []string
+}
+
+// Registrable domain of a host, or the host itself for IPs and unknown suffixes
+func apexDomain(host string) string {
+    if host == "" || net.ParseIP(host) != nil {
+        return host
+    }
+    apex, err := publicsuffix.EffectiveTLDPlusOne(strings.TrimSuffix(strings.ToLower(host), "."))
+    if err != nil {
+        return host
+    }
+    return apex
+}
+
+// Group records by exact SHA256, or merge icons whose perceptual hashes are
+// within threshold bits of each other, and rank the result by size
+func buildClusters(records []record, perceptual bool, threshold int) []cluster {
+    order, groups := groupByHash(records)
+
+    // Union-find over distinct icons
+    parent := make([]int, len(order))
+    for i := range parent {
+        parent[i] = i
+    }
+    var find func(int) int
+    find = func(i int) int {
+        if parent[i] != i {
+            parent[i] = find(parent[i])
+        }
+        return parent[i]
+    }
+
+    if perceptual {
+        hashes := make([]uint64, len(order))
+        valid := make([]bool, len(order))
+        for i, sha := range order {
+            if img, err := decodeIcon(groups[sha][0].Data); err == nil {
+                hashes[i], valid[i] = perceptualHash(img), true
+            }
+        }
+        for i := range order {
+            for j := i + 1; j < len(order); j++ {
+                if valid[i] && valid[j] && hammingDistance(hashes[i], hashes[j]) <= threshold {
+                    parent[find(j)] = find(i)
+                }
+            }
+        }
+    }
+
+    byRoot := map[int]*cluster{}
+    var roots []int
+    for i, sha := range order {
+        root := find(i)
+        c, ok := byRoot[root]
+        if !ok {
+            c = &cluster{}
+            byRoot[root] = c
+            roots = append(roots, root)
+        }
+        c.Hashes = append(c.Hashes, sha)
+        c.Records = append(c.Records, groups[sha]...)
+    }
+
+    var clusters []cluster
+    for _, root := range roots {
+        c := byRoot[root]
+        seen := map[string]struct{}{}
+        for _, r := range c.Records {
+            apex := r.apex()
+            if _, ok := seen[apex]; !ok && apex != "" {
+                seen[apex] = struct{}{}
+                c.Apexes = append(c.Apexes, apex)
+            }
+        }
+        sort.Strings(c.Apexes)
+        clusters = append(clusters, *c)
+    }
+
+    sort.SliceStable(clusters, func(i, j int) bool {
+        if len(clusters[i].Records) != len(clusters[j].Records) {
+            return len(clusters[i].Records) > len(clusters[j].Records)
+        }
+        return len(clusters[i].Apexes) > len(clusters[j].Apexes)
+    })
+    return clusters
+}
+
+// Print clusters of hosts sharing a favicon
+func clusterCommand(args []string) {
+    fs := flag.NewFlagSet("cluster", flag.ExitOnError)
+    dbOpts := dbFlags(fs)
+    perceptual := fs.Bool("perceptual", false, "Also merge visually similar icons using a perceptual hash")
+    threshold := fs.Int("threshold", 6, "Maximum perceptual hash distance (bits) for -perceptual")
+    minSize := fs.Int("min-size", 2, "Only show clusters with at least this many hosts")
+    multiApex := fs.Bool("multi-apex", false, "Only show clusters spanning more than one apex domain")
+    parseFlags(fs, args)
+
+    db, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer db.Close()
+
+    records, err := loadRecords(db, dbOpts.workspace, *perceptual)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error reading database: %v\n", err)
+        return
+    }
+
+    n := 0
+    for _, c := range buildClusters(records, *perceptual, *threshold) {
+        if len(c.Records) < *minSize || (*multiApex && len(c.Apexes) < 2) {
+            continue
+        }
+        n++
+        marker := ""
+        if len(c.Apexes) > 1 {
+            marker = " [MULTI-APEX]"
+        }
+        fmt.Printf("Cluster %d: %d hosts, %d icons, %d apex domains%s\n", n, len(c.Records), len(c.Hashes), len(c.Apexes), marker)
+        fmt.Printf("  MD5: %s | MMH3: %s\n", c.Records[0].MD5, c.Records[0].MMH3)
+        fmt.Printf("  Apex domains: %s\n", strings.Join(c.Apexes, ", "))
+        for _, r := range c.Records {
+            fmt.Printf("  %s\n", r.Link)
+        }
+    }
+    if n == 0 {
+        fmt.Println("No clusters found.")
+    }
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+    "fmt"
+    "io"
+    "os"
+    "strings"
+)
+
+// A subcommand: its name, the line maplink help gives it, and its entry point
+type command struct {
+    name    string
+    summary string
+    run     func(args []string)
+}
+
+// A heading of maplink help and its commands
+type commandGroup struct {
+    title    string
+    commands []command
+}
+
+// Every subcommand, grouped as maplink help lists them. A function, not a
+// variable, because help itself refers to the list.
+func commandGroups() []commandGroup {
+    return []commandGroup{
+        {"Scanning", []command{
+            {"scan", "Fetch and hash the favicons of targets from -file or stdin, once or as a -daemon", scanCommand},
+            {"retry-failed", "Scan again the targets that failed in a run", retryFailedCommand},
+            {"verify", "Re-download stored favicons and check their recorded hashes", verifyCommand},
+            {"local", "Hash icon files already on disk", localCommand},
+            {"vhost", "Hash the favicon every virtual host of an origin serves", vhostCommand},
+            {"brand", "Flag candidate domains serving a brand's favicon", brandCommand},
+            {"typosquat", "Scan lookalikes of a domain for its favicon", typosquatCommand},
+            {"ct", "Scan hosts as certificate transparency logs publish them", ctCommand},
+            {"coordinator", "Hand targets to workers through Redis and store their results", coordinatorCommand},
+            {"worker", "Scan targets from the Redis queue for a coordinator", workerCommand},
+        }},
+        {"Results", []command{
+            {"query", "Search stored favicons", queryCommand},
+            {"stats", "Summary statistics of stored favicons and runs", statsCommand},
+            {"cluster", "Hosts sharing a favicon", clusterCommand},
+            {"hosts", "Hosts and the icons they served", hostsCommand},
+            {"hunt", "Watch hashes and find where they were seen", huntCommand},
+            {"tag", "Tag hosts and hashes", tagCommand},
+            {"note", "Notes on hosts and hashes", noteCommand},
+            {"dns", "DNS records stored by -dns-records and -ptr", dnsCommand},
+            {"sans", "Names on the certificates of scanned hosts", sansCommand},
+            {"tech", "Technologies identified per host", techCommand},
+            {"timings", "Targets whose requests took longest", timingsCommand},
+            {"tui", "Browse stored results interactively", tuiCommand},
+            {"report", "Write an HTML or Markdown report", reportCommand},
+            {"export", "Export results: " + strings.Join(exportFormatNames(), ", "), exportCommand},
+            {"sign", "Create signing keys, sign reports and exports, and verify signatures", signCommand},
+        }},
+        {"Server", []command{
+            {"serve", "Serve the HTTP API and scan the jobs submitted to it", serveCommand},
+            {"apikey", "Manage API keys and read the audit trail", apiKeyCommand},
+            {"openapi", "Print the OpenAPI document or write the Go client", openAPICommand},
+        }},
+        {"Database", []command{
+            {"db", "Maintain, migrate and back up the database", dbCommand},
+            {"workspace", "Manage workspaces", workspaceCommand},
+            {"merge", "Merge result databases from several scanning boxes", mergeCommand},
+            {"audit", "Destructive and administrative actions, who took them and when", auditCommand},
+        }},
+    }
+}
+
+// Formats of maplink export
+func exportFormats() []command {
+    return []command{
+        {"graph", "The host <-> favicon graph as DOT or GraphML", graphCommand},
+        {"maltego", "Maltego local transforms", maltegoCommand},
+        {"misp", "A MISP event, to a file or straight to MISP", mispCommand},
+        {"stix", "A STIX 2.1 bundle", stixCommand},
+        {"nuclei", "Nuclei templates for interesting clusters", nucleiCommand},
+        {"parquet", "Parquet files for DuckDB, Athena or Spark", parquetCommand},
+    }
+}
+
+func exportFormatNames() []string {
+    var names []string
+    for _, c := range exportFormats() {
+        names = append(names, c.name)
+    }
+    return names
+}
+
+func findCommand(commands []command, name string) (command, bool) {
+    for _, c := range commands {
+        if c.name == name {
+            return c, true
+        }
+    }
+    return command{}, false
+}
+
+// Every command by name, with the export formats under their older
+// top-level names too
+func allCommands() []command {
+    var all []command
+    for _, g := range commandGroups() {
+        all = append(all, g.commands...)
+    }
+    return append(all, exportFormats()...)
+}
+
+func printUsage(w io.Writer) {
+    fmt.Fprintln(w, "Usage: maplink <command> [flags]")
+    for _, g := range commandGroups() {
+        fmt.Fprintf(w, "\n%s:\n", g.title)
+        for _, c := range g.commands {
+            fmt.Fprintf(w, "  %-13s %s\n", c.name, c.summary)
+        }
+    }
+    fmt.Fprintln(w, "\nRun maplink <command> -h for its flags. Flags without a command, as in maplink -file urls.txt, run scan.")
+}
+
+// Export stored results for another tool
+func exportCommand(args []string) {
+    if len(args) == 0 || isHelp(args[0]) {
+        fmt.Fprintln(os.Stderr, "Usage: maplink export <format> [flags]")
+        fmt.Fprintln(os.Stderr)
+        for _, c := range exportFormats() {
+            fmt.Fprintf(os.Stderr, "  %-9s %s\n", c.name, c.summary)
+        }
+        return
+    }
+    c, ok := findCommand(exportFormats(), args[0])
+    if !ok {
+        fmt.Fprintf(os.Stderr, "Unknown export format %q (use %s)\n", args[0], strings.Join(exportFormatNames(), ", "))
+        os.Exit(2)
+    }
+    c.run(args[1:])
+}
+
+// Main function
+func main() {
+    args := os.Args[1:]
+    // Flags alone, or nothing at all (targets piped in), scan as before there were subcommands
+    if len(args) == 0 || (strings.HasPrefix(args[0], "-") && !isHelp(args[0])) {
+        scanCommand(args)
+        return
+    }
+    if isHelp(args[0]) {
+        if len(args) > 1 {
+            if c, ok := findCommand(allCommands(), args[1]); ok {
+                c.run([]string{"-h"})
+                return
+            }
+        }
+        printUsage(os.Stdout)
+        return
+    }
+    c, ok := findCommand(allCommands(), args[0])
+    if !ok {
+        fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", args[0])
+        printUsage(os.Stderr)
+        os.Exit(2)
+    }
+    c.run(args[1:])
+}
+
+func isHelp(arg string) bool {
+    return arg == "help" || arg == "-h" || arg == "-help" || arg == "--help"
+}
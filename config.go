@@ -0,0 +1,219 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "os"
+    "sort"
+    "strings"
+
+    "gopkg.in/yaml.v3"
+)
+
+// Config file used when -config is not given, if it exists
+const defaultConfigPath = "maplink.yaml"
+
+// Flag values from a config file. Top-level keys may only be global keys
+// and apply to every command that has the flag; a mapping under a command
+// name (e.g. "report" or "db maintain") applies to that command only and
+// wins. Named profiles under "profiles" have the same shape and, when
+// selected with -profile, override the rest of the file.
+type config map[string]interface{}
+
+// Flags that mean the same thing to every command taking them: the
+// database, and where alerts and results are sent. Others, like -o or
+// -format, differ per command and are only read from its own section.
+var globalKeys = map[string]bool{
+    "db-path":          true,
+    "db-key":           true,
+    "db-keyfile":       true,
+    "db-batch":         true,
+    "db-queue":         true,
+    "workspace":        true,
+    "slack-webhook":    true,
+    "discord-webhook":  true,
+    "telegram-token":   true,
+    "telegram-chat":    true,
+    "kafka-brokers":    true,
+    "kafka-topic":      true,
+    "clickhouse-url":   true,
+    "clickhouse-table": true,
+    "sign-key":         true,
+    "chrome-path":      true,
+    "no-color":         true,
+}
+
+// Load a YAML config file
+func loadConfig(path string) (config, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    // A plain map, so nested sections decode as map[string]interface{} too
+    raw := map[string]interface{}{}
+    if err := yaml.Unmarshal(data, &raw); err != nil {
+        return nil, fmt.Errorf("parsing %s: %v", path, err)
+    }
+    return config(raw), nil
+}
+
+// A flag given once per value, like -label. A config list sets it once per
+// item rather than as one comma-separated value.
+type repeatedFlag interface {
+    flag.Value
+    repeated()
+}
+
+// Render a YAML value as flag values: one, or the items of a list
+func configValue(v interface{}) ([]string, bool) {
+    switch v := v.(type) {
+    case nil:
+        return nil, false
+    case map[string]interface{}:
+        return nil, false
+    case []interface{}:
+        items := make([]string, 0, len(v))
+        for _, item := range v {
+            items = append(items, fmt.Sprint(item))
+        }
+        return items, true
+    default:
+        return []string{fmt.Sprint(v)}, true
+    }
+}
+
+// Flag values the config gives a command, section values overriding top-level ones
+func (c config) values(command string) map[string][]string {
+    values := map[string][]string{}
+    c.merge(values, command)
+    return values
+}
+
+// Add this config's values for a command on top of values
+func (c config) merge(values map[string][]string, command string) {
+    for key, v := range c {
+        if s, ok := configValue(v); ok && globalKeys[key] {
+            values[key] = s
+        }
+    }
+    if section, ok := c[command].(map[string]interface{}); ok {
+        for key, v := range section {
+            if s, ok := configValue(v); ok {
+                values[key] = s
+            }
+        }
+    }
+}
+
+// A named profile from the profiles section
+func (c config) profile(name string) (config, error) {
+    profiles, _ := c["profiles"].(map[string]interface{})
+    p, ok := profiles[name].(map[string]interface{})
+    if !ok {
+        var names []string
+        for n := range profiles {
+            names = append(names, n)
+        }
+        sort.Strings(names)
+        return nil, fmt.Errorf("no profile %q in config (have: %s)", name, strings.Join(names, ", "))
+    }
+    return config(p), nil
+}
+
+// Environment variable for a command's flag: MAPLINK_ plus the upper-cased
+// flag name for a global key, or the command and flag names for any other,
+// with dashes and spaces as underscores
+func envName(command, flagName string) string {
+    name := flagName
+    if !globalKeys[flagName] {
+        name = command + "_" + flagName
+    }
+    return "MAPLINK_" + strings.ToUpper(strings.NewReplacer("-", "_", " ", "_").Replace(name))
+}
+
+// Parse command-line flags, then fill every flag not given on the command
+// line from MAPLINK_* environment variables and then from the config file.
+// Like flag.ExitOnError, invalid values exit with status 2.
+func parseFlags(fs *flag.FlagSet, args []string) {
+    configPath := fs.String("config", os.Getenv("MAPLINK_CONFIG"), "YAML file of flag values (default ./"+defaultConfigPath+" if present)")
+    profileName := fs.String("profile", os.Getenv("MAPLINK_PROFILE"), "Named profile from the config file's profiles section")
+    fs.Parse(args)
+
+    set := map[string]bool{"config": true, "profile": true}
+    fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+    var cfg config
+    path := *configPath
+    if path == "" {
+        if _, err := os.Stat(defaultConfigPath); err == nil {
+            path = defaultConfigPath
+        }
+    }
+    if path != "" {
+        var err error
+        if cfg, err = loadConfig(path); err != nil {
+            fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+            os.Exit(2)
+        }
+    }
+    values := cfg.values(fs.Name())
+    var profile config
+    if *profileName != "" {
+        var err error
+        if profile, err = cfg.profile(*profileName); err != nil {
+            fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+            os.Exit(2)
+        }
+        profile.merge(values, fs.Name())
+    }
+
+    var errs []string
+    fs.VisitAll(func(f *flag.Flag) {
+        if set[f.Name] {
+            return
+        }
+        source := envName(fs.Name(), f.Name)
+        items := []string{}
+        if value, ok := os.LookupEnv(source); ok {
+            items = append(items, value)
+        } else {
+            source = "config " + f.Name
+            if items, ok = values[f.Name]; !ok {
+                return
+            }
+        }
+        // Lists are comma-separated for every other flag
+        if _, ok := f.Value.(repeatedFlag); !ok {
+            items = []string{strings.Join(items, ",")}
+        }
+        for _, value := range items {
+            if err := fs.Set(f.Name, value); err != nil {
+                errs = append(errs, fmt.Sprintf("%s: %v", source, err))
+            }
+        }
+    })
+
+    // Sections may belong to other commands, but a typo in this command's
+    // own section, or a command flag at the top level, is an error
+    for prefix, c := range map[string]config{"": cfg, "profiles." + *profileName + ".": profile} {
+        for key, v := range c {
+            if _, ok := configValue(v); ok && !globalKeys[key] {
+                errs = append(errs, fmt.Sprintf("config %s%s: not a global key, set it under a command", prefix, key))
+            }
+        }
+        if section, ok := c[fs.Name()].(map[string]interface{}); ok {
+            for key := range section {
+                if fs.Lookup(key) == nil {
+                    errs = append(errs, fmt.Sprintf("config %s%s.%s: no such flag", prefix, fs.Name(), key))
+                }
+            }
+        }
+    }
+    if len(errs) > 0 {
+        sort.Strings(errs)
+        for _, e := range errs {
+            fmt.Fprintf(os.Stderr, "Error: %s\n", e)
+        }
+        os.Exit(2)
+    }
+}
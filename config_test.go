@@ -0,0 +1,102 @@
+package main
+
+import (
+    "flag"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+const testConfig = `
+db-path: top.db
+workspace: top
+tech:
+  format: csv
+report:
+  format: md
+  db-path: section.db
+profiles:
+  engagement:
+    db-path: profile.db
+    report:
+      o: profile.html
+`
+
+func TestParseFlagsPrecedence(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "maplink.yaml")
+    if err := os.WriteFile(path, []byte(testConfig), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    tests := []struct {
+        name    string
+        command string
+        args    []string
+        env     map[string]string
+        want    map[string]string
+    }{
+        {
+            name:    "section over top level",
+            command: "report",
+            want:    map[string]string{"db-path": "section.db", "workspace": "top", "format": "md", "o": ""},
+        },
+        {
+            name:    "another command's section",
+            command: "tech",
+            want:    map[string]string{"db-path": "top.db", "workspace": "top", "format": "csv", "o": ""},
+        },
+        {
+            name:    "profile over section",
+            command: "report",
+            args:    []string{"-profile", "engagement"},
+            want:    map[string]string{"db-path": "profile.db", "format": "md", "o": "profile.html"},
+        },
+        {
+            name:    "environment over profile",
+            command: "report",
+            args:    []string{"-profile", "engagement"},
+            env:     map[string]string{"MAPLINK_DB_PATH": "env.db", "MAPLINK_REPORT_O": "env.html"},
+            want:    map[string]string{"db-path": "env.db", "o": "env.html"},
+        },
+        {
+            name:    "command line over environment",
+            command: "report",
+            args:    []string{"-db-path", "cli.db"},
+            env:     map[string]string{"MAPLINK_DB_PATH": "env.db"},
+            want:    map[string]string{"db-path": "cli.db"},
+        },
+        {
+            name:    "plain variable for a command flag",
+            command: "report",
+            env:     map[string]string{"MAPLINK_FORMAT": "json"},
+            want:    map[string]string{"format": "md"},
+        },
+        {
+            name:    "another command's variable",
+            command: "report",
+            env:     map[string]string{"MAPLINK_TECH_FORMAT": "json", "MAPLINK_REPORT_FORMAT": "html"},
+            want:    map[string]string{"format": "html"},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            for _, name := range []string{"MAPLINK_CONFIG", "MAPLINK_PROFILE", "MAPLINK_DB_PATH", "MAPLINK_WORKSPACE"} {
+                t.Setenv(name, "")
+                os.Unsetenv(name)
+            }
+            for name, value := range tt.env {
+                t.Setenv(name, value)
+            }
+            fs := flag.NewFlagSet(tt.command, flag.ContinueOnError)
+            fs.String("db-path", defaultDBPath, "")
+            fs.String("workspace", defaultWorkspace, "")
+            fs.String("format", "text", "")
+            fs.String("o", "", "")
+            parseFlags(fs, append([]string{"-config", path}, tt.args...))
+            for name, want := range tt.want {
+                if got := fs.Lookup(name).Value.String(); got != want {
+                    t.Errorf("-%s = %q, want %q", name, got, want)
+                }
+            }
+        })
+    }
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+    "context"
+    "crypto/x509"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+    "time"
+
+    "github.com/gobwas/ws"
+    "github.com/gobwas/ws/wsutil"
+)
+
+// Which newly certified names are worth a scan: those within one of
+// domains, or containing one of keywords
+type ctFilter struct {
+    domains  []string
+    keywords []string
+}
+
+func (f ctFilter) match(name string) bool {
+    for _, d := range f.domains {
+        if name == d || strings.HasSuffix(name, "."+d) {
+            return true
+        }
+    }
+    for _, kw := range f.keywords {
+        if strings.Contains(name, kw) {
+            return true
+        }
+    }
+    return false
+}
+
+// Send a target for every matching name, wildcards reduced to their base
+func (f ctFilter) emit(ctx context.Context, out chan<- target, names []string) {
+    for _, name := range names {
+        name = strings.TrimPrefix(strings.ToLower(strings.TrimSuffix(name, ".")), "*.")
+        if name == "" || strings.ContainsAny(name, " /*") || !f.match(name) {
+            continue
+        }
+        infof("Certificate issued for %s\n", name)
+        select {
+        case out <- target{URL: "https://" + name + "/"}:
+        case <-ctx.Done():
+            return
+        }
+    }
+}
+
+// Message of a certstream server; only certificate_update carries names
+type certstreamMessage struct {
+    MessageType string `json:"message_type"`
+    Data        struct {
+        LeafCert struct {
+            AllDomains []string `json:"all_domains"`
+        } `json:"leaf_cert"`
+    } `json:"data"`
+}
+
+// Read names off a certstream websocket, reconnecting when it drops
+func tailCertstream(ctx context.Context, endpoint string, f ctFilter, out chan<- target) {
+    for delay := time.Second; ctx.Err() == nil; delay = min(delay*2, time.Minute) {
+        err := readCertstream(ctx, endpoint, f, out)
+        if ctx.Err() != nil {
+            return
+        }
+        errorf("Error reading %s: %v (reconnecting in %s)\n", endpoint, err, delay)
+        select {
+        case <-time.After(delay):
+        case <-ctx.Done():
+        }
+    }
+}
+
+func readCertstream(ctx context.Context, endpoint string, f ctFilter, out chan<- target) error {
+    conn, _, _, err := ws.Dial(ctx, endpoint)
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+    // Unblock the read below on shutdown
+    stop := context.AfterFunc(ctx, func() { conn.Close() })
+    defer stop()
+
+    infof("Tailing %s\n", endpoint)
+    for {
+        data, err := wsutil.ReadServerText(conn)
+        if err != nil {
+            return err
+        }
+        var msg certstreamMessage
+        if err := json.Unmarshal(data, &msg); err != nil {
+            return fmt.Errorf("bad message: %v", err)
+        }
+        if msg.MessageType == "certificate_update" {
+            f.emit(ctx, out, msg.Data.LeafCert.AllDomains)
+        }
+    }
+}
+
+// Entries fetched from a CT log at a time; logs may return fewer
+const ctBatch = 256
+
+// Poll an RFC 6962 log for entries added since it was first seen
+func tailCTLog(ctx context.Context, logURL string, poll time.Duration, f ctFilter, out chan<- target) {
+    logURL = strings.TrimSuffix(logURL, "/")
+    next := int64(-1)
+    for ctx.Err() == nil {
+        size, err := ctTreeSize(ctx, logURL)
+        if err != nil {
+            errorf("Error reading %s: %v\n", logURL, err)
+        } else if next < 0 {
+            next = size
+            infof("Tailing %s from entry %d\n", logURL, next)
+        }
+        for err == nil && next >= 0 && next < size && ctx.Err() == nil {
+            var names [][]string
+            names, err = ctEntries(ctx, logURL, next, min(next+ctBatch, size)-1)
+            if err != nil {
+                errorf("Error reading %s entries from %d: %v\n", logURL, next, err)
+                break
+            }
+            for _, n := range names {
+                f.emit(ctx, out, n)
+            }
+            next += int64(len(names))
+        }
+        select {
+        case <-time.After(poll):
+        case <-ctx.Done():
+        }
+    }
+}
+
+func ctGet(ctx context.Context, endpoint string, v interface{}) error {
+    req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+    if err != nil {
+        return err
+    }
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return fmt.Errorf("%s: %s", endpoint, resp.Status)
+    }
+    return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func ctTreeSize(ctx context.Context, logURL string) (int64, error) {
+    var sth struct {
+        TreeSize int64 `json:"tree_size"`
+    }
+    err := ctGet(ctx, logURL+"/ct/v1/get-sth", &sth)
+    return sth.TreeSize, err
+}
+
+// Names on the certificates of entries start to end; an entry that does not
+// parse has none, keeping the count in step with the log
+func ctEntries(ctx context.Context, logURL string, start, end int64) ([][]string, error) {
+    var resp struct {
+        Entries []struct {
+            LeafInput []byte `json:"leaf_input"`
+            ExtraData []byte `json:"extra_data"`
+        } `json:"entries"`
+    }
+    q := url.Values{"start": {fmt.Sprint(start)}, "end": {fmt.Sprint(end)}}
+    if err := ctGet(ctx, logURL+"/ct/v1/get-entries?"+q.Encode(), &resp); err != nil {
+        return nil, err
+    }
+    if len(resp.Entries) == 0 {
+        return nil, errors.New("no entries returned")
+    }
+    var names [][]string
+    for _, e := range resp.Entries {
+        cert, err := ctCertificate(e.LeafInput, e.ExtraData)
+        if err != nil {
+            names = append(names, nil)
+            continue
+        }
+        names = append(names, append(cert.DNSNames, cert.Subject.CommonName))
+    }
+    return names, nil
+}
+
+// Certificate of a log entry. A MerkleTreeLeaf is version, leaf type, an
+// 8-byte timestamp and a 2-byte entry type, then for x509 entries the
+// certificate; precertificate entries carry theirs first in the extra data.
+func ctCertificate(leaf, extra []byte) (*x509.Certificate, error) {
+    if len(leaf) < 12 {
+        return nil, errors.New("short leaf")
+    }
+    der := leaf[12:]
+    if binary.BigEndian.Uint16(leaf[10:12]) == 1 {
+        der = extra
+    }
+    if len(der) < 3 {
+        return nil, errors.New("short certificate")
+    }
+    n := int(der[0])<<16 | int(der[1])<<8 | int(der[2])
+    if len(der) < 3+n {
+        return nil, errors.New("truncated certificate")
+    }
+    return x509.ParseCertificate(der[3 : 3+n])
+}
+
+// Scan the hosts new certificates are issued for, as CT logs publish them
+func ctCommand(args []string) {
+    fs := flag.NewFlagSet("ct", flag.ExitOnError)
+    dbOpts := dbFlags(fs)
+    opts := registerScanFlags(fs)
+    certstream := fs.String("certstream", "wss://certstream.calidog.io/", "Certstream websocket to tail")
+    logURL := fs.String("log", "", "RFC 6962 log to poll instead of certstream, e.g. https://ct.googleapis.com/logs/us1/argon2025h2")
+    poll := fs.Duration("poll", 10*time.Second, "Time between polls of -log")
+    domains := fs.String("domains", "", "Comma-separated domains whose names (and subdomains) to scan")
+    keywords := fs.String("keywords", "", "Comma-separated words; names containing any are scanned")
+    parseFlags(fs, args)
+
+    f := ctFilter{domains: splitList(strings.ToLower(*domains)), keywords: splitList(strings.ToLower(*keywords))}
+    if len(f.domains) == 0 && len(f.keywords) == 0 {
+        fmt.Fprintln(os.Stderr, "Error: ct needs -domains or -keywords to pick names from the feed")
+        return
+    }
+    db, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer db.Close()
+
+    s, err := opts.newScanner(db, dbOpts.workspace)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error setting up scan: %v\n", err)
+        return
+    }
+    defer s.close()
+
+    ctx := signalContext()
+    feed := make(chan target)
+    go func() {
+        defer close(feed)
+        if *logURL != "" {
+            tailCTLog(ctx, *logURL, *poll, f, feed)
+        } else {
+            tailCertstream(ctx, *certstream, f, feed)
+        }
+    }()
+    s.scanStream(ctx, feed)
+}
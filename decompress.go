@@ -0,0 +1,96 @@
+package main
+
+import (
+    "compress/gzip"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+
+    "github.com/andybalholm/brotli"
+)
+
+// Returned by a body that inflates past -max-decompression-ratio
+var errDecompressionBomb = errors.New("decompression bomb")
+
+// Default -max-decompression-ratio; real pages and icons stay well under it
+const defaultDecompressionRatio = 100
+
+// Bodies may inflate this much before the ratio is checked, so small, very
+// repetitive pages are not mistaken for bombs
+const bombFloor = 1 << 20
+
+// HTTP transport that asks for gzip and brotli and decodes them itself,
+// failing bodies that grow more than maxRatio times their compressed size
+type decompressTransport struct {
+    next     http.RoundTripper
+    maxRatio int64
+}
+
+func (t decompressTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    // A target that sets its own Accept-Encoding gets the body as sent
+    if req.Header.Get("Accept-Encoding") != "" {
+        return t.next.RoundTrip(req)
+    }
+    req = req.Clone(req.Context())
+    req.Header.Set("Accept-Encoding", "gzip, br")
+    resp, err := t.next.RoundTrip(req)
+    if err != nil || req.Method == http.MethodHead || resp.Body == http.NoBody {
+        return resp, err
+    }
+
+    compressed := &countingReader{r: resp.Body}
+    var decoded io.Reader
+    switch strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))) {
+    case "gzip", "x-gzip":
+        if decoded, err = gzip.NewReader(compressed); err != nil {
+            resp.Body.Close()
+            return nil, fmt.Errorf("decoding gzip body of %s: %v", req.URL, err)
+        }
+    case "br":
+        decoded = brotli.NewReader(compressed)
+    default:
+        return resp, nil
+    }
+    resp.Body = &ratioReader{r: decoded, compressed: compressed, closer: resp.Body, maxRatio: t.maxRatio}
+    resp.Header.Del("Content-Encoding")
+    resp.Header.Del("Content-Length")
+    resp.ContentLength, resp.Uncompressed = -1, true
+    return resp, nil
+}
+
+// Reader that counts the bytes read through it
+type countingReader struct {
+    r io.Reader
+    n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+    n, err := c.r.Read(p)
+    c.n += int64(n)
+    return n, err
+}
+
+// Decoded body that fails once it is past bombFloor and more than maxRatio
+// times what was read off the wire
+type ratioReader struct {
+    r          io.Reader
+    compressed *countingReader
+    closer     io.Closer
+    maxRatio   int64
+    n          int64
+}
+
+func (b *ratioReader) Read(p []byte) (int, error) {
+    n, err := b.r.Read(p)
+    b.n += int64(n)
+    if b.maxRatio > 0 && b.n > bombFloor && b.n > b.maxRatio*max(b.compressed.n, 1) {
+        return n, fmt.Errorf("%w: %d compressed bytes inflated to %d, past -max-decompression-ratio %d", errDecompressionBomb, b.compressed.n, b.n, b.maxRatio)
+    }
+    return n, err
+}
+
+func (b *ratioReader) Close() error {
+    return b.closer.Close()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+    _ "embed"
+    "slices"
+    "strings"
+)
+
+// Built-in list of stock icons, see stock_icons.csv
+//
+//go:embed stock_icons.csv
+var stockIconsCSV string
+
+// Tag given to the hashes of stock icons
+const defaultTag = "default"
+
+// Stock icons of frameworks and servers: the built-in list and the
+// "hash,name" lines of filename, if any
+func loadStockIcons(filename string) (fingerprints, error) {
+    stock := fingerprints{}
+    if err := stock.read(strings.NewReader(stockIconsCSV)); err != nil {
+        return nil, err
+    }
+    extra, err := loadFingerprints(filename)
+    if err != nil {
+        return nil, err
+    }
+    for h, name := range extra {
+        stock[h] = name
+    }
+    return stock, nil
+}
+
+// Whether a record is a stock icon: tagged default, or on the stock list
+func isDefaultIcon(r record, stock fingerprints) bool {
+    return slices.Contains(r.HashTags, defaultTag) || stock.identify(r.MD5, r.SHA256, r.MMH3) != ""
+}
+
+// Records that are not stock icons
+func withoutDefaults(records []record, stock fingerprints) []record {
+    var out []record
+    for _, r := range records {
+        if !isDefaultIcon(r, stock) {
+            out = append(out, r)
+        }
+    }
+    return out
+}
+
+// Tags the SHA256 of a stock icon as default
+type defaultIconTag struct {
+    sha256 string
+}
+
+func (op defaultIconTag) apply(w batchStmts, now string) {
+    if _, err := w.tag.Exec(w.workspace, "sha256", op.sha256, defaultTag, now); err != nil {
+        errorf("Error tagging default icon %s: %v\n", op.sha256, err)
+    }
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "net/http"
+    "os"
+    "strings"
+    "time"
+)
+
+// Message sent from workers back to the coordinator
+type workerMessage struct {
+    Worker string   `json:"worker"`
+    Target string   `json:"target"`
+    Icon   *favicon `json:"icon,omitempty"`
+    URL    string   `json:"url,omitempty"`
+    Error  string   `json:"error,omitempty"`
+    Done   bool     `json:"done,omitempty"`
+}
+
+// Redis keys of a queue
+func queueKeys(queue string) (targets, results string) {
+    return queue + ":targets", queue + ":results"
+}
+
+// Distribute targets to workers through Redis and store their results
+func coordinatorCommand(args []string) {
+    fs := flag.NewFlagSet("coordinator", flag.ExitOnError)
+    filename := fs.String("file", "", "File containing a list of URLs to distribute")
+    dbOpts := dbFlags(fs)
+    redisAddr := fs.String("redis", "127.0.0.1:6379", "Redis address")
+    redisPassword := fs.String("redis-password", os.Getenv("REDIS_PASSWORD"), "Redis password (or REDIS_PASSWORD)")
+    queue := fs.String("queue", "maplink", "Queue name prefix shared with workers")
+    idle := fs.Duration("idle-timeout", 10*time.Minute, "Give up when no worker reports for this long")
+    pprofOpts := registerPprofFlags(fs)
+    opts := registerScanFlags(fs)
+    parseFlags(fs, args)
+
+    if *filename == "" {
+        fmt.Println("Please provide a filename using the -file flag.")
+        return
+    }
+    targets, err := readTargets(*filename)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error reading URLs from file: %v\n", err)
+        return
+    }
+    if err := pprofOpts.start(); err != nil {
+        fmt.Fprintf(os.Stderr, "Error starting pprof: %v\n", err)
+        return
+    }
+
+    db, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer db.Close()
+
+    s, err := opts.newScanner(db, dbOpts.workspace)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error setting up scan: %v\n", err)
+        return
+    }
+    defer s.close()
+
+    r, err := dialRedis(*redisAddr, *redisPassword)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error connecting to Redis: %v\n", err)
+        return
+    }
+    defer r.Close()
+
+    ctx := signalContext()
+    targetsKey, resultsKey := queueKeys(*queue)
+    byURL := map[string]target{}
+    for _, t := range targets {
+        byURL[t.URL] = t
+    }
+    todo := s.beginRun(targetURLs(targets))
+    defer s.endRun(ctx)
+    for _, u := range todo {
+        if err := r.lpush(targetsKey, queueEntry(byURL[u])); err != nil {
+            fmt.Fprintf(os.Stderr, "Error queueing %s: %v\n", u, err)
+            return
+        }
+    }
+    infof("Queued %d targets on %s\n", len(todo), targetsKey)
+
+    // Aggregate results until every target is reported done
+    pending := map[string]int{}
+    for _, u := range todo {
+        pending[u]++
+    }
+    remaining := len(todo)
+    lastSeen := time.Now()
+    for remaining > 0 && ctx.Err() == nil {
+        payload, ok, err := r.brpop(resultsKey, 5*time.Second)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error reading results: %v\n", err)
+            return
+        }
+        if !ok {
+            if time.Since(lastSeen) > *idle {
+                fmt.Fprintf(os.Stderr, "No results for %s, giving up with %d targets outstanding\n", *idle, remaining)
+                return
+            }
+            continue
+        }
+        lastSeen = time.Now()
+
+        var msg workerMessage
+        if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+            fmt.Fprintf(os.Stderr, "Error decoding result: %v\n", err)
+            continue
+        }
+        switch {
+        case msg.Icon != nil:
+            s.record(*msg.Icon)
+        case msg.Error != "":
+            s.fail(msg.URL, errors.New(msg.Error))
+        }
+        if msg.Done && pending[msg.Target] > 0 {
+            pending[msg.Target]--
+            remaining--
+            s.targetDone(msg.Target)
+        }
+    }
+    if ctx.Err() != nil {
+        fmt.Fprintf(os.Stderr, "Stopped with %d targets outstanding\n", remaining)
+        return
+    }
+    infof("All %d targets processed\n", len(targets))
+}
+
+// Queue payload for a target: the bare URL, or JSON when it carries overrides
+func queueEntry(t target) string {
+    if !t.hasOverrides() {
+        return t.URL
+    }
+    payload, err := json.Marshal(t)
+    if err != nil {
+        return t.URL
+    }
+    return string(payload)
+}
+
+// Target from a queue payload written by queueEntry
+func parseQueueEntry(payload string) (target, error) {
+    if !strings.HasPrefix(payload, "{") {
+        return target{URL: payload}, nil
+    }
+    var t target
+    err := json.Unmarshal([]byte(payload), &t)
+    return t, err
+}
+
+// Take targets from the Redis queue, download favicons and send them back
+func workerCommand(args []string) {
+    fs := flag.NewFlagSet("worker", flag.ExitOnError)
+    redisAddr := fs.String("redis", "127.0.0.1:6379", "Redis address")
+    redisPassword := fs.String("redis-password", os.Getenv("REDIS_PASSWORD"), "Redis password (or REDIS_PASSWORD)")
+    queue := fs.String("queue", "maplink", "Queue name prefix shared with the coordinator")
+    idleExit := fs.Duration("idle-exit", 0, "Exit after the queue stays empty this long (0 runs forever)")
+    pprofOpts := registerPprofFlags(fs)
+    parseFlags(fs, args)
+    if err := pprofOpts.start(); err != nil {
+        fmt.Fprintf(os.Stderr, "Error starting pprof: %v\n", err)
+        return
+    }
+
+    r, err := dialRedis(*redisAddr, *redisPassword)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error connecting to Redis: %v\n", err)
+        return
+    }
+    defer r.Close()
+
+    worker, _ := os.Hostname()
+    worker = fmt.Sprintf("%s-%d", worker, os.Getpid())
+    targetsKey, resultsKey := queueKeys(*queue)
+
+    send := func(msg workerMessage) {
+        msg.Worker = worker
+        payload, err := json.Marshal(msg)
+        if err == nil {
+            err = r.lpush(resultsKey, string(payload))
+        }
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error sending result for %s: %v\n", msg.Target, err)
+        }
+    }
+
+    fetch := newHTTPFetcher(&http.Client{Transport: decompressTransport{next: newOriginTransport(baseTransport), maxRatio: defaultDecompressionRatio}})
+    ctx := signalContext()
+    infof("Worker %s waiting on %s\n", worker, targetsKey)
+    idleSince := time.Now()
+    for ctx.Err() == nil {
+        payload, ok, err := r.brpop(targetsKey, 5*time.Second)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error reading queue: %v\n", err)
+            return
+        }
+        if !ok {
+            if *idleExit > 0 && time.Since(idleSince) > *idleExit {
+                return
+            }
+            continue
+        }
+        t, err := parseQueueEntry(payload)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error decoding target %q: %v\n", payload, err)
+            continue
+        }
+        target := t.URL
+
+        infof("Processing URL: %s\n", target)
+        icons := downloadFavicons(withTarget(ctx, t), fetch, target, nil, func(url string, err error) {
+            send(workerMessage{Target: target, URL: url, Error: err.Error()})
+        })
+        if ctx.Err() != nil {
+            // Hand the unfinished target to the next worker
+            if err := r.rpush(targetsKey, payload); err != nil {
+                fmt.Fprintf(os.Stderr, "Error requeueing %s: %v\n", target, err)
+            }
+            return
+        }
+        for i := range icons {
+            send(workerMessage{Target: target, Icon: &icons[i]})
+        }
+        send(workerMessage{Target: target, Done: true})
+        idleSince = time.Now()
+    }
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "net"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Outcome of looking up one host name
+type resolution struct {
+    host  string
+    addrs []string
+    err   error
+}
+
+// Whether the name does not exist, as opposed to a lookup that failed
+func (r resolution) notFound() bool {
+    var dnsErr *net.DNSError
+    return errors.As(r.err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// Look up each host, workers at a time, in the order given. Hosts left when
+// ctx is cancelled are not returned.
+func resolveHosts(ctx context.Context, hosts []string, workers int, timeout time.Duration) []resolution {
+    results := make([]resolution, len(hosts))
+    done := make([]bool, len(hosts))
+    jobs := make(chan int)
+    var wg sync.WaitGroup
+    for i := 0; i < workers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for n := range jobs {
+                lookupCtx, cancel := context.WithTimeout(ctx, timeout)
+                addrs, err := net.DefaultResolver.LookupHost(lookupCtx, hosts[n])
+                cancel()
+                if ctx.Err() == nil {
+                    results[n], done[n] = resolution{host: hosts[n], addrs: addrs, err: err}, true
+                }
+            }
+        }()
+    }
+    for n := range hosts {
+        select {
+        case jobs <- n:
+        case <-ctx.Done():
+        }
+    }
+    close(jobs)
+    wg.Wait()
+
+    var out []resolution
+    for n, r := range results {
+        if done[n] {
+            out = append(out, r)
+        }
+    }
+    return out
+}
+
+// Resolve the host names of the pending targets before fetching any, record
+// the answers, and finish targets whose names do not exist with that error.
+// Targets with connect, IP addresses and names that failed to resolve for
+// other reasons go on to be fetched.
+func (s *scanner) prefilterDNS(ctx context.Context, byURL map[string]target, pending []string) []string {
+    hostOfURL := map[string]string{}
+    var hosts []string
+    seen := map[string]bool{}
+    for _, u := range pending {
+        host := targetHost(u)
+        if byURL[u].Connect != "" || host == "" || net.ParseIP(host) != nil {
+            continue
+        }
+        hostOfURL[u] = host
+        if !seen[host] {
+            seen[host] = true
+            hosts = append(hosts, host)
+        }
+    }
+    if len(hosts) == 0 {
+        return pending
+    }
+
+    started := time.Now()
+    missing := map[string]error{}
+    for _, r := range resolveHosts(ctx, hosts, s.dnsWorkers, s.dnsTimeout) {
+        op := resolutionWrite{run: s.run, host: r.host, addrs: strings.Join(r.addrs, ",")}
+        if r.err != nil {
+            op.err = r.err.Error()
+        }
+        s.store.saveResolution(op)
+        if r.notFound() {
+            missing[r.host] = r.err
+        }
+    }
+    infof("Resolved %d host names in %s: %d do not exist\n", len(hosts), time.Since(started).Round(time.Millisecond), len(missing))
+
+    var left []string
+    for _, u := range pending {
+        if err, ok := missing[hostOfURL[u]]; ok {
+            s.failTarget(byURL[u], u, err)
+            s.targetDone(u)
+            continue
+        }
+        left = append(left, u)
+    }
+    return left
+}
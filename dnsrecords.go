@@ -0,0 +1,246 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "database/sql"
+    "flag"
+    "fmt"
+    "math/rand/v2"
+    "net"
+    "os"
+    "strings"
+
+    "golang.org/x/net/dns/dnsmessage"
+)
+
+// One step of a host name's resolution: a CNAME from name to value, or an
+// A or AAAA address of name; or a PTR name of the address name
+type dnsRecord struct {
+    Type  string
+    Name  string
+    Value string
+}
+
+// Records of a host on their way to the dns_records table, in chain order
+type dnsRecordsWrite struct {
+    run     int64
+    host    string
+    records []dnsRecord
+}
+
+func (op dnsRecordsWrite) apply(w batchStmts, now string) {
+    for i, r := range op.records {
+        if _, err := w.dnsRecord.Exec(w.workspace, op.host, i, r.Type, r.Name, r.Value, op.run, now, now); err != nil {
+            errorf("Error saving DNS records of %s: %v\n", op.host, err)
+        }
+    }
+}
+
+// First name server of /etc/resolv.conf, as host:port
+func systemNameserver() string {
+    f, err := os.Open("/etc/resolv.conf")
+    if err != nil {
+        return ""
+    }
+    defer f.Close()
+    lines := bufio.NewScanner(f)
+    for lines.Scan() {
+        if fields := strings.Fields(lines.Text()); len(fields) >= 2 && fields[0] == "nameserver" {
+            return net.JoinHostPort(fields[1], "53")
+        }
+    }
+    return ""
+}
+
+// CNAMEs a recursive server follows from host, in order. The system resolver
+// only reports where a chain ends, so the A query is sent by hand.
+func cnameChain(ctx context.Context, server, host string) ([]dnsRecord, error) {
+    name, err := dnsmessage.NewName(strings.TrimSuffix(host, ".") + ".")
+    if err != nil {
+        return nil, err
+    }
+    id := uint16(rand.N(1 << 16))
+    query, err := (&dnsmessage.Message{
+        Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+        Questions: []dnsmessage.Question{{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}},
+    }).Pack()
+    if err != nil {
+        return nil, err
+    }
+    conn, err := (&net.Dialer{}).DialContext(ctx, "udp", server)
+    if err != nil {
+        return nil, err
+    }
+    defer conn.Close()
+    if deadline, ok := ctx.Deadline(); ok {
+        conn.SetDeadline(deadline)
+    }
+    if _, err := conn.Write(query); err != nil {
+        return nil, err
+    }
+    buf := make([]byte, 4096)
+    for {
+        n, err := conn.Read(buf)
+        if err != nil {
+            return nil, err
+        }
+        var resp dnsmessage.Message
+        if err := resp.Unpack(buf[:n]); err != nil || resp.ID != id {
+            continue
+        }
+        var chain []dnsRecord
+        for _, rr := range resp.Answers {
+            if c, ok := rr.Body.(*dnsmessage.CNAMEResource); ok {
+                chain = append(chain, dnsRecord{Type: "CNAME", Name: trimDot(rr.Header.Name.String()), Value: trimDot(c.CNAME.String())})
+            }
+        }
+        return chain, nil
+    }
+}
+
+func trimDot(name string) string {
+    return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// Resolution chain of host: its CNAMEs, then the addresses the scan connects
+// to. Names the name server cannot see (e.g. from /etc/hosts) still get their
+// addresses and, if the system resolver reports one, their canonical name.
+func (s *scanner) resolveChain(ctx context.Context, host string) ([]dnsRecord, error) {
+    lookupCtx, cancel := context.WithTimeout(ctx, s.dnsTimeout)
+    defer cancel()
+    addrs, err := net.DefaultResolver.LookupIPAddr(lookupCtx, host)
+    if err != nil {
+        return nil, err
+    }
+    var records []dnsRecord
+    if server := systemNameserver(); server != "" {
+        records, _ = cnameChain(lookupCtx, server, host)
+    }
+    if len(records) == 0 {
+        if canonical, err := net.DefaultResolver.LookupCNAME(lookupCtx, host); err == nil && trimDot(canonical) != host {
+            records = append(records, dnsRecord{Type: "CNAME", Name: host, Value: trimDot(canonical)})
+        }
+    }
+    last := host
+    if len(records) > 0 {
+        last = records[len(records)-1].Value
+    }
+    for _, a := range addrs {
+        r := dnsRecord{Type: "A", Name: last, Value: a.IP.String()}
+        if a.IP.To4() == nil {
+            r.Type = "AAAA"
+        }
+        records = append(records, r)
+    }
+    return records, nil
+}
+
+// Names the PTR records of ip give
+func (s *scanner) reverseLookup(ctx context.Context, ip string) ([]dnsRecord, error) {
+    lookupCtx, cancel := context.WithTimeout(ctx, s.dnsTimeout)
+    defer cancel()
+    names, err := net.DefaultResolver.LookupAddr(lookupCtx, ip)
+    if err != nil {
+        return nil, err
+    }
+    var records []dnsRecord
+    for _, name := range names {
+        records = append(records, dnsRecord{Type: "PTR", Name: ip, Value: trimDot(name)})
+    }
+    return records, nil
+}
+
+// Store the resolution chain of a target's host with -dns-records, or the
+// reverse DNS names of a target IP with -ptr, once per run
+func (s *scanner) recordDNS(ctx context.Context, t target) {
+    host := targetHost(t.URL)
+    ip := net.ParseIP(host) != nil
+    if host == "" || (!ip && !s.dnsRecords) || (ip && !s.reverseDNS) {
+        return
+    }
+    s.mu.Lock()
+    if s.dnsSeen[host] {
+        s.mu.Unlock()
+        return
+    }
+    s.dnsSeen[host] = true
+    run := s.run
+    s.mu.Unlock()
+
+    resolve := s.resolveChain
+    if ip {
+        resolve = s.reverseLookup
+    }
+    records, err := resolve(ctx, host)
+    if err != nil {
+        // The fetch reports a name that does not resolve; an address
+        // without PTR records has nothing to store
+        return
+    }
+    if ip && len(records) > 0 {
+        var names []string
+        for _, r := range records {
+            names = append(names, r.Value)
+        }
+        infof("Reverse DNS of %s: %s\n", host, strings.Join(names, ", "))
+    }
+    s.store.saveDNSRecords(dnsRecordsWrite{run: run, host: host, records: records})
+}
+
+// A stored record
+type hostDNSRecord struct {
+    Host     string
+    Position int
+    dnsRecord
+    LastSeen string
+}
+
+// DNS records of a workspace, optionally of one host or one type, chains in order
+func loadDNSRecords(db *sql.DB, workspace, host, typ string) ([]hostDNSRecord, error) {
+    rows, err := db.Query(`SELECT host, position, type, name, value, last_seen FROM dns_records
+        WHERE workspace = ? AND (? = '' OR host = ?) AND (? = '' OR type = ?) ORDER BY host, last_seen DESC, position`,
+        workspace, host, host, typ, typ)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var out []hostDNSRecord
+    for rows.Next() {
+        var r hostDNSRecord
+        if err := rows.Scan(&r.Host, &r.Position, &r.Type, &r.Name, &r.Value, &r.LastSeen); err != nil {
+            return nil, err
+        }
+        out = append(out, r)
+    }
+    return out, rows.Err()
+}
+
+// List the DNS records stored by -dns-records and -ptr
+func dnsCommand(args []string) {
+    fs := flag.NewFlagSet("dns", flag.ExitOnError)
+    dbOpts := dbFlags(fs)
+    host := fs.String("host", "", "Only records of this host")
+    typ := fs.String("type", "", "Only records of this type: A, AAAA, CNAME or PTR")
+    parseFlags(fs, args)
+
+    db, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer db.Close()
+
+    records, err := loadDNSRecords(db, dbOpts.workspace, strings.ToLower(*host), strings.ToUpper(*typ))
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error loading DNS records: %v\n", err)
+        return
+    }
+    if len(records) == 0 {
+        fmt.Println("No DNS records stored (scan with -dns-records or -ptr).")
+        return
+    }
+    for _, r := range records {
+        fmt.Printf("%s\t%s\t%s\t%s\t%s\n", r.Host, r.Type, r.Name, r.Value, r.LastSeen)
+    }
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+    "database/sql"
+    "fmt"
+    "net/url"
+    "os"
+    "sort"
+    "strings"
+    "time"
+)
+
+// Check that a target is an absolute http(s) URL
+func validateTarget(target string) error {
+    // Bare host names get their scheme from -probe when scanned
+    if !strings.Contains(target, "://") {
+        if _, _, ok := bareHost(target); !ok {
+            return fmt.Errorf("not a URL or host name")
+        }
+        return nil
+    }
+    u, err := url.Parse(target)
+    if err != nil {
+        return err
+    }
+    if u.Scheme != "http" && u.Scheme != "https" {
+        return fmt.Errorf("scheme must be http or https")
+    }
+    if u.Host == "" {
+        return fmt.Errorf("missing host")
+    }
+    return nil
+}
+
+// Favicon links previously stored for each target of a workspace
+func storedLinks(db *sql.DB, workspace string) (map[string][]string, error) {
+    rows, err := db.Query("SELECT target, link FROM favicons WHERE workspace = ? AND target IS NOT NULL ORDER BY link", workspace)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    links := map[string][]string{}
+    for rows.Next() {
+        var target, link string
+        if err := rows.Scan(&target, &link); err != nil {
+            return nil, err
+        }
+        links[target] = append(links[target], link)
+    }
+    return links, rows.Err()
+}
+
+// Per-target settings of a dry-run line, e.g. " (proxy http://p:8080, 2 headers)"
+func describeOverrides(t target) string {
+    var parts []string
+    if t.Proxy != "" {
+        parts = append(parts, "proxy "+t.Proxy)
+    }
+    if len(t.Headers) > 0 {
+        parts = append(parts, fmt.Sprintf("%d headers", len(t.Headers)))
+    }
+    if len(t.Cookies) > 0 {
+        parts = append(parts, fmt.Sprintf("%d cookies", len(t.Cookies)))
+    }
+    if len(t.Labels) > 0 {
+        parts = append(parts, "labels "+strings.Join(t.Labels, ","))
+    }
+    if len(parts) == 0 {
+        return ""
+    }
+    return " (" + strings.Join(parts, ", ") + ")"
+}
+
+// Print what a scan would fetch and where it would store results, without
+// making requests or writing to the database
+func dryRun(dbOpts *dbOptions, opts *scanOptions, targets []target) {
+    fmt.Println("Dry run: no requests are made and nothing is written.")
+
+    // Resume and freshness filters read the existing database, if any
+    var st *store
+    var links map[string][]string
+    if _, err := os.Stat(dbOpts.path); err == nil && dbOpts.path != ":memory:" {
+        db, err := dbOpts.openReadOnly()
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+            return
+        }
+        defer db.Close()
+        st = &store{db: db, workspace: dbOpts.workspace}
+        if links, err = storedLinks(db, dbOpts.workspace); err != nil {
+            fmt.Fprintf(os.Stderr, "Error reading stored favicons: %v\n", err)
+        }
+    }
+
+    finished := map[string]struct{}{}
+    if opts.resume && st != nil {
+        r, done, ok, err := st.unfinishedRun()
+        switch {
+        case err != nil:
+            fmt.Fprintf(os.Stderr, "Error loading checkpoint: %v\n", err)
+        case ok:
+            fmt.Printf("Would resume run #%d from %s\n", r.ID, r.StartedAt)
+            finished = done
+        default:
+            fmt.Println("No unfinished run to resume; a new run would start.")
+        }
+    }
+    recent := map[string]struct{}{}
+    if opts.skipIfScanned > 0 && st != nil {
+        var err error
+        if recent, err = st.recentTargets(time.Now().Add(-opts.skipIfScanned)); err != nil {
+            fmt.Fprintf(os.Stderr, "Error checking recently scanned targets: %v\n", err)
+        }
+    }
+
+    seen := map[string]struct{}{}
+    var fetch, skipped, invalid int
+    for _, t := range targets {
+        target := t.URL
+        if err := t.validate(); err != nil {
+            fmt.Printf("  invalid  %s (%v)\n", target, err)
+            invalid++
+            continue
+        }
+        if _, ok := seen[target]; ok {
+            fmt.Printf("  skip     %s (duplicate)\n", target)
+            skipped++
+            continue
+        }
+        seen[target] = struct{}{}
+        if _, ok := finished[target]; ok {
+            fmt.Printf("  skip     %s (finished in the resumed run)\n", target)
+            skipped++
+            continue
+        }
+        if _, ok := recent[target]; ok {
+            fmt.Printf("  skip     %s (scanned within %s)\n", target, opts.skipIfScanned)
+            skipped++
+            continue
+        }
+
+        fetch++
+        fmt.Printf("  GET      %s%s\n", target, describeOverrides(t))
+        for _, link := range links[target] {
+            fmt.Printf("    GET    %s (stored, conditional)\n", link)
+        }
+    }
+
+    fmt.Printf("\nWould fetch %d pages and the favicons they reference; %d skipped, %d invalid.\n", fetch, skipped, invalid)
+    fmt.Printf("Results would be stored in %s", dbOpts.path)
+    var outputs []string
+    if opts.output != "" {
+        outputs = append(outputs, "NDJSON "+opts.output)
+    }
+    if opts.kafkaBrokers != "" {
+        outputs = append(outputs, "Kafka topic "+opts.kafkaTopic)
+    }
+    if opts.s3Bucket != "" {
+        outputs = append(outputs, "s3://"+opts.s3Bucket+"/"+opts.s3Prefix+"/"+opts.runID+"/")
+    }
+    if opts.clickhouseURL != "" {
+        outputs = append(outputs, "ClickHouse table "+opts.clickhouseTable)
+    }
+    if hooks := opts.hooks(); len(hooks) > 0 {
+        events := make([]string, 0, len(hooks))
+        for event := range hooks {
+            events = append(events, event)
+        }
+        sort.Strings(events)
+        outputs = append(outputs, "hooks on "+strings.Join(events, ", "))
+    }
+    if len(outputs) > 0 {
+        fmt.Printf(" and sent to %s", strings.Join(outputs, ", "))
+    }
+    fmt.Println(".")
+    if len(opts.labels) > 0 {
+        fmt.Printf("The run would be labelled %s.\n", opts.labels)
+    }
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// Events a subscriber may have waiting before it counts as too slow
+const streamBuffer = 256
+
+// Interval of the comments that keep idle streams open through proxies
+const streamKeepalive = 15 * time.Second
+
+// Shortest interval between progress events of a job
+const progressInterval = 500 * time.Millisecond
+
+// Progress of the job being scanned, in progress events
+type apiJobProgress struct {
+    Job     int64 `json:"job"`
+    Done    int   `json:"done"`
+    Targets int   `json:"targets"`
+    Errors  int   `json:"errors"`
+}
+
+// A result in result events, with the job that found it. Result holds the
+// stored JSON, or a result{} for the OpenAPI document.
+type apiStreamResult struct {
+    Job    int64       `json:"job"`
+    Result interface{} `json:"result"`
+}
+
+// Data of the events streamed, for the OpenAPI document and the client
+func streamEventTypes() []interface{} {
+    return []interface{}{apiJob{}, apiJobProgress{}, apiStreamResult{Result: result{}}}
+}
+
+// A server-sent event about a job
+type streamEvent struct {
+    job  int64
+    id   int64 // job_results row of a result event, 0 for the others
+    name string
+    data []byte
+    done bool // a job event for a job that will not change again
+}
+
+// A stream's queue of events, of one job or of every job (job 0)
+type subscriber struct {
+    job    int64
+    events chan streamEvent
+}
+
+// Fans live job events out to the streaming endpoints. A subscriber that
+// falls behind is disconnected rather than slowing the scan; it reconnects
+// with Last-Event-ID and replays the results it missed from job_results.
+type eventHub struct {
+    mu     sync.Mutex
+    subs   map[*subscriber]struct{}
+    closed bool
+}
+
+func newEventHub() *eventHub {
+    return &eventHub{subs: map[*subscriber]struct{}{}}
+}
+
+func (h *eventHub) subscribe(job int64) *subscriber {
+    sub := &subscriber{job: job, events: make(chan streamEvent, streamBuffer)}
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    if h.closed {
+        close(sub.events)
+    } else {
+        h.subs[sub] = struct{}{}
+    }
+    return sub
+}
+
+func (h *eventHub) unsubscribe(sub *subscriber) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    if _, ok := h.subs[sub]; ok {
+        delete(h.subs, sub)
+        close(sub.events)
+    }
+}
+
+func (h *eventHub) publish(ev streamEvent) {
+    if h == nil {
+        return
+    }
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    for sub := range h.subs {
+        if sub.job != 0 && sub.job != ev.job {
+            continue
+        }
+        select {
+        case sub.events <- ev:
+        default:
+            delete(h.subs, sub)
+            close(sub.events)
+        }
+    }
+}
+
+// End every stream, so a shutting-down server is not held open by them
+func (h *eventHub) close() {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    h.closed = true
+    for sub := range h.subs {
+        delete(h.subs, sub)
+        close(sub.events)
+    }
+}
+
+// Event of a job's current state
+func (sv *server) jobEvent(id int64) (streamEvent, error) {
+    jobs, err := selectJobs(sv.db, sv.workspace, "j.id = ?", "j.id", 1, id)
+    if err != nil || len(jobs) == 0 {
+        return streamEvent{}, err
+    }
+    data, err := json.Marshal(jobs[0])
+    if err != nil {
+        return streamEvent{}, err
+    }
+    status := jobs[0].Status
+    return streamEvent{job: id, name: "job", data: data, done: status != jobQueued && status != jobRunning}, nil
+}
+
+// Tell the streams a job was queued, started or finished
+func (sv *server) publishJob(id int64) {
+    ev, err := sv.jobEvent(id)
+    if err != nil {
+        errorf("Error loading job %d: %v\n", id, err)
+        return
+    }
+    if ev.name != "" {
+        sv.events.publish(ev)
+    }
+}
+
+// Progress hook of the scanner while it scans a job, at most one event per
+// progressInterval and always the last one
+func (sv *server) jobProgress(id int64) func(done, targets, errors int) {
+    var last time.Time
+    return func(done, targets, errors int) {
+        if done < targets && time.Since(last) < progressInterval {
+            return
+        }
+        last = time.Now()
+        data, _ := json.Marshal(apiJobProgress{Job: id, Done: done, Targets: targets, Errors: errors})
+        sv.events.publish(streamEvent{job: id, name: "progress", data: data})
+    }
+}
+
+func writeEvent(w http.ResponseWriter, rc *http.ResponseController, ev streamEvent) error {
+    if ev.id != 0 {
+        if _, err := fmt.Fprintf(w, "id: %d\n", ev.id); err != nil {
+            return err
+        }
+    }
+    if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.name, ev.data); err != nil {
+        return err
+    }
+    return rc.Flush()
+}
+
+// Write the stored results after the last one a client saw, of one job or,
+// for job 0, of every job of the workspace. Returns the last one written.
+func (sv *server) replayResults(w http.ResponseWriter, rc *http.ResponseController, job, after int64) (int64, error) {
+    query := "SELECT r.id, r.job_id, r.result FROM job_results r JOIN jobs j ON j.id = r.job_id WHERE j.workspace = ? AND r.id > ?"
+    args := []interface{}{sv.workspace, after}
+    if job != 0 {
+        query += " AND r.job_id = ?"
+        args = append(args, job)
+    }
+    rows, err := sv.db.Query(query+" ORDER BY r.id", args...)
+    if err != nil {
+        return after, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var id, jobID int64
+        var res string
+        if err := rows.Scan(&id, &jobID, &res); err != nil {
+            return after, err
+        }
+        data, _ := json.Marshal(apiStreamResult{Job: jobID, Result: json.RawMessage(res)})
+        if err := writeEvent(w, rc, streamEvent{job: jobID, id: id, name: "result", data: data}); err != nil {
+            return after, err
+        }
+        after = id
+    }
+    return after, rows.Err()
+}
+
+// Stream server-sent events of one job, or of every job for job 0, until
+// the client goes away. A job's stream starts with its state and the
+// results found so far, and ends once the job has finished.
+func (sv *server) streamEvents(w http.ResponseWriter, r *http.Request, job int64) {
+    var last int64
+    resume := r.Header.Get("Last-Event-ID")
+    if resume != "" {
+        var err error
+        if last, err = strconv.ParseInt(resume, 10, 64); err != nil {
+            writeError(w, http.StatusBadRequest, "invalid Last-Event-ID")
+            return
+        }
+    }
+    // Subscribed before the replay, so nothing found meanwhile is missed
+    sub := sv.events.subscribe(job)
+    defer sv.events.unsubscribe(sub)
+
+    rc := http.NewResponseController(w)
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.Header().Set("X-Accel-Buffering", "no")
+    w.WriteHeader(http.StatusOK)
+
+    finished := false
+    if job != 0 {
+        ev, err := sv.jobEvent(job)
+        if err == nil && ev.name != "" {
+            err = writeEvent(w, rc, ev)
+            finished = ev.done
+        }
+        if err != nil {
+            return
+        }
+    }
+    // Every stream of a job replays it; the all-jobs stream only resumes
+    if job != 0 || resume != "" {
+        var err error
+        if last, err = sv.replayResults(w, rc, job, last); err != nil {
+            errorf("Error replaying results: %v\n", err)
+            return
+        }
+    }
+    if finished {
+        return
+    }
+    if err := rc.Flush(); err != nil {
+        return
+    }
+
+    keepalive := time.NewTicker(streamKeepalive)
+    defer keepalive.Stop()
+    for {
+        select {
+        case ev, ok := <-sub.events:
+            if !ok {
+                return
+            }
+            if ev.id != 0 {
+                if ev.id <= last {
+                    continue
+                }
+                last = ev.id
+            }
+            if err := writeEvent(w, rc, ev); err != nil {
+                return
+            }
+            if job != 0 && ev.done {
+                return
+            }
+        case <-keepalive.C:
+            if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+                return
+            }
+            if err := rc.Flush(); err != nil {
+                return
+            }
+        case <-r.Context().Done():
+            return
+        }
+    }
+}
+
+// GET /jobs/{id}/events
+func (sv *server) jobEvents(w http.ResponseWriter, r *http.Request) {
+    if job, ok := sv.pathJob(w, r); ok {
+        sv.streamEvents(w, r, job.ID)
+    }
+}
+
+// GET /events, live events of every job of the workspace
+func (sv *server) allEvents(w http.ResponseWriter, r *http.Request) {
+    sv.streamEvents(w, r, 0)
+}
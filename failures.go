@@ -0,0 +1,198 @@
+package main
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "net"
+    "os"
+    "strings"
+)
+
+// Cause of targets that ran out of -target-timeout
+var errTimedOut = errors.New("timed out")
+
+// Status other than 200 of a target's page
+type httpStatusError struct {
+    code int
+}
+
+func (e *httpStatusError) Error() string {
+    return fmt.Sprintf("error: status code %d", e.code)
+}
+
+// Kind of a failure as the errors table records it: dns, timeout, tls,
+// connection, http, circuit or other
+func errorCategory(err error) string {
+    var dnsErr *net.DNSError
+    var statusErr *httpStatusError
+    var circuitErr *circuitOpenError
+    var netErr net.Error
+    var opErr *net.OpError
+    var certErr *tls.CertificateVerificationError
+    var alertErr tls.AlertError
+    var recordErr tls.RecordHeaderError
+    var authorityErr x509.UnknownAuthorityError
+    var hostnameErr x509.HostnameError
+    var invalidErr x509.CertificateInvalidError
+    switch {
+    case errors.As(err, &dnsErr):
+        return "dns"
+    case errors.As(err, &statusErr):
+        return "http"
+    case errors.As(err, &circuitErr):
+        return "circuit"
+    case errors.Is(err, errDecompressionBomb):
+        return "bomb"
+    case errors.As(err, &certErr), errors.As(err, &alertErr), errors.As(err, &recordErr),
+        errors.As(err, &authorityErr), errors.As(err, &hostnameErr), errors.As(err, &invalidErr):
+        return "tls"
+    case errors.Is(err, errTimedOut), errors.As(err, &netErr) && netErr.Timeout():
+        return "timeout"
+    case errors.As(err, &opErr):
+        return "connection"
+    }
+    return "other"
+}
+
+// A failure saved for a later retry-failed
+type errorWrite struct {
+    run      int64
+    target   string
+    spec     string // target as JSON when it has per-target settings
+    url      string
+    category string
+    message  string
+}
+
+func (op errorWrite) apply(w batchStmts, now string) {
+    if _, err := w.failure.Exec(w.workspace, op.run, op.target, op.spec, op.url, op.category, op.message, now); err != nil {
+        errorf("Error recording failure of %s: %v\n", op.url, err)
+    }
+}
+
+// A failure as the errors table holds it
+type failureEntry struct {
+    RunID    int64
+    Target   string
+    Spec     string // JSON of the target's settings, if any
+    URL      string
+    Category string
+    Message  string
+    FailedAt string
+}
+
+// Failures of a run, optionally only those in the given categories, in the
+// order they happened
+func loadFailures(db *sql.DB, workspace string, run int64, categories []string) ([]failureEntry, error) {
+    query := `SELECT COALESCE(run_id, 0), target, COALESCE(spec, ''), url, category, message, failed_at FROM errors
+        WHERE workspace = ? AND run_id = ?`
+    args := []interface{}{workspace, run}
+    if len(categories) > 0 {
+        query += " AND category IN (?" + strings.Repeat(", ?", len(categories)-1) + ")"
+        for _, c := range categories {
+            args = append(args, c)
+        }
+    }
+    rows, err := db.Query(query+" ORDER BY id", args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var failures []failureEntry
+    for rows.Next() {
+        var f failureEntry
+        if err := rows.Scan(&f.RunID, &f.Target, &f.Spec, &f.URL, &f.Category, &f.Message, &f.FailedAt); err != nil {
+            return nil, err
+        }
+        failures = append(failures, f)
+    }
+    return failures, rows.Err()
+}
+
+// Most recent run in the workspace that recorded failures
+func lastFailedRun(db *sql.DB, workspace string) (int64, error) {
+    var run int64
+    err := db.QueryRow("SELECT COALESCE(MAX(run_id), 0) FROM errors WHERE workspace = ?", workspace).Scan(&run)
+    return run, err
+}
+
+// Targets of failures, once each and with their per-target settings
+func failedTargets(failures []failureEntry) []target {
+    seen := map[string]bool{}
+    var targets []target
+    for _, f := range failures {
+        if seen[f.Target] {
+            continue
+        }
+        seen[f.Target] = true
+        t := target{URL: f.Target}
+        if f.Spec != "" {
+            if err := json.Unmarshal([]byte(f.Spec), &t); err != nil {
+                errorf("Error reading settings of %s: %v\n", f.Target, err)
+                t = target{URL: f.Target}
+            }
+        }
+        targets = append(targets, t)
+    }
+    return targets
+}
+
+// Scan again the targets that failed in a run, by default the latest one
+// with failures
+func retryFailedCommand(args []string) {
+    fs := flag.NewFlagSet("retry-failed", flag.ExitOnError)
+    run := fs.Int64("run", 0, "Run whose failed targets to retry (default: the most recent run with failures)")
+    categories := fs.String("category", "", "Comma-separated failure categories to retry: dns, timeout, tls, connection, http, circuit, other (default all)")
+    list := fs.Bool("list", false, "Print the failures and exit without scanning")
+    dbOpts := dbFlags(fs)
+    opts := registerScanFlags(fs)
+    parseFlags(fs, args)
+
+    db, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer db.Close()
+
+    if *run == 0 {
+        if *run, err = lastFailedRun(db, dbOpts.workspace); err != nil {
+            fmt.Fprintf(os.Stderr, "Error loading failures: %v\n", err)
+            return
+        }
+        if *run == 0 {
+            fmt.Println("No failures recorded.")
+            return
+        }
+    }
+    failures, err := loadFailures(db, dbOpts.workspace, *run, splitList(strings.ToLower(*categories)))
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error loading failures: %v\n", err)
+        return
+    }
+    if *list {
+        for _, f := range failures {
+            fmt.Printf("%s\t%s\t%s\t%s\n", f.FailedAt, f.Category, f.URL, f.Message)
+        }
+        return
+    }
+    targets := failedTargets(failures)
+    if len(targets) == 0 {
+        fmt.Printf("No failed targets in run #%d.\n", *run)
+        return
+    }
+
+    s, err := opts.newScanner(db, dbOpts.workspace)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error setting up scan: %v\n", err)
+        return
+    }
+    defer s.close()
+    infof("Retrying %d targets that failed in run #%d\n", len(targets), *run)
+    s.scanTargets(signalContext(), targets)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+    "context"
+    "net/http"
+)
+
+// Downloads pages and favicons for a scan
+type fetcher interface {
+    fetchPage(ctx context.Context, pageURL string) (page, error)
+    fetchFavicon(ctx context.Context, url string, cond validators) (favicon, error)
+}
+
+// Fetcher over HTTP, or through headless Chrome when browser is set
+type httpFetcher struct {
+    client       *http.Client
+    browser      *renderer
+    maxRedirects int      // client-side redirects followed per page
+    preflight    bool     // HEAD each favicon before downloading it
+    maxIconSize  int64    // largest favicon body read, 0 for no limit
+    iconPaths    []string // -icon-paths tried on pages that declare no favicon
+    maxPageIcons int      // favicon links followed per page, 0 for no limit
+}
+
+// Where sites commonly keep an icon they do not link, for -icon-paths default
+const defaultIconPaths = "/favicon.ico,/favicon.png,/apple-touch-icon.png,/static/favicon.ico,/assets/img/favicon.ico"
+
+// Fetcher using client with the default -max-page-redirects
+func newHTTPFetcher(client *http.Client) *httpFetcher {
+    return &httpFetcher{client: client, maxRedirects: 3}
+}
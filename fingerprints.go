@@ -0,0 +1,56 @@
+package main
+
+import (
+    "encoding/csv"
+    "io"
+    "os"
+    "strings"
+)
+
+// Technology names keyed by MD5, SHA256 or MMH3 hash
+type fingerprints map[string]string
+
+// Load "hash,technology" lines from a CSV file; lines starting with # are ignored
+func loadFingerprints(filename string) (fingerprints, error) {
+    fps := fingerprints{}
+    if filename == "" {
+        return fps, nil
+    }
+
+    file, err := os.Open(filename)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+    return fps, fps.read(file)
+}
+
+// Add the "hash,technology" lines of r
+func (fps fingerprints) read(r io.Reader) error {
+    reader := csv.NewReader(r)
+    reader.Comment = '#'
+    reader.FieldsPerRecord = -1
+    for {
+        row, err := reader.Read()
+        if err == io.EOF {
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+        if len(row) < 2 {
+            continue
+        }
+        fps[strings.ToLower(strings.TrimSpace(row[0]))] = strings.TrimSpace(row[1])
+    }
+}
+
+// Identify the technology behind a set of hashes
+func (fps fingerprints) identify(md5Hash, sha256Hash, mmh3Hash string) string {
+    for _, h := range []string{mmh3Hash, md5Hash, sha256Hash} {
+        if tech, ok := fps[h]; ok && h != "" {
+            return tech
+        }
+    }
+    return ""
+}
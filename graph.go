@@ -0,0 +1,170 @@
+package main
+
+import (
+    "bufio"
+    "encoding/xml"
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "strings"
+)
+
+// Bipartite host <-> favicon graph
+type graph struct {
+    Hosts  []string
+    Icons  []record // one representative record per SHA256
+    Edges  [][2]string
+    counts map[string]int
+    labels map[string][]string // run labels of each node, by node ID
+}
+
+// Build the graph from stored records, one edge per distinct host/icon pair
+func buildGraph(records []record) graph {
+    g := graph{counts: map[string]int{}, labels: map[string][]string{}}
+    hostRecords := map[string][]record{}
+    seenHosts := map[string]struct{}{}
+    seenEdges := map[[2]string]struct{}{}
+    order, groups := groupByHash(records)
+    for _, sha := range order {
+        g.Icons = append(g.Icons, groups[sha][0])
+        g.labels["icon:"+sha] = recordRunLabels(groups[sha])
+        for _, r := range groups[sha] {
+            host := r.host()
+            if host == "" {
+                continue
+            }
+            hostRecords[host] = append(hostRecords[host], r)
+            if _, ok := seenHosts[host]; !ok {
+                seenHosts[host] = struct{}{}
+                g.Hosts = append(g.Hosts, host)
+            }
+            edge := [2]string{host, sha}
+            if _, ok := seenEdges[edge]; !ok {
+                seenEdges[edge] = struct{}{}
+                g.Edges = append(g.Edges, edge)
+                g.counts[sha]++
+            }
+        }
+    }
+    for host, rs := range hostRecords {
+        g.labels["host:"+host] = recordRunLabels(rs)
+    }
+    return g
+}
+
+// Run labels of a node as one comma-separated value
+func (g graph) runLabels(id string) string {
+    return strings.Join(g.labels[id], ",")
+}
+
+// Quote a string for DOT
+func dotQuote(s string) string {
+    return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// Write the graph in Graphviz DOT format
+func (g graph) writeDOT(w io.Writer) error {
+    bw := bufio.NewWriter(w)
+    fmt.Fprintln(bw, "graph maplink {")
+    fmt.Fprintln(bw, "  overlap=false;")
+    for _, h := range g.Hosts {
+        fmt.Fprintf(bw, "  %s [shape=box, type=host, run_labels=%s];\n", dotQuote("host:"+h), dotQuote(g.runLabels("host:"+h)))
+    }
+    for _, icon := range g.Icons {
+        label := "md5 " + icon.MD5
+        if icon.MMH3 != "" {
+            label += "\\nmmh3 " + icon.MMH3
+        }
+        fmt.Fprintf(bw, "  %s [shape=ellipse, type=favicon, label=\"%s\", hosts=%d, run_labels=%s];\n", dotQuote("icon:"+icon.SHA256), label, g.counts[icon.SHA256],
+            dotQuote(g.runLabels("icon:"+icon.SHA256)))
+    }
+    for _, e := range g.Edges {
+        fmt.Fprintf(bw, "  %s -- %s;\n", dotQuote("host:"+e[0]), dotQuote("icon:"+e[1]))
+    }
+    fmt.Fprintln(bw, "}")
+    return bw.Flush()
+}
+
+// Escape text for XML content and attributes
+func xmlEscape(s string) string {
+    var b strings.Builder
+    xml.EscapeText(&b, []byte(s))
+    return b.String()
+}
+
+// Write the graph in GraphML format for Gephi and friends
+func (g graph) writeGraphML(w io.Writer) error {
+    bw := bufio.NewWriter(w)
+    fmt.Fprintln(bw, `<?xml version="1.0" encoding="UTF-8"?>`)
+    fmt.Fprintln(bw, `<graphml xmlns="http://graphml.graphdrawing.org/xmlns">`)
+    fmt.Fprintln(bw, `  <key id="type" for="node" attr.name="type" attr.type="string"/>`)
+    fmt.Fprintln(bw, `  <key id="label" for="node" attr.name="label" attr.type="string"/>`)
+    fmt.Fprintln(bw, `  <key id="md5" for="node" attr.name="md5" attr.type="string"/>`)
+    fmt.Fprintln(bw, `  <key id="mmh3" for="node" attr.name="mmh3" attr.type="string"/>`)
+    fmt.Fprintln(bw, `  <key id="hosts" for="node" attr.name="hosts" attr.type="int"/>`)
+    fmt.Fprintln(bw, `  <key id="run_labels" for="node" attr.name="run_labels" attr.type="string"/>`)
+    fmt.Fprintln(bw, `  <graph id="maplink" edgedefault="undirected">`)
+    for _, h := range g.Hosts {
+        fmt.Fprintf(bw, "    <node id=\"%s\"><data key=\"type\">host</data><data key=\"label\">%s</data><data key=\"run_labels\">%s</data></node>\n",
+            xmlEscape("host:"+h), xmlEscape(h), xmlEscape(g.runLabels("host:"+h)))
+    }
+    for _, icon := range g.Icons {
+        fmt.Fprintf(bw, "    <node id=\"%s\"><data key=\"type\">favicon</data><data key=\"label\">%s</data><data key=\"md5\">%s</data><data key=\"mmh3\">%s</data><data key=\"hosts\">%d</data><data key=\"run_labels\">%s</data></node>\n",
+            xmlEscape("icon:"+icon.SHA256), xmlEscape(icon.MD5), xmlEscape(icon.MD5), xmlEscape(icon.MMH3), g.counts[icon.SHA256], xmlEscape(g.runLabels("icon:"+icon.SHA256)))
+    }
+    for i, e := range g.Edges {
+        fmt.Fprintf(bw, "    <edge id=\"e%d\" source=\"%s\" target=\"%s\"/>\n", i, xmlEscape("host:"+e[0]), xmlEscape("icon:"+e[1]))
+    }
+    fmt.Fprintln(bw, "  </graph>")
+    fmt.Fprintln(bw, "</graphml>")
+    return bw.Flush()
+}
+
+// Export the host <-> favicon graph
+func graphCommand(args []string) {
+    fs := flag.NewFlagSet("graph", flag.ExitOnError)
+    dbOpts := dbFlags(fs)
+    format := fs.String("format", "dot", "Graph format: dot or graphml")
+    output := fs.String("o", "", "Output file (default: stdout)")
+    parseFlags(fs, args)
+
+    if *format != "dot" && *format != "graphml" {
+        fmt.Fprintf(os.Stderr, "Unknown graph format %q (use dot or graphml)\n", *format)
+        return
+    }
+
+    db, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer db.Close()
+
+    records, err := loadRecords(db, dbOpts.workspace, false)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error reading database: %v\n", err)
+        return
+    }
+
+    var out io.Writer = os.Stdout
+    if *output != "" {
+        file, err := os.Create(*output)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error creating output file: %v\n", err)
+            return
+        }
+        defer file.Close()
+        out = file
+    }
+
+    g := buildGraph(records)
+    if *format == "graphml" {
+        err = g.writeGraphML(out)
+    } else {
+        err = g.writeDOT(out)
+    }
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error writing graph: %v\n", err)
+    }
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+    "crypto/md5"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/binary"
+    "encoding/hex"
+    "math/bits"
+    "strconv"
+    "strings"
+)
+
+// Hashes recorded for a favicon
+type iconHashes struct {
+    MD5    string
+    SHA256 string
+    MMH3   string
+}
+
+// Computes the hashes recorded for favicon bytes
+type hasher interface {
+    hash(data []byte) iconHashes
+}
+
+// Adapter to use a plain function as a hasher
+type hashFunc func(data []byte) iconHashes
+
+func (f hashFunc) hash(data []byte) iconHashes {
+    return f(data)
+}
+
+// Calculate MD5, SHA256 and the Shodan-style MMH3 hash of favicon bytes
+func calculateHashes(data []byte) iconHashes {
+    md5Sum := md5.Sum(data)
+    sha256Sum := sha256.Sum256(data)
+    return iconHashes{
+        MD5:    hex.EncodeToString(md5Sum[:]),
+        SHA256: hex.EncodeToString(sha256Sum[:]),
+        MMH3:   faviconMMH3(data),
+    }
+}
+
+// MurmurHash3 (x86, 32-bit) of the base64 encoding of the icon, wrapped at
+// 76 columns with a trailing newline, matching Python's base64.encodebytes
+// as used by Shodan's http.favicon.hash
+func faviconMMH3(data []byte) string {
+    encoded := base64.StdEncoding.EncodeToString(data)
+    var b strings.Builder
+    for len(encoded) > 76 {
+        b.WriteString(encoded[:76])
+        b.WriteByte('\n')
+        encoded = encoded[76:]
+    }
+    b.WriteString(encoded)
+    b.WriteByte('\n')
+    return strconv.FormatInt(int64(int32(murmur3([]byte(b.String()), 0))), 10)
+}
+
+// MurmurHash3 x86 32-bit
+func murmur3(data []byte, seed uint32) uint32 {
+    const c1, c2 = 0xcc9e2d51, 0x1b873593
+    h := seed
+    n := len(data) / 4
+    for i := 0; i < n; i++ {
+        k := binary.LittleEndian.Uint32(data[i*4:])
+        k *= c1
+        k = bits.RotateLeft32(k, 15)
+        k *= c2
+        h ^= k
+        h = bits.RotateLeft32(h, 13)
+        h = h*5 + 0xe6546b64
+    }
+
+    tail := data[n*4:]
+    var k uint32
+    switch len(tail) {
+    case 3:
+        k ^= uint32(tail[2]) << 16
+        fallthrough
+    case 2:
+        k ^= uint32(tail[1]) << 8
+        fallthrough
+    case 1:
+        k ^= uint32(tail[0])
+        k *= c1
+        k = bits.RotateLeft32(k, 15)
+        k *= c2
+        h ^= k
+    }
+
+    h ^= uint32(len(data))
+    h ^= h >> 16
+    h *= 0x85ebca6b
+    h ^= h >> 13
+    h *= 0xc2b2ae35
+    h ^= h >> 16
+    return h
+}
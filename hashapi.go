@@ -0,0 +1,156 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+
+    "golang.org/x/sync/singleflight"
+)
+
+// A favicon of GET /hash
+type apiIconHash struct {
+    URL         string `json:"url"`
+    ContentType string `json:"content_type,omitempty"`
+    Size        int    `json:"size"`
+    MD5         string `json:"md5"`
+    SHA256      string `json:"sha256"`
+    MMH3        string `json:"mmh3"`
+}
+
+// A page or favicon GET /hash could not fetch
+type apiHashError struct {
+    URL   string `json:"url"`
+    Error string `json:"error"`
+}
+
+// Answer to GET /hash
+type apiHashResult struct {
+    URL       string         `json:"url"`
+    Title     string         `json:"title,omitempty"`
+    Icons     []apiIconHash  `json:"icons"`
+    Errors    []apiHashError `json:"errors,omitempty"`
+    FetchedAt string         `json:"fetched_at"`
+    Cached    bool           `json:"cached"`
+}
+
+// Longest a GET /hash lookup fetches for when the scan flags set no -target-timeout
+const hashLookupTimeout = time.Minute
+
+// Cached entries kept before expired ones are swept
+const hashCacheSweep = 1024
+
+// On-the-spot favicon lookups with the scanner's settings but a fetcher of
+// their own, cached for ttl. Requests for a URL already being fetched wait
+// for that fetch.
+type hashLookup struct {
+    s       *scanner
+    fetcher fetcher
+    ttl     time.Duration
+    group   singleflight.Group
+
+    mu      sync.Mutex
+    entries map[string]apiHashResult
+}
+
+func newHashLookup(s *scanner, f fetcher, ttl time.Duration) *hashLookup {
+    return &hashLookup{s: s, fetcher: f, ttl: ttl, entries: map[string]apiHashResult{}}
+}
+
+func (l *hashLookup) cached(rawURL string) (apiHashResult, bool) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    res, ok := l.entries[rawURL]
+    if !ok {
+        return res, false
+    }
+    if at, err := time.Parse(time.RFC3339, res.FetchedAt); err != nil || time.Since(at) >= l.ttl {
+        delete(l.entries, rawURL)
+        return res, false
+    }
+    res.Cached = true
+    return res, true
+}
+
+func (l *hashLookup) remember(rawURL string, res apiHashResult) {
+    if l.ttl <= 0 {
+        return
+    }
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if len(l.entries) >= hashCacheSweep {
+        for u, e := range l.entries {
+            if at, err := time.Parse(time.RFC3339, e.FetchedAt); err != nil || time.Since(at) >= l.ttl {
+                delete(l.entries, u)
+            }
+        }
+    }
+    l.entries[rawURL] = res
+}
+
+// Hashes of the favicons of one target, from the cache or fetched now
+func (l *hashLookup) lookup(rawURL string) apiHashResult {
+    if res, ok := l.cached(rawURL); ok {
+        return res
+    }
+    v, _, _ := l.group.Do(rawURL, func() (interface{}, error) {
+        res := l.fetch(rawURL)
+        l.remember(rawURL, res)
+        return res, nil
+    })
+    return v.(apiHashResult)
+}
+
+func (l *hashLookup) fetch(rawURL string) apiHashResult {
+    s := l.s
+    res := apiHashResult{URL: rawURL, Icons: []apiIconHash{}}
+    // Shared by every request waiting on the lookup, so none of their contexts
+    timeout := s.targetTimeout
+    if timeout <= 0 {
+        timeout = hashLookupTimeout
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), timeout)
+    defer cancel()
+
+    t := target{URL: rawURL, HostHeader: s.hostHeader, SNI: s.sni}
+    pageURL := rawURL
+    if !strings.Contains(pageURL, "://") {
+        probed, err := probeHost(ctx, pageURL, s.probes, s.probeTimeout)
+        if err != nil {
+            res.Errors = append(res.Errors, apiHashError{URL: rawURL, Error: err.Error()})
+            res.FetchedAt = time.Now().UTC().Format(time.RFC3339)
+            return res
+        }
+        pageURL = probed
+    }
+    icons := downloadFavicons(withTarget(ctx, t), l.fetcher, pageURL, nil, func(url string, err error) {
+        res.Errors = append(res.Errors, apiHashError{URL: url, Error: err.Error()})
+    })
+    for _, icon := range icons {
+        h := s.hash.hash(icon.Data)
+        res.Icons = append(res.Icons, apiIconHash{URL: icon.URL, ContentType: icon.ContentType, Size: len(icon.Data), MD5: h.MD5, SHA256: h.SHA256, MMH3: h.MMH3})
+        if res.Title == "" {
+            res.Title = icon.Title
+        }
+    }
+    res.FetchedAt = time.Now().UTC().Format(time.RFC3339)
+    return res
+}
+
+// GET /hash?url= fetches one target's favicons and answers with their hashes,
+// without queueing a job or storing anything
+func (sv *server) hashURL(w http.ResponseWriter, r *http.Request) {
+    rawURL := strings.TrimSpace(r.URL.Query().Get("url"))
+    if rawURL == "" {
+        writeError(w, http.StatusBadRequest, "url is required")
+        return
+    }
+    if err := validateTarget(rawURL); err != nil {
+        writeError(w, http.StatusBadRequest, "url: "+err.Error())
+        return
+    }
+    auditFrom(r.Context()).targets = []string{rawURL}
+    writeJSON(w, http.StatusOK, sv.lookups.lookup(rawURL))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "os"
+    "os/exec"
+    "time"
+)
+
+// Scan events hook commands can run on
+const (
+    hookHashed  = "hashed"  // every favicon stored, changed or not
+    hookNew     = "new"     // a link seen for the first time
+    hookChanged = "changed" // a link whose hashes differ from the stored ones
+    hookHunted  = "hunted"  // a favicon matching a -hunt hash
+)
+
+// Shell commands to run per event, each fed the result JSON on stdin
+type hookCommands map[string]string
+
+// Events a result fires, in the order their hooks run
+func hookEvents(r result) []string {
+    events := []string{hookHashed}
+    switch r.Status {
+    case "new":
+        events = append(events, hookNew)
+    case "changed":
+        events = append(events, hookChanged)
+    }
+    if r.Match != "" {
+        events = append(events, hookHunted)
+    }
+    return events
+}
+
+// A hook run waiting in the queue
+type hookCall struct {
+    event   string
+    command string
+    result  []byte
+}
+
+// Sink that runs external commands for results. Writes only queue the
+// runs, as they happen under the scan's lock; a hook that blocks holds up
+// the hooks after it, never the scan.
+type hookSink struct {
+    commands hookCommands
+    timeout  time.Duration
+    calls    *backgroundQueue[hookCall]
+}
+
+func newHookSink(commands hookCommands, timeout time.Duration) *hookSink {
+    h := &hookSink{commands: commands, timeout: timeout}
+    h.calls = newBackgroundQueue("hook run", func(call hookCall) {
+        if err := h.exec(call); err != nil {
+            errorf("Error running %s hook: %v\n", call.event, err)
+        }
+    })
+    return h
+}
+
+func (h *hookSink) Write(r result) error {
+    var payload []byte
+    for _, event := range hookEvents(r) {
+        command, ok := h.commands[event]
+        if !ok {
+            continue
+        }
+        if payload == nil {
+            var err error
+            if payload, err = json.Marshal(r); err != nil {
+                return err
+            }
+        }
+        h.calls.push(hookCall{event: event, command: command, result: payload})
+    }
+    return nil
+}
+
+// Wait for queued hooks to finish
+func (h *hookSink) Close() error {
+    h.calls.close()
+    return nil
+}
+
+// Run one hook through the shell with the event in MAPLINK_EVENT. Its
+// output goes to stderr so it never mixes with results on stdout.
+func (h *hookSink) exec(call hookCall) error {
+    ctx, cancel := context.Background(), context.CancelFunc(func() {})
+    if h.timeout > 0 {
+        ctx, cancel = context.WithTimeout(ctx, h.timeout)
+    }
+    defer cancel()
+
+    cmd := exec.CommandContext(ctx, "sh", "-c", call.command)
+    cmd.Env = append(os.Environ(), "MAPLINK_EVENT="+call.event)
+    cmd.Stdin = bytes.NewReader(append(call.result, '\n'))
+    cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+    // Children left behind by a killed shell must not hold the scan up
+    cmd.WaitDelay = time.Second
+    if err := cmd.Run(); err != nil {
+        if ctx.Err() == context.DeadlineExceeded {
+            return fmt.Errorf("timed out after %s", h.timeout)
+        }
+        return err
+    }
+    return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+    "io"
+    "net/http"
+    "sync"
+)
+
+// HTTP transport that lets at most limit requests per host be in flight at
+// once, however many workers are scanning. A slot is held until the
+// response body is closed, since the connection is busy until then.
+type hostLimiter struct {
+    next  http.RoundTripper
+    limit int
+    mu    sync.Mutex
+    slots map[string]chan struct{}
+}
+
+// Wrap next with a per-host cap of limit concurrent requests
+func newHostLimiter(next http.RoundTripper, limit int) *hostLimiter {
+    return &hostLimiter{next: next, limit: limit, slots: map[string]chan struct{}{}}
+}
+
+// Semaphore of a host, created on first use
+func (l *hostLimiter) slot(host string) chan struct{} {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    sem, ok := l.slots[host]
+    if !ok {
+        sem = make(chan struct{}, l.limit)
+        l.slots[host] = sem
+    }
+    return sem
+}
+
+func (l *hostLimiter) RoundTrip(req *http.Request) (*http.Response, error) {
+    sem := l.slot(req.URL.Hostname())
+    select {
+    case sem <- struct{}{}:
+    case <-req.Context().Done():
+        return nil, req.Context().Err()
+    }
+    release := func() { <-sem }
+
+    resp, err := l.next.RoundTrip(req)
+    if err != nil {
+        release()
+        return nil, err
+    }
+    resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+    return resp, nil
+}
+
+// Response body that frees its host slot once, on Close
+type releasingBody struct {
+    io.ReadCloser
+    once    sync.Once
+    release func()
+}
+
+func (b *releasingBody) Close() error {
+    err := b.ReadCloser.Close()
+    b.once.Do(b.release)
+    return err
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+    "database/sql"
+    "flag"
+    "fmt"
+    "os"
+    "strings"
+)
+
+// Statements keeping hosts and observations current as favicons are written
+const (
+    hostSQL = `INSERT INTO hosts(workspace, name, apex, first_seen, last_seen) VALUES(?, ?, NULLIF(?, ''), ?, ?)
+        ON CONFLICT(workspace, name) DO UPDATE SET last_seen = excluded.last_seen RETURNING id`
+    observationSQL = `INSERT INTO observations(workspace, host_id, link, sha256, first_seen, last_seen, run_id) VALUES(?, ?, ?, ?, ?, ?, NULLIF(?, 0))
+        ON CONFLICT(host_id, link, sha256) DO UPDATE SET last_seen = excluded.last_seen, run_id = COALESCE(excluded.run_id, run_id)`
+    hostOfLinkSQL = "UPDATE favicons SET host_id = ? WHERE workspace = ? AND link = ?"
+)
+
+// Host a favicon was observed on: that of the target it was found on, or
+// the link's own when the target is unknown
+func observedHost(target, link string) string {
+    host := targetHost(target)
+    if host == "" {
+        host = record{Link: link}.host()
+    }
+    return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// A favicon seen on a host, on its way to hosts and observations
+type observation struct {
+    host   string
+    link   string
+    sha256 string
+    run    int64
+}
+
+// Record the observation and point the favicon row at its host
+func (op observation) apply(w batchStmts, now string) {
+    if op.host == "" || op.sha256 == "" {
+        return
+    }
+    var id int64
+    if err := w.host.QueryRow(w.workspace, op.host, apexDomain(op.host), now, now).Scan(&id); err != nil {
+        errorf("Error saving host %s: %v\n", op.host, err)
+        return
+    }
+    if _, err := w.hostOfLink.Exec(id, w.workspace, op.link); err != nil {
+        errorf("Error saving host of %s: %v\n", op.link, err)
+    }
+    if _, err := w.observation.Exec(w.workspace, id, op.link, op.sha256, now, now, op.run); err != nil {
+        errorf("Error saving observation of %s: %v\n", op.link, err)
+    }
+}
+
+// Link favicons without a host to the host of their target, and seed
+// observations from history and the current hashes. Safe to run again:
+// it runs after migration 0025 and after merges.
+func linkHosts(tx *sql.Tx) error {
+    rows, err := tx.Query("SELECT workspace, link, COALESCE(target, ''), COALESCE(first_seen, ''), COALESCE(last_seen, '') FROM favicons WHERE host_id IS NULL")
+    if err != nil {
+        return err
+    }
+    type unlinked struct{ workspace, link, target, firstSeen, lastSeen string }
+    var pending []unlinked
+    for rows.Next() {
+        var u unlinked
+        if err := rows.Scan(&u.workspace, &u.link, &u.target, &u.firstSeen, &u.lastSeen); err != nil {
+            rows.Close()
+            return err
+        }
+        pending = append(pending, u)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return err
+    }
+
+    for _, u := range pending {
+        host := observedHost(u.target, u.link)
+        if host == "" {
+            continue
+        }
+        var id int64
+        if err := tx.QueryRow(`INSERT INTO hosts(workspace, name, apex, first_seen, last_seen) VALUES(?, ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''))
+            ON CONFLICT(workspace, name) DO UPDATE SET
+                first_seen = CASE WHEN hosts.first_seen IS NULL OR excluded.first_seen < hosts.first_seen THEN excluded.first_seen ELSE hosts.first_seen END,
+                last_seen = CASE WHEN hosts.last_seen IS NULL OR excluded.last_seen > hosts.last_seen THEN excluded.last_seen ELSE hosts.last_seen END
+            RETURNING id`, u.workspace, host, apexDomain(host), u.firstSeen, u.lastSeen).Scan(&id); err != nil {
+            return fmt.Errorf("saving host %s: %v", host, err)
+        }
+        if _, err := tx.Exec(hostOfLinkSQL, id, u.workspace, u.link); err != nil {
+            return fmt.Errorf("saving host of %s: %v", u.link, err)
+        }
+    }
+
+    // Every hash a link had, on the host it is linked to, spanning the times it was seen
+    if _, err := tx.Exec(`INSERT INTO observations(workspace, host_id, link, sha256, first_seen, last_seen, run_id)
+        SELECT f.workspace, f.host_id, s.link, s.sha256, MIN(s.seen_at), MAX(s.seen_at), MAX(s.run_id)
+        FROM (SELECT workspace, link, sha256, seen_at, run_id FROM history
+            UNION ALL SELECT workspace, link, sha256, last_seen, NULL FROM favicons) s
+        JOIN favicons f ON f.workspace = s.workspace AND f.link = s.link
+        JOIN favicon_blobs b ON b.sha256 = s.sha256
+        WHERE f.host_id IS NOT NULL AND s.seen_at IS NOT NULL
+        GROUP BY f.workspace, f.host_id, s.link, s.sha256
+        ON CONFLICT(host_id, link, sha256) DO UPDATE SET
+            first_seen = MIN(first_seen, excluded.first_seen), last_seen = MAX(last_seen, excluded.last_seen),
+            run_id = COALESCE(run_id, excluded.run_id)`); err != nil {
+        return fmt.Errorf("seeding observations: %v", err)
+    }
+    return nil
+}
+
+// Remove hosts no favicon or observation refers to any more
+const orphanHostsSQL = `DELETE FROM hosts WHERE id NOT IN (SELECT host_id FROM observations)
+    AND id NOT IN (SELECT host_id FROM favicons WHERE host_id IS NOT NULL)`
+
+// Remove icons no favicon, history row or observation refers to any more
+const orphanBlobsSQL = `DELETE FROM favicon_blobs WHERE sha256 NOT IN (SELECT sha256 FROM favicons)
+    AND sha256 NOT IN (SELECT sha256 FROM history) AND sha256 NOT IN (SELECT sha256 FROM observations)`
+
+// A host with how much was seen on it
+type hostEntry struct {
+    Name      string
+    Apex      string
+    Links     int
+    Icons     int
+    FirstSeen string
+    LastSeen  string
+}
+
+// One observation joined with its host and icon
+type observationEntry struct {
+    Host      string
+    Link      string
+    SHA256    string
+    MD5       string
+    MMH3      string
+    FirstSeen string
+    LastSeen  string
+}
+
+// Observations of a workspace matching a WHERE clause over hosts h,
+// observations o and favicon_blobs b, most recent first
+func queryObservations(db *sql.DB, workspace, where string, args ...interface{}) ([]observationEntry, error) {
+    rows, err := db.Query(`SELECT h.name, o.link, o.sha256, COALESCE(b.md5, ''), COALESCE(b.mmh3, ''), o.first_seen, o.last_seen
+        FROM observations o JOIN hosts h ON h.id = o.host_id LEFT JOIN favicon_blobs b ON b.sha256 = o.sha256
+        WHERE o.workspace = ? AND (`+where+`) ORDER BY o.last_seen DESC, h.name, o.link`, append([]interface{}{workspace}, args...)...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var out []observationEntry
+    for rows.Next() {
+        var e observationEntry
+        if err := rows.Scan(&e.Host, &e.Link, &e.SHA256, &e.MD5, &e.MMH3, &e.FirstSeen, &e.LastSeen); err != nil {
+            return nil, err
+        }
+        out = append(out, e)
+    }
+    return out, rows.Err()
+}
+
+// Hosts of a workspace with their link and icon counts, busiest first
+func loadHosts(db *sql.DB, workspace string) ([]hostEntry, error) {
+    rows, err := db.Query(`SELECT h.name, COALESCE(h.apex, ''), COUNT(DISTINCT o.link), COUNT(DISTINCT o.sha256),
+            COALESCE(h.first_seen, ''), COALESCE(h.last_seen, '')
+        FROM hosts h LEFT JOIN observations o ON o.host_id = h.id WHERE h.workspace = ?
+        GROUP BY h.id ORDER BY COUNT(DISTINCT o.sha256) DESC, h.name`, workspace)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var out []hostEntry
+    for rows.Next() {
+        var e hostEntry
+        if err := rows.Scan(&e.Name, &e.Apex, &e.Links, &e.Icons, &e.FirstSeen, &e.LastSeen); err != nil {
+            return nil, err
+        }
+        out = append(out, e)
+    }
+    return out, rows.Err()
+}
+
+// List hosts, the icons a host served, or the hosts that served an icon
+func hostsCommand(args []string) {
+    fs := flag.NewFlagSet("hosts", flag.ExitOnError)
+    dbOpts := dbFlags(fs)
+    host := fs.String("host", "", "List the icons this host served")
+    fs.Usage = func() {
+        fmt.Fprintln(fs.Output(), "Usage: maplink hosts [-host name] [md5|sha256|mmh3]")
+        fs.PrintDefaults()
+    }
+    parseFlags(fs, args)
+
+    hash := strings.ToLower(strings.TrimSpace(fs.Arg(0)))
+    if hash != "" && hashKind(hash) == "" {
+        fmt.Fprintf(os.Stderr, "Error: %q is not an MD5, SHA256 or MMH3 hash\n", hash)
+        return
+    }
+    db, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer db.Close()
+
+    if hash == "" && *host == "" {
+        hosts, err := loadHosts(db, dbOpts.workspace)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error loading hosts: %v\n", err)
+            return
+        }
+        for _, h := range hosts {
+            fmt.Printf("%s\t%s\t%d links\t%d icons\tfirst seen %s\tlast seen %s\n", h.Name, h.Apex, h.Links, h.Icons, h.FirstSeen, h.LastSeen)
+        }
+        return
+    }
+
+    var where []string
+    var params []interface{}
+    if *host != "" {
+        where = append(where, "h.name = ?")
+        params = append(params, strings.ToLower(*host))
+    }
+    switch hashKind(hash) {
+    case "sha256":
+        where = append(where, "o.sha256 = ?")
+        params = append(params, hash)
+    case "md5":
+        where = append(where, "b.md5 = ?")
+        params = append(params, hash)
+    case "mmh3":
+        where = append(where, "b.mmh3 = ?")
+        params = append(params, hash)
+    }
+    found, err := queryObservations(db, dbOpts.workspace, strings.Join(where, " AND "), params...)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error loading observations: %v\n", err)
+        return
+    }
+    if len(found) == 0 {
+        fmt.Println("Nothing observed.")
+        return
+    }
+    for _, o := range found {
+        fmt.Printf("%s\t%s\tSHA256 %s\tMMH3 %s\tfirst seen %s\tlast seen %s\n", o.Host, o.Link, o.SHA256, o.MMH3, o.FirstSeen, o.LastSeen)
+    }
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+    "bytes"
+    "io"
+
+    "golang.org/x/net/html"
+)
+
+// Most of a page read while looking for the end of its head
+const maxHeadBytes = 2 << 20
+
+// Elements that may appear in a document head; any other start tag means
+// the body has begun even when </head> was omitted
+var headTags = map[string]bool{
+    "html": true, "head": true, "title": true, "base": true, "link": true, "meta": true,
+    "style": true, "script": true, "noscript": true, "template": true,
+}
+
+// Read a page up to the end of its head with a streaming tokenizer and
+// return the markup seen so far. Icons, the title, the base element and
+// refresh redirects all live in the head, so the body is never downloaded.
+func readHead(r io.Reader) (string, error) {
+    z := html.NewTokenizer(io.LimitReader(r, maxHeadBytes))
+    var buf bytes.Buffer
+    for {
+        tt := z.Next()
+        if tt == html.ErrorToken {
+            if z.Err() == io.EOF {
+                return buf.String(), nil
+            }
+            return buf.String(), z.Err()
+        }
+        // Raw is only valid until TagName, so copy it first
+        mark := buf.Len()
+        buf.Write(z.Raw())
+        switch tt {
+        case html.StartTagToken, html.SelfClosingTagToken:
+            if name, _ := z.TagName(); !headTags[string(name)] {
+                buf.Truncate(mark)
+                return buf.String(), nil
+            }
+        case html.EndTagToken:
+            if name, _ := z.TagName(); string(name) == "head" {
+                return buf.String(), nil
+            }
+        }
+    }
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+    "database/sql"
+    "flag"
+    "fmt"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Kind of a favicon hash by its form: md5, sha256 or mmh3, or "" when it
+// is none of them
+func hashKind(hash string) string {
+    isHex := hash != "" && strings.Trim(hash, "0123456789abcdef") == ""
+    switch {
+    case isHex && len(hash) == 32:
+        return "md5"
+    case isHex && len(hash) == 64:
+        return "sha256"
+    }
+    if _, err := strconv.ParseInt(hash, 10, 32); err == nil {
+        return "mmh3"
+    }
+    return ""
+}
+
+// Hashes watched in a workspace: every scan into it alerts on them
+func loadWatchlist(db *sql.DB, workspace string) (map[string]struct{}, error) {
+    rows, err := db.Query("SELECT hash FROM watchlist WHERE workspace = ?", workspace)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    watched := map[string]struct{}{}
+    for rows.Next() {
+        var hash string
+        if err := rows.Scan(&hash); err != nil {
+            return nil, err
+        }
+        watched[hash] = struct{}{}
+    }
+    return watched, rows.Err()
+}
+
+// Hashes of -hunt and the workspace watchlist. Reread at the start of every
+// run, so a daemon picks up hashes added in the meantime.
+func (s *scanner) refreshHunted() {
+    hunted := map[string]struct{}{}
+    for h := range s.huntFlag {
+        hunted[h] = struct{}{}
+    }
+    watched, err := s.store.watchlist()
+    if err != nil {
+        errorf("Error loading watchlist: %v\n", err)
+    }
+    for h := range watched {
+        hunted[h] = struct{}{}
+    }
+    s.hunted = hunted
+}
+
+// Add, remove and list watched hashes, and look up where they were seen
+func huntCommand(args []string) {
+    if len(args) == 0 || isHelp(args[0]) {
+        fmt.Fprintln(os.Stderr, "Usage: maplink hunt add|remove|list|lookup|import [flags] [hash...]")
+        return
+    }
+    if args[0] == "import" {
+        huntImportCommand(args[1:])
+        return
+    }
+    fs := flag.NewFlagSet("hunt "+args[0], flag.ExitOnError)
+    dbOpts := dbFlags(fs)
+    label := fs.String("label", "", "What the hashes are, e.g. the kit or campaign (with add)")
+    parseFlags(fs, args[1:])
+
+    var hashes []string
+    for _, h := range fs.Args() {
+        h = strings.ToLower(strings.TrimSpace(h))
+        if hashKind(h) == "" {
+            fmt.Fprintf(os.Stderr, "Error: %q is not an MD5, SHA256 or MMH3 hash\n", h)
+            return
+        }
+        hashes = append(hashes, h)
+    }
+    switch args[0] {
+    case "add", "remove":
+        if len(hashes) == 0 {
+            fmt.Fprintf(os.Stderr, "Usage: maplink hunt %s <md5|sha256|mmh3>...\n", args[0])
+            return
+        }
+    case "list", "lookup":
+    default:
+        fmt.Fprintf(os.Stderr, "Unknown hunt command %q\n", args[0])
+        return
+    }
+
+    db, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer db.Close()
+
+    switch args[0] {
+    case "add":
+        now := time.Now().UTC().Format(time.RFC3339)
+        for _, h := range hashes {
+            if _, err := db.Exec(`INSERT INTO watchlist(workspace, hash, kind, label, added_at) VALUES(?, ?, ?, NULLIF(?, ''), ?)
+                ON CONFLICT(workspace, hash) DO UPDATE SET label = COALESCE(excluded.label, label)`, dbOpts.workspace, h, hashKind(h), *label, now); err != nil {
+                fmt.Fprintf(os.Stderr, "Error saving %s: %v\n", h, err)
+                return
+            }
+        }
+        recordCLIAction(db, auditAction{Workspace: dbOpts.workspace, Action: actionWatchlistAdd, Subject: strings.Join(hashes, ","), Detail: *label})
+        fmt.Printf("Watching %d hashes in workspace %s\n", len(hashes), dbOpts.workspace)
+    case "remove":
+        for _, h := range hashes {
+            if _, err := db.Exec("DELETE FROM watchlist WHERE workspace = ? AND hash = ?", dbOpts.workspace, h); err != nil {
+                fmt.Fprintf(os.Stderr, "Error removing %s: %v\n", h, err)
+                return
+            }
+        }
+        recordCLIAction(db, auditAction{Workspace: dbOpts.workspace, Action: actionWatchlistRemove, Subject: strings.Join(hashes, ",")})
+        fmt.Printf("Stopped watching %d hashes\n", len(hashes))
+    case "list":
+        rows, err := db.Query("SELECT hash, kind, COALESCE(label, ''), COALESCE(source, ''), added_at FROM watchlist WHERE workspace = ? ORDER BY added_at, hash", dbOpts.workspace)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error reading watchlist: %v\n", err)
+            return
+        }
+        defer rows.Close()
+        for rows.Next() {
+            var hash, kind, l, source, added string
+            if err := rows.Scan(&hash, &kind, &l, &source, &added); err != nil {
+                fmt.Fprintf(os.Stderr, "Error reading watchlist: %v\n", err)
+                return
+            }
+            fmt.Printf("%s\t%s\t%s\t%s\t%s\n", hash, kind, l, source, added)
+        }
+    case "lookup":
+        // Every watched hash unless some are given
+        if len(hashes) == 0 {
+            watched, err := loadWatchlist(db, dbOpts.workspace)
+            if err != nil {
+                fmt.Fprintf(os.Stderr, "Error reading watchlist: %v\n", err)
+                return
+            }
+            for h := range watched {
+                hashes = append(hashes, h)
+            }
+        }
+        if len(hashes) == 0 {
+            fmt.Println("No hashes watched.")
+            return
+        }
+        records, err := matchingRecords(db, dbOpts.workspace, hashes)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error reading favicons: %v\n", err)
+            return
+        }
+        if len(records) == 0 {
+            fmt.Println("No stored favicon matches.")
+            return
+        }
+        printMatchingRecords(records)
+    }
+}
+
+// Stored favicons with any of the hashes
+func matchingRecords(db *sql.DB, workspace string, hashes []string) ([]record, error) {
+    var records []record
+    seen := map[string]bool{}
+    // A chunk at a time, within SQLite's limit on bound parameters
+    for len(hashes) > 0 {
+        chunk := hashes[:min(len(hashes), 300)]
+        hashes = hashes[len(chunk):]
+        in := "(?" + strings.Repeat(", ?", len(chunk)-1) + ")"
+        var params []interface{}
+        for i := 0; i < 3; i++ {
+            for _, h := range chunk {
+                params = append(params, h)
+            }
+        }
+        found, err := queryRecords(db, workspace, false, "f.md5 IN "+in+" OR f.sha256 IN "+in+" OR b.mmh3 IN "+in, params...)
+        if err != nil {
+            return nil, err
+        }
+        for _, r := range found {
+            if !seen[r.Link] {
+                seen[r.Link] = true
+                records = append(records, r)
+            }
+        }
+    }
+    return records, nil
+}
+
+func printMatchingRecords(records []record) {
+    for _, r := range records {
+        fmt.Printf("%s\t%s\tMD5 %s\tMMH3 %s\tlast seen %s\n", r.host(), r.Link, r.MD5, r.MMH3, r.LastSeen)
+    }
+}
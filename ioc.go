@@ -0,0 +1,254 @@
+package main
+
+import (
+    "bufio"
+    "encoding/csv"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "regexp"
+    "strings"
+    "time"
+)
+
+// A hash from a threat intel list, with what the list says it is
+type indicator struct {
+    hash  string
+    label string
+}
+
+// Indicators of a plain list: one hash per line, # for comments, anything
+// after the hash and a space, tab or comma is its label
+func readPlainIndicators(r io.Reader) ([]indicator, int, error) {
+    var out []indicator
+    invalid := 0
+    lines := bufio.NewScanner(r)
+    for lines.Scan() {
+        line := strings.TrimSpace(lines.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        hash, label := line, ""
+        if i := strings.IndexAny(line, " \t,;"); i >= 0 {
+            hash, label = line[:i], strings.Trim(line[i+1:], " \t,;")
+        }
+        if hash = strings.ToLower(hash); hashKind(hash) == "" {
+            invalid++
+            continue
+        }
+        out = append(out, indicator{hash: hash, label: label})
+    }
+    return out, invalid, lines.Err()
+}
+
+// Header names of CSV columns holding hashes and their labels
+var (
+    csvHashColumns  = map[string]bool{"hash": true, "md5": true, "sha256": true, "sha-256": true, "mmh3": true, "favicon_hash": true, "indicator": true, "ioc": true, "value": true}
+    csvLabelColumns = map[string]bool{"label": true, "name": true, "description": true, "comment": true, "tags": true, "threat": true}
+)
+
+// Indicators of a CSV list. With a header row naming hash columns, those
+// are read and a label column if any; without one, the first column is
+// the hash and the second its label.
+func readCSVIndicators(r io.Reader) ([]indicator, int, error) {
+    cr := csv.NewReader(r)
+    cr.FieldsPerRecord, cr.Comment = -1, '#'
+    rows, err := cr.ReadAll()
+    if err != nil {
+        return nil, 0, err
+    }
+    hashCols, labelCol := []int{0}, 1
+    if len(rows) > 0 {
+        var named []int
+        label := -1
+        for i, name := range rows[0] {
+            name = strings.ToLower(strings.TrimSpace(name))
+            if csvHashColumns[name] {
+                named = append(named, i)
+            } else if csvLabelColumns[name] && label < 0 {
+                label = i
+            }
+        }
+        if len(named) > 0 {
+            hashCols, labelCol, rows = named, label, rows[1:]
+        }
+    }
+
+    var out []indicator
+    invalid := 0
+    for _, row := range rows {
+        label := ""
+        if labelCol >= 0 && labelCol < len(row) {
+            label = strings.TrimSpace(row[labelCol])
+        }
+        for _, col := range hashCols {
+            if col >= len(row) || strings.TrimSpace(row[col]) == "" {
+                continue
+            }
+            hash := strings.ToLower(strings.TrimSpace(row[col]))
+            if hashKind(hash) == "" {
+                invalid++
+                continue
+            }
+            out = append(out, indicator{hash: hash, label: label})
+        }
+    }
+    return out, invalid, nil
+}
+
+// Hash comparisons in a STIX pattern, e.g. [file:hashes.'SHA-256' = '...']
+var stixHashPattern = regexp.MustCompile(`file:hashes\.(?:'[^']+'|[A-Za-z0-9-]+)\s*=\s*'([^']+)'`)
+
+// Indicators of a STIX 2.1 bundle: the hashes in indicator patterns, named
+// after their indicator, and in file objects
+func readSTIXIndicators(r io.Reader) ([]indicator, int, error) {
+    var bundle struct {
+        Objects []struct {
+            Type        string            `json:"type"`
+            Name        string            `json:"name"`
+            Pattern     string            `json:"pattern"`
+            PatternType string            `json:"pattern_type"`
+            Hashes      map[string]string `json:"hashes"`
+        } `json:"objects"`
+    }
+    if err := json.NewDecoder(r).Decode(&bundle); err != nil {
+        return nil, 0, err
+    }
+    var out []indicator
+    invalid := 0
+    add := func(hash, label string) {
+        if hash = strings.ToLower(hash); hashKind(hash) == "" {
+            invalid++
+            return
+        }
+        out = append(out, indicator{hash: hash, label: label})
+    }
+    for _, o := range bundle.Objects {
+        switch {
+        case o.Type == "indicator" && (o.PatternType == "" || o.PatternType == "stix"):
+            for _, m := range stixHashPattern.FindAllStringSubmatch(o.Pattern, -1) {
+                add(m[1], o.Name)
+            }
+        case o.Type == "file":
+            for _, hash := range o.Hashes {
+                add(hash, o.Name)
+            }
+        }
+    }
+    return out, invalid, nil
+}
+
+// Format of an indicator file by its extension
+func indicatorFormat(filename string) string {
+    switch strings.ToLower(filepath.Ext(filename)) {
+    case ".json", ".stix":
+        return "stix"
+    case ".csv":
+        return "csv"
+    }
+    return "plain"
+}
+
+// Add the hashes of a threat intel list to the watchlist and report the
+// stored favicons that already match them
+func huntImportCommand(args []string) {
+    fs := flag.NewFlagSet("hunt import", flag.ExitOnError)
+    dbOpts := dbFlags(fs)
+    filename := fs.String("file", "", "Hash list to import")
+    format := fs.String("format", "", "Format of the list: plain, csv or stix (default: by extension)")
+    source := fs.String("source", "", "Name of the feed the list came from (default: the file name)")
+    label := fs.String("label", "", "Label for hashes the list gives none")
+    parseFlags(fs, args)
+
+    if *filename == "" {
+        fmt.Fprintln(os.Stderr, "Usage: maplink hunt import -file iocs.csv [-format plain|csv|stix] [-source feed]")
+        return
+    }
+    if *format == "" {
+        *format = indicatorFormat(*filename)
+    }
+    if *source == "" {
+        *source = filepath.Base(*filename)
+    }
+    read := map[string]func(io.Reader) ([]indicator, int, error){"plain": readPlainIndicators, "csv": readCSVIndicators, "stix": readSTIXIndicators}[*format]
+    if read == nil {
+        fmt.Fprintf(os.Stderr, "Unknown format %q (use plain, csv or stix)\n", *format)
+        return
+    }
+    file, err := os.Open(*filename)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening %s: %v\n", *filename, err)
+        return
+    }
+    indicators, invalid, err := read(file)
+    file.Close()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", *filename, err)
+        return
+    }
+
+    db, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer db.Close()
+
+    tx, err := db.Begin()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error starting import: %v\n", err)
+        return
+    }
+    now := time.Now().UTC().Format(time.RFC3339)
+    added := 0
+    var hashes []string
+    seen := map[string]bool{}
+    for _, ind := range indicators {
+        if seen[ind.hash] {
+            continue
+        }
+        seen[ind.hash] = true
+        if ind.label == "" {
+            ind.label = *label
+        }
+        res, err := tx.Exec(`INSERT INTO watchlist(workspace, hash, kind, label, source, added_at) VALUES(?, ?, ?, NULLIF(?, ''), ?, ?)
+            ON CONFLICT(workspace, hash) DO NOTHING`, dbOpts.workspace, ind.hash, hashKind(ind.hash), ind.label, *source, now)
+        if err != nil {
+            tx.Rollback()
+            fmt.Fprintf(os.Stderr, "Error saving %s: %v\n", ind.hash, err)
+            return
+        }
+        if n, _ := res.RowsAffected(); n > 0 {
+            added++
+        }
+        hashes = append(hashes, ind.hash)
+    }
+    if err := recordAction(tx, auditAction{Workspace: dbOpts.workspace, Actor: localActor("cli"), Action: actionWatchlistImport, Subject: *source,
+        Detail: fmt.Sprintf("%d hashes, %d new", len(hashes), added)}); err != nil {
+        tx.Rollback()
+        fmt.Fprintf(os.Stderr, "Error saving import: %v\n", err)
+        return
+    }
+    if err := tx.Commit(); err != nil {
+        fmt.Fprintf(os.Stderr, "Error saving import: %v\n", err)
+        return
+    }
+    fmt.Printf("Imported %d hashes from %s (%d new, %d invalid entries skipped)\n", len(hashes), *source, added, invalid)
+
+    // Scans alert on the hashes from now on; these were seen already
+    if len(hashes) == 0 {
+        return
+    }
+    records, err := matchingRecords(db, dbOpts.workspace, hashes)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error reading favicons: %v\n", err)
+        return
+    }
+    if len(records) > 0 {
+        fmt.Printf("%d stored favicons already match:\n", len(records))
+        printMatchingRecords(records)
+    }
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+    "math/rand"
+    "net/http"
+    "time"
+)
+
+// HTTP transport that waits a random time up to max before every request,
+// so scan traffic arrives spread out rather than in bursts
+type jitterTransport struct {
+    next http.RoundTripper
+    max  time.Duration
+}
+
+func (j jitterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    delay := time.Duration(rand.Int63n(int64(j.max) + 1))
+    select {
+    case <-time.After(delay):
+    case <-req.Context().Done():
+        return nil, req.Context().Err()
+    }
+    return j.next.RoundTrip(req)
+}
+
+// Copy of the targets in random order
+func shuffled(urls []string) []string {
+    out := append([]string(nil), urls...)
+    rand.Shuffle(len(out), func(i, j int) { out[i], out[j] = out[j], out[i] })
+    return out
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strconv"
+    "sync/atomic"
+    "time"
+)
+
+// Job states before its run takes over; afterwards a job has its run's status
+const (
+    jobQueued  = "queued"
+    jobRunning = "running"
+    jobFailed  = "failed" // its run could not be started
+)
+
+// A submitted job and its progress as the API returns it
+type apiJob struct {
+    ID          int64     `json:"id"`
+    Status      string    `json:"status"`
+    Key         string    `json:"key,omitempty"`
+    Targets     int       `json:"targets"`
+    Done        int       `json:"done"`
+    Results     int       `json:"results"`
+    Errors      int       `json:"errors"`
+    SubmittedAt string    `json:"submitted_at"`
+    StartedAt   string    `json:"started_at,omitempty"`
+    FinishedAt  string    `json:"finished_at,omitempty"`
+    RunID       int64     `json:"run_id,omitempty"`
+    Labels      runLabels `json:"labels,omitempty"` // submitted with the job
+}
+
+// Queue a job of targets submitted with the named key, and the labels its
+// run gets besides those of the server
+func createJob(db *sql.DB, workspace, key string, targets []target, labels runLabels) (int64, error) {
+    tx, err := db.Begin()
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback()
+
+    res, err := tx.Exec("INSERT INTO jobs(workspace, key_name, status, targets, submitted_at, labels) VALUES(?, ?, ?, ?, ?, NULLIF(?, ''))",
+        workspace, key, jobQueued, len(targets), time.Now().UTC().Format(time.RFC3339), labels.encode())
+    if err != nil {
+        return 0, err
+    }
+    id, err := res.LastInsertId()
+    if err != nil {
+        return 0, err
+    }
+    stmt, err := tx.Prepare("INSERT INTO job_targets(job_id, position, target) VALUES(?, ?, ?)")
+    if err != nil {
+        return 0, err
+    }
+    defer stmt.Close()
+    for i, t := range targets {
+        data, err := json.Marshal(t)
+        if err != nil {
+            return 0, err
+        }
+        if _, err := stmt.Exec(id, i, string(data)); err != nil {
+            return 0, err
+        }
+    }
+    return id, tx.Commit()
+}
+
+// Oldest queued job of a workspace with its targets and labels
+func nextJob(db *sql.DB, workspace string) (int64, []target, runLabels, bool, error) {
+    var id int64
+    var labels string
+    err := db.QueryRow("SELECT id, COALESCE(labels, '') FROM jobs WHERE workspace = ? AND status = ? ORDER BY id LIMIT 1", workspace, jobQueued).Scan(&id, &labels)
+    if err == sql.ErrNoRows {
+        return 0, nil, nil, false, nil
+    }
+    if err != nil {
+        return 0, nil, nil, false, err
+    }
+    rows, err := db.Query("SELECT target FROM job_targets WHERE job_id = ? ORDER BY position", id)
+    if err != nil {
+        return 0, nil, nil, false, err
+    }
+    defer rows.Close()
+    var targets []target
+    for rows.Next() {
+        var data string
+        var t target
+        if err := rows.Scan(&data); err != nil {
+            return 0, nil, nil, false, err
+        }
+        if err := json.Unmarshal([]byte(data), &t); err != nil {
+            return 0, nil, nil, false, fmt.Errorf("job %d: %v", id, err)
+        }
+        targets = append(targets, t)
+    }
+    return id, targets, decodeRunLabels(labels), true, rows.Err()
+}
+
+// Jobs of a workspace matching a WHERE clause over jobs j, with their
+// progress taken from the run and its checkpoints
+func selectJobs(db *sql.DB, workspace, where, orderBy string, limit int, args ...interface{}) ([]apiJob, error) {
+    query := `SELECT j.id, j.status, COALESCE(j.key_name, ''), j.targets,
+            COALESCE((SELECT COUNT(*) FROM run_targets t WHERE t.run_id = j.run_id), 0),
+            (SELECT COUNT(*) FROM job_results r WHERE r.job_id = j.id),
+            COALESCE(r.errors, 0), j.submitted_at, COALESCE(j.started_at, ''), COALESCE(j.finished_at, ''), COALESCE(j.run_id, 0), COALESCE(j.labels, '')
+        FROM jobs j LEFT JOIN runs r ON r.id = j.run_id WHERE j.workspace = ?`
+    if where != "" {
+        query += " AND (" + where + ")"
+    }
+    rows, err := db.Query(query+" ORDER BY "+orderBy+" LIMIT ?", append(append([]interface{}{workspace}, args...), limit)...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var jobs []apiJob
+    for rows.Next() {
+        var j apiJob
+        var labels string
+        if err := rows.Scan(&j.ID, &j.Status, &j.Key, &j.Targets, &j.Done, &j.Results, &j.Errors, &j.SubmittedAt, &j.StartedAt, &j.FinishedAt, &j.RunID, &labels); err != nil {
+            return nil, err
+        }
+        j.Labels = decodeRunLabels(labels)
+        jobs = append(jobs, j)
+    }
+    return jobs, rows.Err()
+}
+
+// Sink keeping the results of the job being scanned, and passing them on
+// to the event streams. Results are written by the store's writer with the
+// rest of the scan, and published once committed.
+type jobSink struct {
+    store  resultStore
+    events *eventHub
+    job    atomic.Int64
+}
+
+func (j *jobSink) Write(r result) error {
+    id := j.job.Load()
+    if id == 0 {
+        return nil
+    }
+    data, err := json.Marshal(r)
+    if err != nil {
+        return err
+    }
+    j.store.saveJobResult(&jobResultWrite{job: id, result: data, events: j.events})
+    return nil
+}
+
+func (j *jobSink) Close() error {
+    return nil
+}
+
+// A result of a job, with its job_results row once written
+type jobResultWrite struct {
+    job    int64
+    result []byte
+    events *eventHub
+    row    int64
+}
+
+func (op *jobResultWrite) apply(w batchStmts, now string) {
+    res, err := w.jobResult.Exec(op.job, string(op.result))
+    if err != nil {
+        errorf("Error saving result of job %d: %v\n", op.job, err)
+        return
+    }
+    op.row, _ = res.LastInsertId()
+}
+
+func (op *jobResultWrite) afterCommit(ok bool) {
+    if !ok || op.row == 0 {
+        return
+    }
+    event, _ := json.Marshal(apiStreamResult{Job: op.job, Result: json.RawMessage(op.result)})
+    op.events.publish(streamEvent{job: op.job, id: op.row, name: "result", data: event})
+}
+
+// Scan queued jobs one at a time until ctx is cancelled. Jobs left running by
+// an earlier serve count as interrupted; queued ones are picked up.
+func (sv *server) runJobs(ctx context.Context, s *scanner, results *jobSink) {
+    if _, err := sv.db.Exec("UPDATE jobs SET status = ?, finished_at = ? WHERE workspace = ? AND status = ?",
+        runInterrupted, time.Now().UTC().Format(time.RFC3339), sv.workspace, jobRunning); err != nil {
+        errorf("Error recovering jobs: %v\n", err)
+    }
+    for ctx.Err() == nil {
+        id, targets, labels, ok, err := nextJob(sv.db, sv.workspace)
+        if err != nil {
+            errorf("Error loading job: %v\n", err)
+        }
+        if err != nil || !ok {
+            select {
+            case <-sv.wake:
+            case <-ctx.Done():
+            case <-time.After(time.Minute):
+            }
+            continue
+        }
+        sv.runJob(ctx, s, results, id, targets, labels)
+    }
+}
+
+// Scan one job as a run named after it, labelled with the server's -label
+// and the job's own labels over them
+func (sv *server) runJob(ctx context.Context, s *scanner, results *jobSink, id int64, targets []target, labels runLabels) {
+    base := s.runLabels
+    jobLabels := base.merge(labels)
+
+    run, err := s.store.startRun(fmt.Sprintf("job-%d", id), len(targets), jobLabels)
+    if err == nil {
+        _, err = sv.db.Exec("UPDATE jobs SET status = ?, started_at = ?, run_id = ? WHERE id = ?",
+            jobRunning, time.Now().UTC().Format(time.RFC3339), run, id)
+    }
+    if err != nil {
+        errorf("Error starting job %d: %v\n", id, err)
+        sv.db.Exec("UPDATE jobs SET status = ? WHERE id = ?", jobFailed, id)
+        sv.publishJob(id)
+        return
+    }
+
+    sv.publishJob(id)
+
+    // Connections of one job are never reused by the next
+    s.origin.reset(s.transport.Clone())
+
+    // beginRun keeps a run that is already open, so the job's run is used
+    results.job.Store(id)
+    s.mu.Lock()
+    s.run, s.runLabels, s.onProgress = run, jobLabels, sv.jobProgress(id)
+    s.mu.Unlock()
+    s.scanTargets(ctx, targets)
+    s.mu.Lock()
+    s.runLabels, s.onProgress = base, nil
+    s.mu.Unlock()
+    results.job.Store(0)
+    // Every result is readable by the time the job shows finished
+    s.store.sync()
+
+    if _, err := sv.db.Exec("UPDATE jobs SET status = COALESCE((SELECT status FROM runs WHERE id = ?), ?), finished_at = ? WHERE id = ?",
+        run, runCompleted, time.Now().UTC().Format(time.RFC3339), id); err != nil {
+        errorf("Error finishing job %d: %v\n", id, err)
+    }
+    sv.publishJob(id)
+}
+
+// POST /jobs with {"targets": [...]}, each a URL or a target object as in a
+// JSONL input file, and optional "labels" for the run. The targets are
+// queued as one job, scanned as one run.
+func (sv *server) submitJob(w http.ResponseWriter, r *http.Request) {
+    var body struct {
+        Targets []json.RawMessage `json:"targets"`
+        Labels  runLabels         `json:"labels"`
+    }
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody)).Decode(&body); err != nil {
+        writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+        return
+    }
+    if len(body.Targets) > sv.maxTargets {
+        writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("at most %d targets per job", sv.maxTargets))
+        return
+    }
+    targets, err := parseAPITargets(body.Targets)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    if len(targets) == 0 {
+        writeError(w, http.StatusBadRequest, "no targets")
+        return
+    }
+    if err := body.Labels.validate(); err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    entry := auditFrom(r.Context())
+    entry.targets = targetURLs(targets)
+
+    id, err := createJob(sv.db, sv.workspace, entry.key, targets, body.Labels)
+    if err != nil {
+        errorf("Error queueing job: %v\n", err)
+        writeError(w, http.StatusInternalServerError, "queueing job")
+        return
+    }
+    select {
+    case sv.wake <- struct{}{}:
+    default:
+    }
+    sv.publishJob(id)
+    w.Header().Set("Location", fmt.Sprintf("/jobs/%d", id))
+    writeJSON(w, http.StatusAccepted, apiJobAccepted{Job: id, Queued: len(targets)})
+}
+
+// Job named by the {id} of the path, or a written error
+func (sv *server) pathJob(w http.ResponseWriter, r *http.Request) (apiJob, bool) {
+    id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, "invalid job id")
+        return apiJob{}, false
+    }
+    jobs, err := selectJobs(sv.db, sv.workspace, "j.id = ?", "j.id", 1, id)
+    if err != nil {
+        errorf("Error loading job %d: %v\n", id, err)
+        writeError(w, http.StatusInternalServerError, "loading job")
+        return apiJob{}, false
+    }
+    if len(jobs) == 0 {
+        writeError(w, http.StatusNotFound, "no such job")
+        return apiJob{}, false
+    }
+    return jobs[0], true
+}
+
+// GET /jobs/{id}
+func (sv *server) getJob(w http.ResponseWriter, r *http.Request) {
+    if job, ok := sv.pathJob(w, r); ok {
+        writeJSON(w, http.StatusOK, job)
+    }
+}
+
+// Sort orders of GET /jobs
+var jobSorts = map[string]sortColumn{
+    "id": {expr: "j.id", numeric: true},
+}
+
+// GET /jobs, newest first, filtered by ?status=, ?key= and ?since=/?until= on submission
+func (sv *server) listJobs(w http.ResponseWriter, r *http.Request) {
+    lr, err := parseListRequest(r, jobSorts, "-id")
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    q := r.URL.Query()
+    var f listFilter
+    if status := q.Get("status"); status != "" {
+        f.add("j.status = ?", status)
+    }
+    if key := q.Get("key"); key != "" {
+        f.add("j.key_name = ?", key)
+    }
+    if err := addTimeRange(r, &f, "j.submitted_at"); err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    order := lr.keyset(&f, "j.id", true)
+
+    jobs, err := selectJobs(sv.db, sv.workspace, f.where(), order, lr.fetch(), f.args...)
+    if err != nil {
+        errorf("Error loading jobs: %v\n", err)
+        writeError(w, http.StatusInternalServerError, "loading jobs")
+        return
+    }
+    more := len(jobs) > lr.limit
+    if more {
+        jobs = jobs[:lr.limit]
+    }
+    if jobs == nil {
+        jobs = []apiJob{}
+    }
+    page := apiPage{Items: jobs}
+    if more {
+        id := strconv.FormatInt(jobs[len(jobs)-1].ID, 10)
+        page.NextCursor = lr.cursor(id, id)
+    }
+    writeJSON(w, http.StatusOK, page)
+}
+
+// Sort orders of GET /jobs/{id}/results
+var jobResultSorts = map[string]sortColumn{
+    "id": {expr: "id", numeric: true},
+}
+
+// GET /jobs/{id}/results, in the order they were found
+func (sv *server) listJobResults(w http.ResponseWriter, r *http.Request) {
+    job, ok := sv.pathJob(w, r)
+    if !ok {
+        return
+    }
+    lr, err := parseListRequest(r, jobResultSorts, "id")
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    f := listFilter{clauses: []string{"job_id = ?"}, args: []interface{}{job.ID}}
+    order := lr.keyset(&f, "id", true)
+
+    rows, err := sv.db.Query("SELECT id, result FROM job_results WHERE "+f.where()+" ORDER BY "+order+" LIMIT ?", append(f.args, lr.fetch())...)
+    if err != nil {
+        errorf("Error loading results of job %d: %v\n", job.ID, err)
+        writeError(w, http.StatusInternalServerError, "loading results")
+        return
+    }
+    defer rows.Close()
+    items := []json.RawMessage{}
+    var last int64
+    for len(items) < lr.limit && rows.Next() {
+        var data string
+        if err := rows.Scan(&last, &data); err != nil {
+            errorf("Error loading results of job %d: %v\n", job.ID, err)
+            writeError(w, http.StatusInternalServerError, "loading results")
+            return
+        }
+        items = append(items, json.RawMessage(data))
+    }
+    page := apiPage{Items: items}
+    if rows.Next() {
+        page.NextCursor = lr.cursor(strconv.FormatInt(last, 10), strconv.FormatInt(last, 10))
+    }
+    writeJSON(w, http.StatusOK, page)
+}
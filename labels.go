@@ -0,0 +1,105 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "regexp"
+    "sort"
+    "strings"
+)
+
+// Keys a run label may have
+var labelKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Key/value labels of a run, e.g. engagement=acme, set with -label and
+// carried into every record and export the run produces. A flag.Value
+// taking one key=value per -label, so values may hold commas; a config file
+// list sets one per item.
+type runLabels map[string]string
+
+func (l *runLabels) Set(v string) error {
+    key, value, ok := strings.Cut(v, "=")
+    if !ok {
+        return fmt.Errorf("%q is not key=value", v)
+    }
+    key = strings.TrimSpace(key)
+    if !labelKeyPattern.MatchString(key) {
+        return fmt.Errorf("label key %q may only have letters, digits, '_', '.' and '-'", key)
+    }
+    if *l == nil {
+        *l = runLabels{}
+    }
+    (*l)[key] = strings.TrimSpace(value)
+    return nil
+}
+
+func (l *runLabels) repeated() {}
+
+func (l runLabels) String() string {
+    return strings.Join(l.pairs(), ",")
+}
+
+// Labels as sorted key=value strings
+func (l runLabels) pairs() []string {
+    pairs := make([]string, 0, len(l))
+    for k, v := range l {
+        pairs = append(pairs, k+"="+v)
+    }
+    sort.Strings(pairs)
+    return pairs
+}
+
+// Labels with those of over taking precedence
+func (l runLabels) merge(over runLabels) runLabels {
+    if len(over) == 0 {
+        return l
+    }
+    merged := runLabels{}
+    for k, v := range l {
+        merged[k] = v
+    }
+    for k, v := range over {
+        merged[k] = v
+    }
+    return merged
+}
+
+// Check labels given other than by -label, as in a POST /jobs body
+func (l runLabels) validate() error {
+    for k := range l {
+        if !labelKeyPattern.MatchString(k) {
+            return fmt.Errorf("label key %q may only have letters, digits, '_', '.' and '-'", k)
+        }
+    }
+    return nil
+}
+
+// Labels as stored in a column: a JSON object, or "" for none
+func (l runLabels) encode() string {
+    if len(l) == 0 {
+        return ""
+    }
+    data, _ := json.Marshal(map[string]string(l))
+    return string(data)
+}
+
+// Labels from a column written by encode
+func decodeRunLabels(s string) runLabels {
+    if s == "" {
+        return nil
+    }
+    var l runLabels
+    if err := json.Unmarshal([]byte(s), &l); err != nil {
+        return nil
+    }
+    return l
+}
+
+// Every run label of a group of records, as sorted key=value strings
+func recordRunLabels(records []record) []string {
+    var pairs []string
+    for _, r := range records {
+        pairs = append(pairs, r.RunLabels.pairs()...)
+    }
+    return uniqueSorted(pairs)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "io/fs"
+    "mime"
+    "net/http"
+    "net/url"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// Extensions hashed by default when walking a directory
+const defaultLocalExtensions = ".ico,.png,.gif,.jpg,.jpeg,.svg,.webp,.bmp"
+
+// file:// URL of a path, used as the favicon link and target of local files
+func fileURL(path string) (string, error) {
+    abs, err := filepath.Abs(path)
+    if err != nil {
+        return "", err
+    }
+    return (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String(), nil
+}
+
+// Content type of an icon file from its extension, falling back to sniffing
+func localContentType(path string, data []byte) string {
+    if t := mime.TypeByExtension(strings.ToLower(filepath.Ext(path))); t != "" {
+        return t
+    }
+    return http.DetectContentType(data)
+}
+
+// Hash icon files already on disk and store them like scanned favicons
+func localCommand(args []string) {
+    flags := flag.NewFlagSet("local", flag.ExitOnError)
+    dbOpts := dbFlags(flags)
+    extList := flags.String("ext", defaultLocalExtensions, "Comma-separated extensions to hash when walking a directory (empty hashes every file)")
+    opts := registerScanFlags(flags)
+    parseFlags(flags, args)
+
+    paths := flags.Args()
+    if len(paths) == 0 {
+        fmt.Println("Usage: maplink local [flags] <dir|file>...")
+        return
+    }
+    exts := map[string]bool{}
+    for _, ext := range splitList(*extList) {
+        exts[strings.ToLower("."+strings.TrimPrefix(ext, "."))] = true
+    }
+
+    // Each argument is one target of the run
+    var targets []string
+    roots := map[string]string{}
+    for _, p := range paths {
+        u, err := fileURL(p)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error resolving %s: %v\n", p, err)
+            return
+        }
+        targets = append(targets, u)
+        roots[u] = p
+    }
+
+    db, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer db.Close()
+
+    s, err := opts.newScanner(db, dbOpts.workspace)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error setting up scan: %v\n", err)
+        return
+    }
+    defer s.close()
+
+    ctx := signalContext()
+    pending := s.beginRun(targets)
+    defer s.endRun(ctx)
+    for _, t := range pending {
+        s.hashLocal(ctx, t, roots[t], exts)
+        if ctx.Err() != nil {
+            return
+        }
+        s.targetDone(t)
+    }
+}
+
+// Hash one file, or every matching file under a directory
+func (s *scanner) hashLocal(ctx context.Context, target, root string, exts map[string]bool) {
+    infof("Processing path: %s\n", root)
+    err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+        if ctx.Err() != nil {
+            return ctx.Err()
+        }
+        if err != nil {
+            errorf("Error reading %s: %v\n", path, err)
+            s.fail(path, err)
+            return nil
+        }
+        if d.IsDir() || !d.Type().IsRegular() {
+            return nil
+        }
+        // Files named explicitly are hashed whatever their extension
+        if path != root && len(exts) > 0 && !exts[strings.ToLower(filepath.Ext(path))] {
+            return nil
+        }
+
+        var data []byte
+        link, err := fileURL(path)
+        if err == nil {
+            data, err = os.ReadFile(path)
+        }
+        if err != nil {
+            errorf("Error reading %s: %v\n", path, err)
+            s.fail(path, err)
+            return nil
+        }
+        s.record(favicon{Target: target, URL: link, ContentType: localContentType(path, data), Data: data})
+        return nil
+    })
+    if err != nil && ctx.Err() == nil {
+        errorf("Error walking %s: %v\n", root, err)
+        s.fail(root, err)
+    }
+}
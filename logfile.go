@@ -0,0 +1,115 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "sync"
+    "time"
+)
+
+// Log file that is rotated once it grows past maxSize bytes or has been
+// open for maxAge. Rotated files are renamed to <path>.<timestamp> and only
+// the newest keep of them are retained.
+type rotatingWriter struct {
+    path    string
+    maxSize int64
+    maxAge  time.Duration
+    keep    int
+    mu      sync.Mutex
+    f       *os.File
+    size    int64
+    opened  time.Time
+}
+
+// Open a log file for appending, creating it if needed
+func newRotatingWriter(path string, maxSize int64, maxAge time.Duration, keep int) (*rotatingWriter, error) {
+    w := &rotatingWriter{path: path, maxSize: maxSize, maxAge: maxAge, keep: keep}
+    if err := w.open(); err != nil {
+        return nil, err
+    }
+    return w, nil
+}
+
+func (w *rotatingWriter) open() error {
+    f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+    if err != nil {
+        return err
+    }
+    st, err := f.Stat()
+    if err != nil {
+        f.Close()
+        return err
+    }
+    w.f, w.size, w.opened = f, st.Size(), time.Now()
+    return nil
+}
+
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    if w.due(len(p)) {
+        if err := w.rotate(); err != nil {
+            return 0, err
+        }
+    }
+    n, err := w.f.Write(p)
+    w.size += int64(n)
+    return n, err
+}
+
+// Whether writing n more bytes should start a new file
+func (w *rotatingWriter) due(n int) bool {
+    if w.size == 0 {
+        return false
+    }
+    if w.maxSize > 0 && w.size+int64(n) > w.maxSize {
+        return true
+    }
+    return w.maxAge > 0 && time.Since(w.opened) >= w.maxAge
+}
+
+// Move the current file aside, start a new one and prune old backups
+func (w *rotatingWriter) rotate() error {
+    if err := w.f.Close(); err != nil {
+        return err
+    }
+    backup := w.path + "." + time.Now().UTC().Format("20060102T150405.000000000Z")
+    if err := os.Rename(w.path, backup); err != nil {
+        return err
+    }
+    if err := w.open(); err != nil {
+        return err
+    }
+
+    if w.keep <= 0 {
+        return nil
+    }
+    backups, err := filepath.Glob(w.path + ".[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]T*Z")
+    if err != nil {
+        return err
+    }
+    // Timestamps sort chronologically, so the oldest come first
+    sort.Strings(backups)
+    for len(backups) > w.keep {
+        os.Remove(backups[0])
+        backups = backups[1:]
+    }
+    return nil
+}
+
+// Close the current file
+func (w *rotatingWriter) Close() error {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    return w.f.Close()
+}
+
+// Append a timestamped message to the log file, if one is configured
+func writeLog(level, msg string) {
+    if logOut == nil {
+        return
+    }
+    fmt.Fprintf(logOut, "%s %s %s\n", time.Now().UTC().Format(time.RFC3339), level, msg)
+}
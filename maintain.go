@@ -0,0 +1,257 @@
+package main
+
+import (
+    "database/sql"
+    "flag"
+    "fmt"
+    "os"
+    "time"
+)
+
+// Database administration subcommands
+func dbCommand(args []string) {
+    if len(args) == 0 || isHelp(args[0]) {
+        fmt.Fprintln(os.Stderr, "Usage: maplink db maintain|migrate|backup [flags]")
+        return
+    }
+    switch args[0] {
+    case "maintain":
+        maintainCommand(args[1:])
+    case "migrate":
+        pgMigrateCommand(args[1:])
+    case "backup":
+        backupCommand(args[1:])
+    default:
+        fmt.Fprintf(os.Stderr, "Unknown db command %q\n", args[0])
+    }
+}
+
+// Rows removed by a maintenance pass
+type maintenanceStats struct {
+    duplicates   int64
+    history      int64
+    runs         int64
+    runRows      int64 // skipped icons, resolutions, errors, timings and technologies of pruned runs
+    observations int64
+    blobData     int64
+    blobs        int64
+}
+
+// Tables of rows recorded by a run, removed with it, and the column telling
+// when rows recorded outside any run were written
+var runScopedTables = []struct{ table, at string }{
+    {"skipped_icons", "skipped_at"},
+    {"resolutions", "resolved_at"},
+    {"errors", "failed_at"},
+    {"request_timings", "at"},
+    {"technologies", "detected_at"},
+}
+
+// How long data is kept; a zero cutoff keeps everything of its kind
+type retentionPolicy struct {
+    history      time.Time // runs and history
+    observations time.Time
+    blobData     time.Time // raw bytes of icons last seen before it
+}
+
+// Policy keeping each kind of data for its number of days, 0 for ever
+func newRetentionPolicy(historyDays, observationDays, blobDays int) retentionPolicy {
+    var p retentionPolicy
+    for _, r := range []struct {
+        cutoff *time.Time
+        days   int
+    }{{&p.history, historyDays}, {&p.observations, observationDays}, {&p.blobData, blobDays}} {
+        if r.days > 0 {
+            *r.cutoff = time.Now().AddDate(0, 0, -r.days)
+        }
+    }
+    return p
+}
+
+// Drop the bytes of icons no favicon or observation has seen since the
+// cutoff, keeping their hashes; icons whose hashes are hunted keep theirs
+const staleBlobDataSQL = `UPDATE favicon_blobs SET data = NULL WHERE data IS NOT NULL
+    AND sha256 NOT IN (SELECT sha256 FROM favicons WHERE last_seen >= ?1)
+    AND sha256 NOT IN (SELECT sha256 FROM observations WHERE last_seen >= ?1)
+    AND NOT EXISTS (SELECT 1 FROM watchlist w WHERE w.hash IN (favicon_blobs.sha256, favicon_blobs.md5, favicon_blobs.mmh3))`
+
+// Count the rows touched by a statement
+func execCount(tx *sql.Tx, query string, args ...interface{}) (int64, error) {
+    res, err := tx.Exec(query, args...)
+    if err != nil {
+        return 0, err
+    }
+    return res.RowsAffected()
+}
+
+// Remove duplicate history, prune data past its retention (keeping the
+// latest history entry of every link) and drop blobs nothing refers to
+func maintainDatabase(db *sql.DB, policy retentionPolicy, actor string) (maintenanceStats, error) {
+    var stats maintenanceStats
+    tx, err := db.Begin()
+    if err != nil {
+        return stats, err
+    }
+    defer tx.Rollback()
+
+    if stats.duplicates, err = execCount(tx, `DELETE FROM history WHERE id NOT IN
+        (SELECT MIN(id) FROM history GROUP BY workspace, link, sha256, seen_at)`); err != nil {
+        return stats, fmt.Errorf("removing duplicates: %v", err)
+    }
+
+    if !policy.history.IsZero() {
+        before := policy.history.UTC().Format(time.RFC3339)
+        if stats.history, err = execCount(tx, `DELETE FROM history WHERE seen_at < ? AND seen_at <
+            (SELECT MAX(seen_at) FROM history h WHERE h.workspace = history.workspace AND h.link = history.link)`, before); err != nil {
+            return stats, fmt.Errorf("pruning history: %v", err)
+        }
+        // Rows referring to the runs go first, as observations.run_id is a foreign key
+        pruned := "SELECT id FROM runs WHERE started_at < ?"
+        for _, table := range []string{"history", "observations", "certificate_sans", "dns_records"} {
+            if _, err = tx.Exec("UPDATE "+table+" SET run_id = NULL WHERE run_id IN ("+pruned+")", before); err != nil {
+                return stats, fmt.Errorf("pruning runs: %v", err)
+            }
+        }
+        for _, t := range runScopedTables {
+            n, err := execCount(tx, "DELETE FROM "+t.table+" WHERE run_id IN ("+pruned+") OR (run_id IS NULL AND "+t.at+" < ?)", before, before)
+            if err != nil {
+                return stats, fmt.Errorf("pruning %s: %v", t.table, err)
+            }
+            stats.runRows += n
+        }
+        if stats.runs, err = execCount(tx, "DELETE FROM runs WHERE started_at < ?", before); err != nil {
+            return stats, fmt.Errorf("pruning runs: %v", err)
+        }
+    }
+
+    if !policy.observations.IsZero() {
+        if stats.observations, err = execCount(tx, "DELETE FROM observations WHERE last_seen < ?", policy.observations.UTC().Format(time.RFC3339)); err != nil {
+            return stats, fmt.Errorf("pruning observations: %v", err)
+        }
+        if _, err = tx.Exec(orphanHostsSQL); err != nil {
+            return stats, fmt.Errorf("pruning observations: %v", err)
+        }
+    }
+
+    // Checkpoints double as the per-target scan log, so keep them as long as their run
+    if _, err = tx.Exec("DELETE FROM run_targets WHERE run_id NOT IN (SELECT id FROM runs)"); err != nil {
+        return stats, fmt.Errorf("pruning checkpoints: %v", err)
+    }
+
+    if stats.blobs, err = execCount(tx, orphanBlobsSQL); err != nil {
+        return stats, fmt.Errorf("removing orphaned blobs: %v", err)
+    }
+    if !policy.blobData.IsZero() {
+        if stats.blobData, err = execCount(tx, staleBlobDataSQL, policy.blobData.UTC().Format(time.RFC3339)); err != nil {
+            return stats, fmt.Errorf("pruning blob data: %v", err)
+        }
+    }
+    if stats.history+stats.runs+stats.runRows+stats.observations+stats.blobs+stats.blobData > 0 {
+        if err = recordAction(tx, auditAction{Actor: actor, Action: actionPrune, Detail: fmt.Sprintf("%d history rows, %d runs, %d rows of those runs, %d observations, %d orphaned blobs, data of %d blobs",
+            stats.history, stats.runs, stats.runRows, stats.observations, stats.blobs, stats.blobData)}); err != nil {
+            return stats, err
+        }
+    }
+    return stats, tx.Commit()
+}
+
+// Blobs compressed per transaction by compressBlobs
+const compressChunk = 500
+
+// Compress blobs stored before compression, a chunk at a time so memory
+// stays flat; returns how many shrank
+func compressBlobs(db *sql.DB) (int64, error) {
+    var n int64
+    for after := ""; ; {
+        rows, err := db.Query(`SELECT sha256, data FROM favicon_blobs WHERE encoding IS NULL AND data IS NOT NULL AND sha256 > ?
+            ORDER BY sha256 LIMIT ?`, after, compressChunk)
+        if err != nil {
+            return n, err
+        }
+        packed := map[string][]byte{}
+        seen := 0
+        for rows.Next() {
+            var sha256Hash string
+            var data []byte
+            if err := rows.Scan(&sha256Hash, &data); err != nil {
+                rows.Close()
+                return n, err
+            }
+            if stored, encoding := encodeBlob(data); encoding != nil {
+                packed[sha256Hash] = stored
+            }
+            after = sha256Hash
+            seen++
+        }
+        rows.Close()
+        if err := rows.Err(); err != nil {
+            return n, err
+        }
+        if seen == 0 {
+            return n, nil
+        }
+
+        tx, err := db.Begin()
+        if err != nil {
+            return n, err
+        }
+        for sha256Hash, stored := range packed {
+            if _, err := tx.Exec("UPDATE favicon_blobs SET data = ?, encoding = ? WHERE sha256 = ? AND encoding IS NULL", stored, blobZstd, sha256Hash); err != nil {
+                tx.Rollback()
+                return n, err
+            }
+        }
+        if err := tx.Commit(); err != nil {
+            return n, err
+        }
+        n += int64(len(packed))
+    }
+}
+
+// Keep a long-lived monitoring database small and fast
+func maintainCommand(args []string) {
+    fs := flag.NewFlagSet("db maintain", flag.ExitOnError)
+    dbOpts := dbFlags(fs)
+    retention := fs.Int("retention-days", 0, "Prune runs and history older than this many days (0 keeps everything)")
+    observationRetention := fs.Int("observation-retention-days", 0, "Prune observations not seen for this many days (0 keeps them)")
+    blobRetention := fs.Int("blob-retention-days", 0, "Drop the bytes of icons not seen for this many days, keeping their hashes, unless hunted (0 keeps them)")
+    noVacuum := fs.Bool("no-vacuum", false, "Skip VACUUM, which rewrites the whole file")
+    parseFlags(fs, args)
+
+    db, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer db.Close()
+
+    stats, err := maintainDatabase(db, newRetentionPolicy(*retention, *observationRetention, *blobRetention), localActor("cli"))
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error maintaining database: %v\n", err)
+        return
+    }
+    fmt.Printf("Removed %d duplicate history rows, %d old history rows, %d runs and %d rows they recorded, %d old observations, %d orphaned blobs; dropped the data of %d old blobs\n",
+        stats.duplicates, stats.history, stats.runs, stats.runRows, stats.observations, stats.blobs, stats.blobData)
+    compressed, err := compressBlobs(db)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error compressing blobs: %v\n", err)
+        return
+    }
+    fmt.Printf("Compressed %d blobs\n", compressed)
+
+    for _, stmt := range []string{"REINDEX", "ANALYZE"} {
+        if _, err := db.Exec(stmt); err != nil {
+            fmt.Fprintf(os.Stderr, "Error running %s: %v\n", stmt, err)
+            return
+        }
+    }
+    if *noVacuum {
+        fmt.Println("Indexes rebuilt")
+        return
+    }
+    if _, err := db.Exec("VACUUM"); err != nil {
+        fmt.Fprintf(os.Stderr, "Error running VACUUM: %v\n", err)
+        return
+    }
+    fmt.Println("Indexes rebuilt and database vacuumed")
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+    "database/sql"
+    "fmt"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+// Open a fresh, migrated database in a temporary directory
+func openTestDatabase(t *testing.T) *sql.DB {
+    t.Helper()
+    db, err := openDatabase(filepath.Join(t.TempDir(), "favicons.db"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { db.Close() })
+    return db
+}
+
+func TestMaintainBlobRetention(t *testing.T) {
+    now := time.Now().UTC()
+    recent := now.Add(-24 * time.Hour).Format(time.RFC3339)
+    stale := now.AddDate(0, 0, -90).Format(time.RFC3339)
+    tests := []struct {
+        name     string
+        lastSeen string // of the favicon
+        observed string // last observation, if any
+        hunt     string // which of its hashes is hunted, if any
+        wantData bool
+    }{
+        {name: "seen recently", lastSeen: recent, wantData: true},
+        {name: "stale", lastSeen: stale},
+        {name: "stale but observed recently", lastSeen: stale, observed: recent, wantData: true},
+        {name: "stale and observed long ago", lastSeen: stale, observed: stale},
+        {name: "stale, sha256 hunted", lastSeen: stale, hunt: "sha256", wantData: true},
+        {name: "stale, md5 hunted", lastSeen: stale, hunt: "md5", wantData: true},
+        {name: "stale, mmh3 hunted", lastSeen: stale, hunt: "mmh3", wantData: true},
+    }
+    db := openTestDatabase(t)
+    for i, tt := range tests {
+        hashes := map[string]string{"sha256": fmt.Sprintf("sha256-%d", i), "md5": fmt.Sprintf("md5-%d", i), "mmh3": fmt.Sprint(1000 + i)}
+        link := fmt.Sprintf("https://host%d.example/favicon.ico", i)
+        if _, err := db.Exec("INSERT INTO favicon_blobs(sha256, md5, mmh3, size, data) VALUES(?, ?, ?, 4, x'00010203')",
+            hashes["sha256"], hashes["md5"], hashes["mmh3"]); err != nil {
+            t.Fatal(err)
+        }
+        if _, err := db.Exec("INSERT INTO favicons(link, md5, sha256, first_seen, last_seen) VALUES(?, ?, ?, ?, ?)",
+            link, hashes["md5"], hashes["sha256"], stale, tt.lastSeen); err != nil {
+            t.Fatal(err)
+        }
+        if tt.observed != "" {
+            res, err := db.Exec("INSERT INTO hosts(name, first_seen, last_seen) VALUES(?, ?, ?)", fmt.Sprintf("host%d.example", i), stale, tt.observed)
+            if err != nil {
+                t.Fatal(err)
+            }
+            host, _ := res.LastInsertId()
+            if _, err := db.Exec("INSERT INTO observations(host_id, link, sha256, first_seen, last_seen) VALUES(?, ?, ?, ?, ?)",
+                host, link, hashes["sha256"], stale, tt.observed); err != nil {
+                t.Fatal(err)
+            }
+        }
+        if tt.hunt != "" {
+            if _, err := db.Exec("INSERT INTO watchlist(hash, kind, added_at) VALUES(?, ?, ?)", hashes[tt.hunt], tt.hunt, stale); err != nil {
+                t.Fatal(err)
+            }
+        }
+    }
+
+    stats, err := maintainDatabase(db, newRetentionPolicy(0, 0, 30), "test")
+    if err != nil {
+        t.Fatal(err)
+    }
+    var dropped int64
+    for i, tt := range tests {
+        var size int
+        var hasData bool
+        err := db.QueryRow("SELECT size, data IS NOT NULL FROM favicon_blobs WHERE sha256 = ?", fmt.Sprintf("sha256-%d", i)).Scan(&size, &hasData)
+        if err != nil {
+            t.Errorf("%s: blob gone: %v", tt.name, err)
+            continue
+        }
+        if hasData != tt.wantData {
+            t.Errorf("%s: blob data kept %v, want %v", tt.name, hasData, tt.wantData)
+        }
+        if size != 4 {
+            t.Errorf("%s: blob size %d, want its hashes and size kept", tt.name, size)
+        }
+        if !tt.wantData {
+            dropped++
+        }
+    }
+    if stats.blobData != dropped || stats.blobs != 0 {
+        t.Errorf("dropped the data of %d blobs and %d orphans, want %d and none", stats.blobData, stats.blobs, dropped)
+    }
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+    "encoding/xml"
+    "flag"
+    "fmt"
+    "net/url"
+    "strings"
+)
+
+// Maltego transform response envelope
+type maltegoMessage struct {
+    XMLName  xml.Name        `xml:"MaltegoMessage"`
+    Response maltegoResponse `xml:"MaltegoTransformResponseMessage"`
+}
+
+type maltegoResponse struct {
+    Entities   []maltegoEntity    `xml:"Entities>Entity"`
+    UIMessages []maltegoUIMessage `xml:"UIMessages>UIMessage"`
+}
+
+type maltegoEntity struct {
+    Type   string         `xml:"Type,attr"`
+    Value  string         `xml:"Value"`
+    Weight int            `xml:"Weight"`
+    Fields []maltegoField `xml:"AdditionalFields>Field,omitempty"`
+}
+
+type maltegoField struct {
+    Name        string `xml:"Name,attr"`
+    DisplayName string `xml:"DisplayName,attr"`
+    Value       string `xml:",chardata"`
+}
+
+type maltegoUIMessage struct {
+    Type string `xml:"MessageType,attr"`
+    Text string `xml:",chardata"`
+}
+
+// Favicon hash entity carrying all three hashes
+func hashEntity(r record) maltegoEntity {
+    return maltegoEntity{Type: "maltego.Hash", Value: r.MD5, Weight: 100, Fields: withRunLabels([]maltegoField{
+        {Name: "md5", DisplayName: "MD5", Value: r.MD5},
+        {Name: "sha256", DisplayName: "SHA256", Value: r.SHA256},
+        {Name: "mmh3", DisplayName: "MMH3", Value: r.MMH3},
+    }, r)}
+}
+
+// Domain entity for the host serving a favicon
+func hostEntity(r record) maltegoEntity {
+    return maltegoEntity{Type: "maltego.Domain", Value: r.host(), Weight: 100, Fields: withRunLabels([]maltegoField{
+        {Name: "favicon.link", DisplayName: "Favicon link", Value: r.Link},
+    }, r)}
+}
+
+// Fields with the record's run labels, when it has any
+func withRunLabels(fields []maltegoField, r record) []maltegoField {
+    if len(r.RunLabels) == 0 {
+        return fields
+    }
+    return append(fields, maltegoField{Name: "maplink.run_labels", DisplayName: "Run labels", Value: r.RunLabels.String()})
+}
+
+// Run a Maltego local transform:
+//   maplink maltego [-db-path file] hosts <hash>      hosts serving a favicon hash
+//   maplink maltego [-db-path file] hashes <host|url> favicon hashes seen on a host
+// Maltego appends the entity properties as an extra argument; it is ignored.
+func maltegoCommand(args []string) {
+    fs := flag.NewFlagSet("maltego", flag.ExitOnError)
+    dbOpts := dbFlags(fs)
+    parseFlags(fs, args)
+    args = fs.Args()
+
+    var msg maltegoMessage
+    defer func() {
+        out, _ := xml.MarshalIndent(msg, "", "  ")
+        fmt.Println(string(out))
+    }()
+    inform := func(format string, a ...interface{}) {
+        msg.Response.UIMessages = append(msg.Response.UIMessages, maltegoUIMessage{Type: "Inform", Text: fmt.Sprintf(format, a...)})
+    }
+
+    if len(args) < 2 {
+        inform("usage: maplink maltego hosts|hashes <value>")
+        return
+    }
+    transform, value := args[0], strings.ToLower(strings.TrimSpace(args[1]))
+
+    db, err := dbOpts.open()
+    if err != nil {
+        inform("error opening database: %v", err)
+        return
+    }
+    defer db.Close()
+
+    records, err := loadRecords(db, dbOpts.workspace, false)
+    if err != nil {
+        inform("error reading database: %v", err)
+        return
+    }
+
+    seen := map[string]struct{}{}
+    add := func(key string, e maltegoEntity) {
+        if _, ok := seen[key]; !ok && e.Value != "" {
+            seen[key] = struct{}{}
+            msg.Response.Entities = append(msg.Response.Entities, e)
+        }
+    }
+
+    switch transform {
+    case "hosts":
+        for _, r := range records {
+            if value == r.MD5 || value == r.SHA256 || value == r.MMH3 {
+                add(r.host(), hostEntity(r))
+            }
+        }
+    case "hashes":
+        host := value
+        if u, err := url.Parse(value); err == nil && u.Hostname() != "" {
+            host = u.Hostname()
+        }
+        for _, r := range records {
+            if r.host() == host {
+                add(r.SHA256, hashEntity(r))
+            }
+        }
+    default:
+        inform("unknown transform %q (use hosts or hashes)", transform)
+        return
+    }
+    inform("%d entities returned", len(msg.Response.Entities))
+}
@@ -1,94 +1,269 @@
 package main
 
 import (
-    "crypto/md5"
-    "crypto/sha256"
-    "encoding/hex"
+    "context"
     "database/sql"
     "flag"
     "fmt"
+    "html"
     "io"
     "net/http"
+    "net/url"
     "os"
+    "os/signal"
     "regexp"
+    "slices"
+    "sort"
     "strings"
+    "syscall"
+    "time"
     "bufio"
     _ "github.com/mattn/go-sqlite3"
+    "golang.org/x/net/html/charset"
 )
 
-// Fetch the HTML content of a webpage
-func fetchHTML(url string) (string, error) {
-    resp, err := http.Get(url)
+// Transport shared by every page and favicon request
+var baseTransport = newBaseTransport()
+
+// Fetch the head of a webpage along with its response headers and the URL
+// it was served from after HTTP redirects
+func (f *httpFetcher) fetchHTML(ctx context.Context, url string) (string, http.Header, string, error) {
+    req, err := newRequest(ctx, url)
+    if err != nil {
+        return "", nil, "", err
+    }
+    resp, err := f.client.Do(req)
     if err != nil {
-        return "", err
+        return "", nil, "", err
     }
     defer resp.Body.Close()
 
     if resp.StatusCode != http.StatusOK {
-        return "", fmt.Errorf("error: status code %d", resp.StatusCode)
+        drain(resp)
+        return "", nil, "", &httpStatusError{code: resp.StatusCode}
     }
 
-    body, err := io.ReadAll(resp.Body)
+    // Decode legacy charsets named by the header, a BOM or a meta tag to UTF-8
+    body, err := charset.NewReader(resp.Body, resp.Header.Get("Content-Type"))
+    if err != nil {
+        body = resp.Body
+    }
+    head, err := readHead(body)
     if err != nil {
-        return "", err
+        return "", nil, "", err
     }
+    // Let a short remainder through so the connection stays reusable
+    drain(resp)
 
-    return string(body), nil
+    return head, resp.Header, resp.Request.URL.String(), nil
 }
 
-// Extract favicon links using improved regex
-func extractFaviconLinks(content string) []string {
-    var links []string
-    re := regexp.MustCompile(`(?i)(https?://[^\"\s]*?/favicon\.ico|/favicon\.ico|favicon\.ico)`)
-    matches := re.FindAllString(content, -1)
+// Read what is left of a small response body so its connection can be reused
+func drain(resp *http.Response) {
+    io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+}
 
-    // Deduplicate links using a map
-    seen := map[string]struct{}{}
-    for _, match := range matches {
-        if _, ok := seen[match]; !ok {
-            seen[match] = struct{}{}
-            links = append(links, match)
+// Extract the page title
+func extractTitle(content string) string {
+    re := regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+    m := re.FindStringSubmatch(content)
+    if m == nil {
+        return ""
+    }
+    return strings.Join(strings.Fields(html.UnescapeString(m[1])), " ")
+}
+
+// Patterns for client-side redirects: the meta refresh tag and its URL, and
+// trivial script assignments or calls on location
+var (
+    metaRefreshRe = regexp.MustCompile(`(?is)<meta\s[^>]*http-equiv\s*=\s*["']?refresh["']?[^>]*>`)
+    refreshURLRe  = regexp.MustCompile(`(?is)content\s*=\s*["']?\s*\d*(?:\.\d*)?\s*[;,]\s*url\s*=\s*['"]?([^'">\s]+)`)
+    jsLocationRe  = regexp.MustCompile(`(?:(?:window|document|top|self)\.)?location(?:\.href)?\s*=\s*["']([^"']+)["']|location\.(?:replace|assign)\(\s*["']([^"']+)["']\s*\)`)
+)
+
+// Extract the target of a meta refresh or JavaScript location redirect
+func extractRedirect(content string) string {
+    if tag := metaRefreshRe.FindString(content); tag != "" {
+        if m := refreshURLRe.FindStringSubmatch(tag); m != nil {
+            return html.UnescapeString(m[1])
         }
     }
-    return links
+    if m := jsLocationRe.FindStringSubmatch(content); m != nil {
+        if m[1] != "" {
+            return m[1]
+        }
+        return m[2]
+    }
+    return ""
 }
 
-// Calculate MD5 and SHA256 hashes of a file (favicon.ico)
-func calculateHashes(url string) (string, string, error) {
-    resp, err := http.Get(url)
+// Absolute http(s) URL of a redirect relative to the page, or "" if unusable
+func redirectURL(pageURL, ref string) string {
+    if ref == "" {
+        return ""
+    }
+    page, err := url.Parse(pageURL)
     if err != nil {
-        return "", "", err
+        return ""
     }
-    defer resp.Body.Close()
+    next, err := page.Parse(ref)
+    if err != nil || (next.Scheme != "http" && next.Scheme != "https") {
+        return ""
+    }
+    next.Fragment = ""
+    return next.String()
+}
 
-    // Create a hash for MD5 and SHA256
-    md5Hash := md5.New()
-    sha256Hash := sha256.New()
+// Document base set by a <base href> element
+var baseHrefRe = regexp.MustCompile(`(?is)<base\s[^>]*href\s*=\s*["']?([^"'>\s]+)`)
 
-    // Copy the response body to the hashes
-    if _, err = io.Copy(md5Hash, resp.Body); err != nil {
-        return "", "", err
+// Extract the href of the document's <base> element, if any
+func extractBaseHref(content string) string {
+    m := baseHrefRe.FindStringSubmatch(content)
+    if m == nil {
+        return ""
     }
+    return html.UnescapeString(m[1])
+}
 
-    // Re-fetch to read again for SHA256
-    resp.Body.Close()
-    resp, err = http.Get(url)
+// Resolve a link against a document base URL as a browser would
+func resolveReference(base, link string) string {
+    b, err := url.Parse(base)
     if err != nil {
-        return "", "", err
+        return resolveLink(base, link)
     }
-    defer resp.Body.Close()
+    u, err := b.Parse(link)
+    if err != nil {
+        return resolveLink(base, link)
+    }
+    return u.String()
+}
 
-    if _, err = io.Copy(sha256Hash, resp.Body); err != nil {
-        return "", "", err
+// Scheme and host of a URL
+func urlOrigin(rawURL string) string {
+    u, err := url.Parse(rawURL)
+    if err != nil {
+        return rawURL
     }
+    return u.Scheme + "://" + u.Host
+}
+
+// <link> elements and the attributes that give their rel and href
+var (
+    linkTagRe  = regexp.MustCompile(`(?is)<link\s[^>]*>`)
+    linkAttrRe = regexp.MustCompile(`(?is)\b(rel|href)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+)
 
-    return hex.EncodeToString(md5Hash.Sum(nil)), hex.EncodeToString(sha256Hash.Sum(nil)), nil
+// Order in which icon links are processed: rel=icon, shortcut icon, then
+// apple-touch icons and other icon rels; 0 for links that are not icons
+func iconRank(rel string) int {
+    fields := strings.Fields(strings.ToLower(rel))
+    switch {
+    case slices.Equal(fields, []string{"icon"}):
+        return 1
+    case slices.Contains(fields, "icon"):
+        return 2
+    case slices.ContainsFunc(fields, func(f string) bool { return strings.HasPrefix(f, "apple-touch-icon") }):
+        return 3
+    case slices.ContainsFunc(fields, func(f string) bool { return strings.HasSuffix(f, "-icon") }):
+        return 4
+    }
+    return 0
 }
 
-// Save hashes to SQLite database
-func saveToDatabase(db *sql.DB, link, md5Hash, sha256Hash string) error {
-    _, err := db.Exec("INSERT OR IGNORE INTO favicons(link, md5, sha256) VALUES(?, ?, ?)", link, md5Hash, sha256Hash)
-    return err
+// Extract favicon links: the hrefs of icon <link> elements in priority
+// order, then any other favicon.ico reference
+func extractFaviconLinks(content string) []string {
+    type iconLink struct {
+        href string
+        rank int
+    }
+    var tagged []iconLink
+    for _, tag := range linkTagRe.FindAllString(content, -1) {
+        var rel, href string
+        for _, m := range linkAttrRe.FindAllStringSubmatch(tag, -1) {
+            v := html.UnescapeString(m[2] + m[3] + m[4])
+            if strings.ToLower(m[1]) == "rel" {
+                rel = v
+            } else {
+                href = strings.TrimSpace(v)
+            }
+        }
+        if rank := iconRank(rel); rank > 0 && href != "" {
+            tagged = append(tagged, iconLink{href, rank})
+        }
+    }
+    sort.SliceStable(tagged, func(i, j int) bool { return tagged[i].rank < tagged[j].rank })
+
+    var links []string
+    seen := map[string]bool{}
+    for _, l := range tagged {
+        if !seen[l.href] {
+            seen[l.href] = true
+            links = append(links, l.href)
+        }
+    }
+    re := regexp.MustCompile(`(?i)(https?://[^\"\s]*?/favicon\.ico|/favicon\.ico|favicon\.ico)`)
+    for _, match := range re.FindAllString(content, -1) {
+        // Part of an icon link already taken, e.g. /favicon.ico?v=2
+        if seen[match] || slices.ContainsFunc(links, func(l string) bool { return strings.Contains(l, match) }) {
+            continue
+        }
+        seen[match] = true
+        links = append(links, match)
+    }
+    return links
+}
+
+// HTTP cache validators from an earlier response
+type validators struct {
+    ETag         string
+    LastModified string
+}
+
+// Download a favicon. When validators are given the request is conditional,
+// and a 304 response comes back with NotModified set and no data. Icons
+// skipped by -head-preflight or -max-icon-size, and responses that are no
+// icon at all, come back as *iconSkipped.
+func (f *httpFetcher) fetchFavicon(ctx context.Context, url string, cond validators) (favicon, error) {
+    icon := favicon{URL: url}
+    if f.preflight {
+        if skip := f.preflightFavicon(ctx, url); skip != nil {
+            return icon, skip
+        }
+    }
+    req, err := newRequest(ctx, url)
+    if err != nil {
+        return icon, err
+    }
+    if cond.ETag != "" {
+        req.Header.Set("If-None-Match", cond.ETag)
+    }
+    if cond.LastModified != "" {
+        req.Header.Set("If-Modified-Since", cond.LastModified)
+    }
+    resp, err := f.client.Do(req)
+    if err != nil {
+        return icon, err
+    }
+    defer resp.Body.Close()
+
+    icon.ETag, icon.LastModified = resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+    if resp.StatusCode == http.StatusNotModified {
+        drain(resp)
+        icon.NotModified = true
+        return icon, nil
+    }
+
+    icon.Data, err = f.readIcon(resp)
+    if err != nil {
+        return icon, err
+    }
+    icon.ContentType = resp.Header.Get("Content-Type")
+    if code := invalidIcon(resp.StatusCode, icon.ContentType, icon.Data); code != "" {
+        return icon, &iconSkipped{reason: "invalid_icon", code: code, status: resp.StatusCode, contentType: icon.ContentType, size: int64(len(icon.Data))}
+    }
+    return icon, nil
 }
 
 // Resolve a relative link to an absolute URL
@@ -102,6 +277,33 @@ func resolveLink(baseURL, link string) string {
     return baseURL + "/" + link
 }
 
+// Open the SQLite database and create the schema if needed
+func openDatabase(path string) (*sql.DB, error) {
+    // WAL lets readers run alongside the writer; busy_timeout rides out short
+    // lock waits; SQLite only enforces REFERENCES when asked to
+    db, err := sql.Open("sqlite3", path+"?_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=5000&_foreign_keys=on")
+    if err != nil {
+        return nil, err
+    }
+
+    // Every connection to :memory: is a separate database, so keep just one
+    if path == ":memory:" {
+        db.SetMaxOpenConns(1)
+    }
+
+    // Bring the schema up to date
+    if err := migrate(db); err != nil {
+        db.Close()
+        return nil, fmt.Errorf("migrating schema: %v", err)
+    }
+    return db, nil
+}
+
+// Open an existing database without creating, migrating or writing to it
+func openDatabaseReadOnly(path string) (*sql.DB, error) {
+    return sql.Open("sqlite3", "file:"+path+"?mode=ro&_busy_timeout=5000")
+}
+
 // Read URLs from a file
 func readURLsFromFile(filename string) ([]string, error) {
     file, err := os.Open(filename)
@@ -126,81 +328,257 @@ func readURLsFromFile(filename string) ([]string, error) {
     return urls, nil
 }
 
-// Main function
-func main() {
-    // Command-line arguments
+// Split a comma-separated list, dropping empty entries
+func splitList(list string) []string {
+    var items []string
+    for _, item := range strings.Split(list, ",") {
+        if item = strings.TrimSpace(item); item != "" {
+            items = append(items, item)
+        }
+    }
+    return items
+}
+
+// Location of the results database
+const defaultDBPath = "./favicons.db"
+
+// Workspace used when -workspace is not given
+const defaultWorkspace = "default"
+
+// Workspace names: letters, digits, dots, dashes and underscores
+var workspaceNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Database location, encryption settings and workspace shared by every command
+type dbOptions struct {
+    path      string
+    key       string
+    keyFile   string
+    workspace string
+}
+
+// Register the database flags on a flag set
+func dbFlags(fs *flag.FlagSet) *dbOptions {
+    o := &dbOptions{}
+    fs.StringVar(&o.path, "db-path", defaultDBPath, "SQLite database file, or :memory: for an ephemeral database")
+    fs.StringVar(&o.key, "db-key", os.Getenv("MAPLINK_DB_KEY"), "SQLCipher passphrase for an encrypted database (or MAPLINK_DB_KEY)")
+    fs.StringVar(&o.keyFile, "db-keyfile", "", "File holding the SQLCipher passphrase or a 64-hex-digit raw key")
+    workspace := os.Getenv("MAPLINK_WORKSPACE")
+    if workspace == "" {
+        workspace = defaultWorkspace
+    }
+    fs.StringVar(&o.workspace, "workspace", workspace, "Workspace that scans write to and every other command reads (or MAPLINK_WORKSPACE)")
+    return o
+}
+
+// Open the database with the configured settings
+func (o *dbOptions) open() (*sql.DB, error) {
+    if err := validateWorkspace(o.workspace); err != nil {
+        return nil, err
+    }
+    key, err := o.passphrase()
+    if err != nil {
+        return nil, err
+    }
+    if key == "" {
+        return openDatabase(o.path)
+    }
+    return openEncryptedDatabase(o.path, key, false)
+}
+
+// Open the database for reading only
+func (o *dbOptions) openReadOnly() (*sql.DB, error) {
+    if err := validateWorkspace(o.workspace); err != nil {
+        return nil, err
+    }
+    key, err := o.passphrase()
+    if err != nil {
+        return nil, err
+    }
+    if key == "" {
+        return openDatabaseReadOnly(o.path)
+    }
+    return openEncryptedDatabase(o.path, key, true)
+}
+
+// Check a -workspace name
+func validateWorkspace(name string) error {
+    if !workspaceNameRe.MatchString(name) {
+        return fmt.Errorf("invalid workspace %q: use letters, digits, '.', '-' and '_'", name)
+    }
+    return nil
+}
+
+// SQLCipher key from -db-keyfile or -db-key
+func (o *dbOptions) passphrase() (string, error) {
+    if o.keyFile == "" {
+        return o.key, nil
+    }
+    data, err := os.ReadFile(o.keyFile)
+    if err != nil {
+        return "", fmt.Errorf("reading key file: %v", err)
+    }
+    return strings.TrimSpace(string(data)), nil
+}
+
+// Copy the database to a file, e.g. to keep the results of a :memory: run
+func dumpDatabase(db *sql.DB, filename string) error {
+    if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+        return err
+    }
+    _, err := db.Exec("VACUUM INTO ?", filename)
+    return err
+}
+
+// Context cancelled on SIGINT or SIGTERM; a second signal kills the process
+func signalContext() context.Context {
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    go func() {
+        <-ctx.Done()
+        stop()
+        fmt.Fprintln(os.Stderr, "Interrupted, finishing pending writes (press Ctrl-C again to force quit)")
+    }()
+    return ctx
+}
+
+// Scan targets from a -file or stdin, once or as a daemon
+func scanCommand(args []string) {
+    fs := flag.NewFlagSet("scan", flag.ExitOnError)
     var filename string
-    flag.StringVar(&filename, "file", "", "File containing a list of URLs to scrape for favicon.ico links")
-    flag.Parse()
+    var dumpPath string
+    fs.StringVar(&filename, "file", "", "File containing a list of URLs to scrape for favicon.ico links")
+    dbOpts := dbFlags(fs)
+    fs.StringVar(&dumpPath, "dump-db", "", "Copy the database to this file when the scan finishes (useful with -db-path :memory:)")
+    opts := registerScanFlags(fs)
+
+    var daemon, dry bool
+    var interval, reportInterval time.Duration
+    var smtpHost, smtpUser, smtpPassword, smtpFrom, smtpTo string
+    var smtpPort int
+    fs.BoolVar(&dry, "dry-run", false, "Print what would be fetched and stored without making requests or writing to the database")
+    fs.BoolVar(&daemon, "daemon", false, "Keep running and rescan the URL list every -interval")
+    fs.DurationVar(&interval, "interval", time.Hour, "Time between scans in daemon mode")
+    fs.DurationVar(&reportInterval, "report-interval", 24*time.Hour, "Time between summary emails in daemon mode")
+    var retentionDays, observationRetentionDays, blobRetentionDays int
+    fs.IntVar(&retentionDays, "retention-days", 0, "In daemon mode, prune runs and history older than this many days (0 keeps everything)")
+    fs.IntVar(&observationRetentionDays, "observation-retention-days", 0, "In daemon mode, prune observations not seen for this many days (0 keeps them)")
+    fs.IntVar(&blobRetentionDays, "blob-retention-days", 0, "In daemon mode, drop the bytes of icons not seen for this many days unless hunted (0 keeps them)")
+    pprofOpts := registerPprofFlags(fs)
+    fs.StringVar(&smtpHost, "smtp-host", "", "SMTP server for summary emails")
+    fs.IntVar(&smtpPort, "smtp-port", 587, "SMTP server port")
+    fs.StringVar(&smtpUser, "smtp-user", "", "SMTP username")
+    fs.StringVar(&smtpPassword, "smtp-password", "", "SMTP password")
+    fs.StringVar(&smtpFrom, "smtp-from", "", "Sender address for summary emails")
+    fs.StringVar(&smtpTo, "smtp-to", "", "Comma-separated recipients for summary emails")
+    parseFlags(fs, args)
 
-    if filename == "" {
-        fmt.Println("Please provide a filename using the -file flag.")
+    // Targets come from the file, or stream in on stdin as another tool finds them
+    streaming := filename == "-" || (filename == "" && !isTerminal(os.Stdin))
+    if filename == "" && !streaming {
+        fmt.Println("Please provide a filename using the -file flag, or pipe targets to stdin.")
+        return
+    }
+    if streaming && (daemon || opts.resume) {
+        fmt.Fprintln(os.Stderr, "Error: -daemon and -resume need a -file to read targets from")
         return
     }
+    var targets []target
+    if !streaming {
+        var err error
+        if targets, err = readTargets(filename); err != nil {
+            fmt.Fprintf(os.Stderr, "Error reading URLs from file: %v\n", err)
+            return
+        }
+    }
 
-    // Read URLs from the file
-    urls, err := readURLsFromFile(filename)
-    if err != nil {
-        fmt.Fprintf(os.Stderr, "Error reading URLs from file: %v\n", err)
+    if dry {
+        if streaming {
+            for t := range streamTargets(os.Stdin) {
+                targets = append(targets, t)
+            }
+        }
+        dryRun(dbOpts, opts, targets)
         return
     }
 
     // Database setup
-    db, err := sql.Open("sqlite3", "./favicons.db")
+    db, err := dbOpts.open()
     if err != nil {
         fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
         return
     }
     defer db.Close()
 
-    // Create table if it doesn't exist
-    sqlStmt := `
-    CREATE TABLE IF NOT EXISTS favicons (
-        id INTEGER PRIMARY KEY AUTOINCREMENT,
-        link TEXT UNIQUE,
-        md5 TEXT,
-        sha256 TEXT
-    );
-    `
-    if _, err := db.Exec(sqlStmt); err != nil {
-        fmt.Fprintf(os.Stderr, "Error creating table: %v\n", err)
+    s, err := opts.newScanner(db, dbOpts.workspace)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error setting up scan: %v\n", err)
         return
     }
-
-    // Process each URL
-    for _, baseURL := range urls {
-        fmt.Printf("Processing URL: %s\n", baseURL)
-
-        // Fetch HTML
-        htmlContent, err := fetchHTML(baseURL)
-        if err != nil {
-            fmt.Fprintf(os.Stderr, "Error fetching HTML: %v\n", err)
-            continue
+    if err := pprofOpts.start(); err != nil {
+        fmt.Fprintf(os.Stderr, "Error starting pprof: %v\n", err)
+        return
+    }
+    ctx := signalContext()
+    if !daemon {
+        if streaming {
+            s.scanStream(ctx, streamTargets(os.Stdin))
+        } else {
+            s.scanTargets(ctx, targets)
         }
-
-        // Extract favicon links
-        faviconLinks := extractFaviconLinks(htmlContent)
-        if len(faviconLinks) == 0 {
-            fmt.Println("No favicon.ico links found.")
-            continue
+        s.close()
+        if dumpPath != "" {
+            if err := dumpDatabase(db, dumpPath); err != nil {
+                fmt.Fprintf(os.Stderr, "Error dumping database: %v\n", err)
+            }
         }
+        return
+    }
+    defer s.close()
 
-        // Check each favicon link and calculate hashes
-        for _, link := range faviconLinks {
-            fullURL := resolveLink(baseURL, link)
+    // Daemon mode: rescan on an interval and mail a summary periodically
+    mailer := smtpConfig{host: smtpHost, port: smtpPort, user: smtpUser, password: smtpPassword, from: smtpFrom, to: splitList(smtpTo)}
+    lastReport := time.Now()
+    if mailer.enabled() {
+        s.summary = newSummary()
+    }
+    retain := retentionDays > 0 || observationRetentionDays > 0 || blobRetentionDays > 0
+    var lastRetention time.Time
+    for ctx.Err() == nil {
+        s.scanTargets(ctx, targets)
 
-            md5Hash, sha256Hash, err := calculateHashes(fullURL)
-            if err != nil {
-                fmt.Fprintf(os.Stderr, "Error calculating hash for %s: %v\n", fullURL, err)
-                continue
+        // Enforce retention after the first cycle and daily from then on
+        if retain && ctx.Err() == nil && time.Since(lastRetention) >= 24*time.Hour {
+            policy := newRetentionPolicy(retentionDays, observationRetentionDays, blobRetentionDays)
+            if stats, err := maintainDatabase(db, policy, localActor("daemon")); err != nil {
+                errorf("Error enforcing retention: %v\n", err)
+            } else {
+                infof("Retention: removed %d history rows, %d runs and %d rows they recorded, %d observations, %d orphaned blobs; dropped the data of %d blobs\n",
+                    stats.history, stats.runs, stats.runRows, stats.observations, stats.blobs, stats.blobData)
             }
-            fmt.Printf("Favicon: %s | MD5: %s | SHA256: %s\n", fullURL, md5Hash, sha256Hash)
+            lastRetention = time.Now()
+        }
 
-            // Save to database
-            err = saveToDatabase(db, fullURL, md5Hash, sha256Hash)
-            if err != nil {
-                fmt.Fprintf(os.Stderr, "Error saving to database for %s: %v\n", link, err)
+        if s.summary != nil && time.Since(lastReport) >= reportInterval {
+            report := s.summary.drain(lastReport)
+            if err := mailer.send(report); err != nil {
+                // Keep it for the next attempt, which covers the window since lastReport
+                errorf("Error sending summary email: %v\n", err)
+                s.summary.restore(report)
+            } else {
+                lastReport = report.Until
             }
         }
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-time.After(interval):
+        }
+
+        // Pick up edits to the target list between cycles
+        if fresh, err := readTargets(filename); err != nil {
+            errorf("Error reading URLs from file: %v\n", err)
+        } else {
+            targets = fresh
+        }
     }
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+    "strings"
+    texttemplate "text/template"
+)
+
+// A search engine query that finds other hosts serving the same favicon
+type pivot struct {
+    Engine string
+    Query  string
+}
+
+// Pivot queries for a favicon on the common internet search engines
+func pivotQueries(md5Hash, mmh3Hash string) []pivot {
+    var pivots []pivot
+    if mmh3Hash != "" {
+        pivots = append(pivots,
+            pivot{"Shodan", "http.favicon.hash:" + mmh3Hash},
+            pivot{"FOFA", `icon_hash="` + mmh3Hash + `"`},
+        )
+    }
+    if md5Hash != "" {
+        pivots = append(pivots,
+            pivot{"Censys", "services.http.response.favicons.md5_hash: " + md5Hash},
+            pivot{"ZoomEye", `iconhash:"` + md5Hash + `"`},
+        )
+    }
+    return pivots
+}
+
+// Escape a value for use inside a Markdown table cell
+func markdownCell(s string) string {
+    s = strings.ReplaceAll(s, "|", `\|`)
+    return strings.ReplaceAll(s, "\n", " ")
+}
+
+var markdownReport = texttemplate.Must(texttemplate.New("markdown").Funcs(texttemplate.FuncMap{
+    "cell":   markdownCell,
+    "pivots": pivotQueries,
+}).Parse(`# MAPLINK report
+
+Generated {{.Generated}} · {{len .Hosts}} favicon links · {{len .Hashes}} unique favicons
+
+## Hosts
+
+| Link | Screenshot | Technology | Tags | Run labels | MD5 | SHA256 | MMH3 |
+|------|------------|------------|------|------------|-----|--------|------|
+{{range .Hosts}}| {{cell .Link}} | {{if .Screenshot}}[view]({{.Screenshot}}){{end}} | {{cell .Tech}} | {{cell .Tags}} | {{cell .Labels}} | ` + "`{{.MD5}}`" + ` | ` + "`{{.SHA256}}`" + ` | ` + "`{{.MMH3}}`" + ` |
+{{end}}
+## Hashes
+
+| MD5 | MMH3 | Technology | Tags | Run labels | Hosts |
+|-----|------|------------|------|------------|-------|
+{{range .Hashes}}| ` + "`{{.MD5}}`" + ` | ` + "`{{.MMH3}}`" + ` | {{cell .Tech}} | {{cell .Tags}} | {{cell .Labels}} | {{len .Links}} |
+{{end}}
+## Pivot queries
+
+| MD5 | Engine | Query |
+|-----|--------|-------|
+{{range .Hashes}}{{$md5 := .MD5}}{{range pivots .MD5 .MMH3}}| ` + "`{{$md5}}`" + ` | {{.Engine}} | ` + "`{{.Query}}`" + ` |
+{{end}}{{end}}
+## Change history
+
+| Seen | Link | MD5 |
+|------|------|-----|
+{{range .History}}| {{.SeenAt}} | {{cell .Link}} | ` + "`{{.MD5}}`" + ` |
+{{end}}`))
@@ -0,0 +1,290 @@
+package main
+
+import (
+    "database/sql"
+    "flag"
+    "fmt"
+    "os"
+)
+
+// Keep the newest hashes for a link, the earliest first_seen and the latest last_seen
+const mergeFaviconSQL = `INSERT INTO favicons(workspace, link, md5, sha256, first_seen, last_seen, target, title, server, labels, final_url, final_host, apex, run_labels)
+    VALUES(?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), ?, ?, ?, ?, ?, ?, ?, ?)
+    ON CONFLICT(workspace, link) DO UPDATE SET
+        md5 = CASE WHEN COALESCE(excluded.last_seen, '') > COALESCE(favicons.last_seen, '') THEN excluded.md5 ELSE favicons.md5 END,
+        sha256 = CASE WHEN COALESCE(excluded.last_seen, '') > COALESCE(favicons.last_seen, '') THEN excluded.sha256 ELSE favicons.sha256 END,
+        target = CASE WHEN COALESCE(excluded.last_seen, '') > COALESCE(favicons.last_seen, '') THEN excluded.target ELSE favicons.target END,
+        title = CASE WHEN COALESCE(excluded.last_seen, '') > COALESCE(favicons.last_seen, '') THEN excluded.title ELSE favicons.title END,
+        server = CASE WHEN COALESCE(excluded.last_seen, '') > COALESCE(favicons.last_seen, '') THEN excluded.server ELSE favicons.server END,
+        labels = CASE WHEN COALESCE(excluded.last_seen, '') > COALESCE(favicons.last_seen, '') THEN excluded.labels ELSE favicons.labels END,
+        final_url = CASE WHEN COALESCE(excluded.last_seen, '') > COALESCE(favicons.last_seen, '') THEN excluded.final_url ELSE favicons.final_url END,
+        final_host = CASE WHEN COALESCE(excluded.last_seen, '') > COALESCE(favicons.last_seen, '') THEN excluded.final_host ELSE favicons.final_host END,
+        apex = CASE WHEN COALESCE(excluded.last_seen, '') > COALESCE(favicons.last_seen, '') THEN excluded.apex ELSE favicons.apex END,
+        run_labels = CASE WHEN COALESCE(excluded.last_seen, '') > COALESCE(favicons.last_seen, '') THEN excluded.run_labels ELSE favicons.run_labels END,
+        first_seen = CASE WHEN favicons.first_seen IS NULL OR excluded.first_seen < favicons.first_seen THEN excluded.first_seen ELSE favicons.first_seen END,
+        last_seen = CASE WHEN favicons.last_seen IS NULL OR excluded.last_seen > favicons.last_seen THEN excluded.last_seen ELSE favicons.last_seen END`
+
+// History rows are identical when workspace, link, hash and timestamp match
+const mergeHistorySQL = `INSERT INTO history(workspace, link, md5, sha256, seen_at, run_id) SELECT ?, ?, ?, ?, ?, ?
+    WHERE NOT EXISTS (SELECT 1 FROM history WHERE workspace = ? AND link = ? AND sha256 = ? AND seen_at = ?)`
+
+// Counts of rows copied from one source
+type mergeStats struct {
+    favicons int
+    blobs    int
+    history  int
+    runs     int
+}
+
+// Copy every row of src into dst inside one transaction, each into the
+// workspace it came from
+func mergeDatabase(dst, src *sql.DB) (mergeStats, error) {
+    var stats mergeStats
+    tx, err := dst.Begin()
+    if err != nil {
+        return stats, err
+    }
+    defer tx.Rollback()
+
+    rows, err := src.Query(`SELECT workspace, link, md5, sha256, COALESCE(first_seen, ''), COALESCE(last_seen, ''), target, title, server, labels, final_url, final_host, apex, run_labels FROM favicons`)
+    if err != nil {
+        return stats, err
+    }
+    for rows.Next() {
+        var workspace, link, md5Hash, sha256Hash, firstSeen, lastSeen string
+        var target, title, server, labels, finalURL, finalHost, apex, runLabels sql.NullString
+        if err := rows.Scan(&workspace, &link, &md5Hash, &sha256Hash, &firstSeen, &lastSeen, &target, &title, &server, &labels, &finalURL, &finalHost, &apex, &runLabels); err != nil {
+            rows.Close()
+            return stats, err
+        }
+        if _, err := tx.Exec(mergeFaviconSQL, workspace, link, md5Hash, sha256Hash, firstSeen, lastSeen, target, title, server, labels, finalURL, finalHost, apex, runLabels); err != nil {
+            rows.Close()
+            return stats, err
+        }
+        stats.favicons++
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return stats, err
+    }
+
+    // Blobs are copied as stored, compressed or not
+    rows, err = src.Query("SELECT sha256, md5, mmh3, content_type, size, data, encoding FROM favicon_blobs")
+    if err != nil {
+        return stats, err
+    }
+    for rows.Next() {
+        var sha256Hash, md5Hash, mmh3Hash, contentType, encoding sql.NullString
+        var size sql.NullInt64
+        var data []byte
+        if err := rows.Scan(&sha256Hash, &md5Hash, &mmh3Hash, &contentType, &size, &data, &encoding); err != nil {
+            rows.Close()
+            return stats, err
+        }
+        res, err := tx.Exec(blobSQL, sha256Hash, md5Hash, mmh3Hash, contentType, size, data, encoding)
+        if err != nil {
+            rows.Close()
+            return stats, err
+        }
+        if n, _ := res.RowsAffected(); n > 0 {
+            stats.blobs++
+        }
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return stats, err
+    }
+
+    // Runs get new ids in the target; a run already merged is matched by name and start
+    runIDs := map[int64]int64{}
+    rows, err = src.Query("SELECT id, workspace, name, started_at, finished_at, targets, favicons, errors, status, labels FROM runs ORDER BY id")
+    if err != nil {
+        return stats, err
+    }
+    for rows.Next() {
+        var id int64
+        var workspace string
+        var name, startedAt, finishedAt, status, labels sql.NullString
+        var targets, favicons, errors int
+        if err := rows.Scan(&id, &workspace, &name, &startedAt, &finishedAt, &targets, &favicons, &errors, &status, &labels); err != nil {
+            rows.Close()
+            return stats, err
+        }
+        var existing int64
+        err := tx.QueryRow("SELECT id FROM runs WHERE workspace = ? AND name IS ? AND started_at IS ?", workspace, name, startedAt).Scan(&existing)
+        if err == sql.ErrNoRows {
+            res, err := tx.Exec("INSERT INTO runs(workspace, name, started_at, finished_at, targets, favicons, errors, status, labels) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)",
+                workspace, name, startedAt, finishedAt, targets, favicons, errors, status, labels)
+            if err != nil {
+                rows.Close()
+                return stats, err
+            }
+            existing, _ = res.LastInsertId()
+            stats.runs++
+        } else if err != nil {
+            rows.Close()
+            return stats, err
+        }
+        runIDs[id] = existing
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return stats, err
+    }
+
+    rows, err = src.Query("SELECT workspace, link, md5, sha256, seen_at, run_id FROM history ORDER BY id")
+    if err != nil {
+        return stats, err
+    }
+    for rows.Next() {
+        var workspace, link, md5Hash, sha256Hash, seenAt string
+        var runID sql.NullInt64
+        if err := rows.Scan(&workspace, &link, &md5Hash, &sha256Hash, &seenAt, &runID); err != nil {
+            rows.Close()
+            return stats, err
+        }
+        var run interface{}
+        if id, ok := runIDs[runID.Int64]; ok && runID.Valid {
+            run = id
+        }
+        res, err := tx.Exec(mergeHistorySQL, workspace, link, md5Hash, sha256Hash, seenAt, run, workspace, link, sha256Hash, seenAt)
+        if err != nil {
+            rows.Close()
+            return stats, err
+        }
+        if n, _ := res.RowsAffected(); n > 0 {
+            stats.history++
+        }
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return stats, err
+    }
+
+    // Observations by host name, since host ids differ between databases
+    rows, err = src.Query(`SELECT o.workspace, h.name, o.link, o.sha256, o.first_seen, o.last_seen, o.run_id
+        FROM observations o JOIN hosts h ON h.id = o.host_id ORDER BY o.id`)
+    if err != nil {
+        return stats, err
+    }
+    for rows.Next() {
+        var workspace, host, link, sha256Hash, firstSeen, lastSeen string
+        var runID sql.NullInt64
+        if err := rows.Scan(&workspace, &host, &link, &sha256Hash, &firstSeen, &lastSeen, &runID); err != nil {
+            rows.Close()
+            return stats, err
+        }
+        var run int64
+        if id, ok := runIDs[runID.Int64]; ok && runID.Valid {
+            run = id
+        }
+        var hostID int64
+        if err := tx.QueryRow(`INSERT INTO hosts(workspace, name, apex, first_seen, last_seen) VALUES(?, ?, NULLIF(?, ''), ?, ?)
+            ON CONFLICT(workspace, name) DO UPDATE SET first_seen = MIN(first_seen, excluded.first_seen), last_seen = MAX(last_seen, excluded.last_seen)
+            RETURNING id`, workspace, host, apexDomain(host), firstSeen, lastSeen).Scan(&hostID); err != nil {
+            rows.Close()
+            return stats, err
+        }
+        if _, err := tx.Exec(`INSERT INTO observations(workspace, host_id, link, sha256, first_seen, last_seen, run_id) VALUES(?, ?, ?, ?, ?, ?, NULLIF(?, 0))
+            ON CONFLICT(host_id, link, sha256) DO UPDATE SET first_seen = MIN(first_seen, excluded.first_seen), last_seen = MAX(last_seen, excluded.last_seen)`,
+            workspace, hostID, link, sha256Hash, firstSeen, lastSeen, run); err != nil {
+            rows.Close()
+            return stats, err
+        }
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return stats, err
+    }
+    // Favicons new to the target still need their hosts
+    if err := linkHosts(tx); err != nil {
+        return stats, err
+    }
+
+    // Tags and notes; a note already present with the same text is not copied again
+    rows, err = src.Query("SELECT workspace, kind, value, tag, created_at FROM tags")
+    if err != nil {
+        return stats, err
+    }
+    for rows.Next() {
+        var workspace, kind, value, tag string
+        var createdAt sql.NullString
+        if err := rows.Scan(&workspace, &kind, &value, &tag, &createdAt); err != nil {
+            rows.Close()
+            return stats, err
+        }
+        if _, err := tx.Exec("INSERT OR IGNORE INTO tags(workspace, kind, value, tag, created_at) VALUES(?, ?, ?, ?, ?)", workspace, kind, value, tag, createdAt); err != nil {
+            rows.Close()
+            return stats, err
+        }
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return stats, err
+    }
+    rows, err = src.Query("SELECT workspace, kind, value, note, created_at FROM notes ORDER BY id")
+    if err != nil {
+        return stats, err
+    }
+    for rows.Next() {
+        var workspace, kind, value, note string
+        var createdAt sql.NullString
+        if err := rows.Scan(&workspace, &kind, &value, &note, &createdAt); err != nil {
+            rows.Close()
+            return stats, err
+        }
+        if _, err := tx.Exec(`INSERT INTO notes(workspace, kind, value, note, created_at) SELECT ?, ?, ?, ?, ?
+            WHERE NOT EXISTS (SELECT 1 FROM notes WHERE workspace = ? AND kind = ? AND value = ? AND note = ?)`,
+            workspace, kind, value, note, createdAt, workspace, kind, value, note); err != nil {
+            rows.Close()
+            return stats, err
+        }
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return stats, err
+    }
+
+    return stats, tx.Commit()
+}
+
+// Merge result databases from several scanning boxes into one
+func mergeCommand(args []string) {
+    fs := flag.NewFlagSet("merge", flag.ExitOnError)
+    dbOpts := dbFlags(fs)
+    fs.Usage = func() {
+        fmt.Fprintln(fs.Output(), "Usage: maplink merge [-db-path target.db] source.db [source.db ...]")
+        fs.PrintDefaults()
+    }
+    parseFlags(fs, args)
+
+    if fs.NArg() == 0 {
+        fs.Usage()
+        return
+    }
+
+    dst, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer dst.Close()
+
+    for _, path := range fs.Args() {
+        // Sources share the target's key and are upgraded to the current schema
+        srcOpts := *dbOpts
+        srcOpts.path = path
+        src, err := srcOpts.open()
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error opening %s: %v\n", path, err)
+            continue
+        }
+        stats, err := mergeDatabase(dst, src)
+        src.Close()
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error merging %s: %v\n", path, err)
+            continue
+        }
+        fmt.Printf("Merged %s: %d favicons, %d new blobs, %d new history rows, %d new runs\n", path, stats.favicons, stats.blobs, stats.history, stats.runs)
+    }
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+    "database/sql"
+    "embed"
+    "fmt"
+    "path"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Schema migrations, applied in order of their numeric prefix
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// A single numbered migration
+type migration struct {
+    version int
+    name    string
+    sql     string
+}
+
+// Go steps run after a migration's SQL, in its transaction, for data SQL
+// alone cannot derive
+var migrationHooks = map[int]func(*sql.Tx) error{
+    25: linkHosts,
+}
+
+// Load the embedded migrations sorted by version
+func loadMigrations() ([]migration, error) {
+    entries, err := migrationFiles.ReadDir("migrations")
+    if err != nil {
+        return nil, err
+    }
+
+    var migrations []migration
+    for _, e := range entries {
+        name := e.Name()
+        prefix, _, ok := strings.Cut(name, "_")
+        version, err := strconv.Atoi(prefix)
+        if !ok || err != nil {
+            return nil, fmt.Errorf("migration %s: name must start with a version number", name)
+        }
+        body, err := migrationFiles.ReadFile(path.Join("migrations", name))
+        if err != nil {
+            return nil, err
+        }
+        migrations = append(migrations, migration{version: version, name: name, sql: string(body)})
+    }
+    sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+    return migrations, nil
+}
+
+// Current schema version of the database, 0 if none has been applied
+func schemaVersion(db *sql.DB) (int, error) {
+    if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+        version INTEGER PRIMARY KEY,
+        name TEXT,
+        applied_at TEXT
+    )`); err != nil {
+        return 0, err
+    }
+    var version int
+    err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+    return version, err
+}
+
+// Apply every migration newer than the database, each in its own transaction
+func migrate(db *sql.DB) error {
+    migrations, err := loadMigrations()
+    if err != nil {
+        return err
+    }
+    current, err := schemaVersion(db)
+    if err != nil {
+        return fmt.Errorf("reading schema version: %v", err)
+    }
+
+    for _, m := range migrations {
+        if m.version <= current {
+            continue
+        }
+        tx, err := db.Begin()
+        if err != nil {
+            return err
+        }
+        if _, err := tx.Exec(m.sql); err != nil {
+            tx.Rollback()
+            return fmt.Errorf("migration %s: %v", m.name, err)
+        }
+        if hook := migrationHooks[m.version]; hook != nil {
+            if err := hook(tx); err != nil {
+                tx.Rollback()
+                return fmt.Errorf("migration %s: %v", m.name, err)
+            }
+        }
+        if _, err := tx.Exec("INSERT INTO schema_version(version, name, applied_at) VALUES(?, ?, ?)",
+            m.version, m.name, time.Now().UTC().Format(time.RFC3339)); err != nil {
+            tx.Rollback()
+            return fmt.Errorf("migration %s: %v", m.name, err)
+        }
+        if err := tx.Commit(); err != nil {
+            return fmt.Errorf("migration %s: %v", m.name, err)
+        }
+    }
+    return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+    "database/sql"
+    "path/filepath"
+    "testing"
+)
+
+func TestMigrateEmptyDatabase(t *testing.T) {
+    migrations, err := loadMigrations()
+    if err != nil {
+        t.Fatal(err)
+    }
+    for i, m := range migrations {
+        if m.version != i+1 {
+            t.Fatalf("migration %s has version %d, want %d: versions must run 1, 2, 3... without gaps", m.name, m.version, i+1)
+        }
+    }
+    latest := migrations[len(migrations)-1].version
+
+    db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "empty.db")+"?_foreign_keys=on")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer db.Close()
+
+    // A second run finds nothing to do
+    for pass := 1; pass <= 2; pass++ {
+        if err := migrate(db); err != nil {
+            t.Fatalf("pass %d: %v", pass, err)
+        }
+        version, err := schemaVersion(db)
+        if err != nil {
+            t.Fatal(err)
+        }
+        var applied int
+        if err := db.QueryRow("SELECT COUNT(*) FROM schema_version").Scan(&applied); err != nil {
+            t.Fatal(err)
+        }
+        if version != latest || applied != len(migrations) {
+            t.Errorf("pass %d: at version %d with %d migrations applied, want %d and %d", pass, version, applied, latest, len(migrations))
+        }
+    }
+
+    for _, table := range []string{"favicons", "favicon_blobs", "hosts", "observations", "history", "runs", "run_targets",
+        "errors", "skipped_icons", "technologies", "watchlist", "tags", "notes", "jobs", "job_results", "api_keys", "action_audit"} {
+        var name string
+        if err := db.QueryRow("SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&name); err != nil {
+            t.Errorf("table %s missing after migrating: %v", table, err)
+        }
+    }
+
+    var check string
+    if err := db.QueryRow("PRAGMA integrity_check").Scan(&check); err != nil || check != "ok" {
+        t.Errorf("integrity check %q, %v", check, err)
+    }
+    rows, err := db.Query("PRAGMA foreign_key_check")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer rows.Close()
+    if rows.Next() {
+        t.Errorf("foreign key violations after migrating an empty database")
+    }
+}
@@ -0,0 +1,31 @@
+-- Baseline schema. IF NOT EXISTS keeps this safe on databases created
+-- before migrations were introduced.
+CREATE TABLE IF NOT EXISTS favicons (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    link TEXT UNIQUE,
+    md5 TEXT,
+    sha256 TEXT
+);
+
+CREATE TABLE IF NOT EXISTS blobs (
+    sha256 TEXT PRIMARY KEY,
+    md5 TEXT,
+    mmh3 TEXT,
+    content_type TEXT,
+    size INTEGER,
+    data BLOB
+);
+
+CREATE TABLE IF NOT EXISTS history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    link TEXT,
+    md5 TEXT,
+    sha256 TEXT,
+    seen_at TEXT
+);
+
+CREATE INDEX IF NOT EXISTS favicons_md5 ON favicons(md5);
+CREATE INDEX IF NOT EXISTS favicons_sha256 ON favicons(sha256);
+CREATE INDEX IF NOT EXISTS blobs_md5 ON blobs(md5);
+CREATE INDEX IF NOT EXISTS blobs_mmh3 ON blobs(mmh3);
+CREATE INDEX IF NOT EXISTS history_link ON history(link);
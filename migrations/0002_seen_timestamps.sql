@@ -0,0 +1,9 @@
+-- When each favicon link was first and most recently observed
+ALTER TABLE favicons ADD COLUMN first_seen TEXT;
+ALTER TABLE favicons ADD COLUMN last_seen TEXT;
+
+UPDATE favicons SET
+    first_seen = (SELECT MIN(seen_at) FROM history h WHERE h.link = favicons.link),
+    last_seen = (SELECT MAX(seen_at) FROM history h WHERE h.link = favicons.link);
+
+CREATE INDEX IF NOT EXISTS history_link_seen ON history(link, seen_at);
@@ -0,0 +1,13 @@
+-- One row per scan pass, so history can be attributed and pruned by run
+CREATE TABLE IF NOT EXISTS runs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT,
+    started_at TEXT,
+    finished_at TEXT,
+    targets INTEGER NOT NULL DEFAULT 0,
+    favicons INTEGER NOT NULL DEFAULT 0,
+    errors INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS runs_started ON runs(started_at);
+
+ALTER TABLE history ADD COLUMN run_id INTEGER;
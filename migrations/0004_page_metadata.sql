@@ -0,0 +1,4 @@
+-- The target page a favicon was found on, with its title and Server header
+ALTER TABLE favicons ADD COLUMN target TEXT;
+ALTER TABLE favicons ADD COLUMN title TEXT;
+ALTER TABLE favicons ADD COLUMN server TEXT;
@@ -0,0 +1,3 @@
+-- running, completed or interrupted
+ALTER TABLE runs ADD COLUMN status TEXT;
+UPDATE runs SET status = CASE WHEN finished_at IS NULL THEN 'interrupted' ELSE 'completed' END;
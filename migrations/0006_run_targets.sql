@@ -0,0 +1,6 @@
+-- Targets finished within a run, so an interrupted run can be resumed
+CREATE TABLE IF NOT EXISTS run_targets (
+    run_id INTEGER NOT NULL,
+    target TEXT NOT NULL,
+    PRIMARY KEY (run_id, target)
+) WITHOUT ROWID;
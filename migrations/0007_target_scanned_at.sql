@@ -0,0 +1,3 @@
+-- When each target was finished, for freshness checks across runs
+ALTER TABLE run_targets ADD COLUMN scanned_at TEXT;
+CREATE INDEX IF NOT EXISTS run_targets_target ON run_targets(target, scanned_at);
@@ -0,0 +1,3 @@
+-- HTTP cache validators of the last favicon response, for conditional rescans
+ALTER TABLE favicons ADD COLUMN etag TEXT;
+ALTER TABLE favicons ADD COLUMN last_modified TEXT;
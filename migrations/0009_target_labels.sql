@@ -0,0 +1,2 @@
+-- Labels given to the target in a CSV or JSONL input file, comma-separated
+ALTER TABLE favicons ADD COLUMN labels TEXT;
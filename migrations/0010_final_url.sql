@@ -0,0 +1,5 @@
+-- Where the target's page ended up after redirects, and its registrable domain
+ALTER TABLE favicons ADD COLUMN final_url TEXT;
+ALTER TABLE favicons ADD COLUMN final_host TEXT;
+ALTER TABLE favicons ADD COLUMN apex TEXT;
+CREATE INDEX IF NOT EXISTS favicons_apex ON favicons(apex);
@@ -0,0 +1,2 @@
+-- Targets a run left unscanned: resumed, recently scanned, or cut off by -max-runtime
+ALTER TABLE runs ADD COLUMN skipped INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,35 @@
+-- Workspaces keep engagements apart in one database; existing rows go to "default".
+-- A link may now be stored once per workspace, so favicons is rebuilt with the wider key.
+CREATE TABLE favicons_by_workspace (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    workspace TEXT NOT NULL DEFAULT 'default',
+    link TEXT,
+    md5 TEXT,
+    sha256 TEXT,
+    first_seen TEXT,
+    last_seen TEXT,
+    target TEXT,
+    title TEXT,
+    server TEXT,
+    etag TEXT,
+    last_modified TEXT,
+    labels TEXT,
+    final_url TEXT,
+    final_host TEXT,
+    apex TEXT,
+    UNIQUE (workspace, link)
+);
+INSERT INTO favicons_by_workspace(id, link, md5, sha256, first_seen, last_seen, target, title, server, etag, last_modified, labels, final_url, final_host, apex)
+    SELECT id, link, md5, sha256, first_seen, last_seen, target, title, server, etag, last_modified, labels, final_url, final_host, apex FROM favicons;
+DROP TABLE favicons;
+ALTER TABLE favicons_by_workspace RENAME TO favicons;
+CREATE INDEX favicons_md5 ON favicons(md5);
+CREATE INDEX favicons_sha256 ON favicons(sha256);
+CREATE INDEX favicons_apex ON favicons(apex);
+
+ALTER TABLE history ADD COLUMN workspace TEXT NOT NULL DEFAULT 'default';
+CREATE INDEX history_workspace_link ON history(workspace, link, seen_at);
+
+-- run_targets belong to the workspace of their run
+ALTER TABLE runs ADD COLUMN workspace TEXT NOT NULL DEFAULT 'default';
+CREATE INDEX runs_workspace ON runs(workspace, started_at);
@@ -0,0 +1,20 @@
+-- Tags and free-text notes on a host or a hash (kind is host, md5, sha256 or mmh3)
+CREATE TABLE IF NOT EXISTS tags (
+    workspace TEXT NOT NULL DEFAULT 'default',
+    kind TEXT NOT NULL,
+    value TEXT NOT NULL,
+    tag TEXT NOT NULL,
+    created_at TEXT,
+    PRIMARY KEY (workspace, kind, value, tag)
+) WITHOUT ROWID;
+CREATE INDEX IF NOT EXISTS tags_tag ON tags(workspace, tag);
+
+CREATE TABLE IF NOT EXISTS notes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    workspace TEXT NOT NULL DEFAULT 'default',
+    kind TEXT NOT NULL,
+    value TEXT NOT NULL,
+    note TEXT NOT NULL,
+    created_at TEXT
+);
+CREATE INDEX IF NOT EXISTS notes_subject ON notes(workspace, kind, value);
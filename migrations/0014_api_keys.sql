@@ -0,0 +1,21 @@
+-- Clients of the HTTP API, identified by the SHA256 of their key
+CREATE TABLE IF NOT EXISTS api_keys (
+    name TEXT PRIMARY KEY,
+    key_sha256 TEXT NOT NULL UNIQUE,
+    rate_per_minute INTEGER NOT NULL DEFAULT 60,
+    created_at TEXT,
+    revoked_at TEXT
+);
+
+-- Every API request, with the targets it submitted
+CREATE TABLE IF NOT EXISTS api_audit (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    at TEXT NOT NULL,
+    key_name TEXT,
+    remote TEXT,
+    method TEXT,
+    path TEXT,
+    status INTEGER,
+    targets TEXT
+);
+CREATE INDEX IF NOT EXISTS api_audit_key ON api_audit(key_name, at);
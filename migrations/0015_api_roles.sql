@@ -0,0 +1,2 @@
+-- Role of each API key; keys made before roles keep the scan access they had
+ALTER TABLE api_keys ADD COLUMN role TEXT NOT NULL DEFAULT 'scanner';
@@ -0,0 +1,6 @@
+-- Orders and filters of the API list endpoints
+CREATE INDEX IF NOT EXISTS favicons_workspace_last_seen ON favicons(workspace, COALESCE(last_seen, ''), link);
+CREATE INDEX IF NOT EXISTS favicons_workspace_first_seen ON favicons(workspace, COALESCE(first_seen, ''), link);
+CREATE INDEX IF NOT EXISTS favicons_final_host ON favicons(final_host);
+CREATE INDEX IF NOT EXISTS history_workspace_run ON history(workspace, run_id);
+CREATE INDEX IF NOT EXISTS tags_workspace_tag ON tags(workspace, tag);
@@ -0,0 +1,29 @@
+-- Target lists submitted to the API, scanned one job at a time as runs
+CREATE TABLE IF NOT EXISTS jobs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    workspace TEXT NOT NULL DEFAULT 'default',
+    key_name TEXT,
+    status TEXT NOT NULL,
+    targets INTEGER NOT NULL,
+    submitted_at TEXT NOT NULL,
+    started_at TEXT,
+    finished_at TEXT,
+    run_id INTEGER
+);
+CREATE INDEX IF NOT EXISTS jobs_workspace_status ON jobs(workspace, status, id);
+
+-- Targets of a job as JSON, in submission order
+CREATE TABLE IF NOT EXISTS job_targets (
+    job_id INTEGER NOT NULL,
+    position INTEGER NOT NULL,
+    target TEXT NOT NULL,
+    PRIMARY KEY (job_id, position)
+) WITHOUT ROWID;
+
+-- Results a job emitted, as JSON
+CREATE TABLE IF NOT EXISTS job_results (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    job_id INTEGER NOT NULL,
+    result TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS job_results_job ON job_results(job_id, id);
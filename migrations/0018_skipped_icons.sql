@@ -0,0 +1,13 @@
+-- Favicon links passed over by -head-preflight or -max-icon-size, and why
+CREATE TABLE IF NOT EXISTS skipped_icons (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    workspace TEXT NOT NULL DEFAULT 'default',
+    run_id INTEGER,
+    link TEXT NOT NULL,
+    target TEXT,
+    reason TEXT NOT NULL,
+    content_type TEXT,
+    size INTEGER,
+    skipped_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS skipped_icons_workspace_link ON skipped_icons(workspace, link);
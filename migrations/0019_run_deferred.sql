@@ -0,0 +1,2 @@
+-- Targets a run passed over because their host's circuit breaker was open
+ALTER TABLE runs ADD COLUMN deferred INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,11 @@
+-- Host name lookups of -dns-prefilter: the addresses found, or why none were
+CREATE TABLE IF NOT EXISTS resolutions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    workspace TEXT NOT NULL DEFAULT 'default',
+    run_id INTEGER,
+    host TEXT NOT NULL,
+    addrs TEXT,
+    error TEXT,
+    resolved_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS resolutions_workspace_host ON resolutions(workspace, host);
@@ -0,0 +1,13 @@
+-- Failures of each run with their kind, so retry-failed can scan the targets again
+CREATE TABLE IF NOT EXISTS errors (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    workspace TEXT NOT NULL DEFAULT 'default',
+    run_id INTEGER,
+    target TEXT NOT NULL,
+    spec TEXT,
+    url TEXT NOT NULL,
+    category TEXT NOT NULL,
+    message TEXT NOT NULL,
+    failed_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS errors_workspace_run ON errors(workspace, run_id, category);
@@ -0,0 +1,20 @@
+-- Durations of each request of -timings, in milliseconds; phases a reused
+-- connection skipped are 0
+CREATE TABLE IF NOT EXISTS request_timings (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    workspace TEXT NOT NULL DEFAULT 'default',
+    run_id INTEGER,
+    target TEXT,
+    url TEXT NOT NULL,
+    method TEXT NOT NULL,
+    status INTEGER,
+    reused INTEGER NOT NULL,
+    dns_ms REAL NOT NULL,
+    connect_ms REAL NOT NULL,
+    tls_ms REAL NOT NULL,
+    ttfb_ms REAL NOT NULL,
+    total_ms REAL NOT NULL,
+    error TEXT,
+    at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS request_timings_workspace_run ON request_timings(workspace, run_id, target);
@@ -0,0 +1,9 @@
+-- Hashes every scan into the workspace alerts on, added with hunt add
+CREATE TABLE IF NOT EXISTS watchlist (
+    workspace TEXT NOT NULL DEFAULT 'default',
+    hash TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    label TEXT,
+    added_at TEXT NOT NULL,
+    PRIMARY KEY (workspace, hash)
+) WITHOUT ROWID;
@@ -0,0 +1,2 @@
+-- Feed or file a watched hash was imported from
+ALTER TABLE watchlist ADD COLUMN source TEXT;
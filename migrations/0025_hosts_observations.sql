@@ -0,0 +1,34 @@
+-- Normalized view of what was seen: each host once per workspace, each icon
+-- once as a blob, and one observation per host, link and icon. An icon served
+-- by many hosts is stored once and found from either side by index.
+-- Existing favicons and history are linked to their hosts after this runs.
+ALTER TABLE blobs RENAME TO favicon_blobs;
+
+CREATE TABLE IF NOT EXISTS hosts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    workspace TEXT NOT NULL DEFAULT 'default',
+    name TEXT NOT NULL,
+    apex TEXT,
+    first_seen TEXT,
+    last_seen TEXT,
+    UNIQUE (workspace, name)
+);
+CREATE INDEX IF NOT EXISTS hosts_apex ON hosts(workspace, apex);
+
+-- Host of the target the link was last found on
+ALTER TABLE favicons ADD COLUMN host_id INTEGER REFERENCES hosts(id);
+CREATE INDEX favicons_host ON favicons(host_id);
+
+CREATE TABLE IF NOT EXISTS observations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    workspace TEXT NOT NULL DEFAULT 'default',
+    host_id INTEGER NOT NULL REFERENCES hosts(id),
+    link TEXT NOT NULL,
+    sha256 TEXT NOT NULL REFERENCES favicon_blobs(sha256),
+    first_seen TEXT NOT NULL,
+    last_seen TEXT NOT NULL,
+    run_id INTEGER REFERENCES runs(id),
+    UNIQUE (host_id, link, sha256)
+);
+CREATE INDEX IF NOT EXISTS observations_sha256 ON observations(sha256, host_id);
+CREATE INDEX IF NOT EXISTS observations_workspace_link ON observations(workspace, link);
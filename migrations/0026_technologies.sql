@@ -0,0 +1,14 @@
+-- Technologies identified per host by -tech, from its favicons, response
+-- headers, cookies and HTML; the latest detection of each is kept
+CREATE TABLE IF NOT EXISTS technologies (
+    workspace TEXT NOT NULL DEFAULT 'default',
+    host TEXT NOT NULL,
+    technology TEXT NOT NULL,
+    confidence INTEGER NOT NULL,
+    signals TEXT,
+    target TEXT,
+    run_id INTEGER,
+    detected_at TEXT NOT NULL,
+    PRIMARY KEY (workspace, host, technology)
+) WITHOUT ROWID;
+CREATE INDEX IF NOT EXISTS technologies_technology ON technologies(workspace, technology);
@@ -0,0 +1,3 @@
+-- Screenshot of the page a favicon was found on, taken with -render -screenshot-dir
+ALTER TABLE favicons ADD COLUMN screenshot TEXT;
+ALTER TABLE favicons ADD COLUMN screenshot_sha256 TEXT;
@@ -0,0 +1,10 @@
+-- DNS names on the TLS certificate each host presented
+CREATE TABLE IF NOT EXISTS certificate_sans (
+    workspace TEXT NOT NULL DEFAULT 'default',
+    host TEXT NOT NULL,
+    name TEXT NOT NULL,
+    run_id INTEGER,
+    seen_at TEXT NOT NULL,
+    PRIMARY KEY (workspace, host, name)
+) WITHOUT ROWID;
+CREATE INDEX IF NOT EXISTS certificate_sans_name ON certificate_sans(workspace, name);
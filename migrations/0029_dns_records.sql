@@ -0,0 +1,16 @@
+-- Resolution chains of -dns-records: the CNAMEs from each target host and the
+-- addresses they end at. A record seen again keeps its first_seen, so a chain
+-- that changes leaves its earlier steps behind.
+CREATE TABLE IF NOT EXISTS dns_records (
+    workspace TEXT NOT NULL DEFAULT 'default',
+    host TEXT NOT NULL,
+    position INTEGER NOT NULL,
+    type TEXT NOT NULL,
+    name TEXT NOT NULL,
+    value TEXT NOT NULL,
+    run_id INTEGER,
+    first_seen TEXT NOT NULL,
+    last_seen TEXT NOT NULL,
+    PRIMARY KEY (workspace, host, type, name, value)
+) WITHOUT ROWID;
+CREATE INDEX IF NOT EXISTS dns_records_value ON dns_records(workspace, type, value);
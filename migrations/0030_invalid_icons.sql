@@ -0,0 +1,4 @@
+-- Favicon responses that are no icon, skipped with reason invalid_icon: what
+-- they were instead (code) and the status they came with
+ALTER TABLE skipped_icons ADD COLUMN code TEXT;
+ALTER TABLE skipped_icons ADD COLUMN status INTEGER;
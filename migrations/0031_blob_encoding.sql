@@ -0,0 +1,3 @@
+-- How favicon_blobs.data is stored: NULL for the icon bytes as fetched, zstd
+-- for compressed. size stays the size of the icon itself.
+ALTER TABLE favicon_blobs ADD COLUMN encoding TEXT;
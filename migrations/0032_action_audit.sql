@@ -0,0 +1,22 @@
+-- Destructive and administrative actions, from the command line and the API,
+-- with who took them. Append-only: the triggers refuse to rewrite the trail.
+CREATE TABLE IF NOT EXISTS action_audit (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    at TEXT NOT NULL,
+    workspace TEXT,
+    actor TEXT NOT NULL,
+    action TEXT NOT NULL,
+    subject TEXT,
+    detail TEXT
+);
+CREATE INDEX IF NOT EXISTS action_audit_actor ON action_audit(actor, at);
+CREATE INDEX IF NOT EXISTS action_audit_action ON action_audit(action, at);
+
+CREATE TRIGGER IF NOT EXISTS action_audit_no_update BEFORE UPDATE ON action_audit
+BEGIN
+    SELECT RAISE(ABORT, 'action_audit is append-only');
+END;
+CREATE TRIGGER IF NOT EXISTS action_audit_no_delete BEFORE DELETE ON action_audit
+BEGIN
+    SELECT RAISE(ABORT, 'action_audit is append-only');
+END;
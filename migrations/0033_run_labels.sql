@@ -0,0 +1,6 @@
+-- Key/value labels of a run (-label, or labels given with the job that
+-- submitted it), as a JSON object. A favicon keeps the labels of the last run
+-- that saw it; a job keeps the labels it was submitted with.
+ALTER TABLE runs ADD COLUMN labels TEXT;
+ALTER TABLE favicons ADD COLUMN run_labels TEXT;
+ALTER TABLE jobs ADD COLUMN labels TEXT;
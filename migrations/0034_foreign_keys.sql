@@ -0,0 +1,5 @@
+-- Foreign keys are enforced from now on; clear the references that were
+-- left dangling while they were not, so later writes to those rows succeed.
+UPDATE favicons SET host_id = NULL WHERE host_id IS NOT NULL AND host_id NOT IN (SELECT id FROM hosts);
+UPDATE observations SET run_id = NULL WHERE run_id IS NOT NULL AND run_id NOT IN (SELECT id FROM runs);
+DELETE FROM observations WHERE host_id NOT IN (SELECT id FROM hosts) OR sha256 NOT IN (SELECT sha256 FROM favicon_blobs);
@@ -0,0 +1,196 @@
+package main
+
+import (
+    "bytes"
+    "crypto/tls"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io"
+    "net/http"
+    "os"
+    "strings"
+    "time"
+)
+
+// MISP event wrapper as accepted by POST /events
+type mispEvent struct {
+    Event mispEventBody `json:"Event"`
+}
+
+type mispEventBody struct {
+    Info          string          `json:"info"`
+    Date          string          `json:"date"`
+    Distribution  string          `json:"distribution"`
+    ThreatLevelID string          `json:"threat_level_id"`
+    Analysis      string          `json:"analysis"`
+    Attribute     []mispAttribute `json:"Attribute"`
+}
+
+type mispAttribute struct {
+    Type     string    `json:"type"`
+    Category string    `json:"category"`
+    Value    string    `json:"value"`
+    Comment  string    `json:"comment,omitempty"`
+    ToIDS    bool      `json:"to_ids"`
+    Tag      []mispTag `json:"Tag,omitempty"`
+}
+
+type mispTag struct {
+    Name string `json:"name"`
+}
+
+// MISP tags for maplink tags
+func mispTags(tags []string) []mispTag {
+    var out []mispTag
+    for _, tag := range tags {
+        out = append(out, mispTag{Name: tag})
+    }
+    return out
+}
+
+// MISP machine tags for run labels given as key=value, e.g. maplink:engagement="acme"
+func mispLabelTags(pairs []string) []mispTag {
+    var out []mispTag
+    for _, pair := range pairs {
+        key, value, _ := strings.Cut(pair, "=")
+        out = append(out, mispTag{Name: fmt.Sprintf("maplink:%s=%q", key, value)})
+    }
+    return out
+}
+
+// Build a MISP event with hash attributes per favicon and the URLs serving it
+func buildMISPEvent(records []record, info string, toIDS bool, filter map[string]struct{}) mispEvent {
+    event := mispEvent{Event: mispEventBody{
+        Info:          info,
+        Date:          time.Now().UTC().Format("2006-01-02"),
+        Distribution:  "0",
+        ThreatLevelID: "4",
+        Analysis:      "0",
+    }}
+
+    order, groups := groupByHash(records)
+    for _, sha := range order {
+        group := groups[sha]
+        icon := group[0]
+        if len(filter) > 0 && !matchesAny(filter, icon.MD5, icon.SHA256, icon.MMH3) {
+            continue
+        }
+
+        comment := fmt.Sprintf("Favicon served by %d URL(s)", len(group))
+        hashTags := append(mispTags(icon.HashTags), mispLabelTags(recordRunLabels(group))...)
+        attrs := []mispAttribute{
+            {Type: "md5", Category: "Payload delivery", Value: icon.MD5, Comment: comment, ToIDS: toIDS, Tag: hashTags},
+            {Type: "sha256", Category: "Payload delivery", Value: icon.SHA256, Comment: comment, ToIDS: toIDS, Tag: hashTags},
+        }
+        if icon.MMH3 != "" {
+            attrs = append(attrs, mispAttribute{Type: "favicon-mmh3", Category: "Network activity", Value: icon.MMH3, Comment: comment, ToIDS: toIDS, Tag: hashTags})
+        }
+        for _, r := range group {
+            attrs = append(attrs, mispAttribute{Type: "url", Category: "Network activity", Value: r.Link, Comment: "Serves favicon md5 " + icon.MD5,
+                Tag: append(mispTags(r.HostTags), mispLabelTags(r.RunLabels.pairs())...)})
+        }
+        event.Event.Attribute = append(event.Event.Attribute, attrs...)
+    }
+    return event
+}
+
+// Report whether any of the hashes is in the set
+func matchesAny(set map[string]struct{}, hashes ...string) bool {
+    for _, h := range hashes {
+        if _, ok := set[h]; ok && h != "" {
+            return true
+        }
+    }
+    return false
+}
+
+// Create the event on a MISP instance
+func pushMISPEvent(baseURL, apiKey string, insecure bool, payload []byte) error {
+    req, err := http.NewRequest("POST", strings.TrimSuffix(baseURL, "/")+"/events", bytes.NewReader(payload))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Authorization", apiKey)
+    req.Header.Set("Accept", "application/json")
+    req.Header.Set("Content-Type", "application/json")
+
+    client := http.DefaultClient
+    if insecure {
+        client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+    }
+    resp, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+        return fmt.Errorf("error: status code %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+    }
+    return nil
+}
+
+// Export favicon hashes as a MISP event, to a file or straight to MISP
+func mispCommand(args []string) {
+    fs := flag.NewFlagSet("misp", flag.ExitOnError)
+    dbOpts := dbFlags(fs)
+    output := fs.String("o", "", "Write the event JSON to this file (default: stdout unless -push)")
+    info := fs.String("info", "MAPLINK favicon hashes", "Event info/title")
+    toIDS := fs.Bool("to-ids", false, "Mark hash attributes for IDS export")
+    hashes := fs.String("hash", "", "Comma-separated hashes to export (default: all)")
+    push := fs.Bool("push", false, "Create the event on the MISP instance")
+    mispURL := fs.String("misp-url", os.Getenv("MISP_URL"), "MISP base URL (or MISP_URL)")
+    mispKey := fs.String("misp-key", os.Getenv("MISP_KEY"), "MISP API key (or MISP_KEY)")
+    insecure := fs.Bool("insecure", false, "Skip TLS verification when pushing")
+    signKey := registerSignFlag(fs)
+    parseFlags(fs, args)
+
+    sg, err := outputSigner(*signKey, *output)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error loading signing key: %v\n", err)
+        return
+    }
+    db, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer db.Close()
+
+    records, err := loadRecords(db, dbOpts.workspace, false)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error reading database: %v\n", err)
+        return
+    }
+
+    event := buildMISPEvent(records, *info, *toIDS, parseHashList(*hashes))
+    payload, err := json.MarshalIndent(event, "", "  ")
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error encoding event: %v\n", err)
+        return
+    }
+
+    if *output != "" {
+        if err := os.WriteFile(*output, payload, 0644); err != nil {
+            fmt.Fprintf(os.Stderr, "Error writing event: %v\n", err)
+            return
+        }
+        sg.signOutput(*output)
+    } else if !*push {
+        fmt.Println(string(payload))
+    }
+
+    if *push {
+        if *mispURL == "" || *mispKey == "" {
+            fmt.Fprintln(os.Stderr, "Please provide -misp-url and -misp-key to push the event.")
+            return
+        }
+        if err := pushMISPEvent(*mispURL, *mispKey, *insecure, payload); err != nil {
+            fmt.Fprintf(os.Stderr, "Error pushing event to MISP: %v\n", err)
+            return
+        }
+        fmt.Printf("Pushed %d attributes to %s\n", len(event.Event.Attribute), *mispURL)
+    }
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/url"
+    "strings"
+    "text/template"
+    "time"
+)
+
+// Notification event kinds
+const (
+    eventChanged = "changed"
+    eventHunted  = "hunted"
+)
+
+// Default message templates per event kind
+var defaultTemplates = map[string]string{
+    eventChanged: "[MAPLINK] Favicon changed on {{.Link}}\nMD5: {{.OldMD5}} -> {{.MD5}}\nSHA256: {{.OldSHA256}} -> {{.SHA256}}",
+    eventHunted:  "[MAPLINK] Hunted hash {{.Match}} seen on new host {{.Link}}\nMD5: {{.MD5}}\nSHA256: {{.SHA256}}\nMMH3: {{.MMH3}}",
+}
+
+// Data passed to message templates
+type notification struct {
+    Event     string
+    Link      string
+    MD5       string
+    SHA256    string
+    MMH3      string
+    OldMD5    string
+    OldSHA256 string
+    Match     string
+    Time      time.Time
+}
+
+// A channel that can deliver a rendered message
+type notifier interface {
+    Notify(message string) error
+}
+
+// Slack incoming webhook
+type slackNotifier struct {
+    webhook string
+}
+
+func (n slackNotifier) Notify(message string) error {
+    return postJSON(n.webhook, map[string]string{"text": message})
+}
+
+// Discord webhook
+type discordNotifier struct {
+    webhook string
+}
+
+func (n discordNotifier) Notify(message string) error {
+    return postJSON(n.webhook, map[string]string{"content": message})
+}
+
+// Telegram bot sendMessage
+type telegramNotifier struct {
+    token  string
+    chatID string
+}
+
+func (n telegramNotifier) Notify(message string) error {
+    endpoint := "https://api.telegram.org/bot" + n.token + "/sendMessage"
+    return postJSON(endpoint, map[string]string{"chat_id": n.chatID, "text": message})
+}
+
+// Client of the notifiers: a channel that hangs must not stall the alerts
+// queued behind it for long
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+// POST a JSON payload and check the response status. Errors leave out the
+// endpoint, whose path holds the webhook secret or bot token.
+func postJSON(endpoint string, payload interface{}) error {
+    body, err := json.Marshal(payload)
+    if err != nil {
+        return err
+    }
+
+    resp, err := notifyClient.Post(endpoint, "application/json", bytes.NewReader(body))
+    var urlErr *url.Error
+    if errors.As(err, &urlErr) {
+        return urlErr.Err
+    }
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return fmt.Errorf("error: status code %d", resp.StatusCode)
+    }
+    return nil
+}
+
+// Dispatches events to every configured channel. A slow webhook only holds
+// up the alerts queued behind it; the scan carries on.
+type dispatcher struct {
+    notifiers []notifier
+    templates map[string]*template.Template
+    queue     *backgroundQueue[notification]
+}
+
+// Build a dispatcher; a custom template replaces the defaults for all events
+func newDispatcher(notifiers []notifier, customTemplate string) (*dispatcher, error) {
+    d := &dispatcher{notifiers: notifiers, templates: map[string]*template.Template{}}
+    for event, text := range defaultTemplates {
+        if customTemplate != "" {
+            text = customTemplate
+        }
+        tmpl, err := template.New(event).Parse(text)
+        if err != nil {
+            return nil, fmt.Errorf("parsing notification template: %v", err)
+        }
+        d.templates[event] = tmpl
+    }
+    d.queue = newBackgroundQueue("alert", func(n notification) {
+        if err := d.deliver(n); err != nil {
+            errorf("Error sending alert for %s: %v\n", n.Link, err)
+        }
+    })
+    return d, nil
+}
+
+// Queue an event for every channel. Called with the scan's lock held, so
+// it never waits for room.
+func (d *dispatcher) send(n notification) {
+    if d == nil || len(d.notifiers) == 0 {
+        return
+    }
+    if n.Time.IsZero() {
+        n.Time = time.Now()
+    }
+    d.queue.push(n)
+}
+
+// Wait for queued events to be sent
+func (d *dispatcher) close() {
+    if d == nil {
+        return
+    }
+    d.queue.close()
+}
+
+// Render the event and send it to every channel
+func (d *dispatcher) deliver(n notification) error {
+    var buf bytes.Buffer
+    if err := d.templates[n.Event].Execute(&buf, n); err != nil {
+        return err
+    }
+
+    var errs []string
+    for _, ch := range d.notifiers {
+        if err := ch.Notify(buf.String()); err != nil {
+            errs = append(errs, err.Error())
+        }
+    }
+    if len(errs) > 0 {
+        return fmt.Errorf("notification failed: %s", strings.Join(errs, "; "))
+    }
+    return nil
+}
+
+// Parse a comma-separated list of hashes into a lowercase set
+func parseHashList(list string) map[string]struct{} {
+    hunted := map[string]struct{}{}
+    for _, h := range splitList(list) {
+        hunted[strings.ToLower(h)] = struct{}{}
+    }
+    return hunted
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "net/url"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    texttemplate "text/template"
+)
+
+// Values rendered into one nuclei template
+type nucleiTemplate struct {
+    ID      string
+    MD5     string
+    MMH3    string
+    Hosts   int
+    Apexes  int
+    Paths   []string
+    Example string
+    Labels  string // run labels of the icon's records, quoted for YAML; empty for none
+}
+
+var nucleiYAML = texttemplate.Must(texttemplate.New("nuclei").Parse(`id: {{.ID}}
+
+info:
+  name: MAPLINK favicon {{.MD5}}
+  author: maplink
+  severity: info
+  description: Favicon shared by {{.Hosts}} host(s) across {{.Apexes}} apex domain(s), e.g. {{.Example}}
+  tags: favicon,maplink
+  metadata:
+    md5: {{.MD5}}
+    mmh3: "{{.MMH3}}"
+    shodan-query: http.favicon.hash:{{.MMH3}}
+{{- if .Labels}}
+    run-labels: {{.Labels}}
+{{- end}}
+
+http:
+  - method: GET
+    path:
+{{range .Paths}}      - "{{"{{"}}BaseURL{{"}}"}}{{.}}"
+{{end}}    redirects: true
+    max-redirects: 2
+    stop-at-first-match: true
+    matchers-condition: or
+    matchers:
+      - type: dsl
+        dsl:
+          - 'status_code == 200 && "{{.MMH3}}" == mmh3(base64_py(body))'
+      - type: dsl
+        dsl:
+          - 'status_code == 200 && md5(body) == "{{.MD5}}"'
+`))
+
+// Template ID safe for nuclei (lowercase letters, digits and dashes)
+func nucleiID(mmh3Hash, md5Hash string) string {
+    if mmh3Hash == "" {
+        return "maplink-favicon-" + md5Hash
+    }
+    return "maplink-favicon-" + strings.Replace(mmh3Hash, "-", "n", 1)
+}
+
+// Build templates for every icon in the selected clusters
+func buildNucleiTemplates(clusters []cluster) []nucleiTemplate {
+    var templates []nucleiTemplate
+    for _, c := range clusters {
+        order, groups := groupByHash(c.Records)
+        for _, sha := range order {
+            group := groups[sha]
+            paths := map[string]struct{}{}
+            for _, r := range group {
+                if u, err := url.Parse(r.Link); err == nil && u.Path != "" {
+                    paths[u.RequestURI()] = struct{}{}
+                }
+            }
+            t := nucleiTemplate{
+                ID:      nucleiID(group[0].MMH3, group[0].MD5),
+                MD5:     group[0].MD5,
+                MMH3:    group[0].MMH3,
+                Hosts:   len(c.Records),
+                Apexes:  len(c.Apexes),
+                Example: group[0].host(),
+            }
+            for p := range paths {
+                t.Paths = append(t.Paths, p)
+            }
+            if len(t.Paths) == 0 {
+                t.Paths = []string{"/favicon.ico"}
+            }
+            if labels := recordRunLabels(group); len(labels) > 0 {
+                t.Labels = strconv.Quote(strings.Join(labels, ","))
+            }
+            sort.Strings(t.Paths)
+            templates = append(templates, t)
+        }
+    }
+    return templates
+}
+
+// Write nuclei templates for interesting clusters
+func nucleiCommand(args []string) {
+    fs := flag.NewFlagSet("nuclei", flag.ExitOnError)
+    dbOpts := dbFlags(fs)
+    outDir := fs.String("o", "nuclei-templates", "Directory to write templates to")
+    minSize := fs.Int("min-size", 2, "Only clusters with at least this many hosts")
+    multiApex := fs.Bool("multi-apex", false, "Only clusters spanning more than one apex domain")
+    perceptual := fs.Bool("perceptual", false, "Cluster visually similar icons together")
+    threshold := fs.Int("threshold", 6, "Maximum perceptual hash distance (bits) for -perceptual")
+    parseFlags(fs, args)
+
+    db, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer db.Close()
+
+    records, err := loadRecords(db, dbOpts.workspace, *perceptual)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error reading database: %v\n", err)
+        return
+    }
+
+    var selected []cluster
+    for _, c := range buildClusters(records, *perceptual, *threshold) {
+        if len(c.Records) >= *minSize && (!*multiApex || len(c.Apexes) > 1) {
+            selected = append(selected, c)
+        }
+    }
+
+    if err := os.MkdirAll(*outDir, 0755); err != nil {
+        fmt.Fprintf(os.Stderr, "Error creating output directory: %v\n", err)
+        return
+    }
+
+    templates := buildNucleiTemplates(selected)
+    for _, t := range templates {
+        path := filepath.Join(*outDir, t.ID+".yaml")
+        file, err := os.Create(path)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error creating template: %v\n", err)
+            continue
+        }
+        err = nucleiYAML.Execute(file, t)
+        file.Close()
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error writing template %s: %v\n", path, err)
+        }
+    }
+    fmt.Printf("Wrote %d templates to %s\n", len(templates), *outDir)
+}
@@ -0,0 +1,501 @@
+package main
+
+//go:generate go run . openapi -go-client apiclient/client_gen.go
+
+import (
+    "bytes"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "go/format"
+    "net/http"
+    "os"
+    "reflect"
+    "sort"
+    "strings"
+    "time"
+    "unicode"
+)
+
+// An API endpoint: how it is served, and what the OpenAPI document and the
+// generated client say about it
+type apiRoute struct {
+    method   string
+    path     string
+    role     string
+    op       string // operationId, and the client method name
+    summary  string
+    params   []apiParam
+    body     interface{} // request body, nil for none
+    response interface{} // success response, nil for none
+    status   int
+    stream   bool // answers with server-sent events
+    handler  http.HandlerFunc
+}
+
+// A query parameter of an endpoint
+type apiParam struct {
+    name     string
+    integer  bool
+    required bool
+    doc      string
+}
+
+// Body of POST /jobs. Targets may also be bare URL strings.
+type apiJobRequest struct {
+    Targets []target  `json:"targets"`
+    Labels  runLabels `json:"labels,omitempty"` // for the run, over the server's -label
+}
+
+// Answer to POST /jobs
+type apiJobAccepted struct {
+    Job    int64 `json:"job"`
+    Queued int   `json:"queued"`
+}
+
+// Body of POST /webhooks/hosts as documented. Any JSON is accepted: host
+// names are taken from host, hostname, domain, fqdn, url and similar fields
+// at any depth, and text/plain bodies hold one per line.
+type apiWebhookRequest struct {
+    Hostnames []string `json:"hostnames"`
+}
+
+// Body of every error response
+type apiError struct {
+    Error string `json:"error"`
+}
+
+// The cursor, limit and sort parameters of a list endpoint
+func pageParams(sorts string) []apiParam {
+    params := []apiParam{
+        {name: "cursor", doc: "next_cursor of the previous page"},
+        {name: "limit", integer: true, doc: fmt.Sprintf("Items per page (default %d, at most %d)", defaultPageSize, maxPageSize)},
+    }
+    if sorts != "" {
+        params = append(params, apiParam{name: "sort", doc: "Sort field, - prefixed for descending: " + sorts})
+    }
+    return params
+}
+
+// since and until parameters filtering on a timestamp
+func timeParams(what string) []apiParam {
+    return []apiParam{
+        {name: "since", doc: what + " at or after this time (RFC 3339 or YYYY-MM-DD)"},
+        {name: "until", doc: what + " before this time (RFC 3339 or YYYY-MM-DD)"},
+    }
+}
+
+// Every endpoint of the API
+func (sv *server) routes() []apiRoute {
+    return []apiRoute{
+        {method: "GET", path: "/favicons", role: roleReadOnly, op: "ListFavicons", summary: "Search stored favicons",
+            params: append(append([]apiParam{
+                {name: "search", doc: "Text in the link, target, title, server or labels"},
+                {name: "hash", doc: "MD5, SHA256 or MMH3 of the icon"},
+                {name: "domain", doc: "Apex domain, or final host and its subdomains"},
+                {name: "tag", doc: "Tag on the favicon's host or hashes"},
+                {name: "run", integer: true, doc: "Only favicons new or changed in this run"},
+            }, timeParams("Last seen")...), pageParams("last_seen, first_seen, link")...),
+            response: apiPage{Items: []apiFavicon{}}, status: http.StatusOK, handler: sv.listFavicons},
+        {method: "DELETE", path: "/favicons", role: roleAdmin, op: "DeleteFavicon", summary: "Delete a stored favicon and its history",
+            params: []apiParam{{name: "link", required: true, doc: "Favicon URL"}},
+            status: http.StatusNoContent, handler: sv.deleteFavicon},
+        {method: "GET", path: "/runs", role: roleReadOnly, op: "ListRuns", summary: "List scan runs",
+            params: append(append([]apiParam{{name: "status", doc: "running, completed, interrupted or timed_out"}},
+                timeParams("Started")...), pageParams("id, started_at")...),
+            response: apiPage{Items: []apiRun{}}, status: http.StatusOK, handler: sv.listRuns},
+        {method: "POST", path: "/jobs", role: roleScanner, op: "SubmitJob", summary: "Queue targets to scan as one job",
+            body: apiJobRequest{}, response: apiJobAccepted{}, status: http.StatusAccepted, handler: sv.submitJob},
+        {method: "POST", path: "/webhooks/hosts", role: roleScanner, op: "HostsWebhook", summary: "Queue hosts reported by a discovery system for scanning",
+            body: apiWebhookRequest{}, response: apiJobAccepted{}, status: http.StatusAccepted, handler: sv.hostsWebhook},
+        {method: "GET", path: "/hash", role: roleScanner, op: "HashURL", summary: "Fetch one target's favicons now and return their hashes",
+            params: []apiParam{{name: "url", required: true, doc: "URL or host name of the target"}},
+            response: apiHashResult{}, status: http.StatusOK, handler: sv.hashURL},
+        {method: "GET", path: "/jobs", role: roleReadOnly, op: "ListJobs", summary: "List jobs",
+            params: append(append([]apiParam{
+                {name: "status", doc: "queued, running, failed, or the status of the job's run"},
+                {name: "key", doc: "Name of the API key that submitted the job"},
+            }, timeParams("Submitted")...), pageParams("id")...),
+            response: apiPage{Items: []apiJob{}}, status: http.StatusOK, handler: sv.listJobs},
+        {method: "GET", path: "/jobs/{id}", role: roleReadOnly, op: "GetJob", summary: "Get a job and its progress",
+            response: apiJob{}, status: http.StatusOK, handler: sv.getJob},
+        {method: "GET", path: "/jobs/{id}/results", role: roleReadOnly, op: "ListJobResults", summary: "Page through the results of a job",
+            params: pageParams(""), response: apiPage{Items: []result{}}, status: http.StatusOK, handler: sv.listJobResults},
+        {method: "GET", path: "/jobs/{id}/events", role: roleReadOnly, op: "JobEvents", summary: "Stream a job's state, progress and results until it finishes",
+            status: http.StatusOK, stream: true, handler: sv.jobEvents},
+        {method: "GET", path: "/events", role: roleReadOnly, op: "Events", summary: "Stream live events of every job",
+            status: http.StatusOK, stream: true, handler: sv.allEvents},
+        {method: "GET", path: "/audit", role: roleAdmin, op: "ListAudit", summary: "Read the audit trail",
+            params: append(append([]apiParam{
+                {name: "key", doc: "Name of the API key"},
+                {name: "status", integer: true, doc: "HTTP status of the response"},
+            }, timeParams("Requested")...), pageParams("id")...),
+            response: apiPage{Items: []auditRow{}}, status: http.StatusOK, handler: sv.listAudit},
+        {method: "GET", path: "/audit/actions", role: roleAdmin, op: "ListActions", summary: "Read the trail of destructive and administrative actions",
+            params: append(append([]apiParam{
+                {name: "actor", doc: "key:<name> for API keys, cli:<login>@<host> or daemon:<login>@<host> for the command line"},
+                {name: "action", doc: "e.g. favicon.delete, workspace.delete, watchlist.remove, apikey.revoke"},
+                {name: "workspace", doc: "Workspace the action was taken in"},
+            }, timeParams("Taken")...), pageParams("id")...),
+            response: apiPage{Items: []auditAction{}}, status: http.StatusOK, handler: sv.listActions},
+    }
+}
+
+// Name of the schema of a struct; pages are named after their items
+func schemaName(v reflect.Value) string {
+    t := v.Type()
+    if t == reflect.TypeOf(apiPage{}) {
+        return schemaName(reflect.New(reflect.TypeOf(v.Interface().(apiPage).Items).Elem()).Elem()) + "Page"
+    }
+    if t == reflect.TypeOf(auditRow{}) {
+        return "AuditEntry"
+    }
+    name := strings.TrimPrefix(t.Name(), "api")
+    runes := []rune(name)
+    runes[0] = unicode.ToUpper(runes[0])
+    return string(runes)
+}
+
+// JSON name of a struct field and whether it may be left out; "" for fields JSON skips
+func jsonField(f reflect.StructField) (string, bool) {
+    if !f.IsExported() {
+        return "", false
+    }
+    name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+    if name == "-" {
+        return "", false
+    }
+    if name == "" {
+        name = f.Name
+    }
+    return name, strings.Contains(opts, "omitempty")
+}
+
+// Builder of OpenAPI schemas, collecting the named ones as components
+type schemaSet map[string]interface{}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Schema of a value, registering the structs it holds. Interface fields are
+// described by the value they hold, which is how pages get their item type.
+func (set schemaSet) schema(v reflect.Value) map[string]interface{} {
+    t := v.Type()
+    switch {
+    case t == timeType:
+        return map[string]interface{}{"type": "string", "format": "date-time"}
+    case t.Kind() == reflect.Interface:
+        if v.IsNil() {
+            return map[string]interface{}{}
+        }
+        return set.schema(v.Elem())
+    case t.Kind() == reflect.String:
+        return map[string]interface{}{"type": "string"}
+    case t.Kind() == reflect.Bool:
+        return map[string]interface{}{"type": "boolean"}
+    case t.Kind() == reflect.Int64:
+        return map[string]interface{}{"type": "integer", "format": "int64"}
+    case t.Kind() == reflect.Int:
+        return map[string]interface{}{"type": "integer"}
+    case t.Kind() == reflect.Float64:
+        return map[string]interface{}{"type": "number"}
+    case t.Kind() == reflect.Slice:
+        return map[string]interface{}{"type": "array", "items": set.schema(reflect.New(t.Elem()).Elem())}
+    case t.Kind() == reflect.Map:
+        return map[string]interface{}{"type": "object", "additionalProperties": set.schema(reflect.New(t.Elem()).Elem())}
+    case t.Kind() == reflect.Struct:
+        name := schemaName(v)
+        if _, ok := set[name]; !ok {
+            set[name] = nil // placeholder against recursion
+            props := map[string]interface{}{}
+            var required []string
+            for i := 0; i < t.NumField(); i++ {
+                field, optional := jsonField(t.Field(i))
+                if field == "" {
+                    continue
+                }
+                props[field] = set.schema(v.Field(i))
+                if !optional {
+                    required = append(required, field)
+                }
+            }
+            s := map[string]interface{}{"type": "object", "properties": props}
+            if len(required) > 0 {
+                s["required"] = required
+            }
+            set[name] = s
+        }
+        return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+    }
+    return map[string]interface{}{}
+}
+
+// Parameters of a route: the {name} segments of its path, then its query parameters
+func (rt apiRoute) allParams() []apiParam {
+    var params []apiParam
+    for _, seg := range strings.Split(rt.path, "/") {
+        if strings.HasPrefix(seg, "{") {
+            params = append(params, apiParam{name: strings.Trim(seg, "{}"), integer: true, required: true})
+        }
+    }
+    return append(params, rt.params...)
+}
+
+// OpenAPI 3 document of the API
+func openAPIDocument() map[string]interface{} {
+    set := schemaSet{}
+    errorRef := set.schema(reflect.ValueOf(apiError{}))
+    paths := map[string]map[string]interface{}{}
+    for _, rt := range (&server{}).routes() {
+        var params []interface{}
+        for _, p := range rt.allParams() {
+            typ := "string"
+            if p.integer {
+                typ = "integer"
+            }
+            in := "query"
+            if strings.Contains(rt.path, "{"+p.name+"}") {
+                in = "path"
+            }
+            param := map[string]interface{}{"name": p.name, "in": in, "schema": map[string]interface{}{"type": typ}}
+            if p.required {
+                param["required"] = true
+            }
+            if p.doc != "" {
+                param["description"] = p.doc
+            }
+            params = append(params, param)
+        }
+        success := map[string]interface{}{"description": http.StatusText(rt.status)}
+        description := fmt.Sprintf("Requires a key with the %s role or above.", rt.role)
+        if rt.response != nil {
+            success["content"] = map[string]interface{}{"application/json": map[string]interface{}{"schema": set.schema(reflect.ValueOf(rt.response))}}
+        }
+        if rt.stream {
+            success["content"] = map[string]interface{}{"text/event-stream": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}}
+            for _, v := range streamEventTypes() {
+                set.schema(reflect.ValueOf(v))
+            }
+            description += " Server-sent events: job (a Job), progress (a JobProgress) and result (a StreamResult, its id the result's)." +
+                " Send Last-Event-ID when reconnecting to replay the results missed."
+        }
+        op := map[string]interface{}{
+            "operationId": rt.op,
+            "summary":     rt.summary,
+            "description": description,
+            "responses": map[string]interface{}{
+                fmt.Sprint(rt.status): success,
+                "default": map[string]interface{}{"description": "Error", "content": map[string]interface{}{"application/json": map[string]interface{}{"schema": errorRef}}},
+            },
+        }
+        if len(params) > 0 {
+            op["parameters"] = params
+        }
+        if rt.body != nil {
+            op["requestBody"] = map[string]interface{}{"required": true, "content": map[string]interface{}{"application/json": map[string]interface{}{"schema": set.schema(reflect.ValueOf(rt.body))}}}
+        }
+        if paths[rt.path] == nil {
+            paths[rt.path] = map[string]interface{}{}
+        }
+        paths[rt.path][strings.ToLower(rt.method)] = op
+    }
+    return map[string]interface{}{
+        "openapi": "3.0.3",
+        "info":    map[string]interface{}{"title": "MAPLINK API", "version": "1"},
+        "paths":   paths,
+        "components": map[string]interface{}{
+            "schemas": set,
+            "securitySchemes": map[string]interface{}{
+                "bearer": map[string]interface{}{"type": "http", "scheme": "bearer"},
+                "apiKey": map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+            },
+        },
+        "security": []interface{}{map[string]interface{}{"bearer": []string{}}, map[string]interface{}{"apiKey": []string{}}},
+    }
+}
+
+// GET /openapi.json, the one endpoint needing no key
+func serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+    writeJSON(w, http.StatusOK, openAPIDocument())
+}
+
+// Print the OpenAPI document, or write the Go client generated from the routes
+func openAPICommand(args []string) {
+    fs := flag.NewFlagSet("openapi", flag.ExitOnError)
+    goClient := fs.String("go-client", "", "Write the generated Go client to this file instead of printing the document")
+    pkg := fs.String("package", "apiclient", "Package name of the generated Go client")
+    parseFlags(fs, args)
+
+    if *goClient == "" {
+        enc := json.NewEncoder(os.Stdout)
+        enc.SetIndent("", "  ")
+        enc.Encode(openAPIDocument())
+        return
+    }
+    src, err := generateGoClient(*pkg)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error generating client: %v\n", err)
+        return
+    }
+    if err := os.WriteFile(*goClient, src, 0644); err != nil {
+        fmt.Fprintf(os.Stderr, "Error writing client: %v\n", err)
+    }
+}
+
+// Go client generator: the types the routes use, mirrored with exported
+// names, and a method per route
+type clientGen struct {
+    buf   bytes.Buffer
+    types map[string]string // schema name to its declaration
+}
+
+// Go type of a value in the generated client
+func (g *clientGen) goType(v reflect.Value) string {
+    t := v.Type()
+    switch {
+    case t == timeType:
+        return "time.Time"
+    case t.Kind() == reflect.Interface:
+        if v.IsNil() {
+            return "json.RawMessage"
+        }
+        return g.goType(v.Elem())
+    case t.Kind() == reflect.Slice:
+        return "[]" + g.goType(reflect.New(t.Elem()).Elem())
+    case t.Kind() == reflect.Map:
+        if t.Elem().Kind() == reflect.Interface {
+            return "map[string]interface{}"
+        }
+        return "map[string]" + g.goType(reflect.New(t.Elem()).Elem())
+    case t.Kind() == reflect.Struct:
+        name := schemaName(v)
+        if _, ok := g.types[name]; ok {
+            return name
+        }
+        g.types[name] = ""
+        var b strings.Builder
+        fmt.Fprintf(&b, "type %s struct {\n", name)
+        for i := 0; i < t.NumField(); i++ {
+            field, optional := jsonField(t.Field(i))
+            if field == "" {
+                continue
+            }
+            tag := field
+            if optional {
+                tag += ",omitempty"
+            }
+            fmt.Fprintf(&b, "\t%s %s `json:%q`\n", t.Field(i).Name, g.goType(v.Field(i)), tag)
+        }
+        b.WriteString("}\n")
+        g.types[name] = b.String()
+        return name
+    }
+    return t.Kind().String()
+}
+
+// Go identifier of a parameter name
+func exportedName(name string) string {
+    var b strings.Builder
+    for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '_' || r == '-' }) {
+        if part == "id" {
+            b.WriteString("ID")
+            continue
+        }
+        b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+    }
+    return b.String()
+}
+
+// Source of the Go client package
+func generateGoClient(pkg string) ([]byte, error) {
+    g := &clientGen{types: map[string]string{}}
+    var methods bytes.Buffer
+    for _, rt := range (&server{}).routes() {
+        g.method(&methods, rt)
+    }
+
+    var body bytes.Buffer
+    var names []string
+    for name := range g.types {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        body.WriteString(g.types[name] + "\n")
+    }
+    body.Write(methods.Bytes())
+
+    w := &g.buf
+    fmt.Fprintf(w, "// Code generated by maplink openapi; DO NOT EDIT.\n\n")
+    fmt.Fprintf(w, "package %s\n\nimport (\n", pkg)
+    for _, imp := range []string{"context", "encoding/json", "net/url", "strconv", "time"} {
+        if bytes.Contains(body.Bytes(), []byte(imp[strings.LastIndex(imp, "/")+1:]+".")) {
+            fmt.Fprintf(w, "\t%q\n", imp)
+        }
+    }
+    w.WriteString(")\n\n")
+    w.Write(body.Bytes())
+    return format.Source(w.Bytes())
+}
+
+// Parameter struct, if the route has query parameters, and method of a route
+func (g *clientGen) method(w *bytes.Buffer, rt apiRoute) {
+    var pathArgs []string
+    expr := `"` + rt.path + `"`
+    for _, p := range rt.allParams() {
+        if strings.Contains(rt.path, "{"+p.name+"}") {
+            pathArgs = append(pathArgs, p.name+" int64")
+            expr = strings.Replace(expr, "{"+p.name+"}", `" + strconv.FormatInt(`+p.name+`, 10) + "`, 1)
+        }
+    }
+    expr = strings.ReplaceAll(expr, ` + ""`, "")
+
+    args := append([]string{"ctx context.Context"}, pathArgs...)
+    query := "nil"
+    if len(rt.params) > 0 {
+        paramsType := rt.op + "Params"
+        fmt.Fprintf(w, "// %sParams are the query parameters of %s.\ntype %s struct {\n", rt.op, rt.op, paramsType)
+        for _, p := range rt.params {
+            typ := "string"
+            if p.integer {
+                typ = "int64"
+            }
+            fmt.Fprintf(w, "\t// %s\n\t%s %s\n", p.doc, exportedName(p.name), typ)
+        }
+        fmt.Fprintf(w, "}\n\nfunc (p *%s) values() url.Values {\n\tq := url.Values{}\n\tif p == nil {\n\t\treturn q\n\t}\n", paramsType)
+        for _, p := range rt.params {
+            field := "p." + exportedName(p.name)
+            if p.integer {
+                fmt.Fprintf(w, "\tif %s != 0 {\n\t\tq.Set(%q, strconv.FormatInt(%s, 10))\n\t}\n", field, p.name, field)
+            } else {
+                fmt.Fprintf(w, "\tif %s != \"\" {\n\t\tq.Set(%q, %s)\n\t}\n", field, p.name, field)
+            }
+        }
+        fmt.Fprintf(w, "\treturn q\n}\n\n")
+        args = append(args, "p *"+paramsType)
+        query = "p.values()"
+    }
+    body := "nil"
+    if rt.body != nil {
+        args = append(args, "body "+g.goType(reflect.ValueOf(rt.body)))
+        body = "body"
+    }
+
+    fmt.Fprintf(w, "// %s: %s (%s %s).\n", rt.op, rt.summary, rt.method, rt.path)
+    if rt.stream {
+        for _, v := range streamEventTypes() {
+            g.goType(reflect.ValueOf(v))
+        }
+        fmt.Fprintf(w, "// handle is called with each event until it returns an error or the stream ends.\n")
+        fmt.Fprintf(w, "func (c *Client) %s(%s) error {\n", rt.op, strings.Join(append(args, "handle func(Event) error"), ", "))
+        fmt.Fprintf(w, "\treturn c.stream(ctx, %s, %s, handle)\n}\n\n", expr, query)
+        return
+    }
+    if rt.response == nil {
+        fmt.Fprintf(w, "func (c *Client) %s(%s) error {\n", rt.op, strings.Join(args, ", "))
+        fmt.Fprintf(w, "\treturn c.do(ctx, %q, %s, %s, %s, nil)\n}\n\n", rt.method, expr, query, body)
+        return
+    }
+    out := g.goType(reflect.ValueOf(rt.response))
+    fmt.Fprintf(w, "func (c *Client) %s(%s) (*%s, error) {\n", rt.op, strings.Join(args, ", "), out)
+    fmt.Fprintf(w, "\tvar out %s\n\tif err := c.do(ctx, %q, %s, %s, %s, &out); err != nil {\n\t\treturn nil, err\n\t}\n\treturn &out, nil\n}\n\n", out, rt.method, expr, query, body)
+}
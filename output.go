@@ -0,0 +1,117 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "os"
+    "strings"
+    "text/template"
+)
+
+// Destination of informational messages. Result lines always go to stdout;
+// with -format these move to stderr so stdout carries only results, and
+// -silent drops them.
+var info io.Writer = os.Stdout
+
+// Log file set by -log-file; informational and error messages are copied to it
+var logOut io.Writer
+
+// Print an informational message
+func infof(format string, args ...interface{}) {
+    msg := fmt.Sprintf(format, args...)
+    fmt.Fprint(info, msg)
+    writeLog("INFO", strings.TrimSuffix(msg, "\n"))
+}
+
+// ANSI colors for human output
+const (
+    colorReset   = "\x1b[0m"
+    colorRed     = "\x1b[31m"
+    colorGreen   = "\x1b[32m"
+    colorYellow  = "\x1b[33m"
+    colorMagenta = "\x1b[1;35m"
+)
+
+// Whether stdout and stderr get colors; off when they are not terminals or NO_COLOR is set
+var (
+    colorStdout = isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == ""
+    colorStderr = isTerminal(os.Stderr) && os.Getenv("NO_COLOR") == ""
+)
+
+// Wrap text in a color when enabled
+func paint(enabled bool, color, text string) string {
+    if !enabled {
+        return text
+    }
+    return color + text + colorReset
+}
+
+// Print an error message to stderr, in red on a terminal
+func errorf(format string, args ...interface{}) {
+    msg := strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")
+    fmt.Fprint(os.Stderr, paint(colorStderr, colorRed, msg)+"\n")
+    writeLog("ERROR", msg)
+}
+
+// Color of a human-readable result line: hunted matches stand out most,
+// then changes, then new favicons
+func resultColor(res result) string {
+    switch {
+    case res.Match != "":
+        return colorMagenta
+    case res.Status == "changed":
+        return colorYellow
+    case res.Status == "new":
+        return colorGreen
+    }
+    return ""
+}
+
+// Parse a -format template for result lines, accepting \t and \n escapes
+// and ending each line with a newline
+func parseLineTemplate(text string) (*template.Template, error) {
+    text = strings.NewReplacer(`\t`, "\t", `\n`, "\n").Replace(text)
+    if !strings.HasSuffix(text, "\n") {
+        text += "\n"
+    }
+    return template.New("format").Parse(text)
+}
+
+// Print the stdout line for a result: the -format template, a JSON object
+// in silent mode, or the human-readable line
+func (s *scanner) printResult(res result) {
+    if s.lineFormat == nil && s.silent {
+        line, err := json.Marshal(res)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error formatting result for %s: %v\n", res.URL, err)
+            return
+        }
+        os.Stdout.Write(append(line, '\n'))
+        return
+    }
+    if s.lineFormat == nil {
+        note := ""
+        if res.NotModified {
+            note = " (not modified)"
+        }
+        if res.Status != "unchanged" {
+            note += " [" + strings.ToUpper(res.Status) + "]"
+        }
+        if res.Match != "" {
+            note += " [HUNTED " + res.Match + "]"
+        }
+        if res.Default != "" {
+            note += " [DEFAULT " + res.Default + "]"
+        }
+        line := fmt.Sprintf("Favicon: %s | MD5: %s | SHA256: %s | MMH3: %s%s", res.URL, res.MD5, res.SHA256, res.MMH3, note)
+        if color := resultColor(res); color != "" {
+            line = paint(colorStdout, color, line)
+        }
+        fmt.Println(line)
+        return
+    }
+    if err := s.lineFormat.Execute(os.Stdout, res); err != nil {
+        fmt.Fprintf(os.Stderr, "Error formatting result for %s: %v\n", res.URL, err)
+    }
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Page size of list endpoints unless ?limit= says otherwise, and its cap
+const (
+    defaultPageSize = 100
+    maxPageSize     = 1000
+)
+
+// One page of a list endpoint; next_cursor is empty on the last page
+type apiPage struct {
+    Items      interface{} `json:"items"`
+    NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// Position after the last item of a page: the sort it was made with, that
+// item's sort value and its unique key
+type pageCursor struct {
+    Sort  string `json:"s"`
+    Value string `json:"v"`
+    Key   string `json:"k"`
+}
+
+func (c pageCursor) encode() string {
+    data, _ := json.Marshal(c)
+    return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (pageCursor, error) {
+    var c pageCursor
+    data, err := base64.RawURLEncoding.DecodeString(s)
+    if err == nil {
+        err = json.Unmarshal(data, &c)
+    }
+    if err != nil {
+        return c, fmt.Errorf("invalid cursor")
+    }
+    return c, nil
+}
+
+// SQL conditions and their arguments collected from query parameters
+type listFilter struct {
+    clauses []string
+    args    []interface{}
+}
+
+func (f *listFilter) add(clause string, args ...interface{}) {
+    f.clauses = append(f.clauses, clause)
+    f.args = append(f.args, args...)
+}
+
+func (f *listFilter) where() string {
+    return strings.Join(f.clauses, " AND ")
+}
+
+// A sortable column: ?sort=NAME ascending, ?sort=-NAME descending
+type sortColumn struct {
+    expr    string // SQL expression sorted on
+    numeric bool   // compare cursor values as integers
+}
+
+// Parsed ?sort=, ?limit= and ?cursor= of a list request
+type listRequest struct {
+    sort   string
+    column sortColumn
+    desc   bool
+    limit  int
+    after  *pageCursor
+}
+
+// Read the paging parameters; def is the sort used when none is given
+func parseListRequest(r *http.Request, columns map[string]sortColumn, def string) (listRequest, error) {
+    q := r.URL.Query()
+    lr := listRequest{sort: q.Get("sort")}
+    if lr.sort == "" {
+        lr.sort = def
+    }
+    name := strings.TrimPrefix(lr.sort, "-")
+    col, ok := columns[name]
+    if !ok {
+        var names []string
+        for n := range columns {
+            names = append(names, n)
+        }
+        return lr, fmt.Errorf("invalid sort %q (use %s, with - for descending)", lr.sort, strings.Join(uniqueSorted(names), ", "))
+    }
+    lr.column, lr.desc = col, strings.HasPrefix(lr.sort, "-")
+
+    var err error
+    if lr.limit, err = queryInt(r, "limit", defaultPageSize); err != nil {
+        return lr, err
+    }
+    if lr.limit == 0 || lr.limit > maxPageSize {
+        lr.limit = maxPageSize
+    }
+    if c := q.Get("cursor"); c != "" {
+        cur, err := decodeCursor(c)
+        if err != nil {
+            return lr, err
+        }
+        if cur.Sort != lr.sort {
+            return lr, fmt.Errorf("cursor was made with sort %q", cur.Sort)
+        }
+        lr.after = &cur
+    }
+    return lr, nil
+}
+
+// Keyset condition for the rows after the cursor, if any, and the ORDER BY
+// clause; key is the unique column breaking ties
+func (lr listRequest) keyset(f *listFilter, key string, numericKey bool) string {
+    op, dir := ">", "ASC"
+    if lr.desc {
+        op, dir = "<", "DESC"
+    }
+    if lr.after != nil {
+        var value, k interface{} = lr.after.Value, lr.after.Key
+        if lr.column.numeric {
+            value, _ = strconv.ParseInt(lr.after.Value, 10, 64)
+        }
+        if numericKey {
+            k, _ = strconv.ParseInt(lr.after.Key, 10, 64)
+        }
+        if lr.column.expr == key {
+            f.add(key+" "+op+" ?", k)
+        } else {
+            f.add(fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND %[3]s %[2]s ?))", lr.column.expr, op, key), value, value, k)
+        }
+    }
+    if lr.column.expr == key {
+        return key + " " + dir
+    }
+    return lr.column.expr + " " + dir + ", " + key + " " + dir
+}
+
+// Rows to fetch: one more than the page holds tells whether another follows
+func (lr listRequest) fetch() int {
+    return lr.limit + 1
+}
+
+// Cursor for the page after an item with the given sort value and key
+func (lr listRequest) cursor(value, key string) string {
+    return pageCursor{Sort: lr.sort, Value: value, Key: key}.encode()
+}
+
+// Timestamp query parameter as stored, accepting RFC 3339 or a plain date
+func queryTime(r *http.Request, name string) (string, bool, error) {
+    v := r.URL.Query().Get(name)
+    if v == "" {
+        return "", false, nil
+    }
+    t, err := parseTimeParam(name, v)
+    return t, err == nil, err
+}
+
+// An RFC 3339 time or a date, as stored: RFC 3339 in UTC
+func parseTimeParam(name, v string) (string, error) {
+    for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+        if t, err := time.Parse(layout, v); err == nil {
+            return t.UTC().Format(time.RFC3339), nil
+        }
+    }
+    return "", fmt.Errorf("invalid %s %q (use RFC 3339 or YYYY-MM-DD)", name, v)
+}
+
+// Add ?since= (inclusive) and ?until= (exclusive) conditions on a column
+func addTimeRange(r *http.Request, f *listFilter, column string) error {
+    for _, p := range []struct{ name, op string }{{"since", ">="}, {"until", "<"}} {
+        t, ok, err := queryTime(r, p.name)
+        if err != nil {
+            return err
+        }
+        if ok {
+            f.add(column+" "+p.op+" ?", t)
+        }
+    }
+    return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "os"
+    "strconv"
+    "time"
+
+    "github.com/parquet-go/parquet-go"
+)
+
+// Stable Parquet schema for stored favicons; add columns, never rename them
+type parquetFavicon struct {
+    Link        string            `parquet:"link,zstd"`
+    Host        string            `parquet:"host,dict,zstd"`
+    Apex        string            `parquet:"apex,dict,zstd"`
+    MD5         string            `parquet:"md5,dict,zstd"`
+    SHA256      string            `parquet:"sha256,dict,zstd"`
+    MMH3        *int32            `parquet:"mmh3,optional"`
+    ContentType string            `parquet:"content_type,dict,zstd"`
+    Size        int64             `parquet:"size"`
+    RunLabels   map[string]string `parquet:"run_labels"`
+}
+
+// Stable Parquet schema for the change history
+type parquetHistory struct {
+    Link   string    `parquet:"link,zstd"`
+    Host   string    `parquet:"host,dict,zstd"`
+    MD5    string    `parquet:"md5,dict,zstd"`
+    SHA256 string    `parquet:"sha256,dict,zstd"`
+    SeenAt time.Time `parquet:"seen_at,timestamp(millisecond)"`
+}
+
+// Write rows to a Parquet file
+func writeParquet[T any](filename string, rows []T) error {
+    file, err := os.Create(filename)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    w := parquet.NewGenericWriter[T](file)
+    if _, err := w.Write(rows); err != nil {
+        return err
+    }
+    if err := w.Close(); err != nil {
+        return err
+    }
+    return file.Close()
+}
+
+// Export favicons (and optionally history) as Parquet for DuckDB/Athena/Spark
+func parquetCommand(args []string) {
+    fs := flag.NewFlagSet("parquet", flag.ExitOnError)
+    dbOpts := dbFlags(fs)
+    output := fs.String("o", "favicons.parquet", "Output file for favicons")
+    historyOutput := fs.String("history", "", "Also write the change history to this file")
+    parseFlags(fs, args)
+
+    db, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer db.Close()
+
+    records, err := loadRecords(db, dbOpts.workspace, false)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error reading database: %v\n", err)
+        return
+    }
+
+    rows := make([]parquetFavicon, 0, len(records))
+    for _, r := range records {
+        row := parquetFavicon{
+            Link:        r.Link,
+            Host:        r.host(),
+            Apex:        apexDomain(r.host()),
+            MD5:         r.MD5,
+            SHA256:      r.SHA256,
+            ContentType: r.ContentType,
+            Size:        r.Size,
+            RunLabels:   r.RunLabels,
+        }
+        if v, err := strconv.ParseInt(r.MMH3, 10, 32); err == nil {
+            mmh3 := int32(v)
+            row.MMH3 = &mmh3
+        }
+        rows = append(rows, row)
+    }
+    if err := writeParquet(*output, rows); err != nil {
+        fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", *output, err)
+        return
+    }
+    fmt.Printf("Wrote %d favicons to %s\n", len(rows), *output)
+
+    if *historyOutput == "" {
+        return
+    }
+    history, err := loadHistory(db, dbOpts.workspace)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error reading history: %v\n", err)
+        return
+    }
+    hrows := make([]parquetHistory, 0, len(history))
+    for _, h := range history {
+        seen, _ := time.Parse(time.RFC3339, h.SeenAt)
+        hrows = append(hrows, parquetHistory{Link: h.Link, Host: record{Link: h.Link}.host(), MD5: h.MD5, SHA256: h.SHA256, SeenAt: seen})
+    }
+    if err := writeParquet(*historyOutput, hrows); err != nil {
+        fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", *historyOutput, err)
+        return
+    }
+    fmt.Printf("Wrote %d history rows to %s\n", len(hrows), *historyOutput)
+}
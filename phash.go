@@ -0,0 +1,176 @@
+package main
+
+import (
+    "bytes"
+    "encoding/binary"
+    "fmt"
+    "image"
+    "image/color"
+    _ "image/gif"
+    _ "image/jpeg"
+    "image/png"
+    "math/bits"
+)
+
+// Decode a favicon: PNG, GIF and JPEG via the standard library, ICO here
+func decodeIcon(data []byte) (image.Image, error) {
+    if img, _, err := image.Decode(bytes.NewReader(data)); err == nil {
+        return img, nil
+    }
+    return decodeICO(data)
+}
+
+// Decode the largest image in an ICO container
+func decodeICO(data []byte) (image.Image, error) {
+    if len(data) < 6 || binary.LittleEndian.Uint16(data[0:]) != 0 || binary.LittleEndian.Uint16(data[2:]) != 1 {
+        return nil, fmt.Errorf("not an icon")
+    }
+    count := int(binary.LittleEndian.Uint16(data[4:]))
+
+    best, bestArea := -1, -1
+    for i := 0; i < count; i++ {
+        entry := 6 + i*16
+        if entry+16 > len(data) {
+            break
+        }
+        w, h := int(data[entry]), int(data[entry+1])
+        if w == 0 {
+            w = 256
+        }
+        if h == 0 {
+            h = 256
+        }
+        if w*h > bestArea {
+            best, bestArea = entry, w*h
+        }
+    }
+    if best < 0 {
+        return nil, fmt.Errorf("icon has no images")
+    }
+
+    size := int(binary.LittleEndian.Uint32(data[best+8:]))
+    offset := int(binary.LittleEndian.Uint32(data[best+12:]))
+    if offset < 0 || size < 0 || offset+size > len(data) {
+        return nil, fmt.Errorf("icon entry out of range")
+    }
+    payload := data[offset : offset+size]
+    if bytes.HasPrefix(payload, []byte("\x89PNG")) {
+        return png.Decode(bytes.NewReader(payload))
+    }
+    return decodeDIB(payload)
+}
+
+// Decode the BMP (DIB) payload of an ICO entry, applying its AND mask
+func decodeDIB(d []byte) (image.Image, error) {
+    if len(d) < 40 {
+        return nil, fmt.Errorf("bitmap header too short")
+    }
+    headerSize := int(binary.LittleEndian.Uint32(d[0:]))
+    width := int(int32(binary.LittleEndian.Uint32(d[4:])))
+    height := int(int32(binary.LittleEndian.Uint32(d[8:]))) / 2 // XOR image plus AND mask
+    bpp := int(binary.LittleEndian.Uint16(d[14:]))
+    if width <= 0 || height <= 0 || width > 1024 || height > 1024 || headerSize < 40 || headerSize > len(d) {
+        return nil, fmt.Errorf("unsupported bitmap dimensions")
+    }
+
+    pos := headerSize
+    var palette []color.NRGBA
+    if bpp <= 8 {
+        colors := int(binary.LittleEndian.Uint32(d[32:]))
+        if colors == 0 {
+            colors = 1 << bpp
+        }
+        if pos+colors*4 > len(d) {
+            return nil, fmt.Errorf("bitmap palette truncated")
+        }
+        for i := 0; i < colors; i++ {
+            p := d[pos+i*4:]
+            palette = append(palette, color.NRGBA{R: p[2], G: p[1], B: p[0], A: 255})
+        }
+        pos += colors * 4
+    }
+
+    stride := ((width*bpp + 31) / 32) * 4
+    maskStride := ((width + 31) / 32) * 4
+    if pos+stride*height > len(d) {
+        return nil, fmt.Errorf("bitmap data truncated")
+    }
+    mask := d[pos+stride*height:]
+
+    img := image.NewNRGBA(image.Rect(0, 0, width, height))
+    for y := 0; y < height; y++ {
+        row := d[pos+(height-1-y)*stride:]
+        for x := 0; x < width; x++ {
+            var c color.NRGBA
+            switch bpp {
+            case 32:
+                c = color.NRGBA{R: row[x*4+2], G: row[x*4+1], B: row[x*4], A: row[x*4+3]}
+            case 24:
+                c = color.NRGBA{R: row[x*3+2], G: row[x*3+1], B: row[x*3], A: 255}
+            case 8, 4, 1:
+                bit := x * bpp
+                idx := int(row[bit/8]>>(8-bpp-bit%8)) & (1<<bpp - 1)
+                if idx < len(palette) {
+                    c = palette[idx]
+                }
+            default:
+                return nil, fmt.Errorf("unsupported bitmap depth %d", bpp)
+            }
+            if bpp != 32 && len(mask) >= maskStride*height {
+                m := mask[(height-1-y)*maskStride+x/8]
+                if m&(0x80>>(x%8)) != 0 {
+                    c.A = 0
+                }
+            }
+            img.SetNRGBA(x, y, c)
+        }
+    }
+    return img, nil
+}
+
+// Difference hash: compare neighbouring pixels of a 9x8 grayscale thumbnail
+// composited over white, so visually similar icons get nearby hashes
+func perceptualHash(img image.Image) uint64 {
+    const w, h = 9, 8
+    b := img.Bounds()
+    var gray [h][w]float64
+    for y := 0; y < h; y++ {
+        for x := 0; x < w; x++ {
+            // Average the source pixels that fall into this cell
+            x0, x1 := b.Min.X+x*b.Dx()/w, b.Min.X+(x+1)*b.Dx()/w
+            y0, y1 := b.Min.Y+y*b.Dy()/h, b.Min.Y+(y+1)*b.Dy()/h
+            if x1 == x0 {
+                x1 = x0 + 1
+            }
+            if y1 == y0 {
+                y1 = y0 + 1
+            }
+            var sum float64
+            for sy := y0; sy < y1; sy++ {
+                for sx := x0; sx < x1; sx++ {
+                    r, g, bl, a := img.At(sx, sy).RGBA()
+                    lum := (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)) / 65535
+                    alpha := float64(a) / 65535
+                    sum += lum + (1 - alpha) // white background under transparent pixels
+                }
+            }
+            gray[y][x] = sum / float64((x1-x0)*(y1-y0))
+        }
+    }
+
+    var hash uint64
+    for y := 0; y < h; y++ {
+        for x := 0; x < w-1; x++ {
+            hash <<= 1
+            if gray[y][x] < gray[y][x+1] {
+                hash |= 1
+            }
+        }
+    }
+    return hash
+}
+
+// Number of differing bits between two perceptual hashes
+func hammingDistance(a, b uint64) int {
+    return bits.OnesCount64(a ^ b)
+}
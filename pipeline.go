@@ -0,0 +1,277 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "runtime"
+    "strings"
+    "sync"
+    "time"
+
+    "golang.org/x/sync/errgroup"
+)
+
+// A target on its way through the scan pipeline
+type scanJob struct {
+    target   target
+    icons    []favicon
+    hashes   []iconHashes
+    blobs    []encodedBlob // the icons as stored, compressed with the hashes
+    err      error // why a finished target failed as a whole, e.g. its time ran out
+    finished bool  // false when the run was cancelled while the target was in flight
+    filtered bool  // skipped by the -script filter_target callback
+    deferred bool  // its host's circuit breaker was open
+    page     *page // the target's page, kept for -tech
+}
+
+// Start workers copies of work in g and call done once all of them returned
+func stage(g *errgroup.Group, workers int, work func() error, done func()) {
+    var wg sync.WaitGroup
+    wg.Add(workers)
+    for i := 0; i < workers; i++ {
+        g.Go(func() error {
+            defer wg.Done()
+            return work()
+        })
+    }
+    g.Go(func() error {
+        wg.Wait()
+        done()
+        return nil
+    })
+}
+
+// Process each target in the list as one run, stopping early when ctx is
+// cancelled. Targets flow through bounded channels from an input stage to
+// -workers fetchers, which download pages and favicons, to hash workers and a
+// single store stage. Cancelling stops the input; whatever is already in
+// the pipeline drains into the store so nothing fetched is lost.
+func (s *scanner) scanTargets(ctx context.Context, targets []target) {
+    byURL := map[string]target{}
+    for _, t := range targets {
+        byURL[t.URL] = t
+    }
+    pending := s.beginRun(targetURLs(targets))
+    defer s.endRun(ctx)
+    s.scopeSANs(targets)
+    if s.dnsPrefilter {
+        pending = s.prefilterDNS(ctx, byURL, pending)
+    }
+
+    runCtx, cancel := s.runContext(ctx)
+    defer cancel()
+
+    // Names -expand-sans finds on certificates are scanned in further waves
+    for wave := pending; len(wave) > 0 && runCtx.Err() == nil; wave = s.expandedTargets(byURL) {
+        base, deferred := s.done, s.deferred
+        inputs := make(chan target, s.workers)
+        var g errgroup.Group
+
+        // Input: feed targets until the run is cancelled or out of time
+        stage(&g, 1, func() error {
+            for _, u := range wave {
+                select {
+                case inputs <- byURL[u]:
+                case <-runCtx.Done():
+                    return nil
+                }
+            }
+            return nil
+        }, func() { close(inputs) })
+        s.process(runCtx, &g, inputs)
+        g.Wait()
+
+        if ctx.Err() == nil && runCtx.Err() != nil {
+            s.timedOut = true
+            left := len(wave) - (s.done - base) - (s.deferred - deferred)
+            s.skipped += left
+            errorf("Reached -max-runtime of %s with %d targets left\n", s.maxRuntime, left)
+        }
+    }
+}
+
+// Process targets as they arrive, as one run that ends when in is closed.
+// Each result is emitted as soon as its target is scanned, so a slow
+// producer such as a subdomain enumerator never holds back the output.
+// Repeated targets are scanned once.
+func (s *scanner) scanStream(ctx context.Context, in <-chan target) {
+    s.beginRun(nil)
+    defer s.endRun(ctx)
+    // Certificate names are stored but not expanded: a stream has no scope
+    s.scopeSANs(nil)
+
+    runCtx, cancel := s.runContext(ctx)
+    defer cancel()
+
+    inputs := make(chan target, s.workers)
+    var g errgroup.Group
+    stage(&g, 1, func() error {
+        seen := map[string]bool{}
+        for {
+            var t target
+            var ok bool
+            select {
+            case t, ok = <-in:
+            case <-runCtx.Done():
+                return nil
+            }
+            if !ok {
+                return nil
+            }
+            if seen[t.URL] {
+                continue
+            }
+            seen[t.URL] = true
+            s.mu.Lock()
+            s.targets++
+            if s.bar != nil {
+                s.bar.setTotal(s.targets)
+            }
+            s.mu.Unlock()
+            select {
+            case inputs <- t:
+            case <-runCtx.Done():
+                return nil
+            }
+        }
+    }, func() { close(inputs) })
+    s.process(runCtx, &g, inputs)
+    g.Wait()
+
+    if ctx.Err() == nil && runCtx.Err() != nil {
+        s.timedOut = true
+        errorf("Reached -max-runtime of %s\n", s.maxRuntime)
+    }
+}
+
+// Context of a pass: -max-runtime bounds it, and targets it cuts off count as skipped
+func (s *scanner) runContext(ctx context.Context) (context.Context, context.CancelFunc) {
+    if s.maxRuntime > 0 {
+        return context.WithTimeout(ctx, s.maxRuntime)
+    }
+    return ctx, func() {}
+}
+
+// Start the fetch, hash and store stages of a pass in g, reading targets
+// from inputs until it is closed
+func (s *scanner) process(runCtx context.Context, g *errgroup.Group, inputs <-chan target) {
+    fetched := make(chan *scanJob, s.workers)
+    hashed := make(chan *scanJob, s.workers)
+
+    // Fetch: pages and their favicons, -workers targets at a time
+    stage(g, s.workers, func() error {
+        for t := range inputs {
+            s.store.throttle(runCtx)
+            if runCtx.Err() == nil {
+                fetched <- s.fetchTarget(runCtx, t)
+            }
+        }
+        return nil
+    }, func() { close(fetched) })
+
+    // Hash and compress: CPU-bound, one worker per core, so the single store
+    // stage only writes
+    stage(g, runtime.NumCPU(), func() error {
+        for job := range fetched {
+            job.hashes = make([]iconHashes, len(job.icons))
+            job.blobs = make([]encodedBlob, len(job.icons))
+            for i, icon := range job.icons {
+                job.hashes[i] = s.hash.hash(icon.Data)
+                job.blobs[i] = newEncodedBlob(icon.Data)
+            }
+            hashed <- job
+        }
+        return nil
+    }, func() { close(hashed) })
+
+    // Store: record results and checkpoint finished targets
+    stage(g, 1, func() error {
+        for job := range hashed {
+            for i, icon := range job.icons {
+                s.recordHashed(icon, job.hashes[i], job.blobs[i])
+            }
+            s.detectTech(job)
+            if job.err != nil {
+                errorf("Error scanning %s: %v\n", job.target.URL, job.err)
+                s.failTarget(job.target, job.target.URL, job.err)
+            }
+            if job.deferred {
+                s.mu.Lock()
+                s.deferred++
+                s.mu.Unlock()
+            }
+            if job.filtered {
+                s.mu.Lock()
+                s.skipped++
+                s.mu.Unlock()
+            }
+            if job.finished {
+                s.targetDone(job.target.URL)
+            }
+        }
+        return nil
+    }, func() {})
+}
+
+// Download the page and favicons of one target within -target-timeout. A
+// target that runs out of time is finished with an error; one cut short by
+// ctx is left unfinished, keeping the favicons it got.
+func (s *scanner) fetchTarget(ctx context.Context, t target) *scanJob {
+    targetCtx, cancel := ctx, context.CancelFunc(func() {})
+    if s.targetTimeout > 0 {
+        targetCtx, cancel = context.WithTimeout(ctx, s.targetTimeout)
+    }
+    defer cancel()
+
+    job := &scanJob{target: t}
+    if !s.script.keepTarget(t) {
+        infof("Skipping URL: %s\n", t.URL)
+        job.filtered, job.finished = true, true
+        return job
+    }
+    infof("Processing URL: %s\n", t.URL)
+    if t.HostHeader == "" {
+        t.HostHeader = s.hostHeader
+    }
+    if t.SNI == "" {
+        t.SNI = s.sni
+    }
+    if until, ok := s.breaker.open(targetHost(t.URL)); ok {
+        infof("Deferring %s: circuit breaker open until %s\n", t.URL, until.Format(time.TimeOnly))
+        job.deferred = true
+        return job
+    }
+    s.recordDNS(targetCtx, t)
+    pageURL := t.URL
+    if !strings.Contains(pageURL, "://") {
+        probed, err := probeHost(targetCtx, pageURL, s.probes, s.probeTimeout)
+        if ctx.Err() != nil {
+            return job
+        }
+        if err != nil {
+            job.err, job.finished = err, true
+            return job
+        }
+        pageURL = probed
+    }
+    fetch := s.fetch
+    var capture *pageCapture
+    if s.tech != nil {
+        capture = &pageCapture{fetcher: s.fetch}
+        fetch = capture
+    }
+    job.icons = downloadFavicons(withTarget(targetCtx, t), fetch, pageURL, s.validators, func(url string, err error) {
+        s.failTarget(t, url, err)
+    })
+    if capture != nil {
+        job.page = capture.page
+    }
+    if ctx.Err() != nil {
+        return job
+    }
+    if targetCtx.Err() != nil {
+        job.err = fmt.Errorf("%w after %s", errTimedOut, s.targetTimeout)
+    }
+    job.finished = true
+    return job
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+    "bufio"
+    "crypto/hmac"
+    "crypto/md5"
+    "crypto/rand"
+    "crypto/sha256"
+    "crypto/tls"
+    "encoding/base64"
+    "encoding/binary"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "net/url"
+    "strings"
+    "time"
+
+    "golang.org/x/crypto/pbkdf2"
+)
+
+// Minimal PostgreSQL client speaking the simple query protocol, enough to
+// load data: statements run one Query at a time and their rows are ignored
+type pgConn struct {
+    conn   net.Conn
+    reader *bufio.Reader
+}
+
+// Error reported by the server
+type pgError struct {
+    severity string
+    code     string
+    message  string
+}
+
+func (e *pgError) Error() string {
+    return fmt.Sprintf("%s: %s (SQLSTATE %s)", e.severity, e.message, e.code)
+}
+
+// Connect to a postgres:// URL and authenticate with trust, password, MD5 or
+// SCRAM-SHA-256. sslmode is disable, prefer (the default), require, or
+// verify-full to also check the server's certificate.
+func dialPostgres(rawURL string) (*pgConn, error) {
+    u, err := url.Parse(rawURL)
+    if err != nil {
+        return nil, err
+    }
+    if u.Scheme != "postgres" && u.Scheme != "postgresql" {
+        return nil, fmt.Errorf("%s is not a postgres:// URL", u.Redacted())
+    }
+    host, port := u.Hostname(), u.Port()
+    if host == "" {
+        host = "localhost"
+    }
+    if port == "" {
+        port = "5432"
+    }
+    user := u.User.Username()
+    password, _ := u.User.Password()
+    database := strings.TrimPrefix(u.Path, "/")
+    if database == "" {
+        database = user
+    }
+
+    conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), 10*time.Second)
+    if err != nil {
+        return nil, err
+    }
+    c := &pgConn{conn: conn, reader: bufio.NewReader(conn)}
+    if err := c.startTLS(host, u.Query().Get("sslmode")); err != nil {
+        conn.Close()
+        return nil, err
+    }
+
+    // Startup: protocol 3.0 and the connection parameters
+    var body []byte
+    body = binary.BigEndian.AppendUint32(body, 196608)
+    for _, kv := range [][2]string{{"user", user}, {"database", database}, {"application_name", "maplink"}, {"client_encoding", "UTF8"}} {
+        body = append(append(append(append(body, kv[0]...), 0), kv[1]...), 0)
+    }
+    if err := c.send(0, append(body, 0)); err != nil {
+        conn.Close()
+        return nil, err
+    }
+    if err := c.authenticate(user, password); err != nil {
+        conn.Close()
+        return nil, err
+    }
+    if err := c.ready(); err != nil {
+        conn.Close()
+        return nil, err
+    }
+    return c, nil
+}
+
+// Ask the server for TLS as sslmode says
+func (c *pgConn) startTLS(host, mode string) error {
+    if mode == "" {
+        mode = "prefer"
+    }
+    switch mode {
+    case "disable":
+        return nil
+    case "prefer", "require", "verify-full":
+    default:
+        return fmt.Errorf("unsupported sslmode %q (use disable, prefer, require or verify-full)", mode)
+    }
+    if err := c.send(0, binary.BigEndian.AppendUint32(nil, 80877103)); err != nil {
+        return err
+    }
+    answer, err := c.reader.ReadByte()
+    if err != nil {
+        return err
+    }
+    if answer != 'S' {
+        if mode == "prefer" {
+            return nil
+        }
+        return errors.New("server does not support TLS")
+    }
+    // Like libpq, require encrypts without checking who answers
+    conn := tls.Client(c.conn, &tls.Config{ServerName: host, InsecureSkipVerify: mode != "verify-full"})
+    if err := conn.Handshake(); err != nil {
+        return err
+    }
+    c.conn, c.reader = conn, bufio.NewReader(conn)
+    return nil
+}
+
+// Answer the server's authentication requests until it accepts or refuses
+func (c *pgConn) authenticate(user, password string) error {
+    var scram *scramClient
+    for {
+        typ, body, err := c.receive()
+        if err != nil {
+            return err
+        }
+        if typ == 'E' {
+            return parsePgError(body)
+        }
+        if typ != 'R' || len(body) < 4 {
+            return fmt.Errorf("unexpected message %q during authentication", typ)
+        }
+        switch code, data := binary.BigEndian.Uint32(body), body[4:]; code {
+        case 0:
+            return nil
+        case 3:
+            err = c.send('p', append([]byte(password), 0))
+        case 5:
+            inner := md5.Sum([]byte(password + user))
+            outer := md5.Sum(append([]byte(hex.EncodeToString(inner[:])), data...))
+            err = c.send('p', append([]byte("md5"+hex.EncodeToString(outer[:])), 0))
+        case 10:
+            if !strings.Contains(string(data), "SCRAM-SHA-256\x00") {
+                return errors.New("server offers no supported SASL mechanism")
+            }
+            scram = newSCRAMClient(password)
+            first := scram.first()
+            msg := append([]byte("SCRAM-SHA-256\x00"), binary.BigEndian.AppendUint32(nil, uint32(len(first)))...)
+            err = c.send('p', append(msg, first...))
+        case 11:
+            if scram == nil {
+                return errors.New("SASL continue before start")
+            }
+            var final string
+            if final, err = scram.final(string(data)); err == nil {
+                err = c.send('p', []byte(final))
+            }
+        case 12:
+            if scram == nil || !scram.verify(string(data)) {
+                return errors.New("server failed SCRAM verification")
+            }
+        default:
+            return fmt.Errorf("unsupported authentication method %d", code)
+        }
+        if err != nil {
+            return err
+        }
+    }
+}
+
+// Read until the server is ready for a query, returning the first error it reported
+func (c *pgConn) ready() error {
+    var firstErr error
+    for {
+        typ, body, err := c.receive()
+        if err != nil {
+            return err
+        }
+        switch typ {
+        case 'E':
+            if firstErr == nil {
+                firstErr = parsePgError(body)
+            }
+        case 'Z':
+            return firstErr
+        }
+    }
+}
+
+// Run one or more statements, discarding any rows
+func (c *pgConn) exec(query string) error {
+    if err := c.send('Q', append([]byte(query), 0)); err != nil {
+        return err
+    }
+    return c.ready()
+}
+
+// End the session
+func (c *pgConn) Close() error {
+    c.send('X', nil)
+    return c.conn.Close()
+}
+
+func (c *pgConn) send(typ byte, body []byte) error {
+    var msg []byte
+    if typ != 0 {
+        msg = append(msg, typ)
+    }
+    msg = binary.BigEndian.AppendUint32(msg, uint32(len(body)+4))
+    _, err := c.conn.Write(append(msg, body...))
+    return err
+}
+
+func (c *pgConn) receive() (byte, []byte, error) {
+    var head [5]byte
+    if _, err := io.ReadFull(c.reader, head[:]); err != nil {
+        return 0, nil, err
+    }
+    n := binary.BigEndian.Uint32(head[1:])
+    if n < 4 {
+        return 0, nil, errors.New("malformed message")
+    }
+    body := make([]byte, n-4)
+    if _, err := io.ReadFull(c.reader, body); err != nil {
+        return 0, nil, err
+    }
+    return head[0], body, nil
+}
+
+// Fields of an ErrorResponse: a type byte and a string each
+func parsePgError(body []byte) error {
+    e := &pgError{}
+    for len(body) > 1 {
+        end := strings.IndexByte(string(body[1:]), 0)
+        if end < 0 {
+            break
+        }
+        value := string(body[1 : 1+end])
+        switch body[0] {
+        case 'S':
+            e.severity = value
+        case 'C':
+            e.code = value
+        case 'M':
+            e.message = value
+        }
+        body = body[2+end:]
+    }
+    return e
+}
+
+// Client side of SCRAM-SHA-256 (RFC 5802, 7677) without channel binding
+type scramClient struct {
+    password    string
+    nonce       string
+    firstBare   string
+    authMessage string
+    salted      []byte
+}
+
+func newSCRAMClient(password string) *scramClient {
+    raw := make([]byte, 18)
+    rand.Read(raw)
+    return &scramClient{password: password, nonce: base64.RawStdEncoding.EncodeToString(raw)}
+}
+
+// client-first-message; the server takes the user name from the startup
+func (s *scramClient) first() string {
+    s.firstBare = "n=,r=" + s.nonce
+    return "n,," + s.firstBare
+}
+
+// client-final-message with the proof for the server-first-message
+func (s *scramClient) final(serverFirst string) (string, error) {
+    var nonce, salt string
+    iterations := 0
+    for _, attr := range strings.Split(serverFirst, ",") {
+        k, v, _ := strings.Cut(attr, "=")
+        switch k {
+        case "r":
+            nonce = v
+        case "s":
+            salt = v
+        case "i":
+            fmt.Sscan(v, &iterations)
+        }
+    }
+    saltBytes, err := base64.StdEncoding.DecodeString(salt)
+    if err != nil || !strings.HasPrefix(nonce, s.nonce) || iterations < 1 {
+        return "", errors.New("malformed SCRAM server-first-message")
+    }
+    s.salted = pbkdf2.Key([]byte(s.password), saltBytes, iterations, sha256.Size, sha256.New)
+    withoutProof := "c=biws,r=" + nonce
+    s.authMessage = s.firstBare + "," + serverFirst + "," + withoutProof
+
+    clientKey := hmacSHA256(s.salted, "Client Key")
+    storedKey := sha256.Sum256(clientKey)
+    signature := hmacSHA256(storedKey[:], s.authMessage)
+    proof := make([]byte, len(clientKey))
+    for i := range clientKey {
+        proof[i] = clientKey[i] ^ signature[i]
+    }
+    return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+// Whether the server-final-message proves the server knows the password
+func (s *scramClient) verify(serverFinal string) bool {
+    v, ok := strings.CutPrefix(serverFinal, "v=")
+    if !ok || s.salted == nil {
+        return false
+    }
+    want := hmacSHA256(hmacSHA256(s.salted, "Server Key"), s.authMessage)
+    got, err := base64.StdEncoding.DecodeString(v)
+    return err == nil && hmac.Equal(got, want)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "net"
+    "net/http"
+    "net/http/pprof"
+    "time"
+)
+
+// Profiling endpoint of the long-running modes, off unless -pprof is given
+type pprofOptions struct {
+    addr        string
+    allowRemote bool
+}
+
+func registerPprofFlags(fs *flag.FlagSet) *pprofOptions {
+    o := &pprofOptions{}
+    fs.StringVar(&o.addr, "pprof", "", "Serve net/http/pprof on this address, e.g. 127.0.0.1:6060 (off by default)")
+    fs.BoolVar(&o.allowRemote, "pprof-allow-remote", false, "Let -pprof listen on an address other than loopback")
+    return o
+}
+
+// Start serving /debug/pprof/ in the background. The profiles show memory
+// contents and command lines, so only loopback is allowed unless asked.
+func (o *pprofOptions) start() error {
+    if o.addr == "" {
+        return nil
+    }
+    host, _, err := net.SplitHostPort(o.addr)
+    if err != nil {
+        return fmt.Errorf("invalid -pprof address %q: %v", o.addr, err)
+    }
+    if !o.allowRemote && !loopbackHost(host) {
+        return fmt.Errorf("-pprof address %q is not loopback (add -pprof-allow-remote to expose it)", o.addr)
+    }
+    ln, err := net.Listen("tcp", o.addr)
+    if err != nil {
+        return err
+    }
+    mux := http.NewServeMux()
+    mux.HandleFunc("/debug/pprof/", pprof.Index)
+    mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+    mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+    mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+    mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+    srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+    infof("Serving pprof on http://%s/debug/pprof/\n", ln.Addr())
+    go func() {
+        if err := srv.Serve(ln); err != nil {
+            errorf("Error serving pprof: %v\n", err)
+        }
+    }()
+    return nil
+}
+
+// Whether a host name or address only reaches this machine; "" binds every interface
+func loopbackHost(host string) bool {
+    if host == "localhost" {
+        return true
+    }
+    ip := net.ParseIP(host)
+    return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "mime"
+    "net/http"
+    "strings"
+)
+
+// A favicon link passed over, with why
+type iconSkipped struct {
+    target      string
+    reason      string // too_large, not_image, page_limit or invalid_icon
+    code        string // for invalid_icon: empty, html, json or http_status
+    status      int
+    contentType string
+    size        int64
+}
+
+func (e *iconSkipped) Error() string {
+    switch e.reason {
+    case "too_large":
+        return fmt.Sprintf("%d bytes is over -max-icon-size", e.size)
+    case "page_limit":
+        return "over -max-page-icons"
+    case "invalid_icon":
+        if e.code == "http_status" {
+            return fmt.Sprintf("not an icon: status %d", e.status)
+        }
+        return fmt.Sprintf("not an icon: %s body", e.code)
+    }
+    return fmt.Sprintf("Content-Type %s is not an image", e.contentType)
+}
+
+// Whether a Content-Type may carry an icon. Servers label .ico files in many
+// ways, so generic binary types and a missing header pass too.
+func iconContentType(contentType string) bool {
+    if contentType == "" {
+        return true
+    }
+    media, _, err := mime.ParseMediaType(contentType)
+    if err != nil {
+        return true
+    }
+    switch media {
+    case "application/octet-stream", "binary/octet-stream", "application/ico", "application/x-ico", "application/x-icon", "application/x-win-bitmap":
+        return true
+    }
+    return strings.HasPrefix(media, "image/")
+}
+
+// Why a downloaded favicon is no icon, or "" if it may be one: an empty
+// body, an HTML or JSON page (soft 404s, login redirects, API errors), or
+// an error status. Hashing those would cluster unrelated hosts.
+func invalidIcon(status int, contentType string, data []byte) string {
+    media, _, _ := mime.ParseMediaType(contentType)
+    trimmed := bytes.TrimSpace(data)
+    switch {
+    case len(trimmed) == 0:
+        return "empty"
+    case media == "image/svg+xml" || bytes.Contains(data[:min(len(data), 1024)], []byte("<svg")):
+        // SVG is markup too and may sniff as HTML
+    case media == "text/html" || strings.HasPrefix(http.DetectContentType(data), "text/html"):
+        return "html"
+    case media == "application/json" || (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid(trimmed):
+        return "json"
+    }
+    if status < 200 || status > 299 {
+        return "http_status"
+    }
+    return ""
+}
+
+// Ask for a favicon's headers before downloading it and say whether to skip
+// it. Servers that refuse or fumble HEAD get the GET anyway.
+func (f *httpFetcher) preflightFavicon(ctx context.Context, url string) *iconSkipped {
+    req, err := newRequest(ctx, url)
+    if err != nil {
+        return nil
+    }
+    req.Method = http.MethodHead
+    resp, err := f.client.Do(req)
+    if err != nil {
+        return nil
+    }
+    resp.Body.Close()
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return nil
+    }
+    contentType := resp.Header.Get("Content-Type")
+    if f.maxIconSize > 0 && resp.ContentLength > f.maxIconSize {
+        return &iconSkipped{reason: "too_large", contentType: contentType, size: resp.ContentLength}
+    }
+    if !iconContentType(contentType) {
+        return &iconSkipped{reason: "not_image", contentType: contentType, size: resp.ContentLength}
+    }
+    return nil
+}
+
+// Read a favicon body up to -max-icon-size, skipping one that declares or
+// turns out to be larger
+func (f *httpFetcher) readIcon(resp *http.Response) ([]byte, error) {
+    if f.maxIconSize <= 0 {
+        return io.ReadAll(resp.Body)
+    }
+    contentType := resp.Header.Get("Content-Type")
+    if resp.ContentLength > f.maxIconSize {
+        return nil, &iconSkipped{reason: "too_large", contentType: contentType, size: resp.ContentLength}
+    }
+    data, err := io.ReadAll(io.LimitReader(resp.Body, f.maxIconSize+1))
+    if err != nil {
+        return nil, err
+    }
+    if int64(len(data)) > f.maxIconSize {
+        return nil, &iconSkipped{reason: "too_large", contentType: contentType, size: int64(len(data))}
+    }
+    return data, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+    "context"
+    "crypto/tls"
+    "fmt"
+    "net"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Schemes and ports tried for targets given as bare host names, as
+// subdomain enumeration tools print them
+const defaultProbes = "https:443,http:80"
+
+// Scheme of ports whose protocol goes without saying
+var wellKnownPorts = map[string]string{"80": "http", "443": "https", "8000": "http", "8080": "http", "8443": "https"}
+
+// A scheme and port to try on a bare host
+type probePort struct {
+    scheme string
+    port   string
+}
+
+// Parse a -probe list such as "https:443,http:80,https:8443"
+func parseProbes(list string) ([]probePort, error) {
+    var probes []probePort
+    for _, item := range splitList(list) {
+        scheme, port, ok := strings.Cut(strings.ToLower(item), ":")
+        if n, err := strconv.Atoi(port); !ok || err != nil || n < 1 || n > 65535 || (scheme != "http" && scheme != "https") {
+            return nil, fmt.Errorf("invalid probe %q (use scheme:port, e.g. https:8443)", item)
+        }
+        probes = append(probes, probePort{scheme: scheme, port: port})
+    }
+    return probes, nil
+}
+
+// Host and port of a target given without a scheme, e.g. "sub.example.com"
+// or "10.0.0.5:8080"; ok is false when it is not a bare host
+func bareHost(target string) (host, port string, ok bool) {
+    if strings.Contains(target, "://") || strings.ContainsAny(target, "/?# ") || target == "" {
+        return "", "", false
+    }
+    host = target
+    if h, p, err := net.SplitHostPort(target); err == nil {
+        if _, err := strconv.Atoi(p); err != nil {
+            return "", "", false
+        }
+        host, port = h, p
+    }
+    host = strings.TrimSuffix(host, ".")
+    return host, port, host != ""
+}
+
+// URL to scan a bare host at: the first probe, in order, that accepts a
+// connection. A host given with an uncommon port is checked for TLS on it.
+func probeHost(ctx context.Context, target string, probes []probePort, timeout time.Duration) (string, error) {
+    host, port, ok := bareHost(target)
+    if !ok {
+        return "", fmt.Errorf("not a URL or host name")
+    }
+    dialer := &net.Dialer{Timeout: timeout}
+    if port != "" {
+        if scheme, ok := wellKnownPorts[port]; ok {
+            return scheme + "://" + net.JoinHostPort(host, port), nil
+        }
+        conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+        if err != nil {
+            return "", err
+        }
+        defer conn.Close()
+        conn.SetDeadline(time.Now().Add(timeout))
+        // Only the protocol matters here; certificates are checked by the fetch
+        tlsConn := tls.Client(conn, &tls.Config{ServerName: host, InsecureSkipVerify: true})
+        if tlsConn.HandshakeContext(ctx) == nil {
+            return "https://" + net.JoinHostPort(host, port), nil
+        }
+        return "http://" + net.JoinHostPort(host, port), nil
+    }
+    var lastErr error
+    for _, p := range probes {
+        conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, p.port))
+        if err != nil {
+            lastErr = err
+            continue
+        }
+        conn.Close()
+        if (p.scheme == "https" && p.port == "443") || (p.scheme == "http" && p.port == "80") {
+            return p.scheme + "://" + hostForURL(host), nil
+        }
+        return p.scheme + "://" + net.JoinHostPort(host, p.port), nil
+    }
+    if lastErr == nil {
+        lastErr = fmt.Errorf("no ports to probe")
+    }
+    return "", fmt.Errorf("no web server found: %v", lastErr)
+}
+
+// Host as written in a URL, bracketing IPv6 addresses
+func hostForURL(host string) string {
+    if strings.Contains(host, ":") {
+        return "[" + host + "]"
+    }
+    return host
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+    "fmt"
+    "io"
+    "os"
+    "sync"
+    "time"
+)
+
+// Whether a file is an interactive terminal
+func isTerminal(f *os.File) bool {
+    info, err := f.Stat()
+    return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Live one-line progress indicator redrawn on a terminal
+type progress struct {
+    w      io.Writer
+    total  int
+    base   int
+    start  time.Time
+    mu     sync.Mutex
+    done   int
+    errors int
+    stop   chan struct{}
+    exited chan struct{}
+}
+
+// Start redrawing the indicator every interval; base targets were done
+// before this process started and do not count towards the rate
+func newProgress(w io.Writer, total, base int, interval time.Duration) *progress {
+    p := &progress{w: w, total: total, base: base, done: base, start: time.Now(), stop: make(chan struct{}), exited: make(chan struct{})}
+    go func() {
+        defer close(p.exited)
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-ticker.C:
+                p.draw()
+            case <-p.stop:
+                // Clear the line so the summary starts clean
+                fmt.Fprint(p.w, "\r\x1b[K")
+                return
+            }
+        }
+    }()
+    return p
+}
+
+// Update the number of finished targets and errors
+func (p *progress) update(done, errors int) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    p.done, p.errors = done, errors
+}
+
+// Raise the total as targets keep arriving
+func (p *progress) setTotal(total int) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    p.total = total
+}
+
+func (p *progress) draw() {
+    p.mu.Lock()
+    done, errors, total := p.done, p.errors, p.total
+    p.mu.Unlock()
+
+    elapsed := time.Since(p.start)
+    rate := float64(done-p.base) / elapsed.Seconds()
+    eta := "-"
+    if rate > 0 && done < total {
+        eta = time.Duration(float64(total-done) / rate * float64(time.Second)).Round(time.Second).String()
+    }
+    pct := 100.0
+    if total > 0 {
+        pct = float64(done) * 100 / float64(total)
+    }
+    fmt.Fprintf(p.w, "\r\x1b[K[%5.1f%%] %d/%d targets  %.1f/s  ETA %s  %d errors", pct, done, total, rate, eta, errors)
+}
+
+// Stop redrawing and clear the line
+func (p *progress) close() {
+    close(p.stop)
+    <-p.exited
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "os"
+    "regexp"
+    "strings"
+)
+
+// Searchable text fields and their columns; tags and notes live in their
+// own tables, and run labels are stored as JSON but searched as key=value,
+// so those are matched after loading
+var searchFields = map[string]string{
+    "link":       "f.link",
+    "target":     "f.target",
+    "title":      "f.title",
+    "server":     "f.server",
+    "labels":     "f.labels",
+    "run_labels": "",
+    "apex":       "f.apex",
+    "tags":       "",
+    "notes":      "",
+}
+
+// Text of a record field named in searchFields
+func (r record) field(name string) string {
+    switch name {
+    case "link":
+        return r.Link
+    case "target":
+        return r.Target
+    case "title":
+        return r.Title
+    case "server":
+        return r.Server
+    case "labels":
+        return r.Labels
+    case "run_labels":
+        return r.RunLabels.String()
+    case "apex":
+        return r.Apex
+    case "tags":
+        return strings.Join(r.tags(), ",")
+    case "notes":
+        return strings.Join(r.Notes, "\n")
+    }
+    return ""
+}
+
+// Escape LIKE wildcards so the search text matches literally
+func likePattern(text string) string {
+    r := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+    return "%" + r.Replace(text) + "%"
+}
+
+// Find records whose fields contain search (case-insensitive) and match re
+func searchRecords(db *sql.DB, workspace string, fields []string, search string, re *regexp.Regexp) ([]record, error) {
+    var where string
+    var args []interface{}
+    inSQL := true
+    for _, name := range fields {
+        inSQL = inSQL && searchFields[name] != ""
+    }
+    if search != "" && inSQL {
+        var clauses []string
+        for _, name := range fields {
+            clauses = append(clauses, searchFields[name]+` LIKE ? ESCAPE '\'`)
+            args = append(args, likePattern(search))
+        }
+        where = strings.Join(clauses, " OR ")
+    }
+
+    found, err := queryRecords(db, workspace, false, where, args...)
+    if err != nil || (re == nil && (inSQL || search == "")) {
+        return found, err
+    }
+
+    // RE2 has no SQLite counterpart, and tags, notes and run labels are not
+    // searchable columns, so those matches are made here
+    needle := strings.ToLower(search)
+    var matched []record
+    for _, r := range found {
+        for _, name := range fields {
+            text := r.field(name)
+            if (inSQL || strings.Contains(strings.ToLower(text), needle)) && (re == nil || re.MatchString(text)) {
+                matched = append(matched, r)
+                break
+            }
+        }
+    }
+    return matched, nil
+}
+
+// Search stored favicons by link, target, page title, Server header, target or run labels, tags or notes
+func queryCommand(args []string) {
+    fs := flag.NewFlagSet("query", flag.ExitOnError)
+    dbOpts := dbFlags(fs)
+    search := fs.String("search", "", "Case-insensitive text to look for")
+    pattern := fs.String("regex", "", "Regular expression the field must match")
+    fieldList := fs.String("fields", "link,target,title,server,labels,tags,notes", "Comma-separated fields to search")
+    format := fs.String("format", "text", "Output format: text or json")
+    limit := fs.Int("limit", 0, "Print at most this many records (0 prints all)")
+    parseFlags(fs, args)
+
+    if *search == "" && *pattern == "" {
+        fmt.Fprintln(os.Stderr, "Please provide -search or -regex.")
+        return
+    }
+    fields := splitList(*fieldList)
+    for _, name := range fields {
+        if _, ok := searchFields[name]; !ok {
+            fmt.Fprintf(os.Stderr, "Unknown field %q (use link, target, title, server, labels, run_labels, apex, tags or notes)\n", name)
+            return
+        }
+    }
+    var re *regexp.Regexp
+    if *pattern != "" {
+        var err error
+        if re, err = regexp.Compile(*pattern); err != nil {
+            fmt.Fprintf(os.Stderr, "Error parsing -regex: %v\n", err)
+            return
+        }
+    }
+
+    db, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer db.Close()
+
+    found, err := searchRecords(db, dbOpts.workspace, fields, *search, re)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error searching records: %v\n", err)
+        return
+    }
+    if *limit > 0 && len(found) > *limit {
+        found = found[:*limit]
+    }
+
+    if *format == "json" {
+        type match struct {
+            Link      string    `json:"link"`
+            Target    string    `json:"target,omitempty"`
+            Title     string    `json:"title,omitempty"`
+            Server    string    `json:"server,omitempty"`
+            Labels    string    `json:"labels,omitempty"`
+            RunLabels runLabels `json:"run_labels,omitempty"`
+            FinalURL  string    `json:"final_url,omitempty"`
+            Apex      string    `json:"apex,omitempty"`
+            MD5       string    `json:"md5"`
+            SHA256    string    `json:"sha256"`
+            MMH3      string    `json:"mmh3"`
+            Tags      []string  `json:"tags,omitempty"`
+            Notes     []string  `json:"notes,omitempty"`
+        }
+        enc := json.NewEncoder(os.Stdout)
+        for _, r := range found {
+            enc.Encode(match{r.Link, r.Target, r.Title, r.Server, r.Labels, r.RunLabels, r.FinalURL, r.Apex, r.MD5, r.SHA256, r.MMH3, r.tags(), r.Notes})
+        }
+        return
+    }
+    for _, r := range found {
+        labels := ""
+        if r.Labels != "" {
+            labels = " | Labels: " + r.Labels
+        }
+        if len(r.RunLabels) > 0 {
+            labels += " | Run labels: " + r.RunLabels.String()
+        }
+        if tags := r.tags(); len(tags) > 0 {
+            labels += " | Tags: " + strings.Join(tags, ",")
+        }
+        if len(r.Notes) > 0 {
+            labels += fmt.Sprintf(" | %d notes", len(r.Notes))
+        }
+        fmt.Printf("%s | MMH3: %s | MD5: %s | Title: %s | Server: %s%s\n", r.Link, r.MMH3, r.MD5, r.Title, r.Server, labels)
+    }
+    fmt.Printf("%d matching records\n", len(found))
+}
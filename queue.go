@@ -0,0 +1,48 @@
+package main
+
+import "sync/atomic"
+
+// Items a background queue holds before it starts dropping them
+const backgroundQueueSize = 100
+
+// A bounded queue worked through, one item at a time, by its own goroutine.
+// Pushing never waits: an item that finds the queue full is dropped, the
+// first drop is reported at once and the total when the queue closes.
+type backgroundQueue[T any] struct {
+    items   chan T
+    done    chan struct{}
+    name    string // what is queued, for the drop messages
+    dropped atomic.Int64
+}
+
+// Start a queue of name handing every item to work
+func newBackgroundQueue[T any](name string, work func(T)) *backgroundQueue[T] {
+    q := &backgroundQueue[T]{items: make(chan T, backgroundQueueSize), done: make(chan struct{}), name: name}
+    go func() {
+        defer close(q.done)
+        for item := range q.items {
+            work(item)
+        }
+    }()
+    return q
+}
+
+// Queue an item, or drop it if the queue is full
+func (q *backgroundQueue[T]) push(item T) {
+    select {
+    case q.items <- item:
+    default:
+        if q.dropped.Add(1) == 1 {
+            errorf("The %s queue is full, dropping %ss until it drains\n", q.name, q.name)
+        }
+    }
+}
+
+// Wait for the queued items to be worked through
+func (q *backgroundQueue[T]) close() {
+    close(q.items)
+    <-q.done
+    if n := q.dropped.Load(); n > 0 {
+        errorf("Dropped %d %ss while the %s queue was full\n", n, q.name, q.name)
+    }
+}
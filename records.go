@@ -0,0 +1,215 @@
+package main
+
+import (
+    "database/sql"
+    "fmt"
+    "net/url"
+)
+
+// A stored favicon joined with its blob metadata
+type record struct {
+    Link             string
+    MD5              string
+    SHA256           string
+    MMH3             string
+    ContentType      string
+    Size             int64
+    FirstSeen        string
+    LastSeen         string
+    Target           string
+    Title            string
+    Server           string
+    Labels           string
+    RunLabels        runLabels // of the last run that saw the favicon
+    FinalURL         string
+    FinalHost        string
+    Apex             string
+    Screenshot       string
+    ScreenshotSHA256 string
+    Data             []byte
+
+    // Annotations of the favicon's host and of its hashes
+    HostTags []string
+    HashTags []string
+    Notes    []string
+}
+
+// Host part of the favicon link
+func (r record) host() string {
+    u, err := url.Parse(r.Link)
+    if err != nil {
+        return ""
+    }
+    return u.Hostname()
+}
+
+// Tags of the record's host and hashes together
+func (r record) tags() []string {
+    return uniqueSorted(append(append([]string{}, r.HostTags...), r.HashTags...))
+}
+
+// Registrable domain the record belongs to: the stored apex of its final
+// page, else the apex of the favicon host
+func (r record) apex() string {
+    if r.Apex != "" {
+        return r.Apex
+    }
+    return apexDomain(r.host())
+}
+
+// A row of the change history
+type historyEntry struct {
+    Link   string
+    MD5    string
+    SHA256 string
+    SeenAt string
+    RunID  int64
+}
+
+// A recorded scan pass
+type runEntry struct {
+    ID         int64
+    Name       string
+    StartedAt  string
+    FinishedAt string
+    Targets    int
+    Favicons   int
+    Errors     int
+    Skipped    int
+    Deferred   int
+    Status     string
+    Labels     runLabels
+}
+
+// Load every favicon stored in a workspace, optionally with the raw icon bytes
+func loadRecords(db *sql.DB, workspace string, withData bool) ([]record, error) {
+    return queryRecords(db, workspace, withData, "")
+}
+
+// Load the favicons of a workspace matching a WHERE clause over favicons f and favicon_blobs b
+func queryRecords(db *sql.DB, workspace string, withData bool, where string, args ...interface{}) ([]record, error) {
+    return selectRecords(db, workspace, withData, where, "f.link", 0, args...)
+}
+
+// Load at most limit favicons (0 for all) matching a WHERE clause, in the given order
+func selectRecords(db *sql.DB, workspace string, withData bool, where, orderBy string, limit int, args ...interface{}) ([]record, error) {
+    data := "NULL, NULL"
+    if withData {
+        data = "b.data, b.encoding"
+    }
+    query := `SELECT f.link, f.md5, f.sha256, COALESCE(b.mmh3, ''), COALESCE(b.content_type, ''), COALESCE(b.size, 0),
+        COALESCE(f.first_seen, ''), COALESCE(f.last_seen, ''), COALESCE(f.target, ''), COALESCE(f.title, ''), COALESCE(f.server, ''), COALESCE(f.labels, ''),
+        COALESCE(f.final_url, ''), COALESCE(f.final_host, ''), COALESCE(f.apex, ''), COALESCE(f.screenshot, ''), COALESCE(f.screenshot_sha256, ''), COALESCE(f.run_labels, ''), ` + data + `
+        FROM favicons f LEFT JOIN favicon_blobs b ON b.sha256 = f.sha256 WHERE f.workspace = ?`
+    if where != "" {
+        query += " AND (" + where + ")"
+    }
+    query += " ORDER BY " + orderBy
+    params := append([]interface{}{workspace}, args...)
+    if limit > 0 {
+        query += " LIMIT ?"
+        params = append(params, limit)
+    }
+
+    rows, err := db.Query(query, params...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var records []record
+    for rows.Next() {
+        var r record
+        var runLabels string
+        var encoding sql.NullString
+        if err := rows.Scan(&r.Link, &r.MD5, &r.SHA256, &r.MMH3, &r.ContentType, &r.Size, &r.FirstSeen, &r.LastSeen, &r.Target, &r.Title, &r.Server, &r.Labels, &r.FinalURL, &r.FinalHost, &r.Apex, &r.Screenshot, &r.ScreenshotSHA256, &runLabels, &r.Data, &encoding); err != nil {
+            return nil, err
+        }
+        r.RunLabels = decodeRunLabels(runLabels)
+        if r.Data, err = decodeBlob(r.Data, encoding.String); err != nil {
+            return nil, fmt.Errorf("decoding icon %s: %v", r.SHA256, err)
+        }
+        records = append(records, r)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    a, err := loadAnnotations(db, workspace)
+    if err != nil {
+        return nil, fmt.Errorf("loading tags and notes: %v", err)
+    }
+    for i := range records {
+        a.apply(&records[i])
+    }
+    return records, nil
+}
+
+// Load the change history of a workspace, newest first
+func loadHistory(db *sql.DB, workspace string) ([]historyEntry, error) {
+    rows, err := db.Query("SELECT link, md5, sha256, seen_at, COALESCE(run_id, 0) FROM history WHERE workspace = ? ORDER BY seen_at DESC, id DESC", workspace)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var entries []historyEntry
+    for rows.Next() {
+        var e historyEntry
+        if err := rows.Scan(&e.Link, &e.MD5, &e.SHA256, &e.SeenAt, &e.RunID); err != nil {
+            return nil, err
+        }
+        entries = append(entries, e)
+    }
+    return entries, rows.Err()
+}
+
+// Load every run recorded in a workspace, oldest first
+func loadRuns(db *sql.DB, workspace string) ([]runEntry, error) {
+    return selectRuns(db, workspace, "", "started_at, id", 0)
+}
+
+// Load at most limit runs (0 for all) matching a WHERE clause, in the given order
+func selectRuns(db *sql.DB, workspace, where, orderBy string, limit int, args ...interface{}) ([]runEntry, error) {
+    query := `SELECT id, COALESCE(name, ''), COALESCE(started_at, ''), COALESCE(finished_at, ''), targets, favicons, errors, skipped, deferred, COALESCE(status, ''), COALESCE(labels, '')
+        FROM runs WHERE workspace = ?`
+    if where != "" {
+        query += " AND (" + where + ")"
+    }
+    query += " ORDER BY " + orderBy
+    params := append([]interface{}{workspace}, args...)
+    if limit > 0 {
+        query += " LIMIT ?"
+        params = append(params, limit)
+    }
+    rows, err := db.Query(query, params...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var runs []runEntry
+    for rows.Next() {
+        var r runEntry
+        var labels string
+        if err := rows.Scan(&r.ID, &r.Name, &r.StartedAt, &r.FinishedAt, &r.Targets, &r.Favicons, &r.Errors, &r.Skipped, &r.Deferred, &r.Status, &labels); err != nil {
+            return nil, err
+        }
+        r.Labels = decodeRunLabels(labels)
+        runs = append(runs, r)
+    }
+    return runs, rows.Err()
+}
+
+// Group records by SHA256, preserving first-seen order
+func groupByHash(records []record) ([]string, map[string][]record) {
+    var order []string
+    groups := map[string][]record{}
+    for _, r := range records {
+        if _, ok := groups[r.SHA256]; !ok {
+            order = append(order, r.SHA256)
+        }
+        groups[r.SHA256] = append(groups[r.SHA256], r)
+    }
+    return order, groups
+}
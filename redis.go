@@ -0,0 +1,122 @@
+package main
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "net"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Minimal Redis client speaking RESP, enough for list-based work queues
+type redisConn struct {
+    conn   net.Conn
+    reader *bufio.Reader
+}
+
+// Connect to Redis and authenticate if a password is given
+func dialRedis(addr, password string) (*redisConn, error) {
+    conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+    if err != nil {
+        return nil, err
+    }
+    r := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+    if password != "" {
+        if _, err := r.do("AUTH", password); err != nil {
+            conn.Close()
+            return nil, err
+        }
+    }
+    return r, nil
+}
+
+func (r *redisConn) Close() error {
+    return r.conn.Close()
+}
+
+// Send a command and read its reply: string, int64, []interface{} or nil
+func (r *redisConn) do(args ...string) (interface{}, error) {
+    var b strings.Builder
+    fmt.Fprintf(&b, "*%d\r\n", len(args))
+    for _, a := range args {
+        fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+    }
+    if _, err := io.WriteString(r.conn, b.String()); err != nil {
+        return nil, err
+    }
+    return r.readReply()
+}
+
+func (r *redisConn) readReply() (interface{}, error) {
+    line, err := r.reader.ReadString('\n')
+    if err != nil {
+        return nil, err
+    }
+    line = strings.TrimSuffix(line, "\r\n")
+    if line == "" {
+        return nil, fmt.Errorf("redis: empty reply")
+    }
+
+    switch line[0] {
+    case '+':
+        return line[1:], nil
+    case '-':
+        return nil, fmt.Errorf("redis: %s", line[1:])
+    case ':':
+        return strconv.ParseInt(line[1:], 10, 64)
+    case '$':
+        n, err := strconv.Atoi(line[1:])
+        if err != nil || n < 0 {
+            return nil, err
+        }
+        buf := make([]byte, n+2)
+        if _, err := io.ReadFull(r.reader, buf); err != nil {
+            return nil, err
+        }
+        return string(buf[:n]), nil
+    case '*':
+        n, err := strconv.Atoi(line[1:])
+        if err != nil || n < 0 {
+            return nil, err
+        }
+        items := make([]interface{}, n)
+        for i := range items {
+            if items[i], err = r.readReply(); err != nil {
+                return nil, err
+            }
+        }
+        return items, nil
+    }
+    return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+// Push values onto the head of a list
+func (r *redisConn) lpush(key string, values ...string) error {
+    _, err := r.do(append([]string{"LPUSH", key}, values...)...)
+    return err
+}
+
+// Push values onto the tail of a list, so they are popped next
+func (r *redisConn) rpush(key string, values ...string) error {
+    _, err := r.do(append([]string{"RPUSH", key}, values...)...)
+    return err
+}
+
+// Pop from the tail of a list, waiting up to timeout; ok is false on timeout
+func (r *redisConn) brpop(key string, timeout time.Duration) (string, bool, error) {
+    r.conn.SetReadDeadline(time.Now().Add(timeout + 10*time.Second))
+    defer r.conn.SetReadDeadline(time.Time{})
+
+    reply, err := r.do("BRPOP", key, strconv.Itoa(int(timeout.Seconds())))
+    if err != nil || reply == nil {
+        return "", false, err
+    }
+    items, ok := reply.([]interface{})
+    if !ok || len(items) != 2 {
+        return "", false, fmt.Errorf("redis: unexpected BRPOP reply")
+    }
+    value, _ := items[1].(string)
+    return value, true, nil
+}
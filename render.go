@@ -0,0 +1,174 @@
+package main
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "fmt"
+    "net/http"
+    "net/url"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "github.com/chromedp/cdproto/network"
+    "github.com/chromedp/chromedp"
+)
+
+// Icon the browser displays: the last <link rel=icon> in the rendered DOM,
+// or /favicon.ico of the page's origin when there is none
+const displayedIconJS = `(() => {
+    const links = [...document.querySelectorAll('link[rel]')].filter(l => l.rel.toLowerCase().split(/\s+/).includes('icon') && l.href);
+    return links.length ? links[links.length - 1].href : new URL('/favicon.ico', location.href).href;
+})()`
+
+// Headless Chrome that loads pages with JavaScript so icons set or replaced
+// by scripts are seen. One browser is shared; each page gets its own tab.
+type renderer struct {
+    browser context.Context
+    cancel  func()
+    timeout time.Duration
+    wait    time.Duration
+
+    // Where page screenshots are saved; empty takes none
+    screenshotDir string
+}
+
+// Start the browser; execPath is empty to look for Chrome or Chromium on PATH
+func newRenderer(execPath string, timeout, wait time.Duration) (*renderer, error) {
+    opts := chromedp.DefaultExecAllocatorOptions[:]
+    if execPath != "" {
+        opts = append(opts, chromedp.ExecPath(execPath))
+    }
+    alloc, cancelAlloc := chromedp.NewExecAllocator(context.Background(), opts...)
+    ctx, cancelBrowser := chromedp.NewContext(alloc)
+    cancel := func() {
+        cancelBrowser()
+        cancelAlloc()
+    }
+    // Running no actions launches the browser
+    if err := chromedp.Run(ctx); err != nil {
+        cancel()
+        return nil, fmt.Errorf("starting browser: %v", err)
+    }
+    return &renderer{browser: ctx, cancel: cancel, timeout: timeout, wait: wait}, nil
+}
+
+// Load a page in a new tab and return the icon it displays, its title, the
+// Server header of the document and where the page ended up
+func (r *renderer) render(ctx context.Context, pageURL string) (page, error) {
+    tab, cancel := chromedp.NewContext(r.browser)
+    defer cancel()
+    tab, cancelTimeout := context.WithTimeout(tab, r.timeout)
+    defer cancelTimeout()
+    // The tab lives under the browser's context, so follow ctx by hand
+    go func() {
+        select {
+        case <-ctx.Done():
+            cancel()
+        case <-tab.Done():
+        }
+    }()
+
+    var actions []chromedp.Action
+    if headers := renderHeaders(targetFrom(ctx)); len(headers) > 0 {
+        actions = append(actions, network.Enable(), network.SetExtraHTTPHeaders(headers))
+    }
+    actions = append(actions, chromedp.Navigate(pageURL))
+    resp, err := chromedp.RunResponse(tab, actions...)
+    if err != nil {
+        return page{}, err
+    }
+    if resp.Status != 200 {
+        return page{}, fmt.Errorf("error: status code %d", resp.Status)
+    }
+    p := page{Header: http.Header{}}
+    for name, value := range resp.Headers {
+        if s, ok := value.(string); ok {
+            // Chrome joins repeated headers with newlines
+            for _, v := range strings.Split(s, "\n") {
+                p.Header.Add(name, v)
+            }
+        }
+    }
+    p.Server = p.Header.Get("Server")
+
+    // Give scripts a moment to swap the icon after load
+    var icon string
+    err = chromedp.Run(tab, chromedp.Sleep(r.wait), chromedp.Evaluate(displayedIconJS, &icon), chromedp.Title(&p.Title), chromedp.Location(&p.FinalURL), chromedp.OuterHTML("head", &p.Head, chromedp.ByQuery))
+    if err != nil {
+        return page{}, err
+    }
+    p.Links, p.Title = []string{icon}, strings.Join(strings.Fields(p.Title), " ")
+    if r.screenshotDir != "" {
+        if p.Screenshot, p.ScreenshotSHA256, err = r.screenshot(tab); err != nil {
+            errorf("Error taking screenshot of %s: %v\n", pageURL, err)
+        }
+    }
+    return p, nil
+}
+
+// Capture the tab's viewport as a PNG named after its SHA256, so identical
+// pages share one file, and return its path and hash
+func (r *renderer) screenshot(tab context.Context) (string, string, error) {
+    var shot []byte
+    if err := chromedp.Run(tab, chromedp.CaptureScreenshot(&shot)); err != nil {
+        return "", "", err
+    }
+    sum := sha256.Sum256(shot)
+    hash := hex.EncodeToString(sum[:])
+    path := filepath.Join(r.screenshotDir, hash+".png")
+    if _, err := os.Stat(path); err == nil {
+        return path, hash, nil
+    }
+    if err := os.WriteFile(path, shot, 0o644); err != nil {
+        return "", "", err
+    }
+    return path, hash, nil
+}
+
+// Extra request headers for a target's headers and cookies
+func renderHeaders(t target) network.Headers {
+    headers := network.Headers{}
+    for name, value := range t.Headers {
+        headers[name] = value
+    }
+    var cookies []string
+    for name, value := range t.Cookies {
+        cookies = append(cookies, name+"="+value)
+    }
+    if len(cookies) > 0 {
+        headers["Cookie"] = strings.Join(cookies, "; ")
+    }
+    return headers
+}
+
+// Close the browser
+func (r *renderer) close() {
+    r.cancel()
+}
+
+// Decode an inline data: icon, which scripts often build from a canvas
+func decodeDataURL(link string) (favicon, error) {
+    icon := favicon{URL: link}
+    meta, payload, ok := strings.Cut(strings.TrimPrefix(link, "data:"), ",")
+    if !ok {
+        return icon, fmt.Errorf("malformed data URL")
+    }
+    var err error
+    if strings.HasSuffix(meta, ";base64") {
+        meta = strings.TrimSuffix(meta, ";base64")
+        icon.Data, err = base64.StdEncoding.DecodeString(payload)
+    } else {
+        var text string
+        text, err = url.PathUnescape(payload)
+        icon.Data = []byte(text)
+    }
+    if err != nil {
+        return icon, err
+    }
+    icon.ContentType = meta
+    return icon, nil
+}
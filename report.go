@@ -0,0 +1,247 @@
+package main
+
+import (
+    "encoding/base64"
+    "flag"
+    "fmt"
+    "html/template"
+    "os"
+    "strings"
+    "time"
+)
+
+// Data rendered into reports
+type reportData struct {
+    Generated string
+    Hosts     []reportHost
+    Hashes    []reportHash
+    History   []historyEntry
+}
+
+// One favicon link in a report
+type reportHost struct {
+    record
+    Tech      string
+    Thumbnail template.URL
+    Tags      string
+    Labels    string // run labels
+}
+
+// One distinct favicon in a report
+type reportHash struct {
+    SHA256    string
+    MD5       string
+    MMH3      string
+    Tech      string
+    Thumbnail template.URL
+    Tags      string
+    Labels    string // run labels of every link
+    Links     []string
+}
+
+// Build a data: URI so the report has no external dependencies
+func thumbnailURI(contentType string, data []byte) template.URL {
+    if len(data) == 0 {
+        return ""
+    }
+    if !strings.HasPrefix(contentType, "image/") {
+        contentType = "image/x-icon"
+    }
+    return template.URL("data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data))
+}
+
+// Collect everything a report shows from the database, leaving out stock
+// icons when a stock list is given
+func buildReport(dbOpts *dbOptions, fps, stock fingerprints) (reportData, error) {
+    db, err := dbOpts.open()
+    if err != nil {
+        return reportData{}, err
+    }
+    defer db.Close()
+
+    records, err := loadRecords(db, dbOpts.workspace, true)
+    if err != nil {
+        return reportData{}, err
+    }
+    history, err := loadHistory(db, dbOpts.workspace)
+    if err != nil {
+        return reportData{}, err
+    }
+    detected, err := loadTechnologies(db, dbOpts.workspace, "", 0)
+    if err != nil {
+        return reportData{}, err
+    }
+    stored := map[string][]string{}
+    for _, t := range detected {
+        stored[t.Host] = append(stored[t.Host], t.Technology)
+    }
+    if stock != nil {
+        defaults := map[string]bool{}
+        for _, r := range records {
+            if isDefaultIcon(r, stock) {
+                defaults[r.SHA256] = true
+            }
+        }
+        records = withoutDefaults(records, stock)
+        var kept []historyEntry
+        for _, h := range history {
+            if !defaults[h.SHA256] {
+                kept = append(kept, h)
+            }
+        }
+        history = kept
+    }
+
+    data := reportData{Generated: time.Now().UTC().Format(time.RFC3339), History: history}
+    for _, r := range records {
+        data.Hosts = append(data.Hosts, reportHost{
+            record:    r,
+            Tech:      reportTech(fps.identify(r.MD5, r.SHA256, r.MMH3), stored[observedHost(r.Target, r.Link)]),
+            Thumbnail: thumbnailURI(r.ContentType, r.Data),
+            Tags:      strings.Join(r.tags(), ", "),
+            Labels:    strings.Join(r.RunLabels.pairs(), ", "),
+        })
+    }
+
+    order, groups := groupByHash(records)
+    for _, sha := range order {
+        group := groups[sha]
+        var tech []string
+        for _, r := range group {
+            tech = append(tech, stored[observedHost(r.Target, r.Link)]...)
+        }
+        h := reportHash{
+            SHA256:    sha,
+            MD5:       group[0].MD5,
+            MMH3:      group[0].MMH3,
+            Tech:      reportTech(fps.identify(group[0].MD5, sha, group[0].MMH3), tech),
+            Thumbnail: thumbnailURI(group[0].ContentType, group[0].Data),
+            Tags:      strings.Join(group[0].HashTags, ", "),
+            Labels:    strings.Join(recordRunLabels(group), ", "),
+        }
+        for _, r := range group {
+            h.Links = append(h.Links, r.Link)
+        }
+        data.Hashes = append(data.Hashes, h)
+    }
+    return data, nil
+}
+
+// The fingerprint match and the technologies detected on the host, sorted
+// and without repeats
+func reportTech(match string, stored []string) string {
+    if match != "" {
+        stored = append([]string{match}, stored...)
+    }
+    return strings.Join(uniqueSorted(stored), ", ")
+}
+
+var htmlReport = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>MAPLINK report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; font-size: 13px; }
+th { background: #eee; }
+td.hash { font-family: monospace; }
+img { width: 32px; height: 32px; }
+</style>
+</head>
+<body>
+<h1>MAPLINK report</h1>
+<p>Generated {{.Generated}} &middot; {{len .Hosts}} favicon links &middot; {{len .Hashes}} unique favicons</p>
+
+<h2>Hosts</h2>
+<table>
+<tr><th>Icon</th><th>Link</th><th>Screenshot</th><th>Technology</th><th>Tags</th><th>Run labels</th><th>MD5</th><th>SHA256</th><th>MMH3</th><th>Notes</th></tr>
+{{range .Hosts}}<tr><td>{{if .Thumbnail}}<img src="{{.Thumbnail}}" alt="">{{end}}</td><td>{{.Link}}</td><td>{{if .Screenshot}}<a href="{{.Screenshot}}" title="SHA256 {{.ScreenshotSHA256}}">view</a>{{end}}</td><td>{{.Tech}}</td><td>{{.Tags}}</td><td>{{.Labels}}</td><td class="hash">{{.MD5}}</td><td class="hash">{{.SHA256}}</td><td class="hash">{{.MMH3}}</td><td>{{range $i, $n := .Notes}}{{if $i}}<br>{{end}}{{$n}}{{end}}</td></tr>
+{{end}}</table>
+
+<h2>Hashes</h2>
+<table>
+<tr><th>Icon</th><th>SHA256</th><th>MD5</th><th>MMH3</th><th>Technology</th><th>Tags</th><th>Run labels</th><th>Links</th></tr>
+{{range .Hashes}}<tr><td>{{if .Thumbnail}}<img src="{{.Thumbnail}}" alt="">{{end}}</td><td class="hash">{{.SHA256}}</td><td class="hash">{{.MD5}}</td><td class="hash">{{.MMH3}}</td><td>{{.Tech}}</td><td>{{.Tags}}</td><td>{{.Labels}}</td><td>{{len .Links}}: {{range $i, $l := .Links}}{{if $i}}, {{end}}{{$l}}{{end}}</td></tr>
+{{end}}</table>
+
+<h2>Change history</h2>
+<table>
+<tr><th>Seen</th><th>Link</th><th>MD5</th><th>SHA256</th></tr>
+{{range .History}}<tr><td>{{.SeenAt}}</td><td>{{.Link}}</td><td class="hash">{{.MD5}}</td><td class="hash">{{.SHA256}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// Generate a static report from the database
+func reportCommand(args []string) {
+    fs := flag.NewFlagSet("report", flag.ExitOnError)
+    dbOpts := dbFlags(fs)
+    output := fs.String("o", "report.html", "Output file for the report")
+    format := fs.String("format", "", "Report format: html or md (default: from the output file extension)")
+    fingerprintFile := fs.String("fingerprints", "", "CSV file of hash,technology pairs used to identify favicons")
+    excludeDefaults := fs.Bool("exclude-defaults", false, "Leave out stock icons: those tagged default or on the stock list")
+    defaultIcons := fs.String("default-icons", "", "CSV file of hash,name pairs of stock icons, besides the built-in list")
+    signKey := registerSignFlag(fs)
+    parseFlags(fs, args)
+
+    sg, err := outputSigner(*signKey, *output)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error loading signing key: %v\n", err)
+        return
+    }
+
+    if *format == "" {
+        *format = "html"
+        if strings.HasSuffix(strings.ToLower(*output), ".md") {
+            *format = "md"
+        }
+    }
+    if *format != "html" && *format != "md" {
+        fmt.Fprintf(os.Stderr, "Unknown report format %q (use html or md)\n", *format)
+        return
+    }
+
+    fps, err := loadFingerprints(*fingerprintFile)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error loading fingerprints: %v\n", err)
+        return
+    }
+
+    var stock fingerprints
+    if *excludeDefaults {
+        if stock, err = loadStockIcons(*defaultIcons); err != nil {
+            fmt.Fprintf(os.Stderr, "Error loading default icons: %v\n", err)
+            return
+        }
+    }
+
+    data, err := buildReport(dbOpts, fps, stock)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error reading database: %v\n", err)
+        return
+    }
+
+    file, err := os.Create(*output)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error creating report: %v\n", err)
+        return
+    }
+
+    if *format == "md" {
+        err = markdownReport.Execute(file, data)
+    } else {
+        err = htmlReport.Execute(file, data)
+    }
+    if closeErr := file.Close(); err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error writing report: %v\n", err)
+        return
+    }
+    fmt.Printf("Report written to %s\n", *output)
+    sg.signOutput(*output)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+    "bytes"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+    "time"
+)
+
+// S3-compatible object storage client using AWS Signature Version 4.
+// Works with AWS S3, MinIO and GCS (interoperability mode with HMAC keys).
+type s3Client struct {
+    endpoint     string // scheme://host[:port]
+    region       string
+    bucket       string
+    accessKey    string
+    secretKey    string
+    sessionToken string
+}
+
+// Build a client from flags, taking credentials from the standard AWS variables
+func newS3Client(endpoint, region, bucket string) (*s3Client, error) {
+    c := &s3Client{
+        endpoint:     strings.TrimSuffix(endpoint, "/"),
+        region:       region,
+        bucket:       bucket,
+        accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+        secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+        sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+    }
+    if c.endpoint == "" {
+        c.endpoint = "https://s3." + region + ".amazonaws.com"
+    }
+    if c.accessKey == "" || c.secretKey == "" {
+        return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+    }
+    return c, nil
+}
+
+// Percent-encode a key per RFC 3986, keeping slashes
+func s3EscapePath(key string) string {
+    var b strings.Builder
+    for _, c := range []byte(key) {
+        if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+            b.WriteByte(c)
+        } else {
+            fmt.Fprintf(&b, "%%%02X", c)
+        }
+    }
+    return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+    h := hmac.New(sha256.New, key)
+    h.Write([]byte(data))
+    return h.Sum(nil)
+}
+
+// Upload an object with a path-style PUT
+func (c *s3Client) put(key, contentType string, body []byte) error {
+    path := "/" + c.bucket + "/" + s3EscapePath(strings.TrimPrefix(key, "/"))
+    u, err := url.Parse(c.endpoint + path)
+    if err != nil {
+        return err
+    }
+
+    now := time.Now().UTC()
+    amzDate := now.Format("20060102T150405Z")
+    day := now.Format("20060102")
+    payloadSum := sha256.Sum256(body)
+    payloadHash := hex.EncodeToString(payloadSum[:])
+
+    headers := map[string]string{
+        "host":                 u.Host,
+        "x-amz-content-sha256": payloadHash,
+        "x-amz-date":           amzDate,
+    }
+    signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+    if c.sessionToken != "" {
+        headers["x-amz-security-token"] = c.sessionToken
+        signed = append(signed, "x-amz-security-token")
+    }
+
+    var canonicalHeaders strings.Builder
+    for _, h := range signed {
+        canonicalHeaders.WriteString(h + ":" + headers[h] + "\n")
+    }
+    canonicalRequest := strings.Join([]string{
+        "PUT", path, "", canonicalHeaders.String(), strings.Join(signed, ";"), payloadHash,
+    }, "\n")
+    requestSum := sha256.Sum256([]byte(canonicalRequest))
+
+    scope := day + "/" + c.region + "/s3/aws4_request"
+    stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestSum[:])
+    signingKey := hmacSHA256(hmacSHA256(hmacSHA256(hmacSHA256([]byte("AWS4"+c.secretKey), day), c.region), "s3"), "aws4_request")
+    signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+    req, err := http.NewRequest("PUT", u.String(), bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    for h, v := range headers {
+        if h != "host" {
+            req.Header.Set(h, v)
+        }
+    }
+    if contentType != "" {
+        req.Header.Set("Content-Type", contentType)
+    }
+    req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+        c.accessKey, scope, strings.Join(signed, ";"), signature))
+
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+        return fmt.Errorf("error: status code %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+    }
+    return nil
+}
+
+// Archives a run to object storage: results.ndjson plus raw favicon blobs
+// under <prefix>/<run id>/. Blobs are uploaded as they arrive when streaming,
+// otherwise everything is uploaded when the run closes.
+type s3Sink struct {
+    client  *s3Client
+    prefix  string
+    stream  bool
+    results bytes.Buffer
+    pending map[string]result
+    sent    map[string]struct{}
+}
+
+func newS3Sink(client *s3Client, prefix, runID string, stream bool) *s3Sink {
+    return &s3Sink{
+        client:  client,
+        prefix:  strings.Trim(prefix, "/") + "/" + runID,
+        stream:  stream,
+        pending: map[string]result{},
+        sent:    map[string]struct{}{},
+    }
+}
+
+func (s *s3Sink) blobKey(sha string) string {
+    return s.prefix + "/blobs/" + sha
+}
+
+func (s *s3Sink) Write(r result) error {
+    line, err := json.Marshal(r)
+    if err != nil {
+        return err
+    }
+    s.results.Write(line)
+    s.results.WriteByte('\n')
+
+    if _, ok := s.sent[r.SHA256]; ok {
+        return nil
+    }
+    if !s.stream {
+        s.pending[r.SHA256] = r
+        return nil
+    }
+    if err := s.client.put(s.blobKey(r.SHA256), r.ContentType, r.Data); err != nil {
+        return err
+    }
+    s.sent[r.SHA256] = struct{}{}
+    return nil
+}
+
+// Upload outstanding blobs and the results file
+func (s *s3Sink) Close() error {
+    for sha, r := range s.pending {
+        if err := s.client.put(s.blobKey(sha), r.ContentType, r.Data); err != nil {
+            return err
+        }
+        s.sent[sha] = struct{}{}
+        delete(s.pending, sha)
+    }
+    return s.client.put(s.prefix+"/results.ndjson", "application/x-ndjson", s.results.Bytes())
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+    "database/sql"
+    "flag"
+    "fmt"
+    "net/http"
+    "os"
+    "strings"
+)
+
+// HTTP transport that hands the DNS names of every TLS certificate it is
+// shown to record, with the host that presented it
+type sanTransport struct {
+    next   http.RoundTripper
+    record func(host string, names []string)
+}
+
+func (t sanTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    resp, err := t.next.RoundTrip(req)
+    if err == nil && resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+        t.record(req.URL.Hostname(), resp.TLS.PeerCertificates[0].DNSNames)
+    }
+    return resp, err
+}
+
+// Certificate names of a host on their way to the certificate_sans table
+type sanWrite struct {
+    run   int64
+    host  string
+    names []string
+}
+
+func (op sanWrite) apply(w batchStmts, now string) {
+    for _, name := range op.names {
+        if _, err := w.san.Exec(w.workspace, op.host, name, op.run, now); err != nil {
+            errorf("Error saving certificate names of %s: %v\n", op.host, err)
+        }
+    }
+}
+
+// Names of the targets' registrable domains, within which -expand-sans
+// queues certificate names, and the hosts already targeted
+func (s *scanner) scopeSANs(targets []target) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.sanSeen, s.sanQueued, s.sanScope, s.sanQueue = map[string]bool{}, map[string]bool{}, map[string]bool{}, nil
+    for _, t := range targets {
+        if host := targetHost(t.URL); host != "" {
+            s.sanQueued[host] = true
+            s.sanScope[apexDomain(host)] = true
+        }
+    }
+}
+
+// Store the names of a host's certificate once per run and, with
+// -expand-sans, queue those in scope that no target covers yet
+func (s *scanner) recordSANs(host string, names []string) {
+    host = strings.ToLower(host)
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.sanSeen == nil || s.sanSeen[host] || len(names) == 0 {
+        return
+    }
+    s.sanSeen[host] = true
+    var clean []string
+    for _, name := range names {
+        name = strings.ToLower(strings.TrimSuffix(name, "."))
+        clean = append(clean, name)
+        // Wildcards name no host of their own
+        if !s.expandSANs || strings.HasPrefix(name, "*.") || s.sanQueued[name] || !s.sanScope[apexDomain(name)] {
+            continue
+        }
+        s.sanQueued[name] = true
+        s.sanQueue = append(s.sanQueue, name)
+        infof("Queued %s from the certificate of %s\n", name, host)
+    }
+    s.store.saveSANs(sanWrite{run: s.run, host: host, names: clean})
+}
+
+// Targets for the certificate names queued since the last call, added to
+// the run's totals
+func (s *scanner) expandedTargets(byURL map[string]target) []string {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if len(s.sanQueue) == 0 {
+        return nil
+    }
+    var urls []string
+    for _, name := range s.sanQueue {
+        t := target{URL: "https://" + name + "/"}
+        byURL[t.URL] = t
+        urls = append(urls, t.URL)
+    }
+    s.sanQueue = nil
+    s.targets += len(urls)
+    if s.bar != nil {
+        s.bar.setTotal(s.targets)
+    }
+    infof("Scanning %d hosts found in certificate names\n", len(urls))
+    return urls
+}
+
+// Certificate names stored for a workspace, optionally of one host
+func loadSANs(db *sql.DB, workspace, host string) (map[string][]string, []string, error) {
+    rows, err := db.Query(`SELECT host, name FROM certificate_sans WHERE workspace = ? AND (? = '' OR host = ?) ORDER BY host, name`,
+        workspace, host, host)
+    if err != nil {
+        return nil, nil, err
+    }
+    defer rows.Close()
+    byHost := map[string][]string{}
+    var hosts []string
+    for rows.Next() {
+        var h, name string
+        if err := rows.Scan(&h, &name); err != nil {
+            return nil, nil, err
+        }
+        if _, ok := byHost[h]; !ok {
+            hosts = append(hosts, h)
+        }
+        byHost[h] = append(byHost[h], name)
+    }
+    return byHost, hosts, rows.Err()
+}
+
+// List the names on the certificates of scanned hosts
+func sansCommand(args []string) {
+    fs := flag.NewFlagSet("sans", flag.ExitOnError)
+    dbOpts := dbFlags(fs)
+    host := fs.String("host", "", "Only names from this host's certificate")
+    namesOnly := fs.Bool("names", false, "Print each distinct name once, e.g. to feed back in with -file")
+    parseFlags(fs, args)
+
+    db, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer db.Close()
+
+    byHost, hosts, err := loadSANs(db, dbOpts.workspace, strings.ToLower(*host))
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error loading certificate names: %v\n", err)
+        return
+    }
+    seen := map[string]bool{}
+    for _, h := range hosts {
+        for _, name := range byHost[h] {
+            if !*namesOnly {
+                fmt.Printf("%s\t%s\n", h, name)
+            } else if !seen[name] {
+                seen[name] = true
+                fmt.Println(name)
+            }
+        }
+    }
+}
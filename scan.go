@@ -0,0 +1,672 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "os"
+    "strings"
+    "sync"
+    "text/template"
+    "time"
+)
+
+// State shared by every URL processed in a scan
+type scanner struct {
+    fetch    fetcher
+    hash     hasher
+    store    resultStore
+    browser  *renderer
+    alerts   *dispatcher
+    hunted   map[string]struct{} // -hunt and the watchlist
+    huntFlag map[string]struct{} // -hunt alone
+    stock    fingerprints        // stock icons, tagged default
+    summary  *summary
+    sinks    []sink
+
+    // Current run and its totals
+    runName      string
+    runLabels    runLabels // -label, on the run and everything it stores and emits
+    run          int64
+    started      time.Time
+    targets      int
+    done         int
+    favicons     int
+    found        int
+    changed      int
+    errors       int
+    showProgress bool
+    bar          *progress
+    onProgress   func(done, targets, errors int) // called under mu as targets finish
+    resume       bool
+    skipFresh    time.Duration
+    skipped      int
+    skippedIcons int
+    deferred     int
+    cache        *cacheTransport
+    lineFormat   *template.Template
+    silent       bool
+    log          *rotatingWriter
+
+    // Targets scanned at once; mu guards the totals, summary and output
+    // shared by the workers
+    workers int
+    mu      sync.Mutex
+
+    // Time limits of a pass and of each target
+    maxRuntime    time.Duration
+    targetTimeout time.Duration
+    timedOut      bool
+
+    // Scan targets in random order
+    shuffle bool
+
+    // Ports tried on bare host names, and how long each may take to connect
+    probes       []probePort
+    probeTimeout time.Duration
+
+    // Resolve every target's host name before the first fetch
+    dnsPrefilter bool
+    dnsWorkers   int
+    dnsTimeout   time.Duration
+
+    // -dns-records and -ptr, and the hosts and addresses whose records were
+    // stored this run
+    dnsRecords bool
+    reverseDNS bool
+    dnsSeen    map[string]bool
+
+    // Hosts and icons a hunted hash was alerted on this run
+    huntAlerted map[string]bool
+
+    // -host-header and -sni, for targets that do not set their own
+    hostHeader string
+    sni        string
+
+    // Per-host circuit breaker of -breaker-errors, if any
+    breaker *circuitBreaker
+
+    // The scan's own tuned copy of the base transport, and the origin layer
+    // of the fetcher's chain, which each job of serve points at a fresh clone
+    transport *http.Transport
+    origin    *originTransport
+
+    // Leave stock icons out of the output and summary
+    excludeDefaults bool
+
+    // Certificate names: hosts whose names were stored this run, names
+    // already targeted, the registrable domains of the targets and, with
+    // -expand-sans, names waiting to be scanned
+    expandSANs bool
+    sanSeen    map[string]bool
+    sanQueued  map[string]bool
+    sanScope   map[string]bool
+    sanQueue   []string
+
+    // Rules of -tech; nil when technologies are not identified
+    tech []techMatcher
+
+    // Callbacks of -script, if any
+    script *script
+
+    // Key of -sign-key, signing every result
+    signer *signer
+}
+
+// A downloaded favicon waiting to be hashed and stored
+type favicon struct {
+    Target           string `json:"target"`
+    URL              string `json:"url"`
+    FinalURL         string `json:"final_url,omitempty"`
+    ContentType      string `json:"content_type,omitempty"`
+    Title            string `json:"title,omitempty"`
+    Server           string `json:"server,omitempty"`
+    ETag             string `json:"etag,omitempty"`
+    LastModified     string `json:"last_modified,omitempty"`
+    NotModified      bool     `json:"not_modified,omitempty"`
+    Labels           []string `json:"labels,omitempty"`
+    Screenshot       string `json:"screenshot,omitempty"`
+    ScreenshotSHA256 string `json:"screenshot_sha256,omitempty"`
+    Data             []byte   `json:"data"`
+}
+
+// Close every output sink, flushing buffered results
+func (s *scanner) close() {
+    s.store.close()
+    s.alerts.close()
+    for _, out := range s.sinks {
+        if err := out.Close(); err != nil {
+            errorf("Error closing output: %v\n", err)
+        }
+    }
+    if s.cache != nil {
+        s.cache.Close()
+    }
+    if s.browser != nil {
+        s.browser.close()
+        s.browser = nil
+    }
+    if s.log != nil {
+        logOut = nil
+        s.log.Close()
+    }
+}
+
+// Start a run covering urls and return the ones left to scan. With resume
+// set, the most recent unfinished run is continued instead and the targets
+// it already finished are skipped.
+func (s *scanner) beginRun(urls []string) []string {
+    s.started, s.targets = time.Now(), len(urls)
+    s.done, s.favicons, s.found, s.changed, s.errors, s.skipped, s.skippedIcons, s.deferred = 0, 0, 0, 0, 0, 0, 0, 0
+    s.timedOut = false
+    s.dnsSeen = map[string]bool{}
+    s.huntAlerted = map[string]bool{}
+    s.refreshHunted()
+    pending := urls
+    if s.resume {
+        s.resume = false
+        pending = s.resumeRun(urls)
+    }
+    if s.skipFresh > 0 {
+        pending = s.skipRecent(pending)
+        s.targets -= s.skipped
+    }
+    if s.shuffle {
+        pending = shuffled(pending)
+    }
+    if s.run == 0 {
+        id, err := s.store.startRun(s.runName, s.targets, s.runLabels)
+        if err != nil {
+            errorf("Error recording run: %v\n", err)
+        }
+        s.run = id
+    }
+    if s.showProgress {
+        s.bar = newProgress(os.Stderr, s.targets, s.done, 200*time.Millisecond)
+    }
+    return pending
+}
+
+// Continue the most recent unfinished run, returning the targets it has not finished
+func (s *scanner) resumeRun(urls []string) []string {
+    r, finished, ok, err := s.store.unfinishedRun()
+    if err != nil {
+        errorf("Error loading checkpoint: %v\n", err)
+        return urls
+    }
+    if !ok {
+        infof("No unfinished run to resume, starting a new one.\n")
+        return urls
+    }
+    if err := s.store.reopenRun(r.ID, len(urls)); err != nil {
+        errorf("Error recording run: %v\n", err)
+        return urls
+    }
+
+    s.run, s.favicons, s.errors = r.ID, r.Favicons, r.Errors
+    // The rest of a run is labelled as its start was
+    s.runLabels = r.Labels
+    var pending []string
+    for _, u := range urls {
+        if _, ok := finished[u]; ok {
+            s.done++
+        } else {
+            pending = append(pending, u)
+        }
+    }
+    infof("Resuming run #%d from %s: %d of %d targets already done\n", r.ID, r.StartedAt, s.done, len(urls))
+    return pending
+}
+
+// Drop targets whose favicons were hashed within the freshness window
+func (s *scanner) skipRecent(urls []string) []string {
+    recent, err := s.store.recentTargets(time.Now().Add(-s.skipFresh))
+    if err != nil {
+        errorf("Error checking recently scanned targets: %v\n", err)
+        return urls
+    }
+    var pending []string
+    for _, u := range urls {
+        if _, ok := recent[u]; ok {
+            s.skipped++
+        } else {
+            pending = append(pending, u)
+        }
+    }
+    if s.skipped > 0 {
+        infof("Skipping %d targets scanned within %s\n", s.skipped, s.skipFresh)
+    }
+    return pending
+}
+
+// Close the current run row with its totals and print a summary; the run
+// counts as interrupted when ctx was cancelled
+func (s *scanner) endRun(ctx context.Context) {
+    if s.bar != nil {
+        s.bar.close()
+        s.bar = nil
+    }
+    status := runCompleted
+    switch {
+    case ctx.Err() != nil:
+        status = runInterrupted
+    case s.timedOut:
+        status = runTimedOut
+    }
+    elapsed := time.Since(s.started).Round(time.Millisecond)
+    skipped := ""
+    if s.skipped > 0 {
+        skipped = fmt.Sprintf(", %d skipped", s.skipped)
+    }
+    if s.skippedIcons > 0 {
+        skipped += fmt.Sprintf(", %d favicons skipped", s.skippedIcons)
+    }
+    if s.deferred > 0 {
+        skipped += fmt.Sprintf(", %d deferred", s.deferred)
+    }
+    infof("Scanned %d/%d targets in %s: %d favicons (%d new, %d changed), %d errors%s, %s\n",
+        s.done, s.targets, elapsed, s.favicons, s.found, s.changed, s.errors, skipped, status)
+
+    if s.run == 0 {
+        return
+    }
+    if err := s.store.finishRun(s.run, s.targets, s.favicons, s.errors, s.skipped, s.deferred, status); err != nil {
+        errorf("Error recording run: %v\n", err)
+    }
+    s.run = 0
+}
+
+// Count a finished target towards the run's progress and checkpoint it
+func (s *scanner) targetDone(target string) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.done++
+    if s.run != 0 {
+        s.store.checkpointTarget(s.run, target)
+    }
+    if s.bar != nil {
+        s.bar.update(s.done, s.errors)
+    }
+    if s.onProgress != nil {
+        s.onProgress(s.done, s.targets, s.errors)
+    }
+}
+
+// Note a failed fetch in the summary and the run totals
+func (s *scanner) fail(url string, err error) {
+    s.failTarget(target{URL: url}, url, err)
+}
+
+// Note a failed fetch of url while scanning t, recording it in the errors
+// table for retry-failed. Favicons skipped by the preflight or size limit
+// are recorded as such instead.
+func (s *scanner) failTarget(t target, url string, err error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    var skip *iconSkipped
+    if errors.As(err, &skip) {
+        s.skippedIcons++
+        s.store.skipIcon(iconSkip{run: s.run, link: url, target: skip.target, reason: skip.reason, code: skip.code, status: skip.status,
+            contentType: skip.contentType, size: skip.size})
+        return
+    }
+    s.errors++
+    s.summary.addError(url, err)
+    op := errorWrite{run: s.run, target: t.URL, url: url, category: errorCategory(err), message: err.Error()}
+    if t.hasOverrides() {
+        if spec, err := json.Marshal(t); err == nil {
+            op.spec = string(spec)
+        }
+    }
+    s.store.saveError(op)
+}
+
+// Validators stored for a favicon link, used to make rescans conditional
+func (s *scanner) validators(link string) validators {
+    prev, _, err := s.store.lookup(link)
+    if err != nil {
+        return validators{}
+    }
+    return validators{ETag: prev.ETag, LastModified: prev.LastModified}
+}
+
+// Fetch a page and download every favicon it references, reporting
+// failures through onError. Requests cut short by ctx are not errors.
+// cond, if set, supplies validators for conditional favicon requests. The
+// target attached to ctx, if any, supplies headers, cookies, proxy and labels.
+func downloadFavicons(ctx context.Context, f fetcher, baseURL string, cond func(link string) validators, onError func(url string, err error)) []favicon {
+    // Fetch the page and find its favicon links
+    p, err := f.fetchPage(ctx, baseURL)
+    if ctx.Err() != nil {
+        return nil
+    }
+    if err != nil {
+        errorf("Error fetching HTML: %v\n", err)
+        onError(baseURL, err)
+        return nil
+    }
+    for _, link := range p.Capped {
+        onError(link, &iconSkipped{target: baseURL, reason: "page_limit", size: -1})
+    }
+    if len(p.Capped) > 0 {
+        infof("Skipping %d more favicon links over -max-page-icons.\n", len(p.Capped))
+    }
+    links, probing := p.Links, false
+    if len(links) == 0 && len(p.Probes) > 0 {
+        infof("No favicon.ico links found, trying %d common paths.\n", len(p.Probes))
+        links, probing = p.Probes, true
+    }
+    if len(links) == 0 {
+        infof("No favicon.ico links found.\n")
+        return nil
+    }
+
+    // Download each favicon link
+    var icons []favicon
+    for _, fullURL := range links {
+        var icon favicon
+        if strings.HasPrefix(fullURL, "data:") {
+            // Inline icons have no location of their own; store them under the page
+            icon, err = decodeDataURL(fullURL)
+            icon.URL = baseURL + "#inline-icon"
+        } else {
+            var v validators
+            if cond != nil {
+                v = cond(fullURL)
+            }
+            icon, err = f.fetchFavicon(ctx, fullURL, v)
+        }
+        if ctx.Err() != nil {
+            return icons
+        }
+        // Most guessed paths do not exist; only icons found there count
+        if probing && (err != nil || !looksLikeIcon(icon)) {
+            continue
+        }
+        var skip *iconSkipped
+        if errors.As(err, &skip) {
+            infof("Skipping favicon %s: %v\n", fullURL, err)
+            skip.target = baseURL
+            onError(fullURL, skip)
+            continue
+        }
+        if err != nil {
+            errorf("Error calculating hash for %s: %v\n", fullURL, err)
+            onError(fullURL, err)
+            continue
+        }
+        icon.Target, icon.FinalURL, icon.Title, icon.Server, icon.Labels = baseURL, p.FinalURL, p.Title, p.Server, targetFrom(ctx).Labels
+        icon.Screenshot, icon.ScreenshotSHA256 = p.Screenshot, p.ScreenshotSHA256
+        icons = append(icons, icon)
+    }
+    return icons
+}
+
+// What a fetched page says about its favicons
+type page struct {
+    Links    []string // favicon links, resolved to absolute URLs
+    Title    string
+    Server   string
+    FinalURL string      // where HTTP and client-side redirects ended up
+    Head     string      // HTML of the document head, for -script
+    Header   http.Header // response headers of the final page
+    Probes   []string    // -icon-paths to try when Links is empty
+    Capped   []string    // links past -max-page-icons, lowest priority last
+
+    // Screenshot of the rendered page with -screenshot-dir
+    Screenshot       string
+    ScreenshotSHA256 string
+}
+
+// Fetch a page and collect its favicon links, title and Server header.
+// With -render the page is loaded in the browser and the icon it displays
+// is returned.
+func (f *httpFetcher) fetchPage(ctx context.Context, baseURL string) (page, error) {
+    if f.browser != nil {
+        p, err := f.browser.render(ctx, baseURL)
+        p.Probes = f.iconProbes(p)
+        return p, err
+    }
+
+    htmlContent, header, pageURL, err := f.fetchHTML(ctx, baseURL)
+    if err != nil {
+        return page{}, err
+    }
+
+    // Follow meta refresh and script redirects off interstitial pages
+    visited := map[string]bool{baseURL: true, pageURL: true}
+    for hops := 0; hops < f.maxRedirects; hops++ {
+        next := redirectURL(pageURL, extractRedirect(htmlContent))
+        if next == "" || visited[next] {
+            break
+        }
+        visited[next] = true
+        infof("Following redirect to %s\n", next)
+        content, h, final, err := f.fetchHTML(ctx, next)
+        if err != nil {
+            // Keep what the last page had
+            errorf("Error following redirect to %s: %v\n", next, err)
+            break
+        }
+        pageURL, htmlContent, header = final, content, h
+        visited[final] = true
+    }
+    base := baseURL
+    if pageURL != baseURL {
+        base = urlOrigin(pageURL)
+    }
+
+    // A <base href> changes what relative links point at
+    docBase := ""
+    if href := extractBaseHref(htmlContent); href != "" {
+        docBase = resolveReference(pageURL, href)
+    }
+    p := page{Title: extractTitle(htmlContent), Server: header.Get("Server"), FinalURL: pageURL, Head: htmlContent, Header: header}
+    for _, link := range extractFaviconLinks(htmlContent) {
+        if docBase != "" {
+            p.Links = append(p.Links, resolveReference(docBase, link))
+        } else {
+            p.Links = append(p.Links, resolveLink(base, link))
+        }
+    }
+    if f.maxPageIcons > 0 && len(p.Links) > f.maxPageIcons {
+        p.Links, p.Capped = p.Links[:f.maxPageIcons], p.Links[f.maxPageIcons:]
+    }
+    p.Probes = f.iconProbes(p)
+    return p, nil
+}
+
+// URLs of -icon-paths on the page's final origin, if it declares no favicon
+func (f *httpFetcher) iconProbes(p page) []string {
+    if len(p.Links) > 0 || p.FinalURL == "" {
+        return nil
+    }
+    var probes []string
+    for _, path := range f.iconPaths {
+        probes = append(probes, resolveLink(urlOrigin(p.FinalURL), path))
+    }
+    return probes
+}
+
+// Whether a guessed path served an icon rather than an error or soft 404 page
+func looksLikeIcon(icon favicon) bool {
+    if icon.NotModified {
+        return true
+    }
+    return len(icon.Data) > 0 && (strings.HasPrefix(icon.ContentType, "image/") || strings.HasPrefix(http.DetectContentType(icon.Data), "image/"))
+}
+
+// Hash a downloaded favicon, raise alerts and store it
+func (s *scanner) record(icon favicon) {
+    s.recordHashed(icon, s.hash.hash(icon.Data), newEncodedBlob(icon.Data))
+}
+
+// Raise alerts for and store a favicon whose hashes and stored bytes are
+// already computed
+func (s *scanner) recordHashed(icon favicon, hashes iconHashes, blob encodedBlob) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    fullURL, data, contentType := icon.URL, icon.Data, icon.ContentType
+
+    // Compare with what was stored before to detect changes and new hunted hosts
+    prev, known, err := s.store.lookup(fullURL)
+    if err != nil {
+        errorf("Error reading previous hashes for %s: %v\n", fullURL, err)
+    }
+    if icon.NotModified && known {
+        s.recordNotModified(icon, prev)
+        return
+    }
+    oldMD5, oldSHA256 := prev.MD5, prev.SHA256
+
+    final := locate(icon.FinalURL)
+    md5Hash, sha256Hash := hashes.MD5, hashes.SHA256
+
+    event := notification{Link: fullURL, MD5: md5Hash, SHA256: sha256Hash, MMH3: hashes.MMH3, OldMD5: oldMD5, OldSHA256: oldSHA256}
+    changed := known && (oldMD5 != md5Hash || oldSHA256 != sha256Hash)
+    stock := s.stock.identify(md5Hash, sha256Hash, hashes.MMH3)
+    switch {
+    case !known:
+        s.found++
+        if stock == "" || !s.excludeDefaults {
+            s.summary.addFinding(event)
+        }
+    case changed:
+        s.changed++
+        event.Event = eventChanged
+        s.summary.addChange(event)
+        s.alerts.send(event)
+    }
+    // One alert per host and icon in a run, however many of its hashes are hunted
+    if match := s.huntedHash(md5Hash, sha256Hash, hashes.MMH3); match != "" && (!known || changed) {
+        key := record{Link: fullURL}.host() + " " + sha256Hash
+        if !s.huntAlerted[key] {
+            s.huntAlerted[key] = true
+            event.Event = eventHunted
+            event.Match = match
+            s.alerts.send(event)
+        }
+    }
+
+    // Save to database
+    s.favicons++
+    s.store.save(faviconWrite{
+        link:         fullURL,
+        target:       icon.Target,
+        title:        icon.Title,
+        server:       icon.Server,
+        labels:       strings.Join(icon.Labels, ","),
+        runLabels:    s.runLabels.encode(),
+        final:        final,
+        etag:         icon.ETag,
+        lastModified: icon.LastModified,
+        screenshot:   screenshotRef{path: icon.Screenshot, sha256: icon.ScreenshotSHA256},
+        hashes:       hashes,
+        contentType:  contentType,
+        data:         data,
+        stored:       blob.data,
+        encoding:     blob.encoding,
+        history:      !known || changed,
+        run:          s.run,
+    })
+    if stock != "" && (!known || changed) {
+        s.store.tagDefault(sha256Hash)
+    }
+
+    // Emit to output sinks
+    status := "unchanged"
+    if !known {
+        status = "new"
+    } else if changed {
+        status = "changed"
+    }
+    res := result{
+        Target:           icon.Target,
+        URL:              fullURL,
+        Host:             record{Link: fullURL}.host(),
+        MD5:              md5Hash,
+        SHA256:           sha256Hash,
+        MMH3:             hashes.MMH3,
+        ContentType:      contentType,
+        Title:            icon.Title,
+        Server:           icon.Server,
+        Labels:           icon.Labels,
+        RunLabels:        s.runLabels,
+        FinalURL:         final.URL,
+        FinalHost:        final.Host,
+        Apex:             final.Apex,
+        Size:             len(data),
+        Status:           status,
+        Match:            s.huntedHash(md5Hash, sha256Hash, hashes.MMH3),
+        Default:          stock,
+        Screenshot:       icon.Screenshot,
+        ScreenshotSHA256: icon.ScreenshotSHA256,
+        Timestamp:        time.Now().UTC(),
+        Data:             data,
+    }
+    s.emit(res)
+}
+
+// A 304 response: the stored favicon is still current, so only refresh last_seen
+func (s *scanner) recordNotModified(icon favicon, prev storedFavicon) {
+    s.favicons++
+    final := locate(icon.FinalURL)
+    s.store.touch(faviconTouch{link: icon.URL, target: icon.Target, title: icon.Title, server: icon.Server, labels: strings.Join(icon.Labels, ","), runLabels: s.runLabels.encode(),
+        final: final, screenshot: screenshotRef{path: icon.Screenshot, sha256: icon.ScreenshotSHA256}, sha256: prev.SHA256, run: s.run})
+    s.emit(result{
+        Target:           icon.Target,
+        URL:              icon.URL,
+        Host:             record{Link: icon.URL}.host(),
+        MD5:              prev.MD5,
+        SHA256:           prev.SHA256,
+        MMH3:             prev.MMH3,
+        ContentType:      prev.ContentType,
+        Title:            icon.Title,
+        Server:           icon.Server,
+        Labels:           icon.Labels,
+        RunLabels:        s.runLabels,
+        FinalURL:         final.URL,
+        FinalHost:        final.Host,
+        Apex:             final.Apex,
+        Size:             prev.Size,
+        Status:           "unchanged",
+        NotModified:      true,
+        Match:            s.huntedHash(prev.MD5, prev.SHA256, prev.MMH3),
+        Default:          s.stock.identify(prev.MD5, prev.SHA256, prev.MMH3),
+        Screenshot:       icon.Screenshot,
+        ScreenshotSHA256: icon.ScreenshotSHA256,
+        Timestamp:        time.Now().UTC(),
+    })
+}
+
+// First of the hashes that is on the hunt list
+func (s *scanner) huntedHash(hashes ...string) string {
+    for _, h := range hashes {
+        if _, ok := s.hunted[h]; ok {
+            return h
+        }
+    }
+    return ""
+}
+
+// Print a result and write it to every output sink
+func (s *scanner) emit(res result) {
+    if res.Default != "" && s.excludeDefaults {
+        return
+    }
+    res, keep := s.script.mapResult(res)
+    if !keep {
+        return
+    }
+    if s.signer != nil {
+        res = s.signer.signResult(res)
+    }
+    s.printResult(res)
+    for _, out := range s.sinks {
+        if err := out.Write(res); err != nil {
+            errorf("Error writing result for %s: %v\n", res.URL, err)
+        }
+    }
+}
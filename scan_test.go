@@ -0,0 +1,272 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "sync"
+    "testing"
+    "time"
+)
+
+// Bytes served as a favicon: a PNG signature and then anything
+var testIcon = append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{1, 2, 3, 4}, 64)...)
+
+// In-memory resultStore keeping what a scan saves and looks up
+type fakeStore struct {
+    mu       sync.Mutex
+    stored   map[string]storedFavicon
+    lookups  []string
+    saved    []faviconWrite
+    skipped  []iconSkip
+    failures []errorWrite
+    runs     int64
+}
+
+func newFakeStore() *fakeStore {
+    return &fakeStore{stored: map[string]storedFavicon{}}
+}
+
+func (f *fakeStore) lookup(link string) (storedFavicon, bool, error) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.lookups = append(f.lookups, link)
+    prev, ok := f.stored[link]
+    return prev, ok, nil
+}
+
+func (f *fakeStore) save(op faviconWrite) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.saved = append(f.saved, op)
+    h := op.hashes
+    f.stored[op.link] = storedFavicon{MD5: h.MD5, SHA256: h.SHA256, MMH3: h.MMH3, ContentType: op.contentType, Size: len(op.data)}
+}
+
+func (f *fakeStore) skipIcon(op iconSkip) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.skipped = append(f.skipped, op)
+}
+
+func (f *fakeStore) saveError(op errorWrite) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.failures = append(f.failures, op)
+}
+
+func (f *fakeStore) startRun(name string, targets int, labels runLabels) (int64, error) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.runs++
+    return f.runs, nil
+}
+
+func (f *fakeStore) touch(op faviconTouch)                     {}
+func (f *fakeStore) saveResolution(op resolutionWrite)         {}
+func (f *fakeStore) saveTiming(op timingWrite)                 {}
+func (f *fakeStore) tagDefault(sha256 string)                  {}
+func (f *fakeStore) saveTech(op techWrite)                     {}
+func (f *fakeStore) saveSANs(op sanWrite)                      {}
+func (f *fakeStore) saveDNSRecords(op dnsRecordsWrite)         {}
+func (f *fakeStore) saveJobResult(op *jobResultWrite)          {}
+func (f *fakeStore) sync()                                     {}
+func (f *fakeStore) checkpointTarget(run int64, target string) {}
+func (f *fakeStore) throttle(ctx context.Context)              {}
+func (f *fakeStore) close()                                    {}
+
+func (f *fakeStore) finishRun(id int64, targets, favicons, errors, skipped, deferred int, status string) error {
+    return nil
+}
+
+func (f *fakeStore) unfinishedRun() (runEntry, map[string]struct{}, bool, error) {
+    return runEntry{}, nil, false, nil
+}
+
+func (f *fakeStore) reopenRun(id int64, targets int) error {
+    return nil
+}
+
+func (f *fakeStore) recentTargets(since time.Time) (map[string]struct{}, error) {
+    return nil, nil
+}
+
+func (f *fakeStore) watchlist() (map[string]struct{}, error) {
+    return nil, nil
+}
+
+// Scanner fetching over HTTP into st, trying /favicon.ico on pages without
+// icon links
+func newTestScanner(st resultStore, timeout time.Duration) *scanner {
+    f := newHTTPFetcher(&http.Client{Transport: newOriginTransport(baseTransport.Clone()), Timeout: timeout})
+    f.iconPaths = []string{"/favicon.ico"}
+    return &scanner{fetch: f, hash: hashFunc(calculateHashes), store: st, workers: 2}
+}
+
+// Scan one target with a fresh scanner and fake store
+func scanURL(t *testing.T, url string, timeout time.Duration) *fakeStore {
+    t.Helper()
+    st := newFakeStore()
+    s := newTestScanner(st, timeout)
+    s.scanTargets(context.Background(), []target{{URL: url}})
+    return st
+}
+
+func serveIcon(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "image/png")
+    w.Write(testIcon)
+}
+
+func servePage(body string) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "text/html")
+        w.Write([]byte(body))
+    }
+}
+
+// Check that st saved exactly the test icon under link
+func expectSaved(t *testing.T, st *fakeStore, link string) faviconWrite {
+    t.Helper()
+    if len(st.saved) != 1 {
+        t.Fatalf("saved %d favicons, want 1: %+v", len(st.saved), st.saved)
+    }
+    op := st.saved[0]
+    if op.link != link {
+        t.Errorf("saved link %q, want %q", op.link, link)
+    }
+    if !bytes.Equal(op.data, testIcon) {
+        t.Errorf("saved %d bytes, want the %d of the icon", len(op.data), len(testIcon))
+    }
+    if want := calculateHashes(testIcon); op.hashes != want {
+        t.Errorf("saved hashes %+v, want %+v", op.hashes, want)
+    }
+    if !op.history {
+        t.Errorf("a new favicon was saved without history")
+    }
+    expectLookups(t, st, link)
+    return op
+}
+
+// Check that st was asked about link, for its validators and before saving,
+// and nothing else
+func expectLookups(t *testing.T, st *fakeStore, link string) {
+    t.Helper()
+    if len(st.lookups) == 0 {
+        t.Errorf("never looked up %q", link)
+    }
+    for _, l := range st.lookups {
+        if l != link {
+            t.Errorf("looked up %q, want only %q", l, link)
+        }
+    }
+}
+
+func TestScanLinkedIcon(t *testing.T) {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/", servePage(`<html><head><title>Home</title><link rel="icon" href="/static/icon.png"></head></html>`))
+    mux.HandleFunc("/static/icon.png", serveIcon)
+    srv := httptest.NewServer(mux)
+    defer srv.Close()
+
+    st := scanURL(t, srv.URL, 5*time.Second)
+    op := expectSaved(t, st, srv.URL+"/static/icon.png")
+    if op.title != "Home" {
+        t.Errorf("saved title %q, want %q", op.title, "Home")
+    }
+    if op.target != srv.URL {
+        t.Errorf("saved target %q, want %q", op.target, srv.URL)
+    }
+}
+
+func TestScanFollowsRedirects(t *testing.T) {
+    mux := http.NewServeMux()
+    mux.Handle("/", http.RedirectHandler("/home", http.StatusFound))
+    mux.HandleFunc("/home", servePage(`<link rel="shortcut icon" href="icon.png">`))
+    mux.Handle("/icon.png", http.RedirectHandler("/img/icon.png", http.StatusMovedPermanently))
+    mux.HandleFunc("/img/icon.png", serveIcon)
+    srv := httptest.NewServer(mux)
+    defer srv.Close()
+
+    st := scanURL(t, srv.URL+"/", 5*time.Second)
+    op := expectSaved(t, st, srv.URL+"/icon.png")
+    if op.final.URL != srv.URL+"/home" {
+        t.Errorf("saved final URL %q, want %q", op.final.URL, srv.URL+"/home")
+    }
+}
+
+func TestScanFallsBackToFaviconICO(t *testing.T) {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/", servePage(`<html><head><title>No icons here</title></head></html>`))
+    mux.HandleFunc("/favicon.ico", serveIcon)
+    srv := httptest.NewServer(mux)
+    defer srv.Close()
+
+    st := scanURL(t, srv.URL, 5*time.Second)
+    expectSaved(t, st, srv.URL+"/favicon.ico")
+}
+
+func TestScanSkipsNonImageBody(t *testing.T) {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/", servePage(`<link rel="icon" href="/icon.ico">`))
+    mux.HandleFunc("/icon.ico", servePage(`<!DOCTYPE html><html><body>Not found</body></html>`))
+    srv := httptest.NewServer(mux)
+    defer srv.Close()
+
+    st := scanURL(t, srv.URL, 5*time.Second)
+    if len(st.saved) != 0 {
+        t.Errorf("saved %d favicons, want none: %+v", len(st.saved), st.saved)
+    }
+    expectLookups(t, st, srv.URL+"/icon.ico")
+    if len(st.skipped) != 1 {
+        t.Fatalf("skipped %d favicons, want 1: %+v", len(st.skipped), st.skipped)
+    }
+    skip := st.skipped[0]
+    if skip.link != srv.URL+"/icon.ico" || skip.reason != "invalid_icon" || skip.code != "html" {
+        t.Errorf("skipped %+v, want %s as an invalid_icon of code html", skip, srv.URL+"/icon.ico")
+    }
+}
+
+func TestScanTimeout(t *testing.T) {
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        select {
+        case <-r.Context().Done():
+        case <-time.After(5 * time.Second):
+        }
+    }))
+    defer srv.Close()
+
+    st := scanURL(t, srv.URL, 200*time.Millisecond)
+    if len(st.saved) != 0 || len(st.lookups) != 0 {
+        t.Errorf("saved %d favicons and looked up %q, want neither", len(st.saved), st.lookups)
+    }
+    if len(st.failures) != 1 {
+        t.Fatalf("recorded %d failures, want 1: %+v", len(st.failures), st.failures)
+    }
+    if f := st.failures[0]; f.target != srv.URL || f.category != "timeout" {
+        t.Errorf("recorded failure %+v, want a timeout of %s", f, srv.URL)
+    }
+}
+
+func TestScanUnchangedIconAddsNoHistory(t *testing.T) {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/", servePage(`<link rel="icon" href="/icon.png">`))
+    mux.HandleFunc("/icon.png", serveIcon)
+    srv := httptest.NewServer(mux)
+    defer srv.Close()
+
+    st := newFakeStore()
+    s := newTestScanner(st, 5*time.Second)
+    for i := 0; i < 2; i++ {
+        s.scanTargets(context.Background(), []target{{URL: srv.URL}})
+    }
+    if len(st.saved) != 2 {
+        t.Fatalf("saved %d favicons over two scans, want 2", len(st.saved))
+    }
+    if st.saved[1].history {
+        t.Errorf("an unchanged favicon was saved with a new history row")
+    }
+    if s.found != 0 || s.changed != 0 {
+        t.Errorf("second scan counted %d new and %d changed, want none", s.found, s.changed)
+    }
+}
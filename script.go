@@ -0,0 +1,300 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "reflect"
+    "sort"
+    "strings"
+
+    starjson "go.starlark.net/lib/json"
+    "go.starlark.net/starlark"
+    "go.starlark.net/syntax"
+)
+
+// A -script file and the callbacks it defines; any of them may be missing.
+//
+//   filter_target(target)  target dict {url, labels}; a false result skips the target
+//   on_html(url, head)     extra favicon links found in the page head, resolved against url
+//   on_icon(result)        None keeps the result, False drops it from the output, and a
+//                          dict replaces its fields; keys results lack go under "script"
+//
+// Globals are frozen once the file has run, so the callbacks are safe to
+// call from every worker at once.
+type script struct {
+    filename     string
+    filterTarget starlark.Callable
+    onHTML       starlark.Callable
+    onIcon       starlark.Callable
+}
+
+// Run a script file and pick up its callbacks
+func loadScript(filename string) (*script, error) {
+    sc := &script{filename: filename}
+    opts := &syntax.FileOptions{Set: true, While: true, TopLevelControl: true, GlobalReassign: true}
+    globals, err := starlark.ExecFileOptions(opts, sc.thread("load"), filename, nil, starlark.StringDict{"json": starjson.Module})
+    if err != nil {
+        return nil, err
+    }
+    for name, fn := range map[string]*starlark.Callable{"filter_target": &sc.filterTarget, "on_html": &sc.onHTML, "on_icon": &sc.onIcon} {
+        v, ok := globals[name]
+        if !ok {
+            continue
+        }
+        if *fn, ok = v.(starlark.Callable); !ok {
+            return nil, fmt.Errorf("%s: %s is a %s, not a function", filename, name, v.Type())
+        }
+    }
+    if sc.filterTarget == nil && sc.onHTML == nil && sc.onIcon == nil {
+        return nil, fmt.Errorf("%s defines none of filter_target, on_html or on_icon", filename)
+    }
+    return sc, nil
+}
+
+// Thread for one call; print() goes to the informational output
+func (sc *script) thread(name string) *starlark.Thread {
+    return &starlark.Thread{Name: name, Print: func(_ *starlark.Thread, msg string) {
+        infof("%s: %s\n", sc.filename, msg)
+    }}
+}
+
+func (sc *script) call(fn starlark.Callable, args ...starlark.Value) (starlark.Value, error) {
+    v, err := starlark.Call(sc.thread(fn.Name()), fn, args, nil)
+    if err != nil {
+        if evalErr, ok := err.(*starlark.EvalError); ok {
+            return nil, fmt.Errorf("%s", evalErr.Backtrace())
+        }
+        return nil, err
+    }
+    return v, nil
+}
+
+// Whether filter_target lets a target be scanned. Failing scripts let it through.
+func (sc *script) keepTarget(t target) bool {
+    if sc == nil || sc.filterTarget == nil {
+        return true
+    }
+    labels := make([]interface{}, len(t.Labels))
+    for i, label := range t.Labels {
+        labels[i] = label
+    }
+    v, err := sc.call(sc.filterTarget, toStarlark(map[string]interface{}{"url": t.URL, "labels": labels}))
+    if err != nil {
+        errorf("Error in filter_target for %s: %v\n", t.URL, err)
+        return true
+    }
+    return bool(v.Truth())
+}
+
+// Links on_html adds for a page
+func (sc *script) extraLinks(pageURL, head string) ([]string, error) {
+    v, err := sc.call(sc.onHTML, starlark.String(pageURL), starlark.String(head))
+    if err != nil || v == starlark.None {
+        return nil, err
+    }
+    iter, ok := v.(starlark.Iterable)
+    if !ok {
+        return nil, fmt.Errorf("on_html returned a %s, not a list of links", v.Type())
+    }
+    var links []string
+    it := iter.Iterate()
+    defer it.Done()
+    var item starlark.Value
+    for it.Next(&item) {
+        link, ok := starlark.AsString(item)
+        if !ok {
+            return nil, fmt.Errorf("on_html returned a %s link, not a string", item.Type())
+        }
+        if link = strings.TrimSpace(link); link != "" {
+            links = append(links, link)
+        }
+    }
+    return links, nil
+}
+
+// Pass a result through on_icon; false means it is left out of the output
+func (sc *script) mapResult(res result) (result, bool) {
+    if sc == nil || sc.onIcon == nil {
+        return res, true
+    }
+    var fields map[string]interface{}
+    data, err := json.Marshal(res)
+    if err == nil {
+        err = json.Unmarshal(data, &fields)
+    }
+    if err != nil {
+        errorf("Error converting result for %s: %v\n", res.URL, err)
+        return res, true
+    }
+    v, err := sc.call(sc.onIcon, toStarlark(fields))
+    if err != nil {
+        errorf("Error in on_icon for %s: %v\n", res.URL, err)
+        return res, true
+    }
+    switch v := v.(type) {
+    case starlark.NoneType:
+        return res, true
+    case starlark.Bool:
+        return res, bool(v)
+    case *starlark.Dict:
+        changed, ok := fromStarlark(v).(map[string]interface{})
+        if !ok {
+            errorf("Error in on_icon for %s: returned dict has non-string keys\n", res.URL)
+            return res, true
+        }
+        return applyScriptFields(res, changed), true
+    }
+    errorf("Error in on_icon for %s: returned a %s, want None, a bool or a dict\n", res.URL, v.Type())
+    return res, true
+}
+
+// JSON names of the result fields on_icon may set
+var resultFields = func() map[string]bool {
+    fields := map[string]bool{}
+    t := reflect.TypeOf(result{})
+    for i := 0; i < t.NumField(); i++ {
+        name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+        if name != "" && name != "-" && name != "script" {
+            fields[name] = true
+        }
+    }
+    return fields
+}()
+
+// Result with the fields of a dict from on_icon; keys it has no field for
+// are kept under Script
+func applyScriptFields(res result, changed map[string]interface{}) result {
+    out := res
+    out.Script = nil
+    update := map[string]interface{}{}
+    for key, value := range changed {
+        if resultFields[key] {
+            update[key] = value
+            continue
+        }
+        if out.Script == nil {
+            out.Script = map[string]interface{}{}
+        }
+        out.Script[key] = value
+    }
+    data, err := json.Marshal(update)
+    if err == nil {
+        err = json.Unmarshal(data, &out)
+    }
+    if err != nil {
+        errorf("Error in on_icon for %s: %v\n", res.URL, err)
+        return res
+    }
+    return out
+}
+
+// Fetcher that hands each page head to on_html and adds the links it returns
+type scriptFetcher struct {
+    fetcher
+    script *script
+}
+
+func (f scriptFetcher) fetchPage(ctx context.Context, pageURL string) (page, error) {
+    p, err := f.fetcher.fetchPage(ctx, pageURL)
+    if err != nil {
+        return p, err
+    }
+    base := p.FinalURL
+    if base == "" {
+        base = pageURL
+    }
+    links, err := f.script.extraLinks(base, p.Head)
+    if err != nil {
+        errorf("Error in on_html for %s: %v\n", pageURL, err)
+        return p, nil
+    }
+    seen := map[string]bool{}
+    for _, link := range p.Links {
+        seen[link] = true
+    }
+    for _, link := range links {
+        if !strings.HasPrefix(link, "data:") {
+            link = resolveReference(base, link)
+        }
+        if !seen[link] {
+            seen[link] = true
+            p.Links = append(p.Links, link)
+        }
+    }
+    return p, nil
+}
+
+// Starlark value of decoded JSON
+func toStarlark(v interface{}) starlark.Value {
+    switch v := v.(type) {
+    case nil:
+        return starlark.None
+    case bool:
+        return starlark.Bool(v)
+    case string:
+        return starlark.String(v)
+    case float64:
+        if v == float64(int64(v)) {
+            return starlark.MakeInt64(int64(v))
+        }
+        return starlark.Float(v)
+    case []interface{}:
+        items := make([]starlark.Value, len(v))
+        for i, item := range v {
+            items[i] = toStarlark(item)
+        }
+        return starlark.NewList(items)
+    case map[string]interface{}:
+        keys := make([]string, 0, len(v))
+        for key := range v {
+            keys = append(keys, key)
+        }
+        sort.Strings(keys)
+        d := starlark.NewDict(len(v))
+        for _, key := range keys {
+            d.SetKey(starlark.String(key), toStarlark(v[key]))
+        }
+        return d
+    }
+    return starlark.String(fmt.Sprint(v))
+}
+
+// Go value of a Starlark value, shaped like decoded JSON
+func fromStarlark(v starlark.Value) interface{} {
+    switch v := v.(type) {
+    case starlark.NoneType:
+        return nil
+    case starlark.Bool:
+        return bool(v)
+    case starlark.String:
+        return string(v)
+    case starlark.Int:
+        if i, ok := v.Int64(); ok {
+            return i
+        }
+        return v.String()
+    case starlark.Float:
+        return float64(v)
+    case *starlark.List, starlark.Tuple:
+        var items []interface{}
+        it := v.(starlark.Iterable).Iterate()
+        defer it.Done()
+        var item starlark.Value
+        for it.Next(&item) {
+            items = append(items, fromStarlark(item))
+        }
+        return items
+    case *starlark.Dict:
+        m := map[string]interface{}{}
+        for _, kv := range v.Items() {
+            key, ok := starlark.AsString(kv[0])
+            if !ok {
+                return nil
+            }
+            m[key] = fromStarlark(kv[1])
+        }
+        return m
+    }
+    return v.String()
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Largest request body the API reads, enough for a job of -max-job-targets URLs
+const maxRequestBody = 64 << 20
+
+// HTTP API over a database: search stored favicons and runs, and queue
+// jobs of targets for the scanner running alongside it
+type server struct {
+    db         *sql.DB
+    workspace  string
+    limits     *rateLimiter
+    maxTargets int
+    wake       chan struct{} // a job was queued
+    lookups    *hashLookup   // GET /hash
+    events     *eventHub     // GET /events and /jobs/{id}/events
+}
+
+// A stored favicon as the API returns it
+type apiFavicon struct {
+    Link      string    `json:"link"`
+    Target    string    `json:"target,omitempty"`
+    Title     string    `json:"title,omitempty"`
+    Server    string    `json:"server,omitempty"`
+    Labels    string    `json:"labels,omitempty"`
+    RunLabels runLabels `json:"run_labels,omitempty"`
+    FinalURL  string    `json:"final_url,omitempty"`
+    Apex      string    `json:"apex,omitempty"`
+    MD5       string    `json:"md5"`
+    SHA256    string    `json:"sha256"`
+    MMH3      string    `json:"mmh3"`
+    FirstSeen string    `json:"first_seen,omitempty"`
+    LastSeen  string    `json:"last_seen,omitempty"`
+    Tags      []string  `json:"tags,omitempty"`
+    Notes     []string  `json:"notes,omitempty"`
+}
+
+func newAPIFavicon(r record) apiFavicon {
+    return apiFavicon{r.Link, r.Target, r.Title, r.Server, r.Labels, r.RunLabels, r.FinalURL, r.Apex, r.MD5, r.SHA256, r.MMH3, r.FirstSeen, r.LastSeen, r.tags(), r.Notes}
+}
+
+// A recorded run as the API returns it
+type apiRun struct {
+    ID         int64     `json:"id"`
+    Name       string    `json:"name,omitempty"`
+    StartedAt  string    `json:"started_at"`
+    FinishedAt string    `json:"finished_at,omitempty"`
+    Targets    int       `json:"targets"`
+    Favicons   int       `json:"favicons"`
+    Errors     int       `json:"errors"`
+    Skipped    int       `json:"skipped"`
+    Deferred   int       `json:"deferred"`
+    Status     string    `json:"status"`
+    Labels     runLabels `json:"labels,omitempty"`
+}
+
+// Routes of the API, every one behind an API key with the role it needs,
+// and the OpenAPI document describing them
+func (sv *server) handler() http.Handler {
+    api := http.NewServeMux()
+    for _, rt := range sv.routes() {
+        api.HandleFunc(rt.method+" "+rt.path, requireRole(rt.role, rt.handler))
+    }
+    // Older name of POST /jobs, left out of the document
+    api.HandleFunc("POST /scans", requireRole(roleScanner, sv.submitJob))
+
+    mux := http.NewServeMux()
+    mux.HandleFunc("GET /openapi.json", serveOpenAPI)
+    mux.Handle("/", sv.authenticate(api))
+    return mux
+}
+
+// Sort orders of GET /favicons
+var faviconSorts = map[string]sortColumn{
+    "last_seen":  {expr: "COALESCE(f.last_seen, '')"},
+    "first_seen": {expr: "COALESCE(f.first_seen, '')"},
+    "link":       {expr: "f.link"},
+}
+
+// GET /favicons, newest first, filtered by ?search=, ?hash=, ?domain=,
+// ?tag=, ?run= and ?since=/?until= on the last time a favicon was seen
+func (sv *server) listFavicons(w http.ResponseWriter, r *http.Request) {
+    lr, err := parseListRequest(r, faviconSorts, "-last_seen")
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    q := r.URL.Query()
+    var f listFilter
+    if search := q.Get("search"); search != "" {
+        var clauses []string
+        for _, column := range searchFields {
+            if column != "" {
+                clauses = append(clauses, column+` LIKE ? ESCAPE '\'`)
+                f.args = append(f.args, likePattern(search))
+            }
+        }
+        f.clauses = append(f.clauses, "("+strings.Join(clauses, " OR ")+")")
+    }
+    if hash := strings.ToLower(q.Get("hash")); hash != "" {
+        f.add("(f.md5 = ? OR f.sha256 = ? OR b.mmh3 = ?)", hash, hash, hash)
+    }
+    if domain := strings.ToLower(q.Get("domain")); domain != "" {
+        f.add(`(f.apex = ? OR f.final_host = ? OR f.final_host LIKE ? ESCAPE '\')`, domain, domain, "%."+strings.TrimPrefix(likePattern(domain), "%"))
+    }
+    if tag := q.Get("tag"); tag != "" {
+        f.add(`EXISTS (SELECT 1 FROM tags t WHERE t.workspace = f.workspace AND t.tag = ? AND (
+            (t.kind = 'md5' AND t.value = f.md5) OR (t.kind = 'sha256' AND t.value = f.sha256) OR (t.kind = 'mmh3' AND t.value = b.mmh3) OR
+            (t.kind = 'host' AND (f.link LIKE '%://' || t.value || '/%' OR f.link LIKE '%://' || t.value || ':%'))))`, tag)
+    }
+    if run := q.Get("run"); run != "" {
+        id, err := strconv.ParseInt(run, 10, 64)
+        if err != nil {
+            writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid run %q", run))
+            return
+        }
+        f.add("f.link IN (SELECT h.link FROM history h WHERE h.workspace = ? AND h.run_id = ?)", sv.workspace, id)
+    }
+    if err := addTimeRange(r, &f, "COALESCE(f.last_seen, '')"); err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    order := lr.keyset(&f, "f.link", false)
+
+    found, err := selectRecords(sv.db, sv.workspace, false, f.where(), order, lr.fetch(), f.args...)
+    if err != nil {
+        errorf("Error searching records: %v\n", err)
+        writeError(w, http.StatusInternalServerError, "searching records")
+        return
+    }
+    more := len(found) > lr.limit
+    if more {
+        found = found[:lr.limit]
+    }
+    items := make([]apiFavicon, len(found))
+    for i, rec := range found {
+        items[i] = newAPIFavicon(rec)
+    }
+    page := apiPage{Items: items}
+    if more {
+        last := found[len(found)-1]
+        value := map[string]string{"last_seen": last.LastSeen, "first_seen": last.FirstSeen, "link": last.Link}[strings.TrimPrefix(lr.sort, "-")]
+        page.NextCursor = lr.cursor(value, last.Link)
+    }
+    writeJSON(w, http.StatusOK, page)
+}
+
+// Sort orders of GET /runs
+var runSorts = map[string]sortColumn{
+    "id":         {expr: "id", numeric: true},
+    "started_at": {expr: "COALESCE(started_at, '')"},
+}
+
+// GET /runs, newest first, filtered by ?status= and ?since=/?until= on the start time
+func (sv *server) listRuns(w http.ResponseWriter, r *http.Request) {
+    lr, err := parseListRequest(r, runSorts, "-id")
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    var f listFilter
+    if status := r.URL.Query().Get("status"); status != "" {
+        f.add("status = ?", status)
+    }
+    if err := addTimeRange(r, &f, "COALESCE(started_at, '')"); err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    order := lr.keyset(&f, "id", true)
+
+    runs, err := selectRuns(sv.db, sv.workspace, f.where(), order, lr.fetch(), f.args...)
+    if err != nil {
+        errorf("Error loading runs: %v\n", err)
+        writeError(w, http.StatusInternalServerError, "loading runs")
+        return
+    }
+    more := len(runs) > lr.limit
+    if more {
+        runs = runs[:lr.limit]
+    }
+    items := make([]apiRun, len(runs))
+    for i, run := range runs {
+        items[i] = apiRun(run)
+    }
+    page := apiPage{Items: items}
+    if more {
+        last := runs[len(runs)-1]
+        id := strconv.FormatInt(last.ID, 10)
+        value := map[string]string{"id": id, "started_at": last.StartedAt}[strings.TrimPrefix(lr.sort, "-")]
+        page.NextCursor = lr.cursor(value, id)
+    }
+    writeJSON(w, http.StatusOK, page)
+}
+
+// DELETE /favicons?link=URL removes a stored favicon with its history
+func (sv *server) deleteFavicon(w http.ResponseWriter, r *http.Request) {
+    link := r.URL.Query().Get("link")
+    if link == "" {
+        writeError(w, http.StatusBadRequest, "missing link")
+        return
+    }
+    n, err := deleteFavicon(sv.db, sv.workspace, link, keyActor(auditFrom(r.Context()).key))
+    if err != nil {
+        errorf("Error deleting %s: %v\n", link, err)
+        writeError(w, http.StatusInternalServerError, "deleting favicon")
+        return
+    }
+    if n == 0 {
+        writeError(w, http.StatusNotFound, "no such favicon")
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// Delete a favicon link from a workspace with its history, and the blobs
+// only it referred to, recording who did in the action trail
+func deleteFavicon(db *sql.DB, workspace, link, actor string) (int64, error) {
+    tx, err := db.Begin()
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback()
+
+    n, err := execCount(tx, "DELETE FROM favicons WHERE workspace = ? AND link = ?", workspace, link)
+    if err != nil || n == 0 {
+        return n, err
+    }
+    for _, table := range []string{"history", "observations"} {
+        if _, err := tx.Exec("DELETE FROM "+table+" WHERE workspace = ? AND link = ?", workspace, link); err != nil {
+            return 0, err
+        }
+    }
+    for _, orphans := range []string{orphanHostsSQL, orphanBlobsSQL} {
+        if _, err := tx.Exec(orphans); err != nil {
+            return 0, err
+        }
+    }
+    if err := recordAction(tx, auditAction{Workspace: workspace, Actor: actor, Action: actionFaviconDelete, Subject: link}); err != nil {
+        return 0, err
+    }
+    return n, tx.Commit()
+}
+
+// Sort orders of GET /audit
+var auditSorts = map[string]sortColumn{
+    "id": {expr: "id", numeric: true},
+}
+
+// GET /audit, newest first, filtered by ?key=, ?status= and ?since=/?until=
+func (sv *server) listAudit(w http.ResponseWriter, r *http.Request) {
+    lr, err := parseListRequest(r, auditSorts, "-id")
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    q := r.URL.Query()
+    var f listFilter
+    if key := q.Get("key"); key != "" {
+        f.add("key_name = ?", key)
+    }
+    if status := q.Get("status"); status != "" {
+        f.add("status = ?", status)
+    }
+    if err := addTimeRange(r, &f, "at"); err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    order := lr.keyset(&f, "id", true)
+
+    entries, err := selectAudit(sv.db, f.where(), order, lr.fetch(), f.args...)
+    if err != nil {
+        errorf("Error reading audit trail: %v\n", err)
+        writeError(w, http.StatusInternalServerError, "reading audit trail")
+        return
+    }
+    more := len(entries) > lr.limit
+    if more {
+        entries = entries[:lr.limit]
+    }
+    if entries == nil {
+        entries = []auditRow{}
+    }
+    page := apiPage{Items: entries}
+    if more {
+        id := strconv.FormatInt(entries[len(entries)-1].ID, 10)
+        page.NextCursor = lr.cursor(id, id)
+    }
+    writeJSON(w, http.StatusOK, page)
+}
+
+// Targets of a scan request; each is a URL string or a target object
+func parseAPITargets(raw []json.RawMessage) ([]target, error) {
+    targets := make([]target, 0, len(raw))
+    for i, item := range raw {
+        var t target
+        var u string
+        if err := json.Unmarshal(item, &u); err == nil {
+            t.URL = u
+        } else if err := json.Unmarshal(item, &t); err != nil {
+            return nil, fmt.Errorf("target %d: %v", i+1, err)
+        }
+        t.URL = strings.TrimSpace(t.URL)
+        if err := t.validate(); err != nil {
+            return nil, fmt.Errorf("target %d: %v", i+1, err)
+        }
+        targets = append(targets, t)
+    }
+    return targets, nil
+}
+
+// Integer query parameter, or def when it is missing
+func queryInt(r *http.Request, name string, def int) (int, error) {
+    v := r.URL.Query().Get(name)
+    if v == "" {
+        return def, nil
+    }
+    n, err := strconv.Atoi(v)
+    if err != nil || n < 0 {
+        return 0, fmt.Errorf("invalid %s %q", name, v)
+    }
+    return n, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+    writeJSON(w, status, apiError{Error: msg})
+}
+
+// Serve the HTTP API, scanning submitted targets with the scan flags given
+func serveCommand(args []string) {
+    fs := flag.NewFlagSet("serve", flag.ExitOnError)
+    dbOpts := dbFlags(fs)
+    listen := fs.String("listen", "127.0.0.1:8080", "Address to serve the API on")
+    maxTargets := fs.Int("max-job-targets", 100000, "Most targets one job may submit")
+    hashCacheTTL := fs.Duration("hash-cache-ttl", 10*time.Minute, "How long GET /hash answers are reused for the same URL (0 disables the cache)")
+    tlsOpts := registerTLSFlags(fs)
+    pprofOpts := registerPprofFlags(fs)
+    opts := registerScanFlags(fs)
+    parseFlags(fs, args)
+
+    srv := &http.Server{Addr: *listen, ReadHeaderTimeout: 10 * time.Second}
+    var challenge *http.Server
+    if tlsOpts.enabled() {
+        var err error
+        if srv.TLSConfig, challenge, err = tlsOpts.config(); err != nil {
+            fmt.Fprintf(os.Stderr, "Error setting up TLS: %v\n", err)
+            return
+        }
+    }
+
+    if err := pprofOpts.start(); err != nil {
+        fmt.Fprintf(os.Stderr, "Error starting pprof: %v\n", err)
+        return
+    }
+
+    db, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer db.Close()
+
+    var keys int
+    if err := db.QueryRow("SELECT COUNT(*) FROM api_keys WHERE revoked_at IS NULL").Scan(&keys); err != nil {
+        fmt.Fprintf(os.Stderr, "Error reading API keys: %v\n", err)
+        return
+    }
+    if keys == 0 {
+        fmt.Fprintln(os.Stderr, "No active API keys; create one with: maplink apikey add <name>")
+    }
+
+    s, err := opts.newScanner(db, dbOpts.workspace)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error setting up scan: %v\n", err)
+        return
+    }
+    defer s.close()
+
+    events := newEventHub()
+    results := &jobSink{store: s.store, events: events}
+    s.sinks = append(s.sinks, results)
+
+    sv := &server{db: db, workspace: dbOpts.workspace, limits: newRateLimiter(), maxTargets: *maxTargets, wake: make(chan struct{}, 1),
+        lookups: newHashLookup(s, opts.lookupFetcher(s), *hashCacheTTL), events: events}
+    ctx := signalContext()
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        sv.runJobs(ctx, s, results)
+    }()
+
+    srv.Handler = sv.handler()
+    // Closed once open requests, streams among them, have finished writing
+    // to the database
+    stopped := make(chan struct{})
+    go func() {
+        defer close(stopped)
+        <-ctx.Done()
+        events.close()
+        shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+        defer cancel()
+        if challenge != nil {
+            challenge.Shutdown(shutdown)
+        }
+        srv.Shutdown(shutdown)
+    }()
+    if challenge != nil {
+        go func() {
+            if err := challenge.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+                errorf("Error serving ACME challenges: %v\n", err)
+            }
+        }()
+    }
+
+    scheme := "http"
+    serve := srv.ListenAndServe
+    if srv.TLSConfig != nil {
+        scheme = "https"
+        serve = func() error { return srv.ListenAndServeTLS("", "") }
+    }
+    infof("Serving the API on %s://%s (workspace %s)\n", scheme, *listen, dbOpts.workspace)
+    if err := serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+        fmt.Fprintf(os.Stderr, "Error serving API: %v\n", err)
+        return
+    }
+    <-done
+    <-stopped
+}
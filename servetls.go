@@ -0,0 +1,119 @@
+package main
+
+import (
+    "crypto/tls"
+    "flag"
+    "fmt"
+    "net/http"
+    "os"
+    "sync"
+    "time"
+
+    "golang.org/x/crypto/acme"
+    "golang.org/x/crypto/acme/autocert"
+)
+
+// HTTPS settings of serve: a certificate from files, or one obtained by ACME
+type tlsOptions struct {
+    certFile      string
+    keyFile       string
+    acmeDomains   string
+    acmeCache     string
+    acmeEmail     string
+    acmeDirectory string
+    acmeHTTP      string
+}
+
+func registerTLSFlags(fs *flag.FlagSet) *tlsOptions {
+    o := &tlsOptions{}
+    fs.StringVar(&o.certFile, "tls-cert", "", "PEM certificate (chain) to serve HTTPS with, reloaded when the file changes")
+    fs.StringVar(&o.keyFile, "tls-key", "", "PEM private key of -tls-cert")
+    fs.StringVar(&o.acmeDomains, "acme-domains", "", "Comma-separated host names to get certificates for by ACME (Let's Encrypt unless -acme-directory)")
+    fs.StringVar(&o.acmeCache, "acme-cache", "maplink-acme", "Directory keeping ACME account keys and certificates")
+    fs.StringVar(&o.acmeEmail, "acme-email", "", "Contact address for the ACME account")
+    fs.StringVar(&o.acmeDirectory, "acme-directory", "", "ACME directory URL of an internal CA (default: Let's Encrypt)")
+    fs.StringVar(&o.acmeHTTP, "acme-http", "", "Also answer HTTP-01 challenges on this address, e.g. :80 (TLS-ALPN-01 on -listen always works)")
+    return o
+}
+
+// Whether serve should speak HTTPS
+func (o *tlsOptions) enabled() bool {
+    return o.certFile != "" || o.keyFile != "" || o.acmeDomains != ""
+}
+
+// TLS config for the API server, and the HTTP-01 challenge server to run
+// alongside it when -acme-http is set
+func (o *tlsOptions) config() (*tls.Config, *http.Server, error) {
+    if o.acmeDomains != "" {
+        if o.certFile != "" || o.keyFile != "" {
+            return nil, nil, fmt.Errorf("-acme-domains and -tls-cert/-tls-key are mutually exclusive")
+        }
+        m := &autocert.Manager{
+            Prompt:     autocert.AcceptTOS,
+            Cache:      autocert.DirCache(o.acmeCache),
+            HostPolicy: autocert.HostWhitelist(splitList(o.acmeDomains)...),
+            Email:      o.acmeEmail,
+        }
+        if o.acmeDirectory != "" {
+            m.Client = &acme.Client{DirectoryURL: o.acmeDirectory}
+        }
+        cfg := m.TLSConfig()
+        cfg.MinVersion = tls.VersionTLS12
+        var challenge *http.Server
+        if o.acmeHTTP != "" {
+            challenge = &http.Server{Addr: o.acmeHTTP, Handler: m.HTTPHandler(nil), ReadHeaderTimeout: 10 * time.Second}
+        }
+        return cfg, challenge, nil
+    }
+    if o.certFile == "" || o.keyFile == "" {
+        return nil, nil, fmt.Errorf("-tls-cert and -tls-key go together")
+    }
+    kp := &keyPair{certFile: o.certFile, keyFile: o.keyFile}
+    if _, err := kp.certificate(nil); err != nil {
+        return nil, nil, err
+    }
+    return &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: kp.certificate}, nil, nil
+}
+
+// Certificate and key files, loaded again whenever either is modified so a
+// renewed certificate is picked up without a restart
+type keyPair struct {
+    certFile string
+    keyFile  string
+
+    mu      sync.Mutex
+    cert    *tls.Certificate
+    modTime time.Time
+}
+
+func (k *keyPair) certificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+    k.mu.Lock()
+    defer k.mu.Unlock()
+    var latest time.Time
+    for _, name := range []string{k.certFile, k.keyFile} {
+        info, err := os.Stat(name)
+        if err != nil {
+            if k.cert != nil {
+                return k.cert, nil
+            }
+            return nil, err
+        }
+        if info.ModTime().After(latest) {
+            latest = info.ModTime()
+        }
+    }
+    if k.cert != nil && !latest.After(k.modTime) {
+        return k.cert, nil
+    }
+    cert, err := tls.LoadX509KeyPair(k.certFile, k.keyFile)
+    if err != nil {
+        // Keep serving the old certificate while a renewal is half written
+        if k.cert != nil {
+            errorf("Error reloading TLS certificate: %v\n", err)
+            return k.cert, nil
+        }
+        return nil, fmt.Errorf("loading TLS certificate: %v", err)
+    }
+    k.cert, k.modTime = &cert, latest
+    return k.cert, nil
+}
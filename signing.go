@@ -0,0 +1,363 @@
+package main
+
+import (
+    "bufio"
+    "crypto/ed25519"
+    "crypto/rand"
+    "crypto/sha256"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "encoding/pem"
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "strconv"
+    "time"
+)
+
+// Prefixes of signed messages, so a signature over a file cannot be passed
+// off as one over a result or the other way round
+const (
+    fileSignatureContext   = "maplink file signature v1\n"
+    resultSignatureContext = "maplink result signature v1\n"
+)
+
+// Ed25519 key results and reports are signed with
+type signer struct {
+    key ed25519.PrivateKey
+    id  string
+}
+
+// Short name of a public key: the start of its SHA256, recorded in
+// signatures so a verifier can tell which key made them
+func signingKeyID(pub ed25519.PublicKey) string {
+    sum := sha256.Sum256(pub)
+    return hex.EncodeToString(sum[:8])
+}
+
+// Signer of a PEM private key file written by sign keygen; nil for no path
+func loadSigner(path string) (*signer, error) {
+    if path == "" {
+        return nil, nil
+    }
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    block, _ := pem.Decode(data)
+    if block == nil || block.Type != "PRIVATE KEY" {
+        return nil, fmt.Errorf("%s: not a PEM private key", path)
+    }
+    parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %v", path, err)
+    }
+    key, ok := parsed.(ed25519.PrivateKey)
+    if !ok {
+        return nil, fmt.Errorf("%s: not an Ed25519 key", path)
+    }
+    return &signer{key: key, id: signingKeyID(key.Public().(ed25519.PublicKey))}, nil
+}
+
+// Public key of a PEM file, or the public half of a private key file
+func loadPublicKey(path string) (ed25519.PublicKey, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    block, _ := pem.Decode(data)
+    if block == nil {
+        return nil, fmt.Errorf("%s: not a PEM key", path)
+    }
+    if block.Type == "PRIVATE KEY" {
+        sg, err := loadSigner(path)
+        if err != nil {
+            return nil, err
+        }
+        return sg.key.Public().(ed25519.PublicKey), nil
+    }
+    parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %v", path, err)
+    }
+    pub, ok := parsed.(ed25519.PublicKey)
+    if !ok {
+        return nil, fmt.Errorf("%s: not an Ed25519 key", path)
+    }
+    return pub, nil
+}
+
+// What a signed result covers: its JSON with the signature left out, which
+// includes signed_at and every field a -script added
+func resultSignedMessage(r result) []byte {
+    r.Signature = ""
+    data, _ := json.Marshal(r)
+    return append([]byte(resultSignatureContext), data...)
+}
+
+// Stamp a result with the time and sign it
+func (sg *signer) signResult(r result) result {
+    r.SignedAt = time.Now().UTC().Format(time.RFC3339Nano)
+    r.SigningKey = sg.id
+    r.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(sg.key, resultSignedMessage(r)))
+    return r
+}
+
+func verifyResult(pub ed25519.PublicKey, r result) error {
+    if r.Signature == "" {
+        return fmt.Errorf("not signed")
+    }
+    sig, err := base64.StdEncoding.DecodeString(r.Signature)
+    if err != nil {
+        return fmt.Errorf("invalid signature: %v", err)
+    }
+    if !ed25519.Verify(pub, resultSignedMessage(r), sig) {
+        return fmt.Errorf("signature does not match (key %s)", r.SigningKey)
+    }
+    return nil
+}
+
+// Detached signature of a file, written next to it as <file>.sig
+type fileSignature struct {
+    File      string `json:"file"`
+    Size      int64  `json:"size"`
+    SHA256    string `json:"sha256"`
+    SignedAt  string `json:"signed_at"`
+    KeyID     string `json:"key_id"`
+    Signature string `json:"signature"` // Ed25519, base64, over every field above
+}
+
+func (fsig fileSignature) message() []byte {
+    return []byte(fileSignatureContext + fsig.File + "\n" + strconv.FormatInt(fsig.Size, 10) + "\n" + fsig.SHA256 + "\n" + fsig.SignedAt + "\n" + fsig.KeyID + "\n")
+}
+
+// Size and SHA256 of a file
+func fileDigest(path string) (int64, string, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return 0, "", err
+    }
+    defer f.Close()
+    h := sha256.New()
+    n, err := io.Copy(h, f)
+    if err != nil {
+        return 0, "", err
+    }
+    return n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Sign a file as it is now, returning the path of its signature
+func (sg *signer) signFile(path string) (string, error) {
+    size, digest, err := fileDigest(path)
+    if err != nil {
+        return "", err
+    }
+    fsig := fileSignature{File: filepath.Base(path), Size: size, SHA256: digest, SignedAt: time.Now().UTC().Format(time.RFC3339), KeyID: sg.id}
+    fsig.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(sg.key, fsig.message()))
+    data, err := json.MarshalIndent(fsig, "", "  ")
+    if err != nil {
+        return "", err
+    }
+    sigPath := path + ".sig"
+    return sigPath, os.WriteFile(sigPath, append(data, '\n'), 0644)
+}
+
+// Check a file against its <file>.sig
+func verifyFile(pub ed25519.PublicKey, path string) (fileSignature, error) {
+    var fsig fileSignature
+    data, err := os.ReadFile(path + ".sig")
+    if err != nil {
+        return fsig, err
+    }
+    if err := json.Unmarshal(data, &fsig); err != nil {
+        return fsig, fmt.Errorf("%s.sig: %v", path, err)
+    }
+    sig, err := base64.StdEncoding.DecodeString(fsig.Signature)
+    if err != nil || !ed25519.Verify(pub, fsig.message(), sig) {
+        return fsig, fmt.Errorf("signature does not match (key %s)", fsig.KeyID)
+    }
+    size, digest, err := fileDigest(path)
+    if err != nil {
+        return fsig, err
+    }
+    if size != fsig.Size || digest != fsig.SHA256 {
+        return fsig, fmt.Errorf("contents changed since signed at %s", fsig.SignedAt)
+    }
+    return fsig, nil
+}
+
+// The -sign-key flag of commands that write a report or export file
+func registerSignFlag(fs *flag.FlagSet) *string {
+    return fs.String("sign-key", "", "Ed25519 private key (from sign keygen) to sign the output file with, writing <file>.sig")
+}
+
+// Signer of a -sign-key flag, checked before any work is done; output is
+// the -o file the signature will cover
+func outputSigner(keyPath, output string) (*signer, error) {
+    sg, err := loadSigner(keyPath)
+    if err == nil && sg != nil && output == "" {
+        err = fmt.Errorf("-sign-key needs -o")
+    }
+    return sg, err
+}
+
+// Sign a written output file, if -sign-key was given
+func (sg *signer) signOutput(path string) {
+    if sg == nil {
+        return
+    }
+    sigPath, err := sg.signFile(path)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error signing %s: %v\n", path, err)
+        return
+    }
+    fmt.Fprintf(os.Stderr, "Signed %s with key %s: %s\n", path, sg.id, sigPath)
+}
+
+// Create signing keys, sign files, and verify signed files and results
+func signCommand(args []string) {
+    if len(args) == 0 || isHelp(args[0]) {
+        fmt.Fprintln(os.Stderr, "Usage: maplink sign keygen|file|verify [flags]")
+        return
+    }
+    switch args[0] {
+    case "keygen":
+        signKeygen(args[1:])
+    case "file":
+        signFiles(args[1:])
+    case "verify":
+        verifySignatures(args[1:])
+    default:
+        fmt.Fprintf(os.Stderr, "Unknown sign command %q (use keygen, file or verify)\n", args[0])
+    }
+}
+
+func signKeygen(args []string) {
+    fs := flag.NewFlagSet("sign keygen", flag.ExitOnError)
+    out := fs.String("out", "maplink-signing.pem", "Private key file; the public key goes to the same name with .pub")
+    parseFlags(fs, args)
+
+    if _, err := os.Stat(*out); err == nil {
+        fmt.Fprintf(os.Stderr, "Error: %s already exists\n", *out)
+        return
+    }
+    pub, key, err := ed25519.GenerateKey(rand.Reader)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error generating key: %v\n", err)
+        return
+    }
+    keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error encoding key: %v\n", err)
+        return
+    }
+    pubDER, err := x509.MarshalPKIXPublicKey(pub)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error encoding key: %v\n", err)
+        return
+    }
+    if err := os.WriteFile(*out, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+        fmt.Fprintf(os.Stderr, "Error writing key: %v\n", err)
+        return
+    }
+    if err := os.WriteFile(*out+".pub", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0644); err != nil {
+        fmt.Fprintf(os.Stderr, "Error writing key: %v\n", err)
+        return
+    }
+    fmt.Printf("Key %s written to %s, public key to %s.pub\n", signingKeyID(pub), *out, *out)
+}
+
+func signFiles(args []string) {
+    fs := flag.NewFlagSet("sign file", flag.ExitOnError)
+    keyPath := fs.String("key", "maplink-signing.pem", "Private key file from sign keygen")
+    parseFlags(fs, args)
+
+    if fs.NArg() == 0 {
+        fmt.Fprintln(os.Stderr, "Usage: maplink sign file -key <key.pem> <file>...")
+        return
+    }
+    sg, err := loadSigner(*keyPath)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error loading key: %v\n", err)
+        return
+    }
+    for _, path := range fs.Args() {
+        sg.signOutput(path)
+    }
+}
+
+// Verify files against their .sig, or with -results every line of NDJSON
+// results written by a scan with -sign-key. Exits 1 if any fails.
+func verifySignatures(args []string) {
+    fs := flag.NewFlagSet("sign verify", flag.ExitOnError)
+    pubPath := fs.String("pub", "maplink-signing.pem.pub", "Public key file (or the private key)")
+    results := fs.Bool("results", false, "Verify the signed results in NDJSON files instead of .sig files")
+    parseFlags(fs, args)
+
+    if fs.NArg() == 0 {
+        fmt.Fprintln(os.Stderr, "Usage: maplink sign verify -pub <key.pem.pub> [-results] <file>...")
+        return
+    }
+    pub, err := loadPublicKey(*pubPath)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error loading key: %v\n", err)
+        return
+    }
+    failed := 0
+    for _, path := range fs.Args() {
+        if *results {
+            failed += verifyResultFile(pub, path)
+            continue
+        }
+        fsig, err := verifyFile(pub, path)
+        if err != nil {
+            fmt.Printf("FAIL\t%s\t%v\n", path, err)
+            failed++
+            continue
+        }
+        fmt.Printf("OK\t%s\tsha256 %s, signed %s by key %s\n", path, fsig.SHA256, fsig.SignedAt, fsig.KeyID)
+    }
+    if failed > 0 {
+        os.Exit(1)
+    }
+}
+
+// Verify each result line of an NDJSON file, printing failures; returns how many failed
+func verifyResultFile(pub ed25519.PublicKey, path string) int {
+    f, err := os.Open(path)
+    if err != nil {
+        fmt.Printf("FAIL\t%s\t%v\n", path, err)
+        return 1
+    }
+    defer f.Close()
+    scanner := bufio.NewScanner(f)
+    scanner.Buffer(nil, 16<<20)
+    line, ok, failed := 0, 0, 0
+    for scanner.Scan() {
+        line++
+        if len(scanner.Bytes()) == 0 {
+            continue
+        }
+        var r result
+        err := json.Unmarshal(scanner.Bytes(), &r)
+        if err == nil {
+            err = verifyResult(pub, r)
+        }
+        if err != nil {
+            fmt.Printf("FAIL\t%s:%d\t%s\t%v\n", path, line, r.URL, err)
+            failed++
+            continue
+        }
+        ok++
+    }
+    if err := scanner.Err(); err != nil {
+        fmt.Printf("FAIL\t%s\t%v\n", path, err)
+        failed++
+    }
+    fmt.Printf("%s: %d results verified, %d failed\n", path, ok, failed)
+    return failed
+}
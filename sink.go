@@ -0,0 +1,102 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "os"
+    "time"
+
+    "github.com/segmentio/kafka-go"
+)
+
+// One hashed favicon as emitted to output sinks
+type result struct {
+    Target           string                 `json:"target"`
+    URL              string                 `json:"url"`
+    Host             string                 `json:"host"`
+    MD5              string                 `json:"md5"`
+    SHA256           string                 `json:"sha256"`
+    MMH3             string                 `json:"mmh3"`
+    ContentType      string                 `json:"content_type,omitempty"`
+    Title            string                 `json:"title,omitempty"`
+    Server           string                 `json:"server,omitempty"`
+    Size             int                    `json:"size"`
+    Status           string                 `json:"status"`               // new, changed or unchanged
+    Labels           []string               `json:"labels,omitempty"`
+    RunLabels        runLabels              `json:"run_labels,omitempty"` // -label of the run
+    FinalURL         string                 `json:"final_url,omitempty"`
+    FinalHost        string                 `json:"final_host,omitempty"`
+    Apex             string                 `json:"apex,omitempty"`
+    NotModified      bool                   `json:"not_modified,omitempty"`
+    Match            string                 `json:"match,omitempty"`      // hunted hash this favicon matched
+    Default          string                 `json:"default,omitempty"`    // stock icon this favicon is, if any
+    Screenshot       string                 `json:"screenshot,omitempty"` // path of the page's screenshot
+    ScreenshotSHA256 string                 `json:"screenshot_sha256,omitempty"`
+    Script           map[string]interface{} `json:"script,omitempty"`     // fields added by the -script on_icon callback
+    Timestamp        time.Time              `json:"timestamp"`
+    SignedAt         string                 `json:"signed_at,omitempty"`   // with -sign-key
+    SigningKey       string                 `json:"signing_key,omitempty"` // ID of the key that signed
+    Signature        string                 `json:"signature,omitempty"`   // Ed25519 over the rest, base64
+    Data             []byte                 `json:"-"`                    // raw icon, for sinks that archive blobs
+}
+
+// Destination for scan results besides the database
+type sink interface {
+    Write(r result) error
+    Close() error
+}
+
+// Writes one JSON result per line to a file
+type ndjsonSink struct {
+    file *os.File
+    enc  *json.Encoder
+}
+
+func newNDJSONSink(filename string) (*ndjsonSink, error) {
+    file, err := os.Create(filename)
+    if err != nil {
+        return nil, err
+    }
+    return &ndjsonSink{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+func (n *ndjsonSink) Write(r result) error {
+    return n.enc.Encode(r)
+}
+
+func (n *ndjsonSink) Close() error {
+    return n.file.Close()
+}
+
+// Publishes each result to a Kafka topic, keyed by host
+type kafkaSink struct {
+    writer *kafka.Writer
+}
+
+func newKafkaSink(brokers []string, topic string) *kafkaSink {
+    return &kafkaSink{writer: &kafka.Writer{
+        Addr:         kafka.TCP(brokers...),
+        Topic:        topic,
+        Balancer:     &kafka.Hash{},
+        BatchTimeout: 50 * time.Millisecond,
+        Async:        true,
+        Completion: func(messages []kafka.Message, err error) {
+            if err != nil {
+                errorf("Error publishing %d results to Kafka: %v\n", len(messages), err)
+            }
+        },
+    }}
+}
+
+func (k *kafkaSink) Write(r result) error {
+    value, err := json.Marshal(r)
+    if err != nil {
+        return err
+    }
+    return k.writer.WriteMessages(context.Background(), kafka.Message{Key: []byte(r.Host), Value: value})
+}
+
+// Flush pending messages and close the connection
+func (k *kafkaSink) Close() error {
+    return k.writer.Close()
+}
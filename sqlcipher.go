@@ -0,0 +1,76 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "database/sql/driver"
+    "encoding/hex"
+    "fmt"
+    "strings"
+
+    "github.com/mattn/go-sqlite3"
+)
+
+// Opens connections that unlock the database with PRAGMA key before any
+// other statement touches the file
+type cipherConnector struct {
+    dsn    string
+    driver *sqlite3.SQLiteDriver
+}
+
+func (c cipherConnector) Connect(context.Context) (driver.Conn, error) {
+    return c.driver.Open(c.dsn)
+}
+
+func (c cipherConnector) Driver() driver.Driver {
+    return c.driver
+}
+
+// PRAGMA key argument: a raw key for 64 hex digits, otherwise a passphrase
+func cipherKeyPragma(key string) string {
+    if len(key) == 64 {
+        if _, err := hex.DecodeString(key); err == nil {
+            return `PRAGMA key = "x'` + key + `'"`
+        }
+    }
+    return "PRAGMA key = '" + strings.ReplaceAll(key, "'", "''") + "'"
+}
+
+// Open an SQLCipher-encrypted database. The binary must be linked against
+// SQLCipher (see README); with plain SQLite PRAGMA key is silently ignored,
+// so cipher_version is checked to avoid writing results unencrypted. A
+// read-only database is left at its current schema.
+func openEncryptedDatabase(path, key string, readOnly bool) (*sql.DB, error) {
+    pragmas := []string{cipherKeyPragma(key), "PRAGMA journal_mode = WAL"}
+    dsn := path + "?_synchronous=NORMAL&_busy_timeout=5000&_foreign_keys=on"
+    if readOnly {
+        pragmas = pragmas[:1]
+        dsn = "file:" + path + "?mode=ro&_busy_timeout=5000"
+    }
+    drv := &sqlite3.SQLiteDriver{ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+        for _, stmt := range pragmas {
+            if _, err := conn.Exec(stmt, nil); err != nil {
+                return err
+            }
+        }
+        return nil
+    }}
+    db := sql.OpenDB(cipherConnector{dsn: dsn, driver: drv})
+    if path == ":memory:" {
+        db.SetMaxOpenConns(1)
+    }
+
+    var version string
+    if err := db.QueryRow("PRAGMA cipher_version").Scan(&version); err != nil || version == "" {
+        db.Close()
+        return nil, fmt.Errorf("this build is not linked against SQLCipher; rebuild with -tags libsqlite3 against libsqlcipher")
+    }
+    if readOnly {
+        return db, nil
+    }
+    if err := migrate(db); err != nil {
+        db.Close()
+        return nil, fmt.Errorf("migrating schema (wrong key?): %v", err)
+    }
+    return db, nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "sort"
+    "time"
+)
+
+// How often one favicon occurs across the stored links
+type hashCount struct {
+    MD5        string `json:"md5"`
+    SHA256     string `json:"sha256"`
+    MMH3       string `json:"mmh3"`
+    Links      int    `json:"links"`
+    Hosts      int    `json:"hosts"`
+    Technology string `json:"technology,omitempty"`
+}
+
+// Totals and throughput of one run
+type runStats struct {
+    ID        int64   `json:"id"`
+    Name      string  `json:"name"`
+    StartedAt string  `json:"started_at"`
+    Status    string  `json:"status"`
+    Targets   int     `json:"targets"`
+    Favicons  int     `json:"favicons"`
+    Errors    int     `json:"errors"`
+    Skipped   int     `json:"skipped"`
+    Seconds   float64 `json:"seconds"`
+    PerSecond float64 `json:"favicons_per_second"`
+    ErrorRate float64 `json:"error_rate"`
+}
+
+// Summary statistics over the whole database
+type statsReport struct {
+    Hosts        int         `json:"hosts"`
+    Links        int         `json:"links"`
+    UniqueSHA256 int         `json:"unique_sha256"`
+    UniqueMMH3   int         `json:"unique_mmh3"`
+    Targets      int         `json:"targets"`
+    Errors       int         `json:"errors"`
+    ErrorRate    float64     `json:"error_rate"`
+    TopHashes    []hashCount `json:"top_hashes"`
+    Runs         []runStats  `json:"runs"`
+}
+
+// Compute statistics from stored records and runs
+func buildStats(records []record, runs []runEntry, fps fingerprints, top int) statsReport {
+    var st statsReport
+    st.Links = len(records)
+
+    hosts := map[string]struct{}{}
+    mmh3s := map[string]struct{}{}
+    for _, r := range records {
+        hosts[r.host()] = struct{}{}
+        if r.MMH3 != "" {
+            mmh3s[r.MMH3] = struct{}{}
+        }
+    }
+    st.Hosts = len(hosts)
+    st.UniqueMMH3 = len(mmh3s)
+
+    order, groups := groupByHash(records)
+    st.UniqueSHA256 = len(order)
+    for _, h := range order {
+        group := groups[h]
+        groupHosts := map[string]struct{}{}
+        for _, r := range group {
+            groupHosts[r.host()] = struct{}{}
+        }
+        first := group[0]
+        st.TopHashes = append(st.TopHashes, hashCount{
+            MD5:        first.MD5,
+            SHA256:     first.SHA256,
+            MMH3:       first.MMH3,
+            Links:      len(group),
+            Hosts:      len(groupHosts),
+            Technology: fps.identify(first.MD5, first.SHA256, first.MMH3),
+        })
+    }
+    sort.SliceStable(st.TopHashes, func(i, j int) bool { return st.TopHashes[i].Hosts > st.TopHashes[j].Hosts })
+    if top > 0 && len(st.TopHashes) > top {
+        st.TopHashes = st.TopHashes[:top]
+    }
+
+    for _, r := range runs {
+        rs := runStats{ID: r.ID, Name: r.Name, StartedAt: r.StartedAt, Status: r.Status, Targets: r.Targets, Favicons: r.Favicons, Errors: r.Errors, Skipped: r.Skipped}
+        start, err1 := time.Parse(time.RFC3339, r.StartedAt)
+        end, err2 := time.Parse(time.RFC3339, r.FinishedAt)
+        if err1 == nil && err2 == nil {
+            rs.Seconds = end.Sub(start).Seconds()
+            // Timestamps have one-second resolution, so count a fast run as one second
+            rs.PerSecond = float64(r.Favicons) / max(rs.Seconds, 1)
+        }
+        if r.Targets > 0 {
+            rs.ErrorRate = float64(r.Errors) / float64(r.Targets)
+        }
+        st.Targets += r.Targets
+        st.Errors += r.Errors
+        st.Runs = append(st.Runs, rs)
+    }
+    if st.Targets > 0 {
+        st.ErrorRate = float64(st.Errors) / float64(st.Targets)
+    }
+    return st
+}
+
+// Print the statistics as plain text
+func writeStatsText(w io.Writer, st statsReport) {
+    fmt.Fprintf(w, "Hosts:          %d\n", st.Hosts)
+    fmt.Fprintf(w, "Favicon links:  %d\n", st.Links)
+    fmt.Fprintf(w, "Unique SHA256:  %d\n", st.UniqueSHA256)
+    fmt.Fprintf(w, "Unique MMH3:    %d\n", st.UniqueMMH3)
+    fmt.Fprintf(w, "Runs:           %d\n", len(st.Runs))
+    fmt.Fprintf(w, "Error rate:     %.1f%% (%d of %d targets)\n", st.ErrorRate*100, st.Errors, st.Targets)
+
+    if len(st.TopHashes) > 0 {
+        fmt.Fprintln(w, "\nMost common favicons")
+        for _, h := range st.TopHashes {
+            tech := h.Technology
+            if tech == "" {
+                tech = "-"
+            }
+            fmt.Fprintf(w, "  %5d hosts  %5d links  MMH3 %-12s  MD5 %s  %s\n", h.Hosts, h.Links, h.MMH3, h.MD5, tech)
+        }
+    }
+
+    if len(st.Runs) > 0 {
+        fmt.Fprintln(w, "\nRuns")
+        for _, r := range st.Runs {
+            fmt.Fprintf(w, "  #%-4d %s  %s  %-11s  %d targets  %d favicons  %d errors  %d skipped  %.0fs  %.2f favicons/s\n",
+                r.ID, r.StartedAt, r.Name, r.Status, r.Targets, r.Favicons, r.Errors, r.Skipped, r.Seconds, r.PerSecond)
+        }
+    }
+}
+
+// Print summary statistics about the stored favicons and runs
+func statsCommand(args []string) {
+    fs := flag.NewFlagSet("stats", flag.ExitOnError)
+    dbOpts := dbFlags(fs)
+    top := fs.Int("top", 10, "Number of most common hashes to list (0 lists all)")
+    format := fs.String("format", "text", "Output format: text or json")
+    output := fs.String("o", "", "Write the statistics to this file instead of stdout")
+    fingerprintFile := fs.String("fingerprints", "", "CSV file of hash,technology pairs used to label hashes")
+    excludeDefaults := fs.Bool("exclude-defaults", false, "Leave out stock icons: those tagged default or on the stock list")
+    defaultIcons := fs.String("default-icons", "", "CSV file of hash,name pairs of stock icons, besides the built-in list")
+    parseFlags(fs, args)
+
+    if *format != "text" && *format != "json" {
+        fmt.Fprintf(os.Stderr, "Unknown format %q (use text or json)\n", *format)
+        return
+    }
+
+    fps, err := loadFingerprints(*fingerprintFile)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error loading fingerprints: %v\n", err)
+        return
+    }
+
+    db, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer db.Close()
+
+    records, err := loadRecords(db, dbOpts.workspace, false)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error loading records: %v\n", err)
+        return
+    }
+    if *excludeDefaults {
+        stock, err := loadStockIcons(*defaultIcons)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error loading default icons: %v\n", err)
+            return
+        }
+        records = withoutDefaults(records, stock)
+    }
+    runs, err := loadRuns(db, dbOpts.workspace)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error loading runs: %v\n", err)
+        return
+    }
+    st := buildStats(records, runs, fps, *top)
+
+    w := io.Writer(os.Stdout)
+    if *output != "" {
+        file, err := os.Create(*output)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error creating %s: %v\n", *output, err)
+            return
+        }
+        defer file.Close()
+        w = file
+    }
+
+    if *format == "json" {
+        enc := json.NewEncoder(w)
+        enc.SetIndent("", "  ")
+        if err := enc.Encode(st); err != nil {
+            fmt.Fprintf(os.Stderr, "Error writing statistics: %v\n", err)
+        }
+        return
+    }
+    writeStatsText(w, st)
+}
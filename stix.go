@@ -0,0 +1,195 @@
+package main
+
+import (
+    "bytes"
+    "crypto/rand"
+    "crypto/sha1"
+    "encoding/hex"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "os"
+    "strings"
+    "time"
+)
+
+// Namespace for deterministic STIX cyber-observable identifiers
+var stixNamespace = mustParseUUID("00abedb4-aa42-466c-9c01-fed23315a9b7")
+
+// Parse a UUID in canonical form
+func mustParseUUID(s string) []byte {
+    b, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+    if err != nil || len(b) != 16 {
+        panic("invalid uuid " + s)
+    }
+    return b
+}
+
+// Format 16 bytes as a UUID
+func formatUUID(b []byte) string {
+    h := hex.EncodeToString(b)
+    return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
+
+// Random (version 4) UUID
+func newUUID() string {
+    b := make([]byte, 16)
+    rand.Read(b)
+    b[6] = b[6]&0x0f | 0x40
+    b[8] = b[8]&0x3f | 0x80
+    return formatUUID(b)
+}
+
+// Name-based (version 5) UUID
+func uuidV5(namespace []byte, name string) string {
+    h := sha1.New()
+    h.Write(namespace)
+    h.Write([]byte(name))
+    b := h.Sum(nil)[:16]
+    b[6] = b[6]&0x0f | 0x50
+    b[8] = b[8]&0x3f | 0x80
+    return formatUUID(b)
+}
+
+// Deterministic SCO identifier from its ID contributing properties, which
+// are serialized as canonical JSON (sorted keys, no HTML escaping)
+func stixObservableID(kind string, contributing map[string]interface{}) string {
+    var buf bytes.Buffer
+    enc := json.NewEncoder(&buf)
+    enc.SetEscapeHTML(false)
+    enc.Encode(contributing)
+    return kind + "--" + uuidV5(stixNamespace, strings.TrimSuffix(buf.String(), "\n"))
+}
+
+// STIX timestamp with millisecond precision
+func stixTime(t time.Time) string {
+    return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// Build a STIX 2.1 bundle of file/url observables, indicators and relationships
+func buildSTIXBundle(records []record, filter map[string]struct{}) map[string]interface{} {
+    now := stixTime(time.Now())
+    var objects []map[string]interface{}
+    relate := func(source, target, description string) {
+        objects = append(objects, map[string]interface{}{
+            "type":              "relationship",
+            "spec_version":      "2.1",
+            "id":                "relationship--" + newUUID(),
+            "created":           now,
+            "modified":          now,
+            "relationship_type": "related-to",
+            "source_ref":        source,
+            "target_ref":        target,
+            "description":       description,
+        })
+    }
+
+    order, groups := groupByHash(records)
+    for _, sha := range order {
+        group := groups[sha]
+        icon := group[0]
+        if len(filter) > 0 && !matchesAny(filter, icon.MD5, icon.SHA256, icon.MMH3) {
+            continue
+        }
+
+        fileID := stixObservableID("file", map[string]interface{}{"hashes": map[string]string{"MD5": icon.MD5}})
+        file := map[string]interface{}{
+            "type":         "file",
+            "spec_version": "2.1",
+            "id":           fileID,
+            "hashes":       map[string]string{"MD5": icon.MD5, "SHA-256": icon.SHA256},
+        }
+        if icon.ContentType != "" {
+            file["mime_type"] = icon.ContentType
+        }
+        if icon.MMH3 != "" {
+            file["x_maplink_mmh3"] = icon.MMH3
+        }
+        objects = append(objects, file)
+
+        indicatorID := "indicator--" + newUUID()
+        indicator := map[string]interface{}{
+            "type":         "indicator",
+            "spec_version": "2.1",
+            "id":           indicatorID,
+            "created":      now,
+            "modified":     now,
+            "name":         "Favicon " + icon.MD5,
+            "description":  fmt.Sprintf("Favicon served by %d URL(s), mmh3 %s", len(group), icon.MMH3),
+            "pattern":      fmt.Sprintf("[file:hashes.'SHA-256' = '%s'] OR [file:hashes.MD5 = '%s']", icon.SHA256, icon.MD5),
+            "pattern_type": "stix",
+            "valid_from":   now,
+        }
+        // Run labels as key=value, so the indicator traces back to the runs that found it
+        if labels := recordRunLabels(group); len(labels) > 0 {
+            indicator["labels"] = labels
+        }
+        objects = append(objects, indicator)
+        relate(indicatorID, fileID, "Indicator for favicon")
+
+        for _, r := range group {
+            urlID := stixObservableID("url", map[string]interface{}{"value": r.Link})
+            url := map[string]interface{}{
+                "type":         "url",
+                "spec_version": "2.1",
+                "id":           urlID,
+                "value":        r.Link,
+            }
+            if len(r.RunLabels) > 0 {
+                url["x_maplink_run_labels"] = r.RunLabels
+            }
+            objects = append(objects, url)
+            relate(urlID, fileID, "URL serves favicon")
+        }
+    }
+
+    return map[string]interface{}{
+        "type":    "bundle",
+        "id":      "bundle--" + newUUID(),
+        "objects": objects,
+    }
+}
+
+// Export favicon hashes as a STIX 2.1 bundle
+func stixCommand(args []string) {
+    fs := flag.NewFlagSet("stix", flag.ExitOnError)
+    dbOpts := dbFlags(fs)
+    output := fs.String("o", "", "Output file (default: stdout)")
+    hashes := fs.String("hash", "", "Comma-separated hashes to export (default: all)")
+    signKey := registerSignFlag(fs)
+    parseFlags(fs, args)
+
+    sg, err := outputSigner(*signKey, *output)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error loading signing key: %v\n", err)
+        return
+    }
+    db, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer db.Close()
+
+    records, err := loadRecords(db, dbOpts.workspace, false)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error reading database: %v\n", err)
+        return
+    }
+
+    payload, err := json.MarshalIndent(buildSTIXBundle(records, parseHashList(*hashes)), "", "  ")
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error encoding bundle: %v\n", err)
+        return
+    }
+
+    if *output == "" {
+        fmt.Println(string(payload))
+        return
+    }
+    if err := os.WriteFile(*output, payload, 0644); err != nil {
+        fmt.Fprintf(os.Stderr, "Error writing bundle: %v\n", err)
+        return
+    }
+    sg.signOutput(*output)
+}
@@ -0,0 +1,636 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// Statements used by the store
+const (
+    lookupSQL = `SELECT f.md5, f.sha256, COALESCE(b.mmh3, ''), COALESCE(b.content_type, ''), COALESCE(b.size, 0),
+            COALESCE(f.etag, ''), COALESCE(f.last_modified, '')
+        FROM favicons f LEFT JOIN favicon_blobs b ON b.sha256 = f.sha256 WHERE f.workspace = ? AND f.link = ?`
+    upsertSQL = `INSERT INTO favicons(workspace, link, md5, sha256, first_seen, last_seen, target, title, server, labels, etag, last_modified, final_url, final_host, apex,
+            screenshot, screenshot_sha256, run_labels)
+        VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''))
+        ON CONFLICT(workspace, link) DO UPDATE SET md5 = excluded.md5, sha256 = excluded.sha256, last_seen = excluded.last_seen,
+            target = excluded.target, title = excluded.title, server = excluded.server, labels = excluded.labels, run_labels = excluded.run_labels,
+            final_url = excluded.final_url, final_host = excluded.final_host, apex = excluded.apex,
+            etag = excluded.etag, last_modified = excluded.last_modified,
+            screenshot = COALESCE(excluded.screenshot, screenshot), screenshot_sha256 = COALESCE(excluded.screenshot_sha256, screenshot_sha256)`
+    touchSQL      = `UPDATE favicons SET last_seen = ?, target = ?, title = ?, server = ?, labels = NULLIF(?, ''), run_labels = NULLIF(?, ''),
+        final_url = NULLIF(?, ''), final_host = NULLIF(?, ''), apex = NULLIF(?, ''),
+        screenshot = COALESCE(NULLIF(?, ''), screenshot), screenshot_sha256 = COALESCE(NULLIF(?, ''), screenshot_sha256) WHERE workspace = ? AND link = ?`
+    blobSQL    = `INSERT INTO favicon_blobs(sha256, md5, mmh3, content_type, size, data, encoding) VALUES(?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT(sha256) DO UPDATE SET data = excluded.data, encoding = excluded.encoding WHERE favicon_blobs.data IS NULL`
+    historySQL    = "INSERT INTO history(workspace, link, md5, sha256, seen_at, run_id) VALUES(?, ?, ?, ?, ?, NULLIF(?, 0))"
+    checkpointSQL = "INSERT OR IGNORE INTO run_targets(run_id, target, scanned_at) VALUES(?, ?, ?)"
+    skipIconSQL   = `INSERT INTO skipped_icons(workspace, run_id, link, target, reason, code, status, content_type, size, skipped_at)
+        VALUES(?, NULLIF(?, 0), ?, ?, ?, NULLIF(?, ''), NULLIF(?, 0), NULLIF(?, ''), NULLIF(?, -1), ?)`
+    errorSQL      = `INSERT INTO errors(workspace, run_id, target, spec, url, category, message, failed_at)
+        VALUES(?, NULLIF(?, 0), ?, NULLIF(?, ''), ?, ?, ?, ?)`
+    timingSQL     = `INSERT INTO request_timings(workspace, run_id, target, url, method, status, reused, dns_ms, connect_ms, tls_ms, ttfb_ms, total_ms, error, at)
+        VALUES(?, NULLIF(?, 0), NULLIF(?, ''), ?, ?, NULLIF(?, 0), ?, ?, ?, ?, ?, ?, NULLIF(?, ''), ?)`
+    resolutionSQL = `INSERT INTO resolutions(workspace, run_id, host, addrs, error, resolved_at)
+        VALUES(?, NULLIF(?, 0), ?, NULLIF(?, ''), NULLIF(?, ''), ?)`
+    techSQL       = `INSERT INTO technologies(workspace, host, technology, confidence, signals, target, run_id, detected_at)
+        VALUES(?, ?, ?, ?, NULLIF(?, ''), ?, NULLIF(?, 0), ?)
+        ON CONFLICT(workspace, host, technology) DO UPDATE SET confidence = excluded.confidence, signals = excluded.signals,
+            target = excluded.target, run_id = excluded.run_id, detected_at = excluded.detected_at`
+    sanSQL        = `INSERT INTO certificate_sans(workspace, host, name, run_id, seen_at) VALUES(?, ?, ?, NULLIF(?, 0), ?)
+        ON CONFLICT(workspace, host, name) DO UPDATE SET run_id = excluded.run_id, seen_at = excluded.seen_at`
+    dnsRecordSQL  = `INSERT INTO dns_records(workspace, host, position, type, name, value, run_id, first_seen, last_seen) VALUES(?, ?, ?, ?, ?, ?, NULLIF(?, 0), ?, ?)
+        ON CONFLICT(workspace, host, type, name, value) DO UPDATE SET position = excluded.position, run_id = excluded.run_id, last_seen = excluded.last_seen`
+    tagSQL        = "INSERT OR IGNORE INTO tags(workspace, kind, value, tag, created_at) VALUES(?, ?, ?, ?, ?)"
+    jobResultSQL  = "INSERT INTO job_results(job_id, result) VALUES(?, ?)"
+)
+
+// A write queued for the writer goroutine
+type storeOp interface {
+    apply(w batchStmts, now string)
+}
+
+// A write that wants to know when its batch has been committed, or
+// failed to be
+type committedOp interface {
+    afterCommit(ok bool)
+}
+
+// Closes done once every write queued before it has been committed
+type writeBarrier struct {
+    done chan struct{}
+}
+
+func (writeBarrier) apply(w batchStmts, now string) {}
+
+func (b writeBarrier) afterCommit(ok bool) {
+    close(b.done)
+}
+
+// Statements bound to the transaction of one batch, and the workspace they write to
+type batchStmts struct {
+    workspace  string
+    upsert     *sql.Stmt
+    blob       *sql.Stmt
+    history    *sql.Stmt
+    checkpoint *sql.Stmt
+    touch      *sql.Stmt
+    skipIcon   *sql.Stmt
+    resolution *sql.Stmt
+    failure    *sql.Stmt
+    timing     *sql.Stmt
+    host        *sql.Stmt
+    hostOfLink  *sql.Stmt
+    observation *sql.Stmt
+    tag         *sql.Stmt
+    tech        *sql.Stmt
+    san         *sql.Stmt
+    dnsRecord   *sql.Stmt
+    jobResult   *sql.Stmt
+}
+
+// What the store holds for a favicon link
+type storedFavicon struct {
+    MD5          string
+    SHA256       string
+    MMH3         string
+    ContentType  string
+    Size         int
+    ETag         string
+    LastModified string
+}
+
+// Final URL of a target's page with its host and registrable domain
+type finalLocation struct {
+    URL  string
+    Host string
+    Apex string
+}
+
+// Final location of a favicon's page; empty when it was not fetched over HTTP
+func locate(finalURL string) finalLocation {
+    if finalURL == "" {
+        return finalLocation{}
+    }
+    host := record{Link: finalURL}.host()
+    return finalLocation{URL: finalURL, Host: host, Apex: apexDomain(host)}
+}
+
+// Where a page's screenshot was saved and its hash; empty without one.
+// A scan without screenshots keeps the ones stored before.
+type screenshotRef struct {
+    path   string
+    sha256 string
+}
+
+// One favicon's worth of database writes
+type faviconWrite struct {
+    link         string
+    target       string
+    title        string
+    server       string
+    labels       string
+    runLabels    string // encoded
+    etag         string
+    lastModified string
+    final        finalLocation
+    screenshot   screenshotRef
+    hashes       iconHashes
+    contentType  string
+    data         []byte
+    stored       []byte      // data as it goes into favicon_blobs
+    encoding     interface{} // and how it is encoded there
+    history      bool
+    run          int64
+}
+
+func (op faviconWrite) apply(w batchStmts, now string) {
+    h := op.hashes
+    if _, err := w.upsert.Exec(w.workspace, op.link, h.MD5, h.SHA256, now, now, op.target, op.title, op.server, op.labels, op.etag, op.lastModified,
+        op.final.URL, op.final.Host, op.final.Apex, op.screenshot.path, op.screenshot.sha256, op.runLabels); err != nil {
+        errorf("Error saving to database for %s: %v\n", op.link, err)
+    }
+    if _, err := w.blob.Exec(h.SHA256, h.MD5, h.MMH3, op.contentType, len(op.data), op.stored, op.encoding); err != nil {
+        errorf("Error saving favicon for %s: %v\n", op.link, err)
+    }
+    if op.history {
+        if _, err := w.history.Exec(w.workspace, op.link, h.MD5, h.SHA256, now, op.run); err != nil {
+            errorf("Error saving history for %s: %v\n", op.link, err)
+        }
+    }
+    observation{host: observedHost(op.target, op.link), link: op.link, sha256: h.SHA256, run: op.run}.apply(w, now)
+}
+
+// Refreshes a favicon confirmed unchanged by a 304 response
+type faviconTouch struct {
+    link       string
+    target     string
+    title      string
+    server     string
+    labels     string
+    runLabels  string // encoded
+    final      finalLocation
+    screenshot screenshotRef
+    sha256     string
+    run        int64
+}
+
+func (op faviconTouch) apply(w batchStmts, now string) {
+    if _, err := w.touch.Exec(now, op.target, op.title, op.server, op.labels, op.runLabels, op.final.URL, op.final.Host, op.final.Apex,
+        op.screenshot.path, op.screenshot.sha256, w.workspace, op.link); err != nil {
+        errorf("Error saving to database for %s: %v\n", op.link, err)
+    }
+    observation{host: observedHost(op.target, op.link), link: op.link, sha256: op.sha256, run: op.run}.apply(w, now)
+}
+
+// Marks a target finished within a run
+type targetCheckpoint struct {
+    run    int64
+    target string
+}
+
+func (op targetCheckpoint) apply(w batchStmts, now string) {
+    if _, err := w.checkpoint.Exec(op.run, op.target, now); err != nil {
+        errorf("Error saving checkpoint for %s: %v\n", op.target, err)
+    }
+}
+
+// A favicon link skipped, or downloaded and found to be no icon
+type iconSkip struct {
+    run         int64
+    link        string
+    target      string
+    reason      string
+    code        string // what an invalid_icon was instead
+    status      int
+    contentType string
+    size        int64 // -1 when the server did not say
+}
+
+func (op iconSkip) apply(w batchStmts, now string) {
+    if _, err := w.skipIcon.Exec(w.workspace, op.run, op.link, op.target, op.reason, op.code, op.status, op.contentType, op.size, now); err != nil {
+        errorf("Error recording skipped favicon %s: %v\n", op.link, err)
+    }
+}
+
+// The answer to a host name lookup of -dns-prefilter
+type resolutionWrite struct {
+    run   int64
+    host  string
+    addrs string // comma-separated
+    err   string
+}
+
+func (op resolutionWrite) apply(w batchStmts, now string) {
+    if _, err := w.resolution.Exec(w.workspace, op.run, op.host, op.addrs, op.err, now); err != nil {
+        errorf("Error recording resolution of %s: %v\n", op.host, err)
+    }
+}
+
+// What a scan reads from and writes to its database
+type resultStore interface {
+    lookup(link string) (storedFavicon, bool, error)
+    save(op faviconWrite)
+    touch(op faviconTouch)
+    skipIcon(op iconSkip)
+    saveResolution(op resolutionWrite)
+    saveError(op errorWrite)
+    saveTiming(op timingWrite)
+    tagDefault(sha256 string)
+    saveTech(op techWrite)
+    saveSANs(op sanWrite)
+    saveDNSRecords(op dnsRecordsWrite)
+    saveJobResult(op *jobResultWrite)
+    sync()
+    startRun(name string, targets int, labels runLabels) (int64, error)
+    finishRun(id int64, targets, favicons, errors, skipped, deferred int, status string) error
+    unfinishedRun() (runEntry, map[string]struct{}, bool, error)
+    reopenRun(id int64, targets int) error
+    recentTargets(since time.Time) (map[string]struct{}, error)
+    watchlist() (map[string]struct{}, error)
+    checkpointTarget(run int64, target string)
+    throttle(ctx context.Context)
+    close()
+}
+
+// Database access for scans. Reads use the connection pool; all writes are
+// fed through a channel to a goroutine that gathers them into batches for
+// another to commit with prepared statements, so concurrent fetchers never
+// contend for the SQLite write lock and the next batch fills while one is
+// written. Fetchers are held back when the queue backs up.
+type store struct {
+    db         *sql.DB
+    workspace  string
+    lookupStmt *sql.Stmt
+    upsert     *sql.Stmt
+    blob       *sql.Stmt
+    history    *sql.Stmt
+    checkpoint *sql.Stmt
+    touchStmt  *sql.Stmt
+    skipStmt   *sql.Stmt
+    dnsStmt    *sql.Stmt
+    errorStmt  *sql.Stmt
+    timingStmt *sql.Stmt
+    hostStmt   *sql.Stmt
+    hostOfLink *sql.Stmt
+    obsStmt    *sql.Stmt
+    tagStmt    *sql.Stmt
+    techStmt   *sql.Stmt
+    sanStmt    *sql.Stmt
+    recordStmt *sql.Stmt
+    resultStmt *sql.Stmt
+    ops        chan storeOp
+    batches    chan []storeOp
+    done       chan struct{}
+    batch      int
+    interval   time.Duration
+    stalled    atomic.Bool // fetchers were held back at least once
+    mu         sync.Mutex
+    queued     map[string]queuedFavicon // favicons saved but not yet committed, by link
+}
+
+// A favicon waiting in the writer's queue or batch, and how many of its
+// writes are still to be committed
+type queuedFavicon struct {
+    favicon storedFavicon
+    writes  int
+}
+
+// Prepare statements and start the writer goroutines. queue is how many
+// writes may wait; 0 means four batches.
+func newStore(db *sql.DB, workspace string, batch, queue int, interval time.Duration) (*store, error) {
+    batch = max(batch, 1)
+    if queue <= 0 {
+        queue = batch * 4
+    }
+    st := &store{db: db, workspace: workspace, ops: make(chan storeOp, queue), batches: make(chan []storeOp, 1), done: make(chan struct{}), batch: batch, interval: interval,
+        queued: map[string]queuedFavicon{}}
+    for _, p := range []struct {
+        stmt **sql.Stmt
+        sql  string
+    }{{&st.lookupStmt, lookupSQL}, {&st.upsert, upsertSQL}, {&st.blob, blobSQL}, {&st.history, historySQL}, {&st.checkpoint, checkpointSQL}, {&st.touchStmt, touchSQL}, {&st.skipStmt, skipIconSQL}, {&st.dnsStmt, resolutionSQL}, {&st.errorStmt, errorSQL}, {&st.timingStmt, timingSQL},
+        {&st.hostStmt, hostSQL}, {&st.hostOfLink, hostOfLinkSQL}, {&st.obsStmt, observationSQL}, {&st.tagStmt, tagSQL}, {&st.techStmt, techSQL}, {&st.sanStmt, sanSQL}, {&st.recordStmt, dnsRecordSQL}, {&st.resultStmt, jobResultSQL}} {
+        stmt, err := db.Prepare(p.sql)
+        if err != nil {
+            st.closeStatements()
+            return nil, fmt.Errorf("preparing statement: %v", err)
+        }
+        *p.stmt = stmt
+    }
+    go st.run()
+    go st.write()
+    return st, nil
+}
+
+// Look up what was previously stored for a link. A favicon still queued
+// for writing counts as stored, so a link seen twice within a batch is not
+// new twice.
+func (st *store) lookup(link string) (storedFavicon, bool, error) {
+    st.mu.Lock()
+    q, ok := st.queued[link]
+    st.mu.Unlock()
+    if ok {
+        return q.favicon, true, nil
+    }
+    var f storedFavicon
+    err := st.lookupStmt.QueryRow(st.workspace, link).Scan(&f.MD5, &f.SHA256, &f.MMH3, &f.ContentType, &f.Size, &f.ETag, &f.LastModified)
+    if err == sql.ErrNoRows {
+        return f, false, nil
+    }
+    if err != nil {
+        return f, false, err
+    }
+    return f, true, nil
+}
+
+// Run states
+const (
+    runRunning     = "running"
+    runCompleted   = "completed"
+    runInterrupted = "interrupted"
+    runTimedOut    = "timed_out"
+)
+
+// Record the start of a scan pass. Run rows are rare enough to bypass the
+// batching writer.
+func (st *store) startRun(name string, targets int, labels runLabels) (int64, error) {
+    res, err := st.db.Exec("INSERT INTO runs(workspace, name, started_at, targets, status, labels) VALUES(?, ?, ?, ?, ?, NULLIF(?, ''))",
+        st.workspace, name, time.Now().UTC().Format(time.RFC3339), targets, runRunning, labels.encode())
+    if err != nil {
+        return 0, err
+    }
+    return res.LastInsertId()
+}
+
+// Record the end of a scan pass, its totals and final status
+func (st *store) finishRun(id int64, targets, favicons, errors, skipped, deferred int, status string) error {
+    _, err := st.db.Exec("UPDATE runs SET finished_at = ?, targets = ?, favicons = ?, errors = ?, skipped = ?, deferred = ?, status = ? WHERE id = ?",
+        time.Now().UTC().Format(time.RFC3339), targets, favicons, errors, skipped, deferred, status, id)
+    return err
+}
+
+// Most recent run that did not complete, with the targets it finished
+func (st *store) unfinishedRun() (runEntry, map[string]struct{}, bool, error) {
+    var r runEntry
+    var labels string
+    err := st.db.QueryRow(`SELECT id, COALESCE(name, ''), COALESCE(started_at, ''), favicons, errors, COALESCE(labels, '') FROM runs
+        WHERE workspace = ? AND status != ? ORDER BY id DESC LIMIT 1`, st.workspace, runCompleted).Scan(&r.ID, &r.Name, &r.StartedAt, &r.Favicons, &r.Errors, &labels)
+    if err == sql.ErrNoRows {
+        return r, nil, false, nil
+    }
+    if err != nil {
+        return r, nil, false, err
+    }
+    r.Labels = decodeRunLabels(labels)
+
+    rows, err := st.db.Query("SELECT target FROM run_targets WHERE run_id = ?", r.ID)
+    if err != nil {
+        return r, nil, false, err
+    }
+    defer rows.Close()
+    done := map[string]struct{}{}
+    for rows.Next() {
+        var target string
+        if err := rows.Scan(&target); err != nil {
+            return r, nil, false, err
+        }
+        done[target] = struct{}{}
+    }
+    return r, done, true, rows.Err()
+}
+
+// Targets finished, or whose favicons were stored, at or after since
+func (st *store) recentTargets(since time.Time) (map[string]struct{}, error) {
+    cutoff := since.UTC().Format(time.RFC3339)
+    rows, err := st.db.Query(`SELECT t.target FROM run_targets t JOIN runs r ON r.id = t.run_id WHERE r.workspace = ? AND t.scanned_at >= ?
+        UNION SELECT target FROM favicons WHERE workspace = ? AND target IS NOT NULL AND last_seen >= ?`, st.workspace, cutoff, st.workspace, cutoff)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    recent := map[string]struct{}{}
+    for rows.Next() {
+        var target string
+        if err := rows.Scan(&target); err != nil {
+            return nil, err
+        }
+        recent[target] = struct{}{}
+    }
+    return recent, rows.Err()
+}
+
+// Hashes on the workspace's watchlist
+func (st *store) watchlist() (map[string]struct{}, error) {
+    return loadWatchlist(st.db, st.workspace)
+}
+
+// Mark a run as running again
+func (st *store) reopenRun(id int64, targets int) error {
+    _, err := st.db.Exec("UPDATE runs SET status = ?, finished_at = NULL, targets = ? WHERE id = ?", runRunning, targets, id)
+    return err
+}
+
+// Queue a favicon for writing
+func (st *store) save(op faviconWrite) {
+    h := op.hashes
+    st.mu.Lock()
+    q := st.queued[op.link]
+    q.favicon = storedFavicon{MD5: h.MD5, SHA256: h.SHA256, MMH3: h.MMH3, ContentType: op.contentType, Size: len(op.data), ETag: op.etag, LastModified: op.lastModified}
+    q.writes++
+    st.queued[op.link] = q
+    st.mu.Unlock()
+    st.ops <- op
+}
+
+// Queue a last_seen refresh for an unchanged favicon
+func (st *store) touch(op faviconTouch) {
+    st.ops <- op
+}
+
+// Queue the record of a skipped favicon
+func (st *store) skipIcon(op iconSkip) {
+    st.ops <- op
+}
+
+// Queue the answer to a host name lookup
+func (st *store) saveResolution(op resolutionWrite) {
+    st.ops <- op
+}
+
+// Queue a failure for the errors table
+func (st *store) saveError(op errorWrite) {
+    st.ops <- op
+}
+
+// Queue the timings of a request
+func (st *store) saveTiming(op timingWrite) {
+    st.ops <- op
+}
+
+// Queue the default tag of a stock icon
+func (st *store) tagDefault(sha256 string) {
+    st.ops <- defaultIconTag{sha256: sha256}
+}
+
+// Queue the technologies identified on a host
+func (st *store) saveTech(op techWrite) {
+    st.ops <- op
+}
+
+// Queue the certificate names of a host
+func (st *store) saveSANs(op sanWrite) {
+    st.ops <- op
+}
+
+// Queue the resolution chain of a host
+func (st *store) saveDNSRecords(op dnsRecordsWrite) {
+    st.ops <- op
+}
+
+// Queue a result of the job being scanned
+func (st *store) saveJobResult(op *jobResultWrite) {
+    st.ops <- op
+}
+
+// Wait until every write queued so far has been committed
+func (st *store) sync() {
+    done := make(chan struct{})
+    st.ops <- writeBarrier{done: done}
+    <-done
+}
+
+// Queue a checkpoint for a finished target
+func (st *store) checkpointTarget(run int64, target string) {
+    st.ops <- targetCheckpoint{run: run, target: target}
+}
+
+// Flush pending writes, stop the writer and release the statements
+func (st *store) close() {
+    close(st.ops)
+    <-st.done
+    st.closeStatements()
+}
+
+func (st *store) closeStatements() {
+    for _, stmt := range []*sql.Stmt{st.lookupStmt, st.upsert, st.blob, st.history, st.checkpoint, st.touchStmt, st.skipStmt, st.dnsStmt, st.errorStmt, st.timingStmt, st.hostStmt, st.hostOfLink, st.obsStmt, st.tagStmt, st.techStmt, st.sanStmt, st.recordStmt, st.resultStmt} {
+        if stmt != nil {
+            stmt.Close()
+        }
+    }
+}
+
+// Gather queued writes into batches of -db-batch, or whatever arrived
+// within the interval. Handing over a batch waits while the previous one is
+// still being written, which lets the queue fill and throttle fetchers.
+func (st *store) run() {
+    defer close(st.batches)
+    ticker := time.NewTicker(st.interval)
+    defer ticker.Stop()
+
+    var pending []storeOp
+    for {
+        select {
+        case op, ok := <-st.ops:
+            if !ok {
+                if len(pending) > 0 {
+                    st.batches <- pending
+                }
+                return
+            }
+            pending = append(pending, op)
+            if len(pending) >= st.batch {
+                st.batches <- pending
+                pending = nil
+            }
+        case <-ticker.C:
+            if len(pending) > 0 {
+                st.batches <- pending
+                pending = nil
+            }
+        }
+    }
+}
+
+// Commit batches as they are handed over
+func (st *store) write() {
+    defer close(st.done)
+    for ops := range st.batches {
+        ok := st.flush(ops)
+        st.settle(ops)
+        // After the commit, so whoever the ops tell can read their rows
+        for _, op := range ops {
+            if op, is := op.(committedOp); is {
+                op.afterCommit(ok)
+            }
+        }
+    }
+}
+
+// Forget the favicons of a committed batch, so lookups read them from the
+// database again
+func (st *store) settle(ops []storeOp) {
+    st.mu.Lock()
+    defer st.mu.Unlock()
+    for _, op := range ops {
+        if op, ok := op.(faviconWrite); ok {
+            q := st.queued[op.link]
+            if q.writes--; q.writes <= 0 {
+                delete(st.queued, op.link)
+            } else {
+                st.queued[op.link] = q
+            }
+        }
+    }
+}
+
+// Hold a fetcher back while the writer is behind: from when the queue is
+// three-quarters full until it has drained to half. Fetches pause before
+// the store stage blocks on a full queue with the scan's lock held.
+func (st *store) throttle(ctx context.Context) {
+    if len(st.ops) < cap(st.ops)*3/4 {
+        return
+    }
+    if !st.stalled.Swap(true) {
+        infof("Database writes are falling behind, pausing fetches until they catch up\n")
+    }
+    ticker := time.NewTicker(10 * time.Millisecond)
+    defer ticker.Stop()
+    for len(st.ops) > cap(st.ops)/2 {
+        select {
+        case <-ticker.C:
+        case <-ctx.Done():
+            return
+        }
+    }
+}
+
+// Write a batch in a single transaction and say whether it was committed
+func (st *store) flush(ops []storeOp) bool {
+    if len(ops) == 0 {
+        return true
+    }
+    tx, err := st.db.Begin()
+    if err != nil {
+        errorf("Error starting transaction: %v\n", err)
+        return false
+    }
+    w := batchStmts{workspace: st.workspace, upsert: tx.Stmt(st.upsert), blob: tx.Stmt(st.blob), history: tx.Stmt(st.history), checkpoint: tx.Stmt(st.checkpoint), touch: tx.Stmt(st.touchStmt), skipIcon: tx.Stmt(st.skipStmt),
+        resolution: tx.Stmt(st.dnsStmt), failure: tx.Stmt(st.errorStmt), timing: tx.Stmt(st.timingStmt),
+        host: tx.Stmt(st.hostStmt), hostOfLink: tx.Stmt(st.hostOfLink), observation: tx.Stmt(st.obsStmt), tag: tx.Stmt(st.tagStmt), tech: tx.Stmt(st.techStmt), san: tx.Stmt(st.sanStmt), dnsRecord: tx.Stmt(st.recordStmt),
+        jobResult: tx.Stmt(st.resultStmt)}
+
+    now := time.Now().UTC().Format(time.RFC3339)
+    for _, op := range ops {
+        op.apply(w, now)
+    }
+
+    if err := tx.Commit(); err != nil {
+        errorf("Error committing %d writes: %v\n", len(ops), err)
+        return false
+    }
+    return true
+}
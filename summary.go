@@ -0,0 +1,147 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "net/smtp"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// A failed fetch or hash
+type scanError struct {
+    URL     string
+    Message string
+    Time    time.Time
+}
+
+// Findings, changes and errors accumulated between summary reports. Only
+// the daemon mails reports, so scans keep a nil summary and collect nothing.
+type summary struct {
+    mu       sync.Mutex
+    findings []notification
+    changes  []notification
+    errors   []scanError
+}
+
+// A drained summary covering one reporting window
+type summaryReport struct {
+    Since    time.Time
+    Until    time.Time
+    Findings []notification
+    Changes  []notification
+    Errors   []scanError
+}
+
+func newSummary() *summary {
+    return &summary{}
+}
+
+func (s *summary) addFinding(n notification) {
+    if s == nil {
+        return
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    n.Time = time.Now()
+    s.findings = append(s.findings, n)
+}
+
+func (s *summary) addChange(n notification) {
+    if s == nil {
+        return
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    n.Time = time.Now()
+    s.changes = append(s.changes, n)
+}
+
+func (s *summary) addError(url string, err error) {
+    if s == nil {
+        return
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.errors = append(s.errors, scanError{URL: url, Message: err.Error(), Time: time.Now()})
+}
+
+// Return everything collected since the last drain and start over
+func (s *summary) drain(since time.Time) summaryReport {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    r := summaryReport{Since: since, Until: time.Now(), Findings: s.findings, Changes: s.changes, Errors: s.errors}
+    s.findings, s.changes, s.errors = nil, nil, nil
+    return r
+}
+
+// Put back a report that could not be sent, ahead of what came in since,
+// for the next one to include
+func (s *summary) restore(r summaryReport) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.findings = append(r.Findings, s.findings...)
+    s.changes = append(r.Changes, s.changes...)
+    s.errors = append(r.Errors, s.errors...)
+}
+
+// Render the report as a plain-text email body
+func (r summaryReport) text() string {
+    var b strings.Builder
+    fmt.Fprintf(&b, "MAPLINK summary %s - %s\n\n", r.Since.Format(time.RFC3339), r.Until.Format(time.RFC3339))
+    fmt.Fprintf(&b, "New findings: %d\nChanges: %d\nErrors: %d\n", len(r.Findings), len(r.Changes), len(r.Errors))
+
+    if len(r.Findings) > 0 {
+        b.WriteString("\nNew findings\n")
+        for _, n := range r.Findings {
+            fmt.Fprintf(&b, "  %s  MD5 %s  SHA256 %s\n", n.Link, n.MD5, n.SHA256)
+        }
+    }
+    if len(r.Changes) > 0 {
+        b.WriteString("\nChanged favicons\n")
+        for _, n := range r.Changes {
+            fmt.Fprintf(&b, "  %s  MD5 %s -> %s\n", n.Link, n.OldMD5, n.MD5)
+        }
+    }
+    if len(r.Errors) > 0 {
+        b.WriteString("\nErrors\n")
+        for _, e := range r.Errors {
+            fmt.Fprintf(&b, "  %s  %s  %s\n", e.Time.Format(time.RFC3339), e.URL, e.Message)
+        }
+    }
+    return b.String()
+}
+
+// SMTP settings for summary emails
+type smtpConfig struct {
+    host     string
+    port     int
+    user     string
+    password string
+    from     string
+    to       []string
+}
+
+func (c smtpConfig) enabled() bool {
+    return c.host != "" && c.from != "" && len(c.to) > 0
+}
+
+// Mail the report to every recipient
+func (c smtpConfig) send(r summaryReport) error {
+    var msg bytes.Buffer
+    fmt.Fprintf(&msg, "From: %s\r\n", c.from)
+    fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(c.to, ", "))
+    fmt.Fprintf(&msg, "Subject: MAPLINK summary: %d new, %d changed, %d errors\r\n", len(r.Findings), len(r.Changes), len(r.Errors))
+    fmt.Fprintf(&msg, "Date: %s\r\n", r.Until.Format(time.RFC1123Z))
+    msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+    msg.WriteString(strings.ReplaceAll(r.text(), "\n", "\r\n"))
+
+    var auth smtp.Auth
+    if c.user != "" {
+        auth = smtp.PlainAuth("", c.user, c.password, c.host)
+    }
+    addr := c.host + ":" + strconv.Itoa(c.port)
+    return smtp.SendMail(addr, auth, c.from, c.to, msg.Bytes())
+}
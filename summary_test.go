@@ -0,0 +1,40 @@
+package main
+
+import (
+    "errors"
+    "testing"
+    "time"
+)
+
+func TestSummaryNilCollectsNothing(t *testing.T) {
+    var s *summary
+    s.addFinding(notification{Link: "https://a.example/favicon.ico"})
+    s.addChange(notification{Link: "https://b.example/favicon.ico"})
+    s.addError("https://c.example/", errors.New("refused"))
+}
+
+func TestSummaryRestoreKeepsUnsentReport(t *testing.T) {
+    s := newSummary()
+    s.addFinding(notification{Link: "https://a.example/favicon.ico"})
+    s.addError("https://b.example/", errors.New("refused"))
+    since := time.Now().Add(-time.Hour)
+    failed := s.drain(since)
+
+    // Collected while the failed report was being sent
+    s.addFinding(notification{Link: "https://c.example/favicon.ico"})
+    s.restore(failed)
+
+    r := s.drain(since)
+    if len(r.Findings) != 2 || r.Findings[0].Link != "https://a.example/favicon.ico" || r.Findings[1].Link != "https://c.example/favicon.ico" {
+        t.Errorf("findings %+v, want the unsent one then the new one", r.Findings)
+    }
+    if len(r.Errors) != 1 || r.Errors[0].URL != "https://b.example/" {
+        t.Errorf("errors %+v, want the unsent one", r.Errors)
+    }
+    if r.Since != since {
+        t.Errorf("report since %s, want %s", r.Since, since)
+    }
+    if again := s.drain(since); len(again.Findings)+len(again.Changes)+len(again.Errors) != 0 {
+        t.Errorf("second drain %+v, want it empty", again)
+    }
+}
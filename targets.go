@@ -0,0 +1,258 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// A target with the per-target request settings of a CSV or JSONL input file
+type target struct {
+    URL        string            `json:"url"`
+    Headers    map[string]string `json:"headers,omitempty"`
+    Cookies    map[string]string `json:"cookies,omitempty"`
+    Proxy      string            `json:"proxy,omitempty"`
+    Labels     []string          `json:"labels,omitempty"`
+    Connect    string            `json:"connect,omitempty"`     // address to send the target's own requests to, e.g. an origin IP
+    HostHeader string            `json:"host_header,omitempty"` // Host header of the target's own requests
+    SNI        string            `json:"sni,omitempty"`         // TLS server name of the target's own requests
+}
+
+// Whether a request goes to the target's own host, which its connect,
+// Host header and SNI overrides apply to; links to other hosts keep theirs
+func (t target) ownHost(u *url.URL) bool {
+    return strings.EqualFold(u.Hostname(), hostOf(t.URL))
+}
+
+// Whether the target needs anything beyond a plain request
+func (t target) hasOverrides() bool {
+    return len(t.Headers) > 0 || len(t.Cookies) > 0 || t.Proxy != "" || len(t.Labels) > 0 || t.Connect != "" || t.HostHeader != "" || t.SNI != ""
+}
+
+// Read targets from a file: .jsonl/.ndjson holds one JSON object per line,
+// .csv has a header row, anything else is one URL per line
+func readTargets(filename string) ([]target, error) {
+    switch strings.ToLower(filepath.Ext(filename)) {
+    case ".jsonl", ".ndjson":
+        return readJSONLTargets(filename)
+    case ".csv":
+        return readCSVTargets(filename)
+    }
+    urls, err := readURLsFromFile(filename)
+    if err != nil {
+        return nil, err
+    }
+    targets := make([]target, len(urls))
+    for i, u := range urls {
+        targets[i] = target{URL: u}
+    }
+    return targets, nil
+}
+
+// Send targets to a channel as lines arrive on r, closing it at EOF. Lines
+// are URLs, bare host names or JSON targets; only the first field counts,
+// so "host ip" output of enumeration tools works as is.
+func streamTargets(r io.Reader) <-chan target {
+    out := make(chan target)
+    go func() {
+        defer close(out)
+        lines := bufio.NewScanner(r)
+        for lines.Scan() {
+            line := strings.TrimSpace(lines.Text())
+            if line == "" || strings.HasPrefix(line, "#") {
+                continue
+            }
+            var t target
+            if strings.HasPrefix(line, "{") {
+                if err := json.Unmarshal([]byte(line), &t); err != nil {
+                    errorf("Error reading target %q: %v\n", line, err)
+                    continue
+                }
+            } else {
+                t.URL = strings.Fields(line)[0]
+            }
+            out <- t
+        }
+        if err := lines.Err(); err != nil {
+            errorf("Error reading targets: %v\n", err)
+        }
+    }()
+    return out
+}
+
+// One JSON target per line; blank lines are skipped
+func readJSONLTargets(filename string) ([]target, error) {
+    f, err := os.Open(filename)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    var targets []target
+    dec := json.NewDecoder(f)
+    for {
+        var t target
+        if err := dec.Decode(&t); err == io.EOF {
+            break
+        } else if err != nil {
+            return nil, fmt.Errorf("%s: target %d: %v", filename, len(targets)+1, err)
+        }
+        if t.URL = strings.TrimSpace(t.URL); t.URL == "" {
+            return nil, fmt.Errorf("%s: target %d has no url", filename, len(targets)+1)
+        }
+        targets = append(targets, t)
+    }
+    return targets, nil
+}
+
+// CSV with a header row naming the columns: url (required), proxy, connect,
+// host_header, sni, labels (comma-separated), header:<Name> and cookie:<name>
+func readCSVTargets(filename string) ([]target, error) {
+    f, err := os.Open(filename)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    r := csv.NewReader(f)
+    r.TrimLeadingSpace = true
+    columns, err := r.Read()
+    if err != nil {
+        return nil, fmt.Errorf("%s: reading header: %v", filename, err)
+    }
+    urlColumn := -1
+    for i, name := range columns {
+        name = strings.TrimSpace(name)
+        columns[i] = name
+        lower := strings.ToLower(name)
+        switch {
+        case lower == "url":
+            urlColumn = i
+        case lower == "proxy", lower == "connect", lower == "host_header", lower == "sni", lower == "labels", strings.HasPrefix(lower, "header:"), strings.HasPrefix(lower, "cookie:"):
+        default:
+            return nil, fmt.Errorf("%s: unknown column %q", filename, name)
+        }
+    }
+    if urlColumn < 0 {
+        return nil, fmt.Errorf("%s: no url column", filename)
+    }
+
+    var targets []target
+    for {
+        row, err := r.Read()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return nil, fmt.Errorf("%s: %v", filename, err)
+        }
+        t := target{URL: strings.TrimSpace(row[urlColumn])}
+        if t.URL == "" {
+            continue
+        }
+        for i, value := range row {
+            name := columns[i]
+            if value = strings.TrimSpace(value); value == "" || i == urlColumn {
+                continue
+            }
+            switch lower := strings.ToLower(name); {
+            case lower == "proxy":
+                t.Proxy = value
+            case lower == "connect":
+                t.Connect = value
+            case lower == "host_header":
+                t.HostHeader = value
+            case lower == "sni":
+                t.SNI = value
+            case lower == "labels":
+                t.Labels = splitList(value)
+            case strings.HasPrefix(lower, "header:"):
+                if t.Headers == nil {
+                    t.Headers = map[string]string{}
+                }
+                t.Headers[name[len("header:"):]] = value
+            case strings.HasPrefix(lower, "cookie:"):
+                if t.Cookies == nil {
+                    t.Cookies = map[string]string{}
+                }
+                t.Cookies[name[len("cookie:"):]] = value
+            }
+        }
+        targets = append(targets, t)
+    }
+    return targets, nil
+}
+
+// URLs of the targets, in order
+func targetURLs(targets []target) []string {
+    urls := make([]string, len(targets))
+    for i, t := range targets {
+        urls[i] = t.URL
+    }
+    return urls
+}
+
+// Check a target's URL and proxy
+func (t target) validate() error {
+    if err := validateTarget(t.URL); err != nil {
+        return err
+    }
+    if t.Proxy != "" {
+        if _, err := url.Parse(t.Proxy); err != nil {
+            return fmt.Errorf("proxy: %v", err)
+        }
+    }
+    if t.Connect != "" {
+        if t.Proxy != "" {
+            return fmt.Errorf("connect and proxy cannot be combined")
+        }
+        if _, _, ok := bareHost(t.Connect); !ok {
+            return fmt.Errorf("connect: not a host or host:port")
+        }
+    }
+    return nil
+}
+
+type targetKey struct{}
+
+// Attach a target's request settings to a context
+func withTarget(ctx context.Context, t target) context.Context {
+    return context.WithValue(ctx, targetKey{}, t)
+}
+
+// Target attached to a context, if any
+func targetFrom(ctx context.Context) target {
+    t, _ := ctx.Value(targetKey{}).(target)
+    return t
+}
+
+// Build a GET request carrying the headers and cookies of the context's target
+func newRequest(ctx context.Context, url string) (*http.Request, error) {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+    if err != nil {
+        return nil, err
+    }
+    t := targetFrom(ctx)
+    for name, value := range t.Headers {
+        if strings.EqualFold(name, "Host") {
+            req.Host = value
+            continue
+        }
+        req.Header.Set(name, value)
+    }
+    for name, value := range t.Cookies {
+        req.AddCookie(&http.Cookie{Name: name, Value: value})
+    }
+    if t.HostHeader != "" && t.ownHost(req.URL) {
+        req.Host = t.HostHeader
+    }
+    return req, nil
+}
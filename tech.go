@@ -0,0 +1,379 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    _ "embed"
+    "encoding/csv"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "html"
+    "net/http"
+    "os"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// Built-in technology rules, see tech_rules.json
+//
+//go:embed tech_rules.json
+var techRulesJSON []byte
+
+// What identifies a technology, as tech_rules.json writes it: favicon
+// hashes, and regular expressions over response headers, cookie names,
+// meta tags and the page's HTML
+type techRule struct {
+    Icons   []string          `json:"icons"`
+    Headers map[string]string `json:"headers"`
+    Cookies []string          `json:"cookies"`
+    Meta    map[string]string `json:"meta"`
+    HTML    []string          `json:"html"`
+}
+
+// A rule with its patterns compiled
+type techMatcher struct {
+    name    string
+    icons   map[string]bool
+    headers map[string]*regexp.Regexp // by canonical header name
+    cookies []*regexp.Regexp
+    meta    map[string]*regexp.Regexp // by lower-case meta name or property
+    html    []*regexp.Regexp
+}
+
+// Confidence each kind of signal adds to a detection; the sum is capped at 100
+var techWeights = map[string]int{"icon": 60, "header": 50, "meta": 50, "cookie": 40, "html": 30}
+
+// The built-in rules, with those of filename added or replacing them by name
+func loadTechRules(filename string) ([]techMatcher, error) {
+    rules := map[string]techRule{}
+    if err := json.Unmarshal(techRulesJSON, &rules); err != nil {
+        return nil, fmt.Errorf("built-in rules: %v", err)
+    }
+    if filename != "" {
+        data, err := os.ReadFile(filename)
+        if err != nil {
+            return nil, err
+        }
+        var extra map[string]techRule
+        if err := json.Unmarshal(data, &extra); err != nil {
+            return nil, fmt.Errorf("%s: %v", filename, err)
+        }
+        for name, r := range extra {
+            rules[name] = r
+        }
+    }
+    return compileTechRules(rules)
+}
+
+func compileTechRules(rules map[string]techRule) ([]techMatcher, error) {
+    var out []techMatcher
+    for name, r := range rules {
+        m := techMatcher{name: name, icons: map[string]bool{}, headers: map[string]*regexp.Regexp{}, meta: map[string]*regexp.Regexp{}}
+        compile := func(pattern string) (*regexp.Regexp, error) {
+            re, err := regexp.Compile("(?i)" + pattern)
+            if err != nil {
+                return nil, fmt.Errorf("rule %s: %v", name, err)
+            }
+            return re, nil
+        }
+        for _, h := range r.Icons {
+            m.icons[strings.ToLower(strings.TrimSpace(h))] = true
+        }
+        for header, pattern := range r.Headers {
+            re, err := compile(pattern)
+            if err != nil {
+                return nil, err
+            }
+            m.headers[http.CanonicalHeaderKey(header)] = re
+        }
+        for meta, pattern := range r.Meta {
+            re, err := compile(pattern)
+            if err != nil {
+                return nil, err
+            }
+            m.meta[strings.ToLower(meta)] = re
+        }
+        for _, pattern := range r.Cookies {
+            re, err := compile(pattern)
+            if err != nil {
+                return nil, err
+            }
+            m.cookies = append(m.cookies, re)
+        }
+        for _, pattern := range r.HTML {
+            re, err := compile(pattern)
+            if err != nil {
+                return nil, err
+            }
+            m.html = append(m.html, re)
+        }
+        out = append(out, m)
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].name < out[j].name })
+    return out, nil
+}
+
+// Meta tags and the attributes that name them and give their content
+var (
+    metaTagRe  = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+    metaAttrRe = regexp.MustCompile(`(?is)\b(name|property|content)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+)
+
+// Content of the page's meta tags by lower-case name or property
+func pageMeta(content string) map[string]string {
+    meta := map[string]string{}
+    for _, tag := range metaTagRe.FindAllString(content, -1) {
+        var name, value string
+        for _, m := range metaAttrRe.FindAllStringSubmatch(tag, -1) {
+            v := html.UnescapeString(m[2] + m[3] + m[4])
+            if strings.ToLower(m[1]) == "content" {
+                value = v
+            } else {
+                name = strings.ToLower(v)
+            }
+        }
+        if name != "" {
+            meta[name] = value
+        }
+    }
+    return meta
+}
+
+// What a target showed: its page's headers and HTML and its favicons' hashes
+type techEvidence struct {
+    header http.Header
+    html   string
+    hashes []iconHashes
+}
+
+// A technology identified on a host
+type techDetection struct {
+    Technology string   `json:"technology"`
+    Confidence int      `json:"confidence"`
+    Signals    []string `json:"signals"` // e.g. icon, header:Server, cookie:PHPSESSID
+}
+
+// Technologies the evidence points to, the most certain first
+func detectTech(rules []techMatcher, ev techEvidence) []techDetection {
+    meta := pageMeta(ev.html)
+    cookies := (&http.Response{Header: ev.header}).Cookies()
+
+    var found []techDetection
+    for _, rule := range rules {
+        var signals []string
+        kinds := map[string]bool{}
+        add := func(kind, signal string) {
+            kinds[kind] = true
+            signals = append(signals, signal)
+        }
+        for _, h := range ev.hashes {
+            if rule.icons[h.MD5] || rule.icons[h.SHA256] || rule.icons[h.MMH3] {
+                add("icon", "icon")
+                break
+            }
+        }
+        for name, re := range rule.headers {
+            for _, v := range ev.header.Values(name) {
+                if re.MatchString(v) {
+                    add("header", "header:"+name)
+                    break
+                }
+            }
+        }
+        for _, re := range rule.cookies {
+            for _, c := range cookies {
+                if re.MatchString(c.Name) {
+                    add("cookie", "cookie:"+c.Name)
+                    break
+                }
+            }
+        }
+        for name, re := range rule.meta {
+            if v, ok := meta[name]; ok && re.MatchString(v) {
+                add("meta", "meta:"+name)
+            }
+        }
+        for _, re := range rule.html {
+            if re.MatchString(ev.html) {
+                add("html", "html")
+                break
+            }
+        }
+        if len(signals) == 0 {
+            continue
+        }
+        confidence := 0
+        for kind := range kinds {
+            confidence += techWeights[kind]
+        }
+        sort.Strings(signals)
+        found = append(found, techDetection{Technology: rule.name, Confidence: min(confidence, 100), Signals: signals})
+    }
+    sort.SliceStable(found, func(i, j int) bool { return found[i].Confidence > found[j].Confidence })
+    return found
+}
+
+// Fetcher that keeps the page it fetched, for technology detection
+type pageCapture struct {
+    fetcher
+    page *page
+}
+
+func (c *pageCapture) fetchPage(ctx context.Context, pageURL string) (page, error) {
+    p, err := c.fetcher.fetchPage(ctx, pageURL)
+    if err == nil {
+        c.page = &p
+    }
+    return p, err
+}
+
+// Identify the technologies of a finished target's host and queue them for
+// the technologies table
+func (s *scanner) detectTech(job *scanJob) {
+    if s.tech == nil || job.page == nil {
+        return
+    }
+    ev := techEvidence{header: job.page.Header, html: job.page.Head}
+    for i, icon := range job.icons {
+        h := job.hashes[i]
+        // A 304 carries no body; its hashes are the stored ones
+        if icon.NotModified {
+            prev, known, err := s.store.lookup(icon.URL)
+            if err != nil || !known {
+                continue
+            }
+            h = iconHashes{MD5: prev.MD5, SHA256: prev.SHA256, MMH3: prev.MMH3}
+        }
+        ev.hashes = append(ev.hashes, h)
+    }
+    found := detectTech(s.tech, ev)
+    if len(found) == 0 {
+        return
+    }
+    host := observedHost(job.target.URL, job.page.FinalURL)
+    var names []string
+    for _, d := range found {
+        names = append(names, fmt.Sprintf("%s (%d%%)", d.Technology, d.Confidence))
+    }
+    infof("Technologies on %s: %s\n", host, strings.Join(names, ", "))
+
+    s.mu.Lock()
+    run := s.run
+    s.mu.Unlock()
+    s.store.saveTech(techWrite{run: run, host: host, target: job.target.URL, found: found})
+}
+
+// Technologies identified on a host, on their way to the technologies table
+type techWrite struct {
+    run    int64
+    host   string
+    target string
+    found  []techDetection
+}
+
+func (op techWrite) apply(w batchStmts, now string) {
+    for _, d := range op.found {
+        if _, err := w.tech.Exec(w.workspace, op.host, d.Technology, d.Confidence, strings.Join(d.Signals, ","), op.target, op.run, now); err != nil {
+            errorf("Error saving technologies of %s: %v\n", op.host, err)
+        }
+    }
+}
+
+// A stored detection
+type hostTech struct {
+    Host       string   `json:"host"`
+    Technology string   `json:"technology"`
+    Confidence int      `json:"confidence"`
+    Signals    []string `json:"signals"`
+    Target     string   `json:"target"`
+    DetectedAt string   `json:"detected_at"`
+}
+
+// Detections of a workspace, optionally of one host, at or above a confidence
+func loadTechnologies(db *sql.DB, workspace, host string, minConfidence int) ([]hostTech, error) {
+    rows, err := db.Query(`SELECT host, technology, confidence, COALESCE(signals, ''), COALESCE(target, ''), detected_at FROM technologies
+        WHERE workspace = ? AND (? = '' OR host = ?) AND confidence >= ? ORDER BY host, confidence DESC, technology`,
+        workspace, host, host, minConfidence)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var out []hostTech
+    for rows.Next() {
+        var t hostTech
+        var signals string
+        if err := rows.Scan(&t.Host, &t.Technology, &t.Confidence, &signals, &t.Target, &t.DetectedAt); err != nil {
+            return nil, err
+        }
+        t.Signals = splitList(signals)
+        out = append(out, t)
+    }
+    return out, rows.Err()
+}
+
+// List or export the technologies identified per host
+func techCommand(args []string) {
+    fs := flag.NewFlagSet("tech", flag.ExitOnError)
+    dbOpts := dbFlags(fs)
+    host := fs.String("host", "", "Only this host")
+    minConfidence := fs.Int("min-confidence", 0, "Only detections at least this confident (0-100)")
+    format := fs.String("format", "text", "Output format: text, json or csv")
+    output := fs.String("o", "", "Write to this file instead of stdout")
+    parseFlags(fs, args)
+
+    if *format != "text" && *format != "json" && *format != "csv" {
+        fmt.Fprintf(os.Stderr, "Unknown format %q (use text, json or csv)\n", *format)
+        return
+    }
+    db, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer db.Close()
+
+    found, err := loadTechnologies(db, dbOpts.workspace, strings.ToLower(*host), *minConfidence)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error loading technologies: %v\n", err)
+        return
+    }
+
+    w := os.Stdout
+    if *output != "" {
+        file, err := os.Create(*output)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error creating %s: %v\n", *output, err)
+            return
+        }
+        defer file.Close()
+        w = file
+    }
+    switch *format {
+    case "json":
+        enc := json.NewEncoder(w)
+        for _, t := range found {
+            enc.Encode(t)
+        }
+    case "csv":
+        cw := csv.NewWriter(w)
+        cw.Write([]string{"host", "technology", "confidence", "signals", "target", "detected_at"})
+        for _, t := range found {
+            cw.Write([]string{t.Host, t.Technology, strconv.Itoa(t.Confidence), strings.Join(t.Signals, ","), t.Target, t.DetectedAt})
+        }
+        cw.Flush()
+        if err := cw.Error(); err != nil {
+            fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", *output, err)
+        }
+    default:
+        if len(found) == 0 {
+            fmt.Fprintln(w, "No technologies identified (scan with -tech).")
+            return
+        }
+        for _, t := range found {
+            fmt.Fprintf(w, "%s\t%s\t%d%%\t%s\n", t.Host, t.Technology, t.Confidence, strings.Join(t.Signals, ","))
+        }
+    }
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+    "crypto/tls"
+    "database/sql"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io"
+    "net/http"
+    "net/http/httptrace"
+    "os"
+    "sync"
+    "time"
+)
+
+// Where the time of one request went. Phases a reused connection skipped
+// are zero.
+type requestTiming struct {
+    target  string
+    url     string
+    method  string
+    status  int
+    reused  bool
+    dns     time.Duration
+    connect time.Duration
+    tls     time.Duration
+    ttfb    time.Duration // from sending the request to the first response byte
+    total   time.Duration // until the body was closed
+    err     string
+}
+
+// HTTP transport that traces each request and hands its timings to record
+// once the response body is closed, or the request failed
+type timingTransport struct {
+    next   http.RoundTripper
+    record func(requestTiming)
+}
+
+// Phase boundaries of one request, set from the trace callbacks
+type requestTrace struct {
+    mu                     sync.Mutex
+    start                  time.Time
+    dnsStart, dnsDone      time.Time
+    connectStart, connDone time.Time
+    tlsStart, tlsDone      time.Time
+    firstByte              time.Time
+    reused                 bool
+}
+
+func (rt *requestTrace) set(at *time.Time, keepFirst bool) {
+    rt.mu.Lock()
+    defer rt.mu.Unlock()
+    if !keepFirst || at.IsZero() {
+        *at = time.Now()
+    }
+}
+
+func (rt *requestTrace) clientTrace() *httptrace.ClientTrace {
+    return &httptrace.ClientTrace{
+        DNSStart:          func(httptrace.DNSStartInfo) { rt.set(&rt.dnsStart, true) },
+        DNSDone:           func(httptrace.DNSDoneInfo) { rt.set(&rt.dnsDone, false) },
+        ConnectStart:      func(string, string) { rt.set(&rt.connectStart, true) },
+        ConnectDone:       func(string, string, error) { rt.set(&rt.connDone, false) },
+        TLSHandshakeStart: func() { rt.set(&rt.tlsStart, true) },
+        TLSHandshakeDone:  func(tls.ConnectionState, error) { rt.set(&rt.tlsDone, false) },
+        GotConn: func(info httptrace.GotConnInfo) {
+            rt.mu.Lock()
+            rt.reused = info.Reused
+            rt.mu.Unlock()
+        },
+        GotFirstResponseByte: func() { rt.set(&rt.firstByte, true) },
+    }
+}
+
+// Timings of the request so far
+func (rt *requestTrace) timing(req *http.Request) requestTiming {
+    rt.mu.Lock()
+    defer rt.mu.Unlock()
+    span := func(from, to time.Time) time.Duration {
+        if from.IsZero() || to.IsZero() {
+            return 0
+        }
+        return to.Sub(from)
+    }
+    return requestTiming{
+        target:  targetFrom(req.Context()).URL,
+        url:     req.URL.String(),
+        method:  req.Method,
+        reused:  rt.reused,
+        dns:     span(rt.dnsStart, rt.dnsDone),
+        connect: span(rt.connectStart, rt.connDone),
+        tls:     span(rt.tlsStart, rt.tlsDone),
+        ttfb:    span(rt.start, rt.firstByte),
+        total:   time.Since(rt.start),
+    }
+}
+
+func (t timingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    rt := &requestTrace{start: time.Now()}
+    req = req.WithContext(httptrace.WithClientTrace(req.Context(), rt.clientTrace()))
+    resp, err := t.next.RoundTrip(req)
+    if err != nil {
+        timing := rt.timing(req)
+        timing.err = err.Error()
+        t.record(timing)
+        return nil, err
+    }
+    resp.Body = &timedBody{ReadCloser: resp.Body, done: func() {
+        timing := rt.timing(req)
+        timing.status = resp.StatusCode
+        t.record(timing)
+    }}
+    return resp, nil
+}
+
+// Response body that reports its request's timings once, on Close
+type timedBody struct {
+    io.ReadCloser
+    once sync.Once
+    done func()
+}
+
+func (b *timedBody) Close() error {
+    err := b.ReadCloser.Close()
+    b.once.Do(b.done)
+    return err
+}
+
+// Queue the timings of a request for the run underway
+func (s *scanner) recordTiming(t requestTiming) {
+    s.mu.Lock()
+    run := s.run
+    s.mu.Unlock()
+    s.store.saveTiming(timingWrite{run: run, timing: t})
+}
+
+// A request's timings on their way to the request_timings table
+type timingWrite struct {
+    run    int64
+    timing requestTiming
+}
+
+func (op timingWrite) apply(w batchStmts, now string) {
+    t := op.timing
+    ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+    if _, err := w.timing.Exec(w.workspace, op.run, t.target, t.url, t.method, t.status, t.reused,
+        ms(t.dns), ms(t.connect), ms(t.tls), ms(t.ttfb), ms(t.total), t.err, now); err != nil {
+        errorf("Error recording timings of %s: %v\n", t.url, err)
+    }
+}
+
+// Request timings of one target, summed over a run
+type targetTimings struct {
+    Target   string  `json:"target"`
+    Requests int     `json:"requests"`
+    Errors   int     `json:"errors"`
+    DNS      float64 `json:"dns_ms"`
+    Connect  float64 `json:"connect_ms"`
+    TLS      float64 `json:"tls_ms"`
+    TTFB     float64 `json:"ttfb_ms"`
+    Total    float64 `json:"total_ms"`
+    Slowest  float64 `json:"slowest_ms"`
+}
+
+// Targets of a run (0 for every run) by the time their requests took, the
+// slowest first; phases are averages per request
+func loadTargetTimings(db *sql.DB, workspace string, run int64, top int) ([]targetTimings, error) {
+    query := `SELECT COALESCE(target, ''), COUNT(*), SUM(error IS NOT NULL), AVG(dns_ms), AVG(connect_ms), AVG(tls_ms), AVG(ttfb_ms),
+            SUM(total_ms), MAX(total_ms)
+        FROM request_timings WHERE workspace = ? AND (? = 0 OR run_id = ?) GROUP BY target ORDER BY SUM(total_ms) DESC`
+    args := []interface{}{workspace, run, run}
+    if top > 0 {
+        query += " LIMIT ?"
+        args = append(args, top)
+    }
+    rows, err := db.Query(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var out []targetTimings
+    for rows.Next() {
+        var t targetTimings
+        if err := rows.Scan(&t.Target, &t.Requests, &t.Errors, &t.DNS, &t.Connect, &t.TLS, &t.TTFB, &t.Total, &t.Slowest); err != nil {
+            return nil, err
+        }
+        out = append(out, t)
+    }
+    return out, rows.Err()
+}
+
+// List the targets whose requests took longest, with where the time went
+func timingsCommand(args []string) {
+    fs := flag.NewFlagSet("timings", flag.ExitOnError)
+    dbOpts := dbFlags(fs)
+    run := fs.Int64("run", 0, "Only requests of this run (default every run)")
+    top := fs.Int("top", 20, "Number of slowest targets to list (0 lists all)")
+    format := fs.String("format", "text", "Output format: text or json")
+    parseFlags(fs, args)
+
+    if *format != "text" && *format != "json" {
+        fmt.Fprintf(os.Stderr, "Unknown format %q (use text or json)\n", *format)
+        return
+    }
+    db, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer db.Close()
+
+    timings, err := loadTargetTimings(db, dbOpts.workspace, *run, *top)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error loading timings: %v\n", err)
+        return
+    }
+    if *format == "json" {
+        enc := json.NewEncoder(os.Stdout)
+        for _, t := range timings {
+            enc.Encode(t)
+        }
+        return
+    }
+    if len(timings) == 0 {
+        fmt.Println("No request timings recorded (scan with -timings).")
+        return
+    }
+    fmt.Printf("%-40s %5s %6s %8s %8s %8s %8s %10s %9s\n", "TARGET", "REQS", "ERRORS", "DNS", "CONNECT", "TLS", "TTFB", "TOTAL", "SLOWEST")
+    for _, t := range timings {
+        fmt.Printf("%-40s %5d %6d %7.0fms %7.0fms %7.0fms %7.0fms %9.0fms %7.0fms\n",
+            t.Target, t.Requests, t.Errors, t.DNS, t.Connect, t.TLS, t.TTFB, t.Total, t.Slowest)
+    }
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+    "database/sql"
+    "flag"
+    "fmt"
+    "os"
+    "os/exec"
+    "strings"
+
+    tea "github.com/charmbracelet/bubbletea"
+)
+
+// Screens of the browser
+const (
+    viewHosts = iota
+    viewRuns
+    viewDetail
+)
+
+// Stored data loaded for browsing
+type tuiData struct {
+    records []record
+    history map[string][]historyEntry
+    runs    []runEntry
+}
+
+// Load the records, history grouped by link, and runs of a workspace
+func loadTUIData(db *sql.DB, workspace string) (tuiData, error) {
+    var d tuiData
+    var err error
+    if d.records, err = loadRecords(db, workspace, false); err != nil {
+        return d, err
+    }
+    entries, err := loadHistory(db, workspace)
+    if err != nil {
+        return d, err
+    }
+    d.history = map[string][]historyEntry{}
+    for _, e := range entries {
+        d.history[e.Link] = append(d.history[e.Link], e)
+    }
+    d.runs, err = loadRuns(db, workspace)
+    return d, err
+}
+
+// Sent when data has been (re)loaded
+type tuiLoadedMsg struct {
+    data tuiData
+    err  error
+}
+
+// Sent when a rescan process exits
+type tuiRescanMsg struct {
+    target string
+    err    error
+}
+
+// State of the interactive browser
+type tuiModel struct {
+    db     *sql.DB
+    dbOpts *dbOptions
+    fps    fingerprints
+    data   tuiData
+
+    view      int
+    filter    string
+    filtering bool
+    runFilter int64
+    visible   []record
+    cursor    int
+    runCursor int
+    height    int
+    status    string
+}
+
+func (m tuiModel) load() tea.Msg {
+    data, err := loadTUIData(m.db, m.dbOpts.workspace)
+    return tuiLoadedMsg{data: data, err: err}
+}
+
+func (m tuiModel) Init() tea.Cmd {
+    return m.load
+}
+
+// Technology label of a record, if fingerprints identify it
+func (m tuiModel) tech(r record) string {
+    return m.fps.identify(r.MD5, r.SHA256, r.MMH3)
+}
+
+// Recompute the records shown for the current filters
+func (m *tuiModel) applyFilter() {
+    needle := strings.ToLower(m.filter)
+    m.visible = nil
+    for _, r := range m.data.records {
+        if m.runFilter != 0 && !m.inRun(r.Link, m.runFilter) {
+            continue
+        }
+        if needle != "" && !m.matches(r, needle) {
+            continue
+        }
+        m.visible = append(m.visible, r)
+    }
+    if m.cursor >= len(m.visible) {
+        m.cursor = max(len(m.visible)-1, 0)
+    }
+}
+
+// Whether a link was new or changed in the given run
+func (m tuiModel) inRun(link string, run int64) bool {
+    for _, e := range m.data.history[link] {
+        if e.RunID == run {
+            return true
+        }
+    }
+    return false
+}
+
+// Match a filter against the host, apex domain, hashes and technology
+func (m tuiModel) matches(r record, needle string) bool {
+    host := r.host()
+    for _, h := range []string{r.MD5, r.SHA256, r.MMH3} {
+        if strings.HasPrefix(h, needle) {
+            return true
+        }
+    }
+    for _, text := range []string{host, apexDomain(host), r.Title, m.tech(r)} {
+        if strings.Contains(strings.ToLower(text), needle) {
+            return true
+        }
+    }
+    return false
+}
+
+// Run a scan of one target in the foreground, suspending the browser
+func (m tuiModel) rescan(r record) tea.Cmd {
+    target := r.Target
+    if target == "" {
+        target = "http://" + r.host()
+    }
+    list, err := os.CreateTemp("", "maplink-rescan-*.txt")
+    if err != nil {
+        return func() tea.Msg { return tuiRescanMsg{target: target, err: err} }
+    }
+    fmt.Fprintln(list, target)
+    list.Close()
+
+    self, err := os.Executable()
+    if err != nil {
+        os.Remove(list.Name())
+        return func() tea.Msg { return tuiRescanMsg{target: target, err: err} }
+    }
+    args := []string{"-file", list.Name(), "-db-path", m.dbOpts.path, "-workspace", m.dbOpts.workspace}
+    if m.dbOpts.keyFile != "" {
+        args = append(args, "-db-keyfile", m.dbOpts.keyFile)
+    }
+    cmd := exec.Command(self, args...)
+    cmd.Env = append(os.Environ(), "MAPLINK_DB_KEY="+m.dbOpts.key)
+    return tea.ExecProcess(cmd, func(err error) tea.Msg {
+        os.Remove(list.Name())
+        return tuiRescanMsg{target: target, err: err}
+    })
+}
+
+func (m tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+    switch msg := msg.(type) {
+    case tea.WindowSizeMsg:
+        m.height = msg.Height
+        return m, nil
+
+    case tuiLoadedMsg:
+        if msg.err != nil {
+            m.status = "Error loading database: " + msg.err.Error()
+            return m, nil
+        }
+        m.data = msg.data
+        m.applyFilter()
+        return m, nil
+
+    case tuiRescanMsg:
+        if msg.err != nil {
+            m.status = fmt.Sprintf("Rescan of %s failed: %v", msg.target, msg.err)
+        } else {
+            m.status = "Rescanned " + msg.target
+        }
+        return m, m.load
+
+    case tea.KeyMsg:
+        if m.filtering {
+            switch msg.Type {
+            case tea.KeyEnter, tea.KeyEsc:
+                m.filtering = false
+            case tea.KeyBackspace:
+                if m.filter != "" {
+                    m.filter = m.filter[:len(m.filter)-1]
+                }
+            case tea.KeyRunes, tea.KeySpace:
+                m.filter += string(msg.Runes)
+            }
+            m.applyFilter()
+            return m, nil
+        }
+
+        switch msg.String() {
+        case "q", "ctrl+c":
+            return m, tea.Quit
+        case "tab":
+            if m.view == viewRuns {
+                m.view = viewHosts
+            } else {
+                m.view = viewRuns
+            }
+        case "esc", "backspace":
+            switch {
+            case m.view == viewDetail:
+                m.view = viewHosts
+            case m.runFilter != 0:
+                m.runFilter = 0
+                m.applyFilter()
+            case m.filter != "":
+                m.filter = ""
+                m.applyFilter()
+            }
+        case "/":
+            if m.view != viewRuns {
+                m.view = viewHosts
+                m.filtering = true
+            }
+        case "up", "k":
+            m.move(-1)
+        case "down", "j":
+            m.move(1)
+        case "pgup":
+            m.move(-m.pageSize())
+        case "pgdown":
+            m.move(m.pageSize())
+        case "enter":
+            switch m.view {
+            case viewHosts:
+                if len(m.visible) > 0 {
+                    m.view = viewDetail
+                }
+            case viewRuns:
+                if len(m.data.runs) > 0 {
+                    m.runFilter = m.data.runs[m.runCursor].ID
+                    m.view = viewHosts
+                    m.cursor = 0
+                    m.applyFilter()
+                }
+            }
+        case "r":
+            return m, m.load
+        case "s":
+            if m.view != viewRuns && len(m.visible) > 0 {
+                return m, m.rescan(m.visible[m.cursor])
+            }
+        }
+    }
+    return m, nil
+}
+
+// Move the cursor of the current list
+func (m *tuiModel) move(delta int) {
+    if m.view == viewRuns {
+        m.runCursor = min(max(m.runCursor+delta, 0), max(len(m.data.runs)-1, 0))
+        return
+    }
+    m.cursor = min(max(m.cursor+delta, 0), max(len(m.visible)-1, 0))
+}
+
+// Rows available for a list below the header and above the footer
+func (m tuiModel) pageSize() int {
+    return max(m.height-5, 5)
+}
+
+// Window of a list of n rows that keeps the cursor visible
+func (m tuiModel) window(n, cursor int) (int, int) {
+    size := m.pageSize()
+    start := 0
+    if cursor >= size {
+        start = cursor - size + 1
+    }
+    return start, min(start+size, n)
+}
+
+func (m tuiModel) View() string {
+    var b strings.Builder
+    switch m.view {
+    case viewHosts:
+        title := fmt.Sprintf("MAPLINK  %d of %d favicons", len(m.visible), len(m.data.records))
+        if m.runFilter != 0 {
+            title += fmt.Sprintf("  changed in run #%d", m.runFilter)
+        }
+        if m.filter != "" || m.filtering {
+            title += "  filter: " + m.filter
+            if m.filtering {
+                title += "_"
+            }
+        }
+        b.WriteString(title + "\n\n")
+        start, end := m.window(len(m.visible), m.cursor)
+        for i := start; i < end; i++ {
+            r := m.visible[i]
+            marker := "  "
+            if i == m.cursor {
+                marker = "> "
+            }
+            fmt.Fprintf(&b, "%s%-40s %-12s %s  %s\n", marker, r.host(), r.MMH3, r.MD5, m.tech(r))
+        }
+        b.WriteString("\nenter detail  / filter  s rescan  tab runs  r reload  esc clear  q quit")
+
+    case viewRuns:
+        fmt.Fprintf(&b, "MAPLINK  %d runs\n\n", len(m.data.runs))
+        start, end := m.window(len(m.data.runs), m.runCursor)
+        for i := start; i < end; i++ {
+            r := m.data.runs[i]
+            marker := "  "
+            if i == m.runCursor {
+                marker = "> "
+            }
+            fmt.Fprintf(&b, "%s#%-5d %s  %-18s %-11s %6d targets %6d favicons %5d errors\n",
+                marker, r.ID, r.StartedAt, r.Name, r.Status, r.Targets, r.Favicons, r.Errors)
+        }
+        b.WriteString("\nenter show changes  tab hosts  q quit")
+
+    case viewDetail:
+        r := m.visible[m.cursor]
+        fmt.Fprintf(&b, "%s\n\n", r.Link)
+        fmt.Fprintf(&b, "Host:        %s (%s)\n", r.host(), apexDomain(r.host()))
+        fmt.Fprintf(&b, "Target:      %s\n", r.Target)
+        fmt.Fprintf(&b, "Title:       %s\n", r.Title)
+        fmt.Fprintf(&b, "Server:      %s\n", r.Server)
+        fmt.Fprintf(&b, "Technology:  %s\n", m.tech(r))
+        fmt.Fprintf(&b, "MD5:         %s\n", r.MD5)
+        fmt.Fprintf(&b, "SHA256:      %s\n", r.SHA256)
+        fmt.Fprintf(&b, "MMH3:        %s\n", r.MMH3)
+        fmt.Fprintf(&b, "Type/size:   %s, %d bytes\n", r.ContentType, r.Size)
+        fmt.Fprintf(&b, "First seen:  %s\n", r.FirstSeen)
+        fmt.Fprintf(&b, "Last seen:   %s\n", r.LastSeen)
+        b.WriteString("\nHistory\n")
+        for _, e := range m.data.history[r.Link] {
+            fmt.Fprintf(&b, "  %s  MD5 %s  run #%d\n", e.SeenAt, e.MD5, e.RunID)
+        }
+        b.WriteString("\ns rescan  esc back  q quit")
+    }
+    if m.status != "" {
+        b.WriteString("\n" + m.status)
+    }
+    return b.String()
+}
+
+// Browse stored results interactively
+func tuiCommand(args []string) {
+    fs := flag.NewFlagSet("tui", flag.ExitOnError)
+    dbOpts := dbFlags(fs)
+    fingerprintFile := fs.String("fingerprints", "", "CSV file of hash,technology pairs used to label and filter favicons")
+    parseFlags(fs, args)
+
+    fps, err := loadFingerprints(*fingerprintFile)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error loading fingerprints: %v\n", err)
+        return
+    }
+    db, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer db.Close()
+
+    m := tuiModel{db: db, dbOpts: dbOpts, fps: fps}
+    if _, err := tea.NewProgram(m, tea.WithAltScreen()).Run(); err != nil {
+        fmt.Fprintf(os.Stderr, "Error running TUI: %v\n", err)
+    }
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "os"
+    "sort"
+    "strings"
+    "time"
+
+    "golang.org/x/net/idna"
+    "golang.org/x/net/publicsuffix"
+)
+
+// A lookalike of the seed domain and how it was made
+type permutation struct {
+    Domain string
+    Kind   string // bitsquat, homoglyph, tld, omission, repetition, transposition or hyphenation
+}
+
+// Characters that pass for others, ASCII first and then Cyrillic letters
+// encoded as punycode
+var homoglyphs = map[rune][]string{
+    'a': {"4", "а"},
+    'b': {"d", "lb"},
+    'c': {"e", "с"},
+    'd': {"b", "cl"},
+    'e': {"3", "е"},
+    'g': {"q", "9"},
+    'h': {"lh"},
+    'i': {"1", "l", "і"},
+    'k': {"lc"},
+    'l': {"1", "i"},
+    'm': {"rn", "nn"},
+    'n': {"m", "r"},
+    'o': {"0", "о"},
+    'p': {"р"},
+    'q': {"g"},
+    's': {"5", "ѕ"},
+    'u': {"v"},
+    'v': {"u"},
+    'w': {"vv"},
+    'x': {"х"},
+    'y': {"у"},
+    'z': {"2"},
+}
+
+// Suffixes tried in place of the seed's, besides the ones its label has
+var squatTLDs = []string{"com", "net", "org", "info", "biz", "co", "io", "app", "dev", "online", "site", "xyz", "top", "shop", "live", "us", "uk", "co.uk", "de", "ru", "cn", "me", "cc", "tv"}
+
+// Whether a label only holds what a host name may
+func validLabel(label string) bool {
+    if label == "" || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+        return false
+    }
+    for _, r := range label {
+        if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r > 127) {
+            return false
+        }
+    }
+    return true
+}
+
+// Lookalikes of a domain's registrable label under its own suffix, and of
+// the label under other suffixes
+func permutations(domain string) ([]permutation, error) {
+    domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+    apex, err := publicsuffix.EffectiveTLDPlusOne(domain)
+    if err != nil {
+        return nil, err
+    }
+    label, suffix, _ := strings.Cut(apex, ".")
+
+    seen := map[string]bool{apex: true}
+    var perms []permutation
+    add := func(l, suffix, kind string) {
+        if !validLabel(l) {
+            return
+        }
+        d, err := idna.Lookup.ToASCII(l + "." + suffix)
+        if err != nil || seen[d] {
+            return
+        }
+        seen[d] = true
+        perms = append(perms, permutation{Domain: d, Kind: kind})
+    }
+
+    // One bit flipped in one character
+    for i := 0; i < len(label); i++ {
+        for bit := 0; bit < 8; bit++ {
+            add(label[:i]+string(label[i]^byte(1<<bit))+label[i+1:], suffix, "bitsquat")
+        }
+    }
+    for i, r := range label {
+        for _, g := range homoglyphs[r] {
+            add(label[:i]+g+label[i+len(string(r)):], suffix, "homoglyph")
+        }
+    }
+    for i := 0; i < len(label); i++ {
+        add(label[:i]+label[i+1:], suffix, "omission")
+        add(label[:i+1]+label[i:], suffix, "repetition")
+        if i+1 < len(label) {
+            add(label[:i]+string(label[i+1])+string(label[i])+label[i+2:], suffix, "transposition")
+        }
+        if i > 0 {
+            add(label[:i]+"-"+label[i:], suffix, "hyphenation")
+        }
+    }
+    for _, tld := range squatTLDs {
+        add(label, tld, "tld")
+    }
+    return perms, nil
+}
+
+// Permutations that resolve, looked up workers at a time
+func resolvePermutations(ctx context.Context, perms []permutation, workers int, timeout time.Duration) []permutation {
+    domains := make([]string, len(perms))
+    kinds := map[string]string{}
+    for i, p := range perms {
+        domains[i], kinds[p.Domain] = p.Domain, p.Kind
+    }
+    var live []permutation
+    for _, r := range resolveHosts(ctx, domains, workers, timeout) {
+        if r.err == nil && len(r.addrs) > 0 {
+            live = append(live, permutation{Domain: r.host, Kind: kinds[r.host]})
+        }
+    }
+    sort.Slice(live, func(i, j int) bool { return live[i].Domain < live[j].Domain })
+    return live
+}
+
+// Sink adding every scanned favicon to a brand corpus
+type corpusSink struct {
+    corpus *brandCorpus
+    label  string
+    stored func(link string) []byte
+}
+
+func (c *corpusSink) Write(res result) error {
+    data := res.Data
+    if len(data) == 0 {
+        data = c.stored(res.URL)
+    }
+    if len(data) > 0 {
+        c.corpus.addIcon(data, c.label)
+    }
+    return nil
+}
+
+func (c *corpusSink) Close() error {
+    return nil
+}
+
+// Generate lookalikes of a domain, resolve them and report those serving
+// the seed's favicon or one close to it
+func typosquatCommand(args []string) {
+    fs := flag.NewFlagSet("typosquat", flag.ExitOnError)
+    seed := fs.String("domain", "", "Seed domain whose lookalikes to hunt")
+    corpusFile := fs.String("corpus", "", "Brand corpus to match besides the seed's own favicon (see brand)")
+    allow := fs.String("allow", "", "Comma-separated domains besides the seed allowed to use its icons")
+    threshold := fs.Int("threshold", 6, "Maximum perceptual hash distance (bits) counted as a lookalike")
+    resolvers := fs.Int("resolve-workers", 32, "Concurrent DNS lookups")
+    resolveTimeout := fs.Duration("resolve-timeout", 5*time.Second, "Timeout of each DNS lookup")
+    list := fs.Bool("list", false, "Print the permutations and exit without resolving or scanning")
+    report := fs.String("report", "text", "Format of the lookalike report printed after the scan: text or json")
+    dbOpts := dbFlags(fs)
+    opts := registerScanFlags(fs)
+    parseFlags(fs, args)
+
+    if *seed == "" {
+        fmt.Println("Usage: maplink typosquat -domain example.com [-corpus brand.txt]")
+        return
+    }
+    perms, err := permutations(*seed)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error generating permutations: %v\n", err)
+        return
+    }
+    if *list {
+        for _, p := range perms {
+            fmt.Printf("%s\t%s\n", p.Domain, p.Kind)
+        }
+        return
+    }
+
+    corpus := &brandCorpus{hashes: map[string]string{}}
+    if *corpusFile != "" {
+        if corpus, err = loadBrandCorpus(*corpusFile); err != nil {
+            fmt.Fprintf(os.Stderr, "Error reading brand corpus: %v\n", err)
+            return
+        }
+    }
+    allowed, _ := loadAllowlist(*allow, "")
+    allowed = append(allowed, apexDomain(strings.ToLower(*seed)))
+
+    db, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer db.Close()
+
+    s, err := opts.newScanner(db, dbOpts.workspace)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error setting up scan: %v\n", err)
+        return
+    }
+    defer s.close()
+    stored := func(link string) []byte {
+        records, err := queryRecords(db, dbOpts.workspace, true, "f.link = ?", link)
+        if err != nil || len(records) == 0 {
+            return nil
+        }
+        return records[0].Data
+    }
+    ctx := signalContext()
+
+    // The seed's own favicon is the icon its lookalikes would copy
+    sinks := s.sinks
+    s.sinks = append(sinks, &corpusSink{corpus: corpus, label: *seed, stored: stored})
+    s.scanTargets(ctx, []target{{URL: *seed}})
+    if len(corpus.hashes) == 0 {
+        fmt.Fprintf(os.Stderr, "Error: no favicon found on %s and no -corpus given\n", *seed)
+        return
+    }
+
+    infof("Resolving %d permutations of %s\n", len(perms), *seed)
+    live := resolvePermutations(ctx, perms, *resolvers, *resolveTimeout)
+    infof("%d permutations resolve\n", len(live))
+    if len(live) == 0 || ctx.Err() != nil {
+        printBrandMatches(nil, *report)
+        return
+    }
+    kinds := map[string]string{}
+    targets := make([]target, len(live))
+    for i, p := range live {
+        kinds[p.Domain] = p.Kind
+        targets[i] = target{URL: p.Domain}
+    }
+
+    flagged := &brandSink{corpus: corpus, allow: allowed, threshold: *threshold, stored: stored}
+    s.sinks = append(sinks, flagged)
+    s.scanTargets(ctx, targets)
+
+    matches := flagged.results()
+    for i := range matches {
+        matches[i].Permutation = kinds[matches[i].Host]
+    }
+    printBrandMatches(matches, *report)
+}
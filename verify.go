@@ -0,0 +1,106 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "os"
+    "strings"
+
+    "golang.org/x/sync/errgroup"
+)
+
+// Outcome of re-fetching a stored favicon
+type verification struct {
+    record
+    Status  string // match, mismatch or error
+    Fetched string // SHA256 of what the link serves now
+    Err     error
+}
+
+// Re-download stored favicons and compare them with their recorded hashes
+func verifyCommand(args []string) {
+    fs := flag.NewFlagSet("verify", flag.ExitOnError)
+    dbOpts := dbFlags(fs)
+    opts := registerScanFlags(fs)
+    sample := fs.Int("sample", 0, "Verify this many favicons picked at random (0 verifies all)")
+    link := fs.String("link", "", "Verify only this favicon link")
+    mismatchesOnly := fs.Bool("mismatches", false, "Print only mismatches and errors")
+    parseFlags(fs, args)
+
+    db, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer db.Close()
+
+    where, order, params := "", "f.link", []interface{}{}
+    if *link != "" {
+        where, params = "f.link = ?", append(params, *link)
+    }
+    if *sample > 0 {
+        order = "RANDOM()"
+    }
+    records, err := selectRecords(db, dbOpts.workspace, false, where, order, *sample, params...)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error loading favicons: %v\n", err)
+        return
+    }
+    if len(records) == 0 {
+        fmt.Println("No favicons to verify.")
+        return
+    }
+
+    // The scanner brings the scan flags' transport: proxies, timeouts, rate limits
+    s, err := opts.newScanner(db, dbOpts.workspace)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error setting up scan: %v\n", err)
+        return
+    }
+    defer s.close()
+
+    ctx := signalContext()
+    results := make([]verification, len(records))
+    var g errgroup.Group
+    g.SetLimit(s.workers)
+    for i, r := range records {
+        if ctx.Err() != nil {
+            break
+        }
+        g.Go(func() error {
+            v := verification{record: r, Status: "error"}
+            icon, err := s.fetch.fetchFavicon(ctx, r.Link, validators{})
+            if err != nil {
+                v.Err = err
+            } else {
+                v.Fetched = s.hash.hash(icon.Data).SHA256
+                v.Status = "mismatch"
+                if v.Fetched == r.SHA256 {
+                    v.Status = "match"
+                }
+            }
+            results[i] = v
+            return nil
+        })
+    }
+    g.Wait()
+
+    counts := map[string]int{}
+    for _, v := range results {
+        if v.Status == "" {
+            // Not reached before an interrupt
+            continue
+        }
+        counts[v.Status]++
+        if *mismatchesOnly && v.Status == "match" {
+            continue
+        }
+        detail := v.Fetched
+        if v.Err != nil {
+            detail = strings.ReplaceAll(v.Err.Error(), "\t", " ")
+        }
+        fmt.Printf("%s\t%s\t%s\t%s\t%s\n", v.Status, v.Link, v.SHA256, v.LastSeen, detail)
+    }
+    fmt.Fprintf(os.Stderr, "Verified %d favicons: %d match, %d mismatch, %d errors\n",
+        counts["match"]+counts["mismatch"]+counts["error"], counts["match"], counts["mismatch"], counts["error"])
+}
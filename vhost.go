@@ -0,0 +1,114 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "net"
+    "os"
+    "strings"
+)
+
+// Items of a comma-separated flag and a file of one per line, in order
+func listAndFile(list, filename string) ([]string, error) {
+    items := splitList(list)
+    if filename != "" {
+        lines, err := readURLsFromFile(filename)
+        if err != nil {
+            return nil, err
+        }
+        for _, line := range lines {
+            if !strings.HasPrefix(line, "#") {
+                items = append(items, strings.Fields(line)[0])
+            }
+        }
+    }
+    return items, nil
+}
+
+// Targets asking origin for each host name: the URL names the virtual host,
+// so the Host header, SNI and stored links are its own, and connect sends
+// the requests to the origin
+func vhostTargets(origin string, hosts, schemes []string) []target {
+    var targets []target
+    for _, host := range hosts {
+        for _, scheme := range schemes {
+            targets = append(targets, target{
+                URL:     scheme + "://" + hostForURL(strings.ToLower(host)),
+                Connect: origin,
+                Labels:  []string{"origin:" + origin},
+            })
+        }
+    }
+    return targets
+}
+
+// Hash the favicon every virtual host of one or more origins serves
+func vhostCommand(args []string) {
+    fs := flag.NewFlagSet("vhost", flag.ExitOnError)
+    ips := fs.String("ips", "", "Comma-separated origin IPs (or ip:port) to send requests to")
+    ipFile := fs.String("ip-file", "", "File of origin IPs, one per line")
+    hosts := fs.String("hosts", "", "Comma-separated host names to ask each origin for")
+    hostFile := fs.String("hosts-file", "", "File of host names, one per line, e.g. subfinder output")
+    schemes := fs.String("schemes", "https", "Comma-separated schemes to try for each host name: https, http or both")
+    dbOpts := dbFlags(fs)
+    opts := registerScanFlags(fs)
+    parseFlags(fs, args)
+
+    origins, err := listAndFile(*ips, *ipFile)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error reading origins: %v\n", err)
+        return
+    }
+    names, err := listAndFile(*hosts, *hostFile)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error reading host names: %v\n", err)
+        return
+    }
+    if len(origins) == 0 || len(names) == 0 {
+        fmt.Println("Usage: maplink vhost -ips 203.0.113.10 -hosts-file names.txt")
+        return
+    }
+    for _, origin := range origins {
+        if _, _, ok := bareHost(origin); !ok || strings.Contains(origin, "/") {
+            fmt.Fprintf(os.Stderr, "Error: invalid origin %q\n", origin)
+            return
+        }
+    }
+    schemeList := splitList(strings.ToLower(*schemes))
+    for _, s := range schemeList {
+        if s != "http" && s != "https" {
+            fmt.Fprintf(os.Stderr, "Error: invalid scheme %q\n", s)
+            return
+        }
+    }
+    for _, name := range names {
+        if net.ParseIP(name) != nil {
+            fmt.Fprintf(os.Stderr, "Error: %s is an IP, not a host name\n", name)
+            return
+        }
+    }
+
+    db, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer db.Close()
+
+    s, err := opts.newScanner(db, dbOpts.workspace)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error setting up scan: %v\n", err)
+        return
+    }
+    defer s.close()
+
+    // One run per origin: its targets share URLs with the other origins'
+    ctx := signalContext()
+    for _, origin := range origins {
+        if ctx.Err() != nil {
+            return
+        }
+        infof("Asking %s for %d host names\n", origin, len(names))
+        s.scanTargets(ctx, vhostTargets(origin, names, schemeList))
+    }
+}
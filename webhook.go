@@ -0,0 +1,123 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "mime"
+    "net/http"
+    "net/url"
+    "strings"
+)
+
+// Fields of a webhook payload that hold host names or URLs, at any depth
+var webhookHostFields = map[string]bool{
+    "host": true, "hosts": true, "hostname": true, "hostnames": true, "fqdn": true, "fqdns": true,
+    "domain": true, "domains": true, "subdomain": true, "subdomains": true, "url": true, "urls": true,
+}
+
+// Host names and URLs in a decoded JSON payload: the strings under
+// webhookHostFields, however the discovery system nests them
+func webhookHosts(v interface{}, underHostField bool, out *[]string) {
+    switch v := v.(type) {
+    case string:
+        if underHostField {
+            *out = append(*out, v)
+        }
+    case []interface{}:
+        for _, item := range v {
+            webhookHosts(item, underHostField, out)
+        }
+    case map[string]interface{}:
+        for k, item := range v {
+            webhookHosts(item, webhookHostFields[strings.ToLower(k)], out)
+        }
+    }
+}
+
+// A reported host name or URL as a target. Host names are lowercased, and
+// wildcard assets stand for their base name; of a URL only the scheme and
+// host are, as paths and tokens may be case-sensitive.
+func webhookTarget(name string) string {
+    name = strings.TrimSpace(name)
+    if strings.Contains(name, "://") {
+        if u, err := url.Parse(name); err == nil {
+            u.Scheme, u.Host = strings.ToLower(u.Scheme), strings.ToLower(u.Host)
+            return u.String()
+        }
+        return name
+    }
+    return strings.TrimPrefix(strings.TrimSuffix(strings.ToLower(name), "."), "*.")
+}
+
+// Targets of a webhook body, deduplicated: a JSON document, or plain text
+// with one host name or URL per line
+func parseWebhookTargets(contentType string, body []byte) ([]target, error) {
+    var found []string
+    if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == "text/plain" {
+        found = strings.Fields(string(body))
+    } else {
+        var payload interface{}
+        if err := json.Unmarshal(body, &payload); err != nil {
+            return nil, fmt.Errorf("invalid JSON body: %v", err)
+        }
+        webhookHosts(payload, false, &found)
+    }
+
+    seen := map[string]bool{}
+    var targets []target
+    for _, name := range found {
+        name = webhookTarget(name)
+        if name == "" || seen[name] {
+            continue
+        }
+        seen[name] = true
+        t := target{URL: name}
+        if err := t.validate(); err != nil {
+            return nil, fmt.Errorf("%q: %v", name, err)
+        }
+        targets = append(targets, t)
+    }
+    return targets, nil
+}
+
+// POST /webhooks/hosts: an asset-discovery system reports hosts it found,
+// and they are queued as a job for the scanner to take up right away
+func (sv *server) hostsWebhook(w http.ResponseWriter, r *http.Request) {
+    body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBody))
+    if err != nil {
+        writeError(w, http.StatusRequestEntityTooLarge, "body too large")
+        return
+    }
+    targets, err := parseWebhookTargets(r.Header.Get("Content-Type"), body)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    if len(targets) > sv.maxTargets {
+        writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("at most %d targets per job", sv.maxTargets))
+        return
+    }
+    // Events without hosts (pings, deletions) are acknowledged so they are not redelivered
+    if len(targets) == 0 {
+        writeJSON(w, http.StatusOK, apiJobAccepted{})
+        return
+    }
+    entry := auditFrom(r.Context())
+    entry.targets = targetURLs(targets)
+
+    id, err := createJob(sv.db, sv.workspace, entry.key, targets, nil)
+    if err != nil {
+        errorf("Error queueing job: %v\n", err)
+        writeError(w, http.StatusInternalServerError, "queueing job")
+        return
+    }
+    select {
+    case sv.wake <- struct{}{}:
+    default:
+    }
+    sv.publishJob(id)
+    infof("Webhook from %s queued %d hosts as job %d\n", entry.key, len(targets), id)
+    w.Header().Set("Location", fmt.Sprintf("/jobs/%d", id))
+    writeJSON(w, http.StatusAccepted, apiJobAccepted{Job: id, Queued: len(targets)})
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+    "database/sql"
+    "flag"
+    "fmt"
+    "os"
+)
+
+// Workspace administration subcommands
+func workspaceCommand(args []string) {
+    if len(args) == 0 || isHelp(args[0]) {
+        fmt.Fprintln(os.Stderr, "Usage: maplink workspace list|delete [flags]")
+        return
+    }
+    switch args[0] {
+    case "list":
+        workspaceListCommand(args[1:])
+    case "delete":
+        workspaceDeleteCommand(args[1:])
+    default:
+        fmt.Fprintf(os.Stderr, "Unknown workspace command %q\n", args[0])
+    }
+}
+
+// Contents of one workspace
+type workspaceInfo struct {
+    name     string
+    favicons int
+    runs     int
+    lastSeen string
+}
+
+// Every workspace holding favicons or runs, by name
+func listWorkspaces(db *sql.DB) ([]workspaceInfo, error) {
+    rows, err := db.Query(`SELECT w.workspace,
+            (SELECT COUNT(*) FROM favicons f WHERE f.workspace = w.workspace),
+            (SELECT COUNT(*) FROM runs r WHERE r.workspace = w.workspace),
+            COALESCE((SELECT MAX(last_seen) FROM favicons f WHERE f.workspace = w.workspace), '')
+        FROM (SELECT workspace FROM favicons UNION SELECT workspace FROM runs) w ORDER BY w.workspace`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var workspaces []workspaceInfo
+    for rows.Next() {
+        var w workspaceInfo
+        if err := rows.Scan(&w.name, &w.favicons, &w.runs, &w.lastSeen); err != nil {
+            return nil, err
+        }
+        workspaces = append(workspaces, w)
+    }
+    return workspaces, rows.Err()
+}
+
+// Print the workspaces of a database
+func workspaceListCommand(args []string) {
+    fs := flag.NewFlagSet("workspace list", flag.ExitOnError)
+    dbOpts := dbFlags(fs)
+    parseFlags(fs, args)
+
+    db, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer db.Close()
+
+    workspaces, err := listWorkspaces(db)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error listing workspaces: %v\n", err)
+        return
+    }
+    for _, w := range workspaces {
+        fmt.Printf("%s\t%d favicons\t%d runs\tlast seen %s\n", w.name, w.favicons, w.runs, w.lastSeen)
+    }
+}
+
+// Rows removed with a workspace
+type workspaceDeletion struct {
+    favicons int64
+    history  int64
+    runs     int64
+    jobs     int64
+    blobs    int64
+}
+
+// Delete everything stored in a workspace, its tags, notes, watchlist and
+// jobs included, and the blobs only it referred to. The action trail keeps its record of it.
+func deleteWorkspace(db *sql.DB, workspace, actor string) (workspaceDeletion, error) {
+    var stats workspaceDeletion
+    tx, err := db.Begin()
+    if err != nil {
+        return stats, err
+    }
+    defer tx.Rollback()
+
+    // Children before the rows they refer to: job targets and results before
+    // their jobs, what runs recorded before the runs, observations before hosts
+    for _, child := range []string{"job_results", "job_targets"} {
+        if _, err = tx.Exec("DELETE FROM "+child+" WHERE job_id IN (SELECT id FROM jobs WHERE workspace = ?)", workspace); err != nil {
+            return stats, fmt.Errorf("deleting %s: %v", child, err)
+        }
+    }
+    if stats.jobs, err = execCount(tx, "DELETE FROM jobs WHERE workspace = ?", workspace); err != nil {
+        return stats, fmt.Errorf("deleting jobs: %v", err)
+    }
+    for _, table := range []string{"skipped_icons", "resolutions", "errors", "request_timings", "technologies", "certificate_sans", "dns_records", "observations"} {
+        if _, err = tx.Exec("DELETE FROM "+table+" WHERE workspace = ?", workspace); err != nil {
+            return stats, fmt.Errorf("deleting %s: %v", table, err)
+        }
+    }
+    if stats.history, err = execCount(tx, "DELETE FROM history WHERE workspace = ?", workspace); err != nil {
+        return stats, fmt.Errorf("deleting history: %v", err)
+    }
+    if _, err = tx.Exec("DELETE FROM run_targets WHERE run_id IN (SELECT id FROM runs WHERE workspace = ?)", workspace); err != nil {
+        return stats, fmt.Errorf("deleting checkpoints: %v", err)
+    }
+    if stats.runs, err = execCount(tx, "DELETE FROM runs WHERE workspace = ?", workspace); err != nil {
+        return stats, fmt.Errorf("deleting runs: %v", err)
+    }
+    if stats.favicons, err = execCount(tx, "DELETE FROM favicons WHERE workspace = ?", workspace); err != nil {
+        return stats, fmt.Errorf("deleting favicons: %v", err)
+    }
+    for _, table := range []string{"hosts", "tags", "notes", "watchlist"} {
+        if _, err = tx.Exec("DELETE FROM "+table+" WHERE workspace = ?", workspace); err != nil {
+            return stats, fmt.Errorf("deleting %s: %v", table, err)
+        }
+    }
+    if stats.blobs, err = execCount(tx, orphanBlobsSQL); err != nil {
+        return stats, fmt.Errorf("removing orphaned blobs: %v", err)
+    }
+    if err = recordAction(tx, auditAction{Workspace: workspace, Actor: actor, Action: actionWorkspaceDelete, Subject: workspace,
+        Detail: fmt.Sprintf("%d favicons, %d history rows, %d runs, %d jobs, %d orphaned blobs", stats.favicons, stats.history, stats.runs, stats.jobs, stats.blobs)}); err != nil {
+        return stats, err
+    }
+    return stats, tx.Commit()
+}
+
+// Remove one engagement's data from a shared database
+func workspaceDeleteCommand(args []string) {
+    fs := flag.NewFlagSet("workspace delete", flag.ExitOnError)
+    dbOpts := dbFlags(fs)
+    yes := fs.Bool("yes", false, "Delete without asking; required when stdin is not a terminal")
+    fs.Usage = func() {
+        fmt.Fprintln(fs.Output(), "Usage: maplink workspace delete [-yes] <workspace>")
+        fs.PrintDefaults()
+    }
+    parseFlags(fs, args)
+
+    if fs.NArg() != 1 {
+        fs.Usage()
+        return
+    }
+    workspace := fs.Arg(0)
+    if err := validateWorkspace(workspace); err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        return
+    }
+
+    db, err := dbOpts.open()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+        return
+    }
+    defer db.Close()
+
+    if !*yes {
+        if !isTerminal(os.Stdin) {
+            fmt.Fprintln(os.Stderr, "Refusing to delete without -yes when stdin is not a terminal.")
+            return
+        }
+        fmt.Printf("Delete everything stored in workspace %q? Type its name to confirm: ", workspace)
+        var answer string
+        fmt.Scanln(&answer)
+        if answer != workspace {
+            fmt.Println("Nothing deleted.")
+            return
+        }
+    }
+
+    stats, err := deleteWorkspace(db, workspace, localActor("cli"))
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error deleting workspace: %v\n", err)
+        return
+    }
+    fmt.Printf("Deleted workspace %s: %d favicons, %d history rows, %d runs, %d jobs, %d orphaned blobs\n",
+        workspace, stats.favicons, stats.history, stats.runs, stats.jobs, stats.blobs)
+}